@@ -0,0 +1,46 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestLocalSinkJSONL(t *testing.T) {
+	ctx := context.Background()
+	sink := NewLocalSink(t.TempDir())
+	if _, err := sink.Get(ctx, "logs/2025-03/2025-03-01.jsonl.gz"); !errors.Is(err, ErrNotExist) {
+		t.Fatalf("expected ErrNotExist, got %v", err)
+	}
+	var buf bytes.Buffer
+	writer := NewJSONLWriter(&buf)
+	for _, line := range []string{`{"id":1}`, `{"id":2,"content":"a\nb"}`} {
+		if err := writer.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Put(ctx, "logs/2025-03/2025-03-01.jsonl.gz", bytes.NewReader(buf.Bytes()), int64(buf.Len())); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := sink.Get(ctx, "logs/2025-03/2025-03-01.jsonl.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stored.Close()
+	var lines []string
+	err = ScanJSONL(stored, func(line json.RawMessage) error {
+		lines = append(lines, string(line))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if writer.Lines != 2 || len(lines) != 2 || lines[1] != `{"id":2,"content":"a\nb"}` {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
@@ -0,0 +1,60 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+)
+
+// JSONLWriter writes one JSON document per line, compressed with gzip
+type JSONLWriter struct {
+	writer *gzip.Writer
+	Lines  int64
+}
+
+func NewJSONLWriter(w io.Writer) *JSONLWriter {
+	return &JSONLWriter{writer: gzip.NewWriter(w)}
+}
+
+func (w *JSONLWriter) Write(line []byte) error {
+	if _, err := w.writer.Write(line); err != nil {
+		return err
+	}
+	if _, err := w.writer.Write([]byte{'\n'}); err != nil {
+		return err
+	}
+	w.Lines++
+	return nil
+}
+
+// Close flushes the gzip stream, it does not close the underlying writer
+func (w *JSONLWriter) Close() error {
+	return w.writer.Close()
+}
+
+// ScanJSONL reads what JSONLWriter wrote, every line must be a valid JSON document.
+// The line passed to fn is only valid until fn returns.
+func ScanJSONL(r io.Reader, fn func(line json.RawMessage) error) error {
+	reader, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if !json.Valid(line) {
+			return errInvalidLine
+		}
+		if err = fn(line); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
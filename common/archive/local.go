@@ -0,0 +1,56 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// LocalSink stores the objects as files under a directory
+type LocalSink struct {
+	Dir string
+}
+
+func NewLocalSink(dir string) *LocalSink {
+	return &LocalSink{Dir: dir}
+}
+
+func (sink *LocalSink) path(key string) string {
+	return filepath.Join(sink.Dir, filepath.FromSlash(key))
+}
+
+// Put writes to a temporary file first so that a crash never leaves a truncated archive
+func (sink *LocalSink) Put(ctx context.Context, key string, body io.Reader, size int64) error {
+	path := sink.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	written, err := io.Copy(file, body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && written != size {
+		err = fmt.Errorf("wrote %d bytes of %s, %d expected", written, key, size)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (sink *LocalSink) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	file, err := os.Open(sink.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotExist
+	}
+	return file, err
+}
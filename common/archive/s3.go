@@ -0,0 +1,89 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// S3Sink stores the objects in a bucket of an S3 compatible storage, such as AWS S3 or MinIO.
+// Requests use path style addressing, which every S3 compatible storage supports.
+type S3Sink struct {
+	Endpoint    string
+	Region      string
+	Bucket      string
+	credentials aws.Credentials
+	signer      *v4.Signer
+	client      *http.Client
+}
+
+func NewS3Sink(endpoint string, region string, bucket string, accessKey string, secretKey string) *S3Sink {
+	return &S3Sink{
+		Endpoint:    strings.TrimSuffix(endpoint, "/"),
+		Region:      region,
+		Bucket:      bucket,
+		credentials: aws.Credentials{AccessKeyID: accessKey, SecretAccessKey: secretKey},
+		signer:      v4.NewSigner(),
+		client:      &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+// emptyPayloadHash is the SHA-256 of the empty body of the GET requests
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// do sends a request, the body of a PUT is streamed and its payload left unsigned
+func (sink *S3Sink) do(ctx context.Context, method string, key string, body io.Reader, size int64) (*http.Response, error) {
+	url := fmt.Sprintf("%s/%s/%s", sink.Endpoint, sink.Bucket, key)
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	payloadHash := emptyPayloadHash
+	if body != nil {
+		payloadHash = "UNSIGNED-PAYLOAD"
+		req.ContentLength = size
+		req.Header.Set("Content-Type", "application/gzip")
+	}
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	err = sink.signer.SignHTTP(ctx, sink.credentials, req, payloadHash, "s3", sink.Region, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return sink.client.Do(req)
+}
+
+func (sink *S3Sink) Put(ctx context.Context, key string, body io.Reader, size int64) error {
+	resp, err := sink.do(ctx, http.MethodPut, key, body, size)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("failed to put %s: status %d: %s", key, resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+func (sink *S3Sink) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := sink.do(ctx, http.MethodGet, key, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		_ = resp.Body.Close()
+		return nil, ErrNotExist
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("failed to get %s: status %d: %s", key, resp.StatusCode, string(body))
+	}
+	return resp.Body, nil
+}
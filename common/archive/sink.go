@@ -0,0 +1,41 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/songquanpeng/one-api/common/config"
+)
+
+// ErrNotExist is returned by Sink.Get when there is no object under the key
+var ErrNotExist = errors.New("archive object does not exist")
+
+var errInvalidLine = errors.New("archive contains an invalid JSON line")
+
+// Sink stores archive objects under slash separated keys
+type Sink interface {
+	// Put stores the size bytes read from body
+	Put(ctx context.Context, key string, body io.Reader, size int64) error
+	// Get opens the object, the caller closes it
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// NewSinkFromConfig returns the sink configured by LOG_ARCHIVE_SINK, or nil when archiving is disabled
+func NewSinkFromConfig() (Sink, error) {
+	switch config.LogArchiveSink {
+	case "":
+		return nil, nil
+	case "local":
+		return NewLocalSink(config.LogArchiveDir), nil
+	case "s3":
+		if config.LogArchiveS3Endpoint == "" || config.LogArchiveS3Bucket == "" {
+			return nil, errors.New("LOG_ARCHIVE_S3_ENDPOINT and LOG_ARCHIVE_S3_BUCKET are required by the s3 archive sink")
+		}
+		return NewS3Sink(config.LogArchiveS3Endpoint, config.LogArchiveS3Region, config.LogArchiveS3Bucket,
+			config.LogArchiveS3AccessKey, config.LogArchiveS3SecretKey), nil
+	default:
+		return nil, fmt.Errorf("unknown LOG_ARCHIVE_SINK: %s", config.LogArchiveSink)
+	}
+}
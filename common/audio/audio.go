@@ -0,0 +1,421 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+var ErrUnsupportedFormat = errors.New("unsupported audio format")
+
+// GetDuration reads the duration in seconds of a wav, mp3, m4a or webm file from its headers,
+// without decoding the audio
+func GetDuration(r io.ReaderAt, size int64) (float64, error) {
+	head := make([]byte, 12)
+	n, _ := r.ReadAt(head, 0)
+	head = head[:n]
+	switch {
+	case len(head) >= 12 && bytes.Equal(head[0:4], []byte("RIFF")) && bytes.Equal(head[8:12], []byte("WAVE")):
+		return getWavDuration(r, size)
+	case len(head) >= 8 && bytes.Equal(head[4:8], []byte("ftyp")):
+		return getMp4Duration(r, size)
+	case len(head) >= 4 && bytes.Equal(head[0:4], []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		return getWebmDuration(r, size)
+	case len(head) >= 3 && (bytes.Equal(head[0:3], []byte("ID3")) || (head[0] == 0xFF && head[1]&0xE0 == 0xE0)):
+		return getMp3Duration(r, size)
+	}
+	return 0, ErrUnsupportedFormat
+}
+
+func readAt(r io.ReaderAt, offset int64, n int) ([]byte, error) {
+	buf := make([]byte, n)
+	if _, err := r.ReadAt(buf, offset); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+const (
+	wavFormatPCM        = 1
+	wavFormatIEEEFloat  = 3
+	wavFormatExtensible = 0xFFFE
+	wavMaxSampleRate    = 384000
+	wavMaxChannels      = 32
+	wavMaxBitsPerSample = 64
+)
+
+// checkWavFormat checks the byte rate of the fmt chunk against the other fields, a forged byte rate
+// would shorten the duration. Compressed formats have a lower byte rate than the samples they decode to.
+func checkWavFormat(fmtChunk []byte) (uint32, error) {
+	format := binary.LittleEndian.Uint16(fmtChunk[0:2])
+	channels := uint64(binary.LittleEndian.Uint16(fmtChunk[2:4]))
+	sampleRate := uint64(binary.LittleEndian.Uint32(fmtChunk[4:8]))
+	byteRate := binary.LittleEndian.Uint32(fmtChunk[8:12])
+	bitsPerSample := uint64(binary.LittleEndian.Uint16(fmtChunk[14:16]))
+	if channels == 0 || channels > wavMaxChannels || sampleRate == 0 || sampleRate > wavMaxSampleRate || bitsPerSample > wavMaxBitsPerSample {
+		return 0, errors.New("invalid wav format")
+	}
+	switch format {
+	case wavFormatPCM, wavFormatIEEEFloat, wavFormatExtensible:
+		if uint64(byteRate) != channels*sampleRate*bitsPerSample/8 {
+			return 0, errors.New("invalid wav byte rate")
+		}
+	default:
+		if byteRate == 0 || uint64(byteRate) > channels*sampleRate*wavMaxBitsPerSample/8 {
+			return 0, errors.New("invalid wav byte rate")
+		}
+	}
+	return byteRate, nil
+}
+
+// https://www.mmsp.ece.mcgill.ca/Documents/AudioFormats/WAVE/WAVE.html
+func getWavDuration(r io.ReaderAt, size int64) (float64, error) {
+	var byteRate uint32
+	offset := int64(12)
+	for offset+8 <= size {
+		header, err := readAt(r, offset, 8)
+		if err != nil {
+			return 0, err
+		}
+		chunkSize := int64(binary.LittleEndian.Uint32(header[4:8]))
+		switch string(header[0:4]) {
+		case "fmt ":
+			fmtChunk, err := readAt(r, offset+8, 16)
+			if err != nil {
+				return 0, err
+			}
+			byteRate, err = checkWavFormat(fmtChunk)
+			if err != nil {
+				return 0, err
+			}
+		case "data":
+			if byteRate == 0 {
+				return 0, errors.New("wav data chunk before fmt chunk")
+			}
+			// the size of a streamed file is unknown and left at 0 or 0xFFFFFFFF
+			if chunkSize == 0 || offset+8+chunkSize > size {
+				chunkSize = size - offset - 8
+			}
+			return float64(chunkSize) / float64(byteRate), nil
+		}
+		// chunks are padded to an even size
+		offset += 8 + chunkSize + chunkSize%2
+	}
+	return 0, errors.New("wav data chunk not found")
+}
+
+var (
+	mp3BitRatesV1 = [16]int{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0}
+	mp3BitRatesV2 = [16]int{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0}
+	mp3SampleRate = [4]int{44100, 48000, 32000, 0}
+)
+
+// getMp3Duration reads the frame count of the Xing or VBRI header of a VBR file, and otherwise
+// assumes a constant bit rate. Only layer III is supported.
+//
+// http://www.mp3-tech.org/programmer/frame_header.html
+func getMp3Duration(r io.ReaderAt, size int64) (float64, error) {
+	offset := int64(0)
+	id3, err := readAt(r, 0, 10)
+	if err != nil {
+		return 0, err
+	}
+	if bytes.Equal(id3[0:3], []byte("ID3")) {
+		// the size of the tag is a syncsafe integer
+		tagSize := int64(id3[6]&0x7F)<<21 | int64(id3[7]&0x7F)<<14 | int64(id3[8]&0x7F)<<7 | int64(id3[9]&0x7F)
+		offset = 10 + tagSize
+		if id3[5]&0x10 != 0 {
+			offset += 10
+		}
+	}
+	// look for the first frame in the next 64KB, as some encoders pad the tag
+	buf := make([]byte, 64*1024)
+	n, _ := r.ReadAt(buf, offset)
+	buf = buf[:n]
+	for i := 0; i+4 <= len(buf); i++ {
+		if buf[i] != 0xFF || buf[i+1]&0xE0 != 0xE0 {
+			continue
+		}
+		version := (buf[i+1] >> 3) & 0x03 // 0: MPEG 2.5, 2: MPEG 2, 3: MPEG 1
+		layer := (buf[i+1] >> 1) & 0x03   // 1: layer III
+		bitRateIndex := buf[i+2] >> 4
+		sampleRateIndex := (buf[i+2] >> 2) & 0x03
+		if version == 1 || layer != 1 || bitRateIndex == 0 || bitRateIndex == 15 || sampleRateIndex == 3 {
+			continue
+		}
+		bitRate := mp3BitRatesV1[bitRateIndex]
+		sampleRate := mp3SampleRate[sampleRateIndex]
+		samplesPerFrame := 1152
+		sideInfoSize := 32
+		mono := buf[i+3]>>6 == 3
+		if mono {
+			sideInfoSize = 17
+		}
+		if version != 3 {
+			bitRate = mp3BitRatesV2[bitRateIndex]
+			sampleRate /= 2
+			samplesPerFrame = 576
+			sideInfoSize = 17
+			if mono {
+				sideInfoSize = 9
+			}
+		}
+		if version == 0 {
+			sampleRate /= 2
+		}
+		if frames := getMp3FrameCount(buf[i:], sideInfoSize); frames > 0 {
+			return float64(frames) * float64(samplesPerFrame) / float64(sampleRate), nil
+		}
+		return float64(size-offset-int64(i)) * 8 / float64(bitRate*1000), nil
+	}
+	return 0, errors.New("mp3 frame not found")
+}
+
+func getMp3FrameCount(frame []byte, sideInfoSize int) uint32 {
+	xing := 4 + sideInfoSize
+	if len(frame) >= xing+12 && (bytes.Equal(frame[xing:xing+4], []byte("Xing")) || bytes.Equal(frame[xing:xing+4], []byte("Info"))) {
+		if binary.BigEndian.Uint32(frame[xing+4:xing+8])&0x01 != 0 {
+			return binary.BigEndian.Uint32(frame[xing+8 : xing+12])
+		}
+		return 0
+	}
+	// the VBRI header is always 32 bytes after the frame header
+	if len(frame) >= 36+18 && bytes.Equal(frame[36:40], []byte("VBRI")) {
+		return binary.BigEndian.Uint32(frame[50:54])
+	}
+	return 0
+}
+
+// getMp4Duration reads the duration of the movie header box
+//
+// https://developer.apple.com/documentation/quicktime-file-format/movie_header_atom
+func getMp4Duration(r io.ReaderAt, size int64) (float64, error) {
+	offset, end := int64(0), size
+	for offset+8 <= end {
+		header, err := readAt(r, offset, 8)
+		if err != nil {
+			return 0, err
+		}
+		boxSize := int64(binary.BigEndian.Uint32(header[0:4]))
+		headerSize := int64(8)
+		if boxSize == 1 {
+			largeSize, err := readAt(r, offset+8, 8)
+			if err != nil {
+				return 0, err
+			}
+			boxSize = int64(binary.BigEndian.Uint64(largeSize))
+			headerSize = 16
+		} else if boxSize == 0 {
+			boxSize = end - offset
+		}
+		if boxSize < headerSize {
+			return 0, errors.New("invalid mp4 box size")
+		}
+		switch string(header[4:8]) {
+		case "moov":
+			// look for mvhd in the children of moov
+			end = offset + boxSize
+			offset += headerSize
+			continue
+		case "mvhd":
+			box, err := readAt(r, offset+headerSize, 32)
+			if err != nil {
+				return 0, err
+			}
+			var timescale uint32
+			var duration uint64
+			if box[0] == 1 {
+				timescale = binary.BigEndian.Uint32(box[20:24])
+				duration = binary.BigEndian.Uint64(box[24:32])
+			} else {
+				timescale = binary.BigEndian.Uint32(box[12:16])
+				duration = uint64(binary.BigEndian.Uint32(box[16:20]))
+			}
+			if timescale == 0 {
+				return 0, errors.New("invalid mp4 timescale")
+			}
+			return float64(duration) / float64(timescale), nil
+		}
+		offset += boxSize
+	}
+	return 0, errors.New("mp4 mvhd box not found")
+}
+
+const (
+	ebmlIdSegment       = 0x18538067
+	ebmlIdInfo          = 0x1549A966
+	ebmlIdTimecodeScale = 0x2AD7B1
+	ebmlIdDuration      = 0x4489
+	ebmlIdCluster       = 0x1F43B675
+	ebmlIdTimecode      = 0xE7
+	ebmlIdSimpleBlock   = 0xA3
+	ebmlIdBlockGroup    = 0xA0
+	ebmlIdBlock         = 0xA1
+)
+
+// readEbmlVint reads a variable size integer, keeping the length marker for the ids. The size of
+// an element of unknown size is reported as -1.
+func readEbmlVint(r io.ReaderAt, offset int64, keepMarker bool) (value int64, length int, err error) {
+	first, err := readAt(r, offset, 1)
+	if err != nil {
+		return 0, 0, err
+	}
+	length = 1
+	for mask := byte(0x80); first[0]&mask == 0; mask >>= 1 {
+		length++
+		if length > 8 {
+			return 0, 0, errors.New("invalid ebml vint")
+		}
+	}
+	buf, err := readAt(r, offset, length)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !keepMarker {
+		buf[0] &= 0xFF >> length
+	}
+	allOnes := buf[0] == 0xFF>>length
+	for _, b := range buf {
+		value = value<<8 | int64(b)
+	}
+	for _, b := range buf[1:] {
+		allOnes = allOnes && b == 0xFF
+	}
+	if !keepMarker && allOnes {
+		value = -1
+	}
+	return value, length, nil
+}
+
+// ebmlMaxUintSize is the largest size of the unsigned integer and float elements
+const ebmlMaxUintSize = 8
+
+// readEbmlUintAt reads an unsigned integer element, its size is checked before anything is read
+func readEbmlUintAt(r io.ReaderAt, offset int64, size int64) (int64, error) {
+	if size > ebmlMaxUintSize {
+		return 0, errors.New("invalid ebml integer size")
+	}
+	data, err := readAt(r, offset, int(size))
+	if err != nil {
+		return 0, err
+	}
+	return readEbmlUint(data), nil
+}
+
+func readEbmlUint(data []byte) int64 {
+	var value int64
+	for _, b := range data {
+		value = value<<8 | int64(b)
+	}
+	return value
+}
+
+// getWebmDuration reads the duration of the segment info. The files recorded by the browsers
+// have none, so the timecode of the last block is used then.
+//
+// https://www.matroska.org/technical/elements.html
+func getWebmDuration(r io.ReaderAt, size int64) (float64, error) {
+	timecodeScale := int64(1000000)
+	var clusterTimecode, lastTimecode int64
+	offset := int64(0)
+	for offset < size {
+		id, idLength, err := readEbmlVint(r, offset, true)
+		if err != nil {
+			return 0, err
+		}
+		dataSize, sizeLength, err := readEbmlVint(r, offset+int64(idLength), false)
+		if err != nil {
+			return 0, err
+		}
+		dataOffset := offset + int64(idLength+sizeLength)
+		switch id {
+		case ebmlIdSegment, ebmlIdCluster, ebmlIdBlockGroup:
+			// the children follow, whether the size is known or not
+			offset = dataOffset
+			continue
+		}
+		if dataSize < 0 || dataOffset+dataSize > size {
+			break
+		}
+		switch id {
+		case ebmlIdInfo:
+			duration, scale, err := getWebmInfo(r, dataOffset, dataSize)
+			if err != nil {
+				return 0, err
+			}
+			if scale > 0 {
+				timecodeScale = scale
+			}
+			if duration > 0 {
+				return duration * float64(timecodeScale) / 1e9, nil
+			}
+		case ebmlIdTimecode:
+			clusterTimecode, err = readEbmlUintAt(r, dataOffset, dataSize)
+			if err != nil {
+				return 0, err
+			}
+		case ebmlIdSimpleBlock, ebmlIdBlock:
+			// the track number is followed by the timecode relative to the cluster
+			_, trackLength, err := readEbmlVint(r, dataOffset, false)
+			if err != nil {
+				return 0, err
+			}
+			data, err := readAt(r, dataOffset+int64(trackLength), 2)
+			if err != nil {
+				return 0, err
+			}
+			if timecode := clusterTimecode + int64(int16(binary.BigEndian.Uint16(data))); timecode > lastTimecode {
+				lastTimecode = timecode
+			}
+		}
+		offset = dataOffset + dataSize
+	}
+	if lastTimecode == 0 {
+		return 0, errors.New("webm duration not found")
+	}
+	return float64(lastTimecode) * float64(timecodeScale) / 1e9, nil
+}
+
+// getWebmInfo reads the children of the segment info, each of them must fit in it
+func getWebmInfo(r io.ReaderAt, offset int64, size int64) (duration float64, timecodeScale int64, err error) {
+	end := offset + size
+	for offset < end {
+		id, idLength, err := readEbmlVint(r, offset, true)
+		if err != nil {
+			return 0, 0, err
+		}
+		dataSize, sizeLength, err := readEbmlVint(r, offset+int64(idLength), false)
+		if err != nil {
+			return 0, 0, err
+		}
+		dataOffset := offset + int64(idLength+sizeLength)
+		if dataSize < 0 || dataOffset+dataSize > end {
+			return 0, 0, errors.New("invalid webm info")
+		}
+		switch id {
+		case ebmlIdTimecodeScale:
+			timecodeScale, err = readEbmlUintAt(r, dataOffset, dataSize)
+			if err != nil {
+				return 0, 0, err
+			}
+		case ebmlIdDuration:
+			if dataSize > ebmlMaxUintSize {
+				return 0, 0, errors.New("invalid webm duration size")
+			}
+			data, err := readAt(r, dataOffset, int(dataSize))
+			if err != nil {
+				return 0, 0, err
+			}
+			switch dataSize {
+			case 4:
+				duration = float64(math.Float32frombits(binary.BigEndian.Uint32(data)))
+			case 8:
+				duration = math.Float64frombits(binary.BigEndian.Uint64(data))
+			}
+		}
+		offset = dataOffset + dataSize
+	}
+	return duration, timecodeScale, nil
+}
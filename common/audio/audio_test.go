@@ -0,0 +1,115 @@
+package audio_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/songquanpeng/one-api/common/audio"
+	"github.com/stretchr/testify/assert"
+)
+
+func getDuration(t *testing.T, data []byte) float64 {
+	duration, err := audio.GetDuration(bytes.NewReader(data), int64(len(data)))
+	assert.NoError(t, err)
+	return duration
+}
+
+func wav(byteRate uint32) []byte {
+	// 16kHz mono 16 bit pcm, 2.5 seconds
+	data := &bytes.Buffer{}
+	dataSize := uint32(16000 * 2 * 5 / 2)
+	data.WriteString("RIFF")
+	_ = binary.Write(data, binary.LittleEndian, 36+dataSize)
+	data.WriteString("WAVEfmt ")
+	_ = binary.Write(data, binary.LittleEndian, []uint32{16})
+	_ = binary.Write(data, binary.LittleEndian, []uint16{1, 1})
+	_ = binary.Write(data, binary.LittleEndian, []uint32{16000, byteRate})
+	_ = binary.Write(data, binary.LittleEndian, []uint16{2, 16})
+	data.WriteString("data")
+	_ = binary.Write(data, binary.LittleEndian, dataSize)
+	data.Write(make([]byte, dataSize))
+	return data.Bytes()
+}
+
+func TestWav(t *testing.T) {
+	assert.InDelta(t, 2.5, getDuration(t, wav(32000)), 0.001)
+
+	// a forged byte rate would shorten the billed duration
+	forged := wav(0x7FFFFFFF)
+	_, err := audio.GetDuration(bytes.NewReader(forged), int64(len(forged)))
+	assert.Error(t, err)
+}
+
+func TestMp3(t *testing.T) {
+	// MPEG 1 layer III, 128kbps, 44.1kHz, stereo
+	frame := []byte{0xFF, 0xFB, 0x90, 0x00}
+	cbr := append([]byte("ID3\x04\x00\x00\x00\x00\x00\x00"), frame...)
+	cbr = append(cbr, make([]byte, 16000*3-4)...)
+	assert.InDelta(t, 3, getDuration(t, cbr), 0.001)
+
+	// a Xing header with 1000 frames of 1152 samples
+	vbr := append([]byte{}, frame...)
+	vbr = append(vbr, make([]byte, 32)...)
+	vbr = append(vbr, []byte("Xing\x00\x00\x00\x01")...)
+	vbr = binary.BigEndian.AppendUint32(vbr, 1000)
+	vbr = append(vbr, make([]byte, 1000)...)
+	assert.InDelta(t, 1000*1152/44100.0, getDuration(t, vbr), 0.001)
+}
+
+func box(boxType string, payload []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(payload)))
+	return append(append(b, boxType...), payload...)
+}
+
+func TestM4a(t *testing.T) {
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[12:16], 44100)
+	binary.BigEndian.PutUint32(mvhd[16:20], 44100*7)
+	data := box("ftyp", []byte("M4A \x00\x00\x00\x00"))
+	data = append(data, box("mdat", make([]byte, 64))...)
+	data = append(data, box("moov", box("mvhd", mvhd))...)
+	assert.InDelta(t, 7, getDuration(t, data), 0.001)
+}
+
+func element(id []byte, payload []byte) []byte {
+	return append(append(append([]byte{}, id...), 0x01, 0, 0, 0, 0, 0, 0, byte(len(payload))), payload...)
+}
+
+func TestWebm(t *testing.T) {
+	header := element([]byte{0x1A, 0x45, 0xDF, 0xA3}, []byte{0x42, 0x82, 0x84, 'w', 'e', 'b', 'm'})
+	duration := binary.BigEndian.AppendUint64(nil, math.Float64bits(4200))
+	info := element([]byte{0x15, 0x49, 0xA9, 0x66}, append(element([]byte{0x2A, 0xD7, 0xB1}, []byte{0x0F, 0x42, 0x40}), element([]byte{0x44, 0x89}, duration)...))
+	data := append(header, element([]byte{0x18, 0x53, 0x80, 0x67}, info)...)
+	assert.InDelta(t, 4.2, getDuration(t, data), 0.001)
+
+	// recorded by a browser, without a duration and with clusters of unknown size
+	unknownSize := []byte{0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+	info = element([]byte{0x15, 0x49, 0xA9, 0x66}, element([]byte{0x2A, 0xD7, 0xB1}, []byte{0x0F, 0x42, 0x40}))
+	recorded := append(append([]byte{}, header...), 0x18, 0x53, 0x80, 0x67)
+	recorded = append(recorded, unknownSize...)
+	recorded = append(recorded, info...)
+	for _, clusterTimecode := range []uint16{0, 5000} {
+		recorded = append(recorded, 0x1F, 0x43, 0xB6, 0x75)
+		recorded = append(recorded, unknownSize...)
+		recorded = append(recorded, element([]byte{0xE7}, binary.BigEndian.AppendUint16(nil, clusterTimecode))...)
+		recorded = append(recorded, element([]byte{0xA3}, []byte{0x81, 0x03, 0xE8, 0x80, 0x00})...)
+	}
+	assert.InDelta(t, 6, getDuration(t, recorded), 0.001)
+
+	// the declared sizes are checked before anything is allocated
+	hugeScale := append([]byte{0x2A, 0xD7, 0xB1, 0x01}, 0, 0, 0x01, 0, 0, 0, 0, 0)
+	forged := append(append([]byte{}, header...), element([]byte{0x18, 0x53, 0x80, 0x67}, element([]byte{0x15, 0x49, 0xA9, 0x66}, hugeScale))...)
+	_, err := audio.GetDuration(bytes.NewReader(forged), int64(len(forged)))
+	assert.Error(t, err)
+	wideScale := element([]byte{0x2A, 0xD7, 0xB1}, make([]byte, 16))
+	forged = append(append([]byte{}, header...), element([]byte{0x18, 0x53, 0x80, 0x67}, element([]byte{0x15, 0x49, 0xA9, 0x66}, wideScale))...)
+	_, err = audio.GetDuration(bytes.NewReader(forged), int64(len(forged)))
+	assert.Error(t, err)
+}
+
+func TestUnsupported(t *testing.T) {
+	_, err := audio.GetDuration(bytes.NewReader([]byte("OggS\x00\x02")), 6)
+	assert.ErrorIs(t, err, audio.ErrUnsupportedFormat)
+}
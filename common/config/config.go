@@ -40,6 +40,7 @@ var OidcEnabled = false
 var WeChatAuthEnabled = false
 var TurnstileCheckEnabled = false
 var RegisterEnabled = true
+var AdminTwoFactorRequired = false // admins cannot use the admin routes before enabling two-factor authentication
 
 var EmailDomainRestrictionEnabled = false
 var EmailDomainWhitelist = []string{
@@ -84,6 +85,13 @@ var OidcWellKnown = ""
 var OidcAuthorizationEndpoint = ""
 var OidcTokenEndpoint = ""
 var OidcUserinfoEndpoint = ""
+var OidcScopes = "openid profile email"
+var OidcClaim = ""                         // claim, such as groups, roles or realm_access.roles, mapped to the group and role at each login
+var OidcGroupMapping = map[string]string{} // claim value -> group
+var OidcRoleMapping = map[string]string{}  // claim value -> role, common or admin
+
+var ScimToken = ""                         // bearer token of the SCIM endpoint, empty disables it
+var ScimGroupMapping = map[string]string{} // identity provider group -> group
 
 var WeChatServerAddress = ""
 var WeChatServerToken = ""
@@ -108,6 +116,23 @@ var RetryTimes = 0
 
 var RootUserEmail = ""
 
+// anomaly detection compares the spend of each token and user in an hour with its hourly average of the
+// last week and with the hourly limits, AnomalyPolicy maps the type of an anomaly to the action taken
+var AnomalyDetectionEnabled = false
+var AnomalySpikeFactor = 10.0
+var AnomalySpikeMinQuota int64 = 2500000   // spends below it are never spikes
+var AnomalyTokenHourlyQuotaLimit int64 = 0 // 0 means no limit
+var AnomalyUserHourlyQuotaLimit int64 = 0  // 0 means no limit
+var AnomalyPolicy = map[string]string{}    // anomaly type -> alert, disable_token, ban_user or none
+
+var AnomalyCheckInterval = env.Int("ANOMALY_CHECK_INTERVAL", 300) // unit is second
+var TokenIpRetentionDays = env.Int("TOKEN_IP_RETENTION_DAYS", 30) // the addresses of the tokens not seen for longer are forgotten
+
+// the reconciliation alerts when the upstream balance a channel spent in a day exceeds the quota billed on it
+// by more than the ratio and by at least the amount in USD
+var ChannelReconcileAlertRatio = 0.2
+var ChannelReconcileAlertMinUSD = 1.0
+
 var IsMasterNode = os.Getenv("NODE_TYPE") != "slave"
 
 var requestInterval, _ = strconv.Atoi(os.Getenv("POLLING_INTERVAL"))
@@ -117,8 +142,36 @@ var SyncFrequency = env.Int("SYNC_FREQUENCY", 10*60) // unit is second
 
 var BatchUpdateEnabled = false
 var BatchUpdateInterval = env.Int("BATCH_UPDATE_INTERVAL", 5)
-
-var RelayTimeout = env.Int("RELAY_TIMEOUT", 0) // unit is second
+var BatchUpdateMaxSize = env.Int("BATCH_UPDATE_MAX_SIZE", 1000)             // pending entries triggering a flush before the interval
+var ChannelKeyCooldownSeconds = env.Int("CHANNEL_KEY_COOLDOWN_SECONDS", 60) // unit is second, for multi-key channels
+var ChannelLimitQueueTimeout = env.Int("CHANNEL_LIMIT_QUEUE_TIMEOUT", 0)    // unit is second, 0 means a channel at its limit is skipped
+var ChannelLimitQueueSize = env.Int("CHANNEL_LIMIT_QUEUE_SIZE", 100)        // requests waiting per channel and instance
+
+var RelayTimeout = env.Int("RELAY_TIMEOUT", 0)        // unit is second
+var ShutdownTimeout = env.Int("SHUTDOWN_TIMEOUT", 30) // unit is second, in flight requests are waited for before the buffers are flushed
+
+// expiring logs are archived before deletion when LOG_ARCHIVE_SINK is "local" or "s3"
+var LogArchiveSink = env.String("LOG_ARCHIVE_SINK", "")
+var LogArchiveDir = env.String("LOG_ARCHIVE_DIR", "./archive")
+var LogArchiveS3Endpoint = env.String("LOG_ARCHIVE_S3_ENDPOINT", "") // such as https://s3.us-east-1.amazonaws.com or http://localhost:9000 for MinIO
+var LogArchiveS3Region = env.String("LOG_ARCHIVE_S3_REGION", "us-east-1")
+var LogArchiveS3Bucket = env.String("LOG_ARCHIVE_S3_BUCKET", "")
+var LogArchiveS3AccessKey = env.String("LOG_ARCHIVE_S3_ACCESS_KEY", "")
+var LogArchiveS3SecretKey = env.String("LOG_ARCHIVE_S3_SECRET_KEY", "")
+
+// asynchronous tasks are polled until they finish, and fail with a refund after TASK_TIMEOUT.
+// Their results are downloaded to TASK_RESULT_DIR when it is set, the upstream urls are passed through otherwise.
+var TaskPollInterval = env.Int("TASK_POLL_INTERVAL", 15) // unit is second
+var TaskTimeout = env.Int("TASK_TIMEOUT", 24*3600)       // unit is second
+var TaskResultDir = env.String("TASK_RESULT_DIR", "")
+var TaskResultMaxSize = env.Int("TASK_RESULT_MAX_SIZE", 1024) // unit is MB
+
+// RealtimeMaxMessageSize limits the size of a message the clients of a realtime session may send
+var RealtimeMaxMessageSize = env.Int("REALTIME_MAX_MESSAGE_SIZE", 16) // unit is MB
+
+// the export schedules write their files into EXPORT_DIR, they are not run when it is empty
+var ExportDir = env.String("EXPORT_DIR", "")
+var ExportCheckInterval = env.Int("EXPORT_CHECK_INTERVAL", 600) // unit is second
 
 var GeminiSafetySetting = env.String("GEMINI_SAFETY_SETTING", "BLOCK_NONE")
 
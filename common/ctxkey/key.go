@@ -20,6 +20,7 @@ const (
 	TokenName         = "token_name"
 	BaseURL           = "base_url"
 	AvailableModels   = "available_models"
+	TokenModelQuotas  = "token_model_quotas"
 	KeyRequestBody    = "key_request_body"
 	SystemPrompt      = "system_prompt"
 	Surfing           = "surfing"
@@ -27,4 +28,8 @@ const (
 	Thinking          = "thinking"
 	ThinkingContext   = "thinking_context"
 	NoThinking        = "no_thinking"
+	RoutingChannelIds = "routing_channel_ids"
+	VirtualModel      = "virtual_model"
+	ChannelKeyHash    = "channel_key_hash"
+	ManagementKeyId   = "management_key_id"
 )
@@ -13,6 +13,8 @@ var (
 	PrintHelp    = flag.Bool("help", false, "print help and exit")
 	LogDir       = flag.String("log-dir", "./logs", "specify the log directory")
 	EnvFile      = flag.String("env-file", "", "env file dir")
+
+	ReencryptChannels = flag.Bool("reencrypt-channels", false, "encrypt all channel credentials under the current master key and exit")
 )
 
 func init() {
@@ -3,4 +3,6 @@ package helper
 const (
 	RequestIdKey = "X-Aihubmix-Request-Id"
 	StartTimeKey = "X-Aihubmix-Start-Time"
+	// ServedModelKey is the response header reporting the real model behind a virtual model
+	ServedModelKey = "X-Aihubmix-Served-Model"
 )
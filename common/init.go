@@ -15,7 +15,7 @@ func printHelp() {
 	fmt.Println("AiHubMix " + Version + " - All in one API service for OpenAI API.")
 	fmt.Println("Copyright (C) 2023 AiHubMix. All rights reserved.")
 	fmt.Println("GitHub: https://github.com/euansu/AIHubMix")
-	fmt.Println("Usage: one-api [--port <port>] [--log-dir <log directory>] [--version] [--help]")
+	fmt.Println("Usage: one-api [--port <port>] [--log-dir <log directory>] [--version] [--help] [--reencrypt-channels]")
 }
 
 func Init() {
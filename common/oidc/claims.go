@@ -0,0 +1,41 @@
+package oidc
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ClaimValues returns the values of the claim at path, a dot separated path into nested objects
+// such as realm_access.roles. A string claim may hold several values separated by spaces or commas.
+func ClaimValues(claims map[string]any, path string) []string {
+	if path == "" {
+		return nil
+	}
+	var value any = claims
+	for _, name := range strings.Split(path, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = object[name]
+	}
+	switch v := value.(type) {
+	case string:
+		return strings.FieldsFunc(v, func(r rune) bool {
+			return r == ' ' || r == ','
+		})
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			} else if item != nil {
+				values = append(values, fmt.Sprint(item))
+			}
+		}
+		return values
+	case []string:
+		return v
+	}
+	return nil
+}
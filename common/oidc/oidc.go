@@ -0,0 +1,219 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// the discovery document is fetched again after this duration
+const discoveryTTL = time.Hour
+
+// the key set is fetched again for an unknown key id at most once per interval, so that
+// rotated keys are picked up without letting forged key ids hammer the provider
+const jwksRefreshInterval = time.Minute
+
+var httpClient = &http.Client{Timeout: 5 * time.Second}
+
+// Provider is the part of the discovery document of an OpenID provider used for login
+type Provider struct {
+	Issuer                        string   `json:"issuer"`
+	AuthorizationEndpoint         string   `json:"authorization_endpoint"`
+	TokenEndpoint                 string   `json:"token_endpoint"`
+	UserinfoEndpoint              string   `json:"userinfo_endpoint"`
+	JwksUri                       string   `json:"jwks_uri"`
+	CodeChallengeMethodsSupported []string `json:"code_challenge_methods_supported"`
+
+	fetchedAt time.Time
+}
+
+type keySet struct {
+	keys      map[string]any
+	fetchedAt time.Time
+}
+
+var (
+	lock      sync.Mutex
+	providers = make(map[string]*Provider)
+	keySets   = make(map[string]*keySet)
+)
+
+func getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned status %d", url, resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Discover returns the provider described by the .well-known/openid-configuration document at wellKnown
+func Discover(ctx context.Context, wellKnown string) (*Provider, error) {
+	lock.Lock()
+	provider, ok := providers[wellKnown]
+	lock.Unlock()
+	if ok && time.Since(provider.fetchedAt) < discoveryTTL {
+		return provider, nil
+	}
+	provider = &Provider{}
+	if err := getJSON(ctx, wellKnown, provider); err != nil {
+		return nil, fmt.Errorf("failed to fetch the OIDC discovery document: %w", err)
+	}
+	if provider.Issuer == "" || provider.AuthorizationEndpoint == "" || provider.TokenEndpoint == "" || provider.JwksUri == "" {
+		return nil, errors.New("the OIDC discovery document misses issuer, authorization_endpoint, token_endpoint or jwks_uri")
+	}
+	provider.fetchedAt = time.Now()
+	lock.Lock()
+	providers[wellKnown] = provider
+	lock.Unlock()
+	return provider, nil
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// ParseJWKS returns the RSA and EC signing keys of a JSON Web Key Set by key id
+func ParseJWKS(data []byte) (map[string]any, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]any, len(set.Keys))
+	for _, key := range set.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		publicKey, err := key.publicKey()
+		if err != nil {
+			// a key of an unsupported type does not prevent the others from being used
+			continue
+		}
+		keys[key.Kid] = publicKey
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no usable signing key in the key set")
+	}
+	return keys, nil
+}
+
+func (p *Provider) keyFunc(ctx context.Context) jwt.Keyfunc {
+	return func(token *jwt.Token) (any, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA:
+		default:
+			return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+		}
+		kid, _ := token.Header["kid"].(string)
+		return p.getKey(ctx, kid)
+	}
+}
+
+func (p *Provider) getKey(ctx context.Context, kid string) (any, error) {
+	lock.Lock()
+	set, ok := keySets[p.JwksUri]
+	lock.Unlock()
+	if ok {
+		if key, found := findKey(set.keys, kid); found {
+			return key, nil
+		}
+		if time.Since(set.fetchedAt) < jwksRefreshInterval {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+	}
+	var raw json.RawMessage
+	if err := getJSON(ctx, p.JwksUri, &raw); err != nil {
+		return nil, fmt.Errorf("failed to fetch the OIDC key set: %w", err)
+	}
+	keys, err := ParseJWKS(raw)
+	if err != nil {
+		return nil, err
+	}
+	lock.Lock()
+	keySets[p.JwksUri] = &keySet{keys: keys, fetchedAt: time.Now()}
+	lock.Unlock()
+	if key, found := findKey(keys, kid); found {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// findKey returns the key of kid, a token without kid is accepted when the set has a single key
+func findKey(keys map[string]any, kid string) (any, bool) {
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key, true
+		}
+	}
+	key, ok := keys[kid]
+	return key, ok
+}
+
+// VerifyIDToken checks the signature, issuer, audience, expiry and nonce of the id token and returns its claims
+func (p *Provider) VerifyIDToken(ctx context.Context, rawIDToken string, clientId string, nonce string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(rawIDToken, claims, p.keyFunc(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("invalid id_token: %w", err)
+	}
+	if !claims.VerifyIssuer(p.Issuer, true) {
+		return nil, errors.New("invalid id_token: unexpected issuer")
+	}
+	if !claims.VerifyAudience(clientId, true) {
+		return nil, errors.New("invalid id_token: unexpected audience")
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, errors.New("invalid id_token: missing exp")
+	}
+	if nonce == "" {
+		return nil, errors.New("invalid id_token: no nonce to check")
+	}
+	if claimNonce, _ := claims["nonce"].(string); claimNonce != nonce {
+		return nil, errors.New("invalid id_token: unexpected nonce")
+	}
+	return claims, nil
+}
+
+// NewCodeVerifier returns a PKCE code verifier, also suitable as a nonce
+func NewCodeVerifier() string {
+	data := make([]byte, 32)
+	_, _ = rand.Read(data)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// CodeChallenge returns the S256 PKCE code challenge of the verifier
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
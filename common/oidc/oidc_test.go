@@ -0,0 +1,101 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+func TestVerifyIDToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks, _ := json.Marshal(map[string]any{"keys": []map[string]string{{
+		"kid": "k1",
+		"kty": "RSA",
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(jwks)
+	}))
+	defer server.Close()
+	provider := &Provider{Issuer: "https://idp.example.com", JwksUri: server.URL}
+
+	sign := func(claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "k1"
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	claims := jwt.MapClaims{
+		"iss":   "https://idp.example.com",
+		"aud":   []string{"client"},
+		"sub":   "alice",
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"nonce": "n1",
+	}
+	verified, err := provider.VerifyIDToken(context.Background(), sign(claims), "client", "n1")
+	if err != nil || verified["sub"] != "alice" {
+		t.Fatalf("expected a valid token, got %v %v", verified, err)
+	}
+	if _, err = provider.VerifyIDToken(context.Background(), sign(claims), "client", "n2"); err == nil {
+		t.Fatal("expected a nonce mismatch to be rejected")
+	}
+	if _, err = provider.VerifyIDToken(context.Background(), sign(claims), "client", ""); err == nil {
+		t.Fatal("expected a login without a nonce to be rejected")
+	}
+	if _, err = provider.VerifyIDToken(context.Background(), sign(claims), "other", "n1"); err == nil {
+		t.Fatal("expected another audience to be rejected")
+	}
+	claims["exp"] = time.Now().Add(-time.Minute).Unix()
+	if _, err = provider.VerifyIDToken(context.Background(), sign(claims), "client", "n1"); err == nil {
+		t.Fatal("expected an expired token to be rejected")
+	}
+	hmacToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+	if _, err = provider.VerifyIDToken(context.Background(), hmacToken, "client", "n1"); err == nil {
+		t.Fatal("expected a symmetric signature to be rejected")
+	}
+}
+
+func TestClaimValues(t *testing.T) {
+	claims := map[string]any{
+		"groups":       []any{"eng", "ops"},
+		"role":         "admin, billing",
+		"realm_access": map[string]any{"roles": []any{"viewer"}},
+	}
+	if values := ClaimValues(claims, "groups"); !reflect.DeepEqual(values, []string{"eng", "ops"}) {
+		t.Fatalf("unexpected groups %v", values)
+	}
+	if values := ClaimValues(claims, "role"); !reflect.DeepEqual(values, []string{"admin", "billing"}) {
+		t.Fatalf("unexpected role %v", values)
+	}
+	if values := ClaimValues(claims, "realm_access.roles"); !reflect.DeepEqual(values, []string{"viewer"}) {
+		t.Fatalf("unexpected nested roles %v", values)
+	}
+	if values := ClaimValues(claims, "missing.roles"); values != nil {
+		t.Fatalf("expected no values, got %v", values)
+	}
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// the example of RFC 7636 appendix B
+	if challenge := CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"); challenge != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Fatalf("unexpected challenge %s", challenge)
+	}
+}
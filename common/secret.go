@@ -0,0 +1,185 @@
+package common
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// 信封加密：每个值使用随机的数据密钥（DEK）加密，DEK 再由带版本号的主密钥加密。
+// 密文格式：enc:<主密钥版本>:<base64 加密后的 DEK>:<base64 加密后的数据>
+const secretPrefix = "enc:"
+
+// CHANNEL_SECRET_KEYS 形如 "1:<base64 32 字节密钥>,2:<base64 32 字节密钥>"，
+// CHANNEL_SECRET_KEY_VERSION 指定加密使用的版本，默认使用最大的版本
+var (
+	secretMasterKeys       = make(map[int][]byte)
+	SecretMasterKeyVersion = 0
+)
+
+func init() {
+	for _, item := range strings.Split(os.Getenv("CHANNEL_SECRET_KEYS"), ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 {
+			logger.FatalLog("invalid CHANNEL_SECRET_KEYS item: " + item)
+		}
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || version <= 0 {
+			logger.FatalLog("invalid CHANNEL_SECRET_KEYS version: " + parts[0])
+		}
+		key, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil || len(key) != 32 {
+			logger.FatalLog(fmt.Sprintf("CHANNEL_SECRET_KEYS version %d must be 32 bytes encoded in base64", version))
+		}
+		secretMasterKeys[version] = key
+		if version > SecretMasterKeyVersion {
+			SecretMasterKeyVersion = version
+		}
+	}
+	if v := os.Getenv("CHANNEL_SECRET_KEY_VERSION"); v != "" {
+		version, err := strconv.Atoi(v)
+		if err != nil || secretMasterKeys[version] == nil {
+			logger.FatalLog("CHANNEL_SECRET_KEY_VERSION does not match any key of CHANNEL_SECRET_KEYS")
+		}
+		SecretMasterKeyVersion = version
+	}
+}
+
+// SetSecretMasterKey registers a master key, the last registered version is used for encryption
+func SetSecretMasterKey(version int, key []byte) error {
+	if version <= 0 || len(key) != 32 {
+		return errors.New("master key must be 32 bytes with a positive version")
+	}
+	secretMasterKeys[version] = key
+	SecretMasterKeyVersion = version
+	return nil
+}
+
+func SecretEncryptionEnabled() bool {
+	return SecretMasterKeyVersion > 0
+}
+
+func IsEncryptedSecret(value string) bool {
+	return strings.HasPrefix(value, secretPrefix)
+}
+
+// SecretVersion returns the master key version of an encrypted value, 0 for plaintext
+func SecretVersion(value string) int {
+	if !IsEncryptedSecret(value) {
+		return 0
+	}
+	parts := strings.SplitN(strings.TrimPrefix(value, secretPrefix), ":", 2)
+	version, _ := strconv.Atoi(parts[0])
+	return version
+}
+
+func sealWithKey(key []byte, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func openWithKey(key []byte, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+}
+
+// EncryptSecret encrypts the value with a new data key under the current master key.
+// Empty values, already encrypted values and values without a configured master key are returned as is.
+func EncryptSecret(value string) (string, error) {
+	if value == "" || IsEncryptedSecret(value) || !SecretEncryptionEnabled() {
+		return value, nil
+	}
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+	wrappedKey, err := sealWithKey(secretMasterKeys[SecretMasterKeyVersion], dataKey)
+	if err != nil {
+		return "", err
+	}
+	data, err := sealWithKey(dataKey, []byte(value))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%d:%s:%s", secretPrefix, SecretMasterKeyVersion,
+		base64.StdEncoding.EncodeToString(wrappedKey), base64.StdEncoding.EncodeToString(data)), nil
+}
+
+// DecryptSecret returns the plaintext of an encrypted value, plaintext values are returned as is
+func DecryptSecret(value string) (string, error) {
+	if !IsEncryptedSecret(value) {
+		return value, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(value, secretPrefix), ":")
+	if len(parts) != 3 {
+		return "", errors.New("invalid encrypted secret")
+	}
+	version, _ := strconv.Atoi(parts[0])
+	masterKey, ok := secretMasterKeys[version]
+	if !ok {
+		return "", fmt.Errorf("master key version %d is not configured", version)
+	}
+	wrappedKey, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := openWithKey(masterKey, wrappedKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := openWithKey(dataKey, data)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// ReencryptSecret decrypts the value and encrypts it again under the current master key
+func ReencryptSecret(value string) (string, error) {
+	if SecretVersion(value) == SecretMasterKeyVersion {
+		return value, nil
+	}
+	plaintext, err := DecryptSecret(value)
+	if err != nil {
+		return "", err
+	}
+	return EncryptSecret(plaintext)
+}
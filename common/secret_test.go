@@ -0,0 +1,42 @@
+package common
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSecretRotation(t *testing.T) {
+	if err := SetSecretMasterKey(1, bytes.Repeat([]byte{1}, 32)); err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := EncryptSecret("sk-plaintext")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncryptedSecret(encrypted) || SecretVersion(encrypted) != 1 {
+		t.Fatalf("unexpected encrypted value %s", encrypted)
+	}
+	if again, _ := EncryptSecret(encrypted); again != encrypted {
+		t.Fatal("expected an encrypted value not to be encrypted twice")
+	}
+
+	if err = SetSecretMasterKey(2, bytes.Repeat([]byte{2}, 32)); err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := ReencryptSecret(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if SecretVersion(rotated) != 2 {
+		t.Fatalf("expected the value to move to version 2, got %s", rotated)
+	}
+	for _, value := range []string{encrypted, rotated, "sk-plaintext"} {
+		plaintext, err := DecryptSecret(value)
+		if err != nil || plaintext != "sk-plaintext" {
+			t.Fatalf("failed to decrypt %s: %q %v", value, plaintext, err)
+		}
+	}
+	if _, err = DecryptSecret("enc:3:AAAA:AAAA"); err == nil {
+		t.Fatal("expected an unknown master key version to fail")
+	}
+}
@@ -0,0 +1,40 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+func TestCodeAt(t *testing.T) {
+	// the SHA1 test vectors of RFC 6238 appendix B, truncated to 6 digits
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, want := range vectors {
+		code, err := CodeAt(secret, unix/Period)
+		if err != nil || code != want {
+			t.Fatalf("code at %d: got %s %v, want %s", unix, code, err, want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret := GenerateSecret()
+	now := time.Unix(1700000000, 0)
+	code, _ := CodeAt(secret, Step(now)-1)
+	step, ok := Validate(secret, code, now, 0)
+	if !ok || step != Step(now)-1 {
+		t.Fatal("expected the code of the previous period to be accepted")
+	}
+	if _, ok = Validate(secret, code, now, step); ok {
+		t.Fatal("expected a used code to be rejected")
+	}
+	if _, ok = Validate(secret, code, now.Add(2*Period*time.Second), 0); ok {
+		t.Fatal("expected an old code to be rejected")
+	}
+}
@@ -0,0 +1,113 @@
+package controller
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/model"
+)
+
+func getAuditLogQuery(c *gin.Context) *model.AuditLogQuery {
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	return &model.AuditLogQuery{
+		UserId:         userId,
+		Username:       c.Query("username"),
+		Action:         c.Query("action"),
+		TargetType:     c.Query("target_type"),
+		TargetId:       c.Query("target_id"),
+		StartTimestamp: startTimestamp,
+		EndTimestamp:   endTimestamp,
+	}
+}
+
+func GetAuditLogs(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	auditLogs, total, err := model.GetAuditLogs(getAuditLogQuery(c), p*config.ItemsPerPage, config.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"items": auditLogs,
+			"total": total,
+		},
+	})
+	return
+}
+
+// ExportAuditLogs streams the matching audit logs as CSV, or as JSONL with format=jsonl
+func ExportAuditLogs(c *gin.Context) {
+	query := getAuditLogQuery(c)
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "jsonl" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "不支持的导出格式",
+		})
+		return
+	}
+	filename := fmt.Sprintf("audit_log_%s.%s", time.Now().Format("20060102150405"), format)
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	var err error
+	if format == "jsonl" {
+		c.Header("Content-Type", "application/x-ndjson")
+		encoder := json.NewEncoder(c.Writer)
+		err = model.ExportAuditLogs(query, func(auditLogs []*model.AuditLog) error {
+			for _, auditLog := range auditLogs {
+				if err := encoder.Encode(auditLog); err != nil {
+					return err
+				}
+			}
+			c.Writer.Flush()
+			return nil
+		})
+	} else {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		writer := csv.NewWriter(c.Writer)
+		_ = writer.Write([]string{"id", "created_at", "user_id", "username", "ip", "action", "target_type", "target_id", "success", "request_id", "diff"})
+		err = model.ExportAuditLogs(query, func(auditLogs []*model.AuditLog) error {
+			for _, auditLog := range auditLogs {
+				err := writer.Write([]string{
+					strconv.Itoa(auditLog.Id),
+					time.Unix(auditLog.CreatedAt, 0).Format(time.RFC3339),
+					strconv.Itoa(auditLog.UserId),
+					auditLog.Username,
+					auditLog.Ip,
+					auditLog.Action,
+					auditLog.TargetType,
+					auditLog.TargetId,
+					strconv.FormatBool(auditLog.Success),
+					auditLog.RequestId,
+					auditLog.Diff,
+				})
+				if err != nil {
+					return err
+				}
+			}
+			writer.Flush()
+			return writer.Error()
+		})
+	}
+	if err != nil {
+		// the response is already started, the export is cut short
+		logger.SysError("failed to export audit logs: " + err.Error())
+	}
+}
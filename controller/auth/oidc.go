@@ -1,7 +1,7 @@
 package auth
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,10 +9,14 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/oidc"
+	"github.com/songquanpeng/one-api/common/random"
 	"github.com/songquanpeng/one-api/controller"
 	"github.com/songquanpeng/one-api/model"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -31,28 +35,130 @@ type OidcUser struct {
 	Name              string `json:"name"`
 	PreferredUsername string `json:"preferred_username"`
 	Picture           string `json:"picture"`
+
+	Claims map[string]any `json:"-"` // the claims of the id token and of the userinfo endpoint
 }
 
-func getOidcUserInfoByCode(code string) (*OidcUser, error) {
+const (
+	oidcCodeVerifierKey = "oidc_code_verifier"
+	oidcNonceKey        = "oidc_nonce"
+)
+
+// getOidcProvider returns the discovered provider, or the hand configured endpoints when no
+// well-known URL is set, in which case the id token cannot be verified and the userinfo endpoint is used alone
+func getOidcProvider(ctx context.Context) (*oidc.Provider, error) {
+	if config.OidcWellKnown != "" {
+		return oidc.Discover(ctx, config.OidcWellKnown)
+	}
+	if config.OidcAuthorizationEndpoint == "" || config.OidcTokenEndpoint == "" || config.OidcUserinfoEndpoint == "" {
+		return nil, errors.New("管理员未配置 OIDC Well-Known 地址或端点")
+	}
+	return &oidc.Provider{
+		AuthorizationEndpoint: config.OidcAuthorizationEndpoint,
+		TokenEndpoint:         config.OidcTokenEndpoint,
+		UserinfoEndpoint:      config.OidcUserinfoEndpoint,
+	}, nil
+}
+
+func getOidcRedirectUri() string {
+	return fmt.Sprintf("%s/oauth/oidc", config.ServerAddress)
+}
+
+// OidcLogin starts the login with PKCE and a nonce, and redirects to the authorization endpoint
+func OidcLogin(c *gin.Context) {
+	if !config.OidcEnabled {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "管理员未开启通过 OIDC 登录以及注册",
+		})
+		return
+	}
+	provider, err := getOidcProvider(c.Request.Context())
+	if err != nil {
+		logger.SysError("failed to get OIDC provider: " + err.Error())
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无法连接至 OIDC 服务器，请稍后重试！",
+		})
+		return
+	}
+	authorizationUrl, err := url.Parse(provider.AuthorizationEndpoint)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	state := random.GetRandomString(12)
+	verifier := oidc.NewCodeVerifier()
+	nonce := oidc.NewCodeVerifier()
+	session := sessions.Default(c)
+	session.Set("oauth_state", state)
+	session.Set(oidcCodeVerifierKey, verifier)
+	session.Set(oidcNonceKey, nonce)
+	if err = session.Save(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无法保存会话信息，请重试",
+		})
+		return
+	}
+	query := authorizationUrl.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", config.OidcClientId)
+	query.Set("redirect_uri", getOidcRedirectUri())
+	query.Set("scope", config.OidcScopes)
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", oidc.CodeChallenge(verifier))
+	query.Set("code_challenge_method", "S256")
+	authorizationUrl.RawQuery = query.Encode()
+	c.Redirect(http.StatusFound, authorizationUrl.String())
+}
+
+// popOidcSessionValue returns a value set by OidcLogin, each value is only used once
+func popOidcSessionValue(session sessions.Session, key string) string {
+	value, _ := session.Get(key).(string)
+	if value != "" {
+		session.Delete(key)
+		_ = session.Save()
+	}
+	return value
+}
+
+func getOidcUserInfoByCode(c *gin.Context, code string) (*OidcUser, error) {
 	if code == "" {
 		return nil, errors.New("无效的参数")
 	}
-	values := map[string]string{
-		"client_id":     config.OidcClientId,
-		"client_secret": config.OidcClientSecret,
-		"code":          code,
-		"grant_type":    "authorization_code",
-		"redirect_uri":  fmt.Sprintf("%s/oauth/oidc", config.ServerAddress),
-	}
-	jsonData, err := json.Marshal(values)
+	ctx := c.Request.Context()
+	provider, err := getOidcProvider(ctx)
 	if err != nil {
-		return nil, err
+		logger.SysError("failed to get OIDC provider: " + err.Error())
+		return nil, errors.New("无法连接至 OIDC 服务器，请稍后重试！")
+	}
+	session := sessions.Default(c)
+	verifier := popOidcSessionValue(session, oidcCodeVerifierKey)
+	nonce := popOidcSessionValue(session, oidcNonceKey)
+	if config.OidcWellKnown != "" && (verifier == "" || nonce == "") {
+		// the discovered providers are always signed in through OidcLogin
+		return nil, errors.New("OIDC 登录会话已失效，请重新登录")
+	}
+	values := url.Values{
+		"client_id":     {config.OidcClientId},
+		"client_secret": {config.OidcClientSecret},
+		"code":          {code},
+		"grant_type":    {"authorization_code"},
+		"redirect_uri":  {getOidcRedirectUri()},
 	}
-	req, err := http.NewRequest("POST", config.OidcTokenEndpoint, bytes.NewBuffer(jsonData))
+	if verifier != "" {
+		values.Set("code_verifier", verifier)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", provider.TokenEndpoint, strings.NewReader(values.Encode()))
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("Accept", "application/json")
 	client := http.Client{
 		Timeout: 5 * time.Second,
@@ -68,24 +174,92 @@ func getOidcUserInfoByCode(code string) (*OidcUser, error) {
 	if err != nil {
 		return nil, err
 	}
-	req, err = http.NewRequest("GET", config.OidcUserinfoEndpoint, nil)
-	if err != nil {
-		return nil, err
+	if res.StatusCode != http.StatusOK || oidcResponse.AccessToken == "" {
+		logger.SysError(fmt.Sprintf("OIDC token endpoint returned status %d", res.StatusCode))
+		return nil, errors.New("OIDC 授权码无效或已过期，请重新登录")
+	}
+	claims := make(map[string]any)
+	if provider.JwksUri != "" {
+		if oidcResponse.IDToken == "" {
+			return nil, errors.New("OIDC 服务器未返回 id_token")
+		}
+		idTokenClaims, err := provider.VerifyIDToken(ctx, oidcResponse.IDToken, config.OidcClientId, nonce)
+		if err != nil {
+			logger.SysError("failed to verify OIDC id_token: " + err.Error())
+			return nil, errors.New("OIDC id_token 校验失败")
+		}
+		claims = idTokenClaims
+	}
+	if provider.UserinfoEndpoint != "" {
+		req, err = http.NewRequestWithContext(ctx, "GET", provider.UserinfoEndpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+oidcResponse.AccessToken)
+		res2, err := client.Do(req)
+		if err != nil {
+			logger.SysLog(err.Error())
+			return nil, errors.New("无法连接至 OIDC 服务器，请稍后重试！")
+		}
+		defer res2.Body.Close()
+		userinfo := make(map[string]any)
+		err = json.NewDecoder(res2.Body).Decode(&userinfo)
+		if err != nil {
+			return nil, err
+		}
+		if sub, ok := claims["sub"]; ok && userinfo["sub"] != sub {
+			return nil, errors.New("OIDC userinfo 与 id_token 的用户不一致")
+		}
+		for name, value := range userinfo {
+			claims[name] = value
+		}
 	}
-	req.Header.Set("Authorization", "Bearer "+oidcResponse.AccessToken)
-	res2, err := client.Do(req)
+	data, err := json.Marshal(claims)
 	if err != nil {
-		logger.SysLog(err.Error())
-		return nil, errors.New("无法连接至 OIDC 服务器，请稍后重试！")
+		return nil, err
 	}
 	var oidcUser OidcUser
-	err = json.NewDecoder(res2.Body).Decode(&oidcUser)
+	err = json.Unmarshal(data, &oidcUser)
 	if err != nil {
 		return nil, err
 	}
+	if oidcUser.OpenID == "" {
+		return nil, errors.New("OIDC 服务器未返回用户标识")
+	}
+	oidcUser.Claims = claims
 	return &oidcUser, nil
 }
 
+// syncOidcClaimMapping gives the user the group and the role mapped from the configured claim,
+// root users keep their role
+func syncOidcClaimMapping(user *model.User, oidcUser *OidcUser) error {
+	if config.OidcClaim == "" {
+		return nil
+	}
+	values := oidc.ClaimValues(oidcUser.Claims, config.OidcClaim)
+	group := user.Group
+	if len(config.OidcGroupMapping) > 0 {
+		group = "default"
+		for _, value := range values {
+			if mapped, ok := config.OidcGroupMapping[value]; ok {
+				group = mapped
+				break
+			}
+		}
+	}
+	role := user.Role
+	if len(config.OidcRoleMapping) > 0 && user.Role != model.RoleRootUser {
+		role = model.RoleCommonUser
+		for _, value := range values {
+			if config.OidcRoleMapping[value] == "admin" {
+				role = model.RoleAdminUser
+				break
+			}
+		}
+	}
+	return user.UpdateGroupAndRole(group, role)
+}
+
 func OidcAuth(c *gin.Context) {
 	session := sessions.Default(c)
 	state := c.Query("state")
@@ -109,7 +283,7 @@ func OidcAuth(c *gin.Context) {
 		return
 	}
 	code := c.Query("code")
-	oidcUser, err := getOidcUserInfoByCode(code)
+	oidcUser, err := getOidcUserInfoByCode(c, code)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -166,6 +340,13 @@ func OidcAuth(c *gin.Context) {
 		})
 		return
 	}
+	if err := syncOidcClaimMapping(&user, oidcUser); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 	controller.SetupLogin(&user, c)
 }
 
@@ -178,7 +359,7 @@ func OidcBind(c *gin.Context) {
 		return
 	}
 	code := c.Query("code")
-	oidcUser, err := getOidcUserInfoByCode(code)
+	oidcUser, err := getOidcUserInfoByCode(c, code)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -1,6 +1,10 @@
 package controller
 
 import (
+	"math"
+	"strconv"
+	"time"
+
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/ctxkey"
@@ -92,6 +96,32 @@ func GetUsage(c *gin.Context) {
 		Object:     "list",
 		TotalUsage: amount * 100,
 	}
+	// the tokens, including the cache reads and writes, of the dates requested
+	tokenName := ""
+	if token != nil {
+		tokenName = token.Name
+	}
+	startHour, endHour := getUsageHours(c.Query("start_date"), c.Query("end_date"))
+	tokens, err := model.SumUsageTokens(c.GetInt(ctxkey.Id), tokenName, startHour, endHour)
+	if err == nil {
+		usage.InputTokens = tokens.InputTokens
+		usage.CachedTokens = tokens.CachedTokens
+		usage.CacheWriteTokens = tokens.CacheWriteTokens
+		usage.OutputTokens = tokens.OutputTokens
+	}
 	c.JSON(200, usage)
 	return
 }
+
+// getUsageHours converts the start_date and end_date, in the format of OpenAI, to the hours of the
+// usage rows. The end date is excluded, and a date left empty leaves the range open.
+func getUsageHours(startDate string, endDate string) (int, int) {
+	startHour, endHour := 0, math.MaxInt32
+	if date, err := time.ParseInLocation("2006-01-02", startDate, time.Local); err == nil {
+		startHour, _ = strconv.Atoi(date.Format("2006010215"))
+	}
+	if date, err := time.ParseInLocation("2006-01-02", endDate, time.Local); err == nil {
+		endHour, _ = strconv.Atoi(date.Format("2006010215"))
+	}
+	return startHour, endHour
+}
@@ -11,9 +11,11 @@ import (
 
 	"github.com/songquanpeng/one-api/common/client"
 	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/logger"
 	"github.com/songquanpeng/one-api/model"
 	"github.com/songquanpeng/one-api/monitor"
+	"github.com/songquanpeng/one-api/relay/billing/ratio"
 	"github.com/songquanpeng/one-api/relay/channeltype"
 
 	"github.com/gin-gonic/gin"
@@ -48,7 +50,11 @@ type OpenAICreditGrants struct {
 type OpenAIUsageResponse struct {
 	Object string `json:"object"`
 	//DailyCosts []OpenAIUsageDailyCost `json:"daily_costs"`
-	TotalUsage float64 `json:"total_usage"` // unit: 0.01 dollar
+	TotalUsage       float64 `json:"total_usage"` // unit: 0.01 dollar
+	InputTokens      int64   `json:"input_tokens,omitempty"`
+	CachedTokens     int64   `json:"cached_tokens,omitempty"`
+	CacheWriteTokens int64   `json:"cache_write_tokens,omitempty"`
+	OutputTokens     int64   `json:"output_tokens,omitempty"`
 }
 
 type OpenAISBUsageResponse struct {
@@ -285,7 +291,37 @@ func updateChannelDeepSeekBalance(channel *model.Channel) (float64, error) {
 	return balance, nil
 }
 
+// balanceUnitsPerUSD is the currency of the balances the channel types report, in units per USD.
+// AIProxy and AIGC2D report points, their balances are not reconciled.
+var balanceUnitsPerUSD = map[int]float64{
+	channeltype.OpenAI:      1,
+	channeltype.Custom:      1,
+	channeltype.CloseAI:     ratio.USD2RMB,
+	channeltype.OpenAISB:    10000 * ratio.USD2RMB,
+	channeltype.API2GPT:     ratio.USD2RMB,
+	channeltype.DeepSeek:    ratio.USD2RMB,
+	channeltype.SiliconFlow: ratio.USD2RMB,
+}
+
+// updateChannelBalance updates the balance of the channel and records it in USD for the reconciliation
 func updateChannelBalance(channel *model.Channel) (float64, error) {
+	balance, err := queryChannelBalance(channel)
+	if err != nil {
+		return 0, err
+	}
+	if unitsPerUSD, ok := balanceUnitsPerUSD[channel.Type]; ok {
+		if err = model.RecordChannelBalance(channel.Id, balance/unitsPerUSD); err != nil {
+			logger.SysError("failed to record balance: " + err.Error())
+		}
+	}
+	return balance, nil
+}
+
+func queryChannelBalance(channel *model.Channel) (float64, error) {
+	// the balance is queried with the plaintext key, the copy only ever writes the balance columns
+	keyedChannel := *channel
+	keyedChannel.Key = channel.DecryptedKey()
+	channel = &keyedChannel
 	baseURL := channeltype.ChannelBaseURLs[channel.Type]
 	if channel.GetBaseURL() == "" {
 		channel.BaseURL = &baseURL
@@ -390,16 +426,15 @@ func updateAllChannelsBalance() error {
 		if channel.Status != model.ChannelStatusEnabled {
 			continue
 		}
-		// TODO: support Azure
-		if channel.Type != channeltype.OpenAI && channel.Type != channeltype.Custom {
-			continue
-		}
+		// the balances of all types are recorded for the reconciliation, the channels without a balance API
+		// return an error and are skipped
 		balance, err := updateChannelBalance(channel)
 		if err != nil {
 			continue
 		} else {
 			// err is nil & balance <= 0 means quota is used up
-			if balance <= 0 {
+			// TODO: support the other types, their balances are not in USD
+			if balance <= 0 && (channel.Type == channeltype.OpenAI || channel.Type == channeltype.Custom) {
 				monitor.DisableChannel(channel.Id, channel.Name, "余额不足")
 			}
 		}
@@ -424,6 +459,51 @@ func UpdateAllChannelsBalance(c *gin.Context) {
 	return
 }
 
+// the logs are summed day by day for the reconciliation
+const maxReconcileRangeDays = 92
+
+// GetChannelReconciliations compares by day the upstream balance the channels spent with the quota billed on them
+func GetChannelReconciliations(c *gin.Context) {
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	if endTimestamp == 0 {
+		endTimestamp = helper.GetTimestamp()
+	}
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	if startTimestamp == 0 {
+		startTimestamp = endTimestamp - defaultUsageRangeDays*24*3600
+	}
+	var err error
+	if startTimestamp >= endTimestamp {
+		err = errors.New("结束时间必须晚于开始时间")
+	} else if endTimestamp-startTimestamp > maxReconcileRangeDays*24*3600 {
+		err = fmt.Errorf("时间范围不能超过 %d 天", maxReconcileRangeDays)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	channelId, _ := strconv.Atoi(c.Query("channel_id"))
+	reconciliations, err := model.GetChannelReconciliations(channelId, time.Unix(startTimestamp, 0), time.Unix(endTimestamp, 0))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"items": reconciliations,
+			"total": model.SumChannelReconciliations(reconciliations),
+		},
+	})
+}
+
 func AutomaticallyUpdateChannels(frequency int) {
 	for {
 		time.Sleep(time.Duration(frequency) * time.Minute)
@@ -60,7 +60,9 @@ func testChannel(channel *model.Channel, request *relaymodel.GeneralOpenAIReques
 	c.Set(ctxkey.BaseURL, channel.GetBaseURL())
 	cfg, _ := channel.LoadConfig()
 	c.Set(ctxkey.Config, cfg)
-	middleware.SetupContextForSelectedChannel(c, channel, "")
+	if err := middleware.SetupContextForSelectedChannel(c, channel, ""); err != nil {
+		return err, nil
+	}
 	meta := meta.GetByContext(c)
 	apiType := channeltype.ToAPIType(channel.Type)
 	adaptor := relay.GetAdaptor(apiType)
@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common"
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/model"
@@ -86,7 +87,30 @@ func AddChannel(c *gin.Context) {
 		})
 		return
 	}
+	if err = model.CheckVirtualModelNames(strings.Split(channel.Models, ",")); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 	channel.CreatedTime = helper.GetTimestamp()
+	if channel.KeyStrategy != "" {
+		// a multi-key channel keeps all its keys
+		err = model.BatchInsertChannels([]model.Channel{channel})
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "",
+		})
+		return
+	}
 	keys := strings.Split(channel.Key, "\n")
 	channels := make([]model.Channel, 0, len(keys))
 	for _, key := range keys {
@@ -157,6 +181,15 @@ func UpdateChannel(c *gin.Context) {
 		})
 		return
 	}
+	if channel.Models != "" {
+		if err = model.CheckVirtualModelNames(strings.Split(channel.Models, ",")); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	}
 	err = channel.Update()
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -172,3 +205,105 @@ func UpdateChannel(c *gin.Context) {
 	})
 	return
 }
+
+func GetChannelKeys(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	channel, err := model.GetChannelById(id, true)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"key_strategy": channel.KeyStrategy,
+			"keys":         model.GetChannelKeyStatuses(channel),
+		},
+	})
+	return
+}
+
+type channelKeyStatusRequest struct {
+	KeyHash string `json:"key_hash"`
+	Status  int    `json:"status"`
+}
+
+func UpdateChannelKeyStatus(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	req := channelKeyStatusRequest{}
+	err = c.ShouldBindJSON(&req)
+	if err != nil || req.KeyHash == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	if req.Status != model.ChannelKeyStatusEnabled && req.Status != model.ChannelKeyStatusManuallyDisabled {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的密钥状态",
+		})
+		return
+	}
+	err = model.UpdateChannelKeyStatus(id, req.KeyHash, req.Status, "")
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+	return
+}
+
+func GetChannelOccupancies(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    model.CacheGetChannelOccupancies(),
+	})
+	return
+}
+
+func ReencryptChannels(c *gin.Context) {
+	count, err := model.ReencryptChannelSecrets()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"count":              count,
+			"master_key_version": common.SecretMasterKeyVersion,
+		},
+	})
+	return
+}
@@ -0,0 +1,163 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/model"
+)
+
+func getExportQuery(c *gin.Context) *model.ExportQuery {
+	query := &model.ExportQuery{
+		Username:  c.Query("username"),
+		ModelName: c.Query("model_name"),
+		TokenName: c.Query("token_name"),
+	}
+	query.UserId, _ = strconv.Atoi(c.Query("user_id"))
+	query.LogType, _ = strconv.Atoi(c.Query("type"))
+	query.Channel, _ = strconv.Atoi(c.Query("channel"))
+	query.StartTimestamp, _ = strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	query.EndTimestamp, _ = strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	return query
+}
+
+// exportData streams the matching rows of the table given by table (logs by default) as CSV,
+// or as JSONL with format=jsonl
+func exportData(c *gin.Context, query *model.ExportQuery) {
+	table := c.DefaultQuery("table", model.ExportTableLogs)
+	format := c.DefaultQuery("format", model.ExportFormatCSV)
+	message := ""
+	if !model.IsExportTable(table) {
+		message = "不支持导出的数据：" + table
+	} else if format != model.ExportFormatCSV && format != model.ExportFormatJSONL {
+		message = "不支持的导出格式"
+	}
+	if message != "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": message,
+		})
+		return
+	}
+	filename := fmt.Sprintf("%s_%s.%s", table, time.Now().Format("20060102150405"), format)
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	if format == model.ExportFormatJSONL {
+		c.Header("Content-Type", "application/x-ndjson")
+	} else {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+	}
+	err := model.WriteExport(c.Writer, c.Writer.Flush, table, format, query)
+	if err != nil {
+		// the response is already started, the export is cut short
+		logger.SysError("failed to export " + table + ": " + err.Error())
+	}
+}
+
+func ExportData(c *gin.Context) {
+	exportData(c, getExportQuery(c))
+}
+
+// ExportUserData exports the rows of the user only. The failed logs hold the channels tried, the
+// upstream responses and the request bodies, they are only exported by the admins.
+func ExportUserData(c *gin.Context) {
+	if table := c.Query("table"); table == model.ExportTableFailedLogs {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "不支持导出的数据：" + table,
+		})
+		return
+	}
+	query := getExportQuery(c)
+	query.UserId = c.GetInt(ctxkey.Id)
+	query.Username = ""
+	exportData(c, query)
+}
+
+func GetAllExportSchedules(c *gin.Context) {
+	schedules, err := model.GetAllExportSchedules()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    schedules,
+	})
+}
+
+func AddExportSchedule(c *gin.Context) {
+	schedule := model.ExportSchedule{}
+	err := c.ShouldBindJSON(&schedule)
+	if err == nil {
+		err = schedule.Validate()
+	}
+	if err == nil {
+		if schedule.Status == 0 {
+			schedule.Status = model.ExportScheduleStatusEnabled
+		}
+		err = schedule.Insert()
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    schedule,
+	})
+}
+
+func UpdateExportSchedule(c *gin.Context) {
+	schedule := model.ExportSchedule{}
+	err := c.ShouldBindJSON(&schedule)
+	if err == nil {
+		err = schedule.Validate()
+	}
+	if err == nil {
+		if schedule.Status == 0 {
+			schedule.Status = model.ExportScheduleStatusEnabled
+		}
+		err = schedule.Update()
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    schedule,
+	})
+}
+
+func DeleteExportSchedule(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	err := model.DeleteExportScheduleById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
@@ -0,0 +1,21 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportUserDataRefusesFailedLogs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/export/self?table=failed_logs", nil)
+	ExportUserData(c)
+	assert.True(t, strings.Contains(w.Body.String(), `"success":false`))
+	assert.Empty(t, w.Header().Get("Content-Disposition"))
+}
@@ -243,27 +243,28 @@ func FlushUserUsage(c *gin.Context) {
 			}
 			hourStr := createdAt.Format("2006010215")
 			hour, _ := strconv.Atoi(hourStr)
-			key := strconv.Itoa(log.UserId) + log.ModelName + log.TokenName + hourStr
+			channelType := model.GetChannelTypeById(log.ChannelId)
+			key := strconv.Itoa(log.UserId) + log.ModelName + log.TokenName + strconv.Itoa(channelType) + hourStr
 			if _, ok := usages[key]; !ok {
 				usages[key] = &model.Usage{
-					UserId:       log.UserId,
-					Hour:         hour,
-					ModelName:    log.ModelName,
-					TokenName:    log.TokenName,
-					Count:        0,
-					InputTokens:  0,
-					OutputTokens: 0,
-					Quota:        0,
+					UserId:      log.UserId,
+					Hour:        hour,
+					ModelName:   log.ModelName,
+					TokenName:   log.TokenName,
+					ChannelType: channelType,
 				}
 			}
 			usage := usages[key]
 			usage.Count++
 			usage.InputTokens += log.PromptTokens
+			usage.CachedTokens += log.CachedTokens
+			usage.CacheWriteTokens += log.CacheWriteTokens
 			usage.OutputTokens += log.CompletionTokens
 			usage.Quota += log.Quota
+			usage.Duration += log.Duration
 		}
 		for _, usage := range usages {
-			err = model.AddUsage(usage.UserId, usage.ModelName, usage.Hour, usage.TokenName, usage.Count, usage.InputTokens, usage.OutputTokens, usage.Quota)
+			err = model.AddUsage(usage)
 			if err != nil {
 				logger.SysError("failed to add usage: " + err.Error())
 			}
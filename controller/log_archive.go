@@ -0,0 +1,111 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/archive"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/model"
+)
+
+type restoreArchivedLogsRequest struct {
+	Table string `json:"table"`
+	Day   string `json:"day"`
+}
+
+func getArchiveSink() (archive.Sink, error) {
+	sink, err := archive.NewSinkFromConfig()
+	if err != nil {
+		return nil, err
+	}
+	if sink == nil {
+		return nil, errors.New("未配置日志归档（LOG_ARCHIVE_SINK）")
+	}
+	return sink, nil
+}
+
+func archiveErrorMessage(err error) string {
+	if errors.Is(err, archive.ErrNotExist) {
+		return "该日期没有归档"
+	}
+	return err.Error()
+}
+
+// GetArchivedLogs queries the rows of one archived day, table is logs (default) or failed_logs
+func GetArchivedLogs(c *gin.Context) {
+	sink, err := getArchiveSink()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	table := c.DefaultQuery("table", model.ArchiveTableLogs)
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	query := model.ArchiveQuery{
+		UserId:    userId,
+		Username:  c.Query("username"),
+		ModelName: c.Query("model_name"),
+		TokenName: c.Query("token_name"),
+	}
+	rows, total, err := model.QueryArchivedLogs(c.Request.Context(), sink, table, c.Query("day"), query, p*config.ItemsPerPage, config.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": archiveErrorMessage(err),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"items": rows,
+			"total": total,
+		},
+	})
+}
+
+// RestoreArchivedLogs inserts the rows of one archived day back into the database
+func RestoreArchivedLogs(c *gin.Context) {
+	var req restoreArchivedLogsRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Day == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	if req.Table == "" {
+		req.Table = model.ArchiveTableLogs
+	}
+	sink, err := getArchiveSink()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	count, err := model.RestoreArchivedLogs(c.Request.Context(), sink, req.Table, req.Day)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": archiveErrorMessage(err),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    count,
+	})
+}
@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/model"
+)
+
+func GetManagementKeys(c *gin.Context) {
+	keys, err := model.GetUserManagementKeys(c.GetInt(ctxkey.Id))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    keys,
+	})
+	return
+}
+
+// AddManagementKey creates a key, the key is only returned by this call
+func AddManagementKey(c *gin.Context) {
+	key := model.ManagementKey{}
+	err := c.ShouldBindJSON(&key)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	userId := c.GetInt(ctxkey.Id)
+	cleanKey := model.ManagementKey{
+		UserId:      userId,
+		Name:        key.Name,
+		Scopes:      key.Scopes,
+		AllowIps:    key.AllowIps,
+		ExpiredTime: key.ExpiredTime,
+	}
+	if cleanKey.ExpiredTime == 0 {
+		cleanKey.ExpiredTime = -1
+	}
+	err = cleanKey.Validate(model.CacheGetUserPermissions(userId, c.GetInt(ctxkey.Role)))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	plaintext, err := cleanKey.Insert()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"key":            plaintext,
+			"management_key": cleanKey,
+		},
+	})
+	return
+}
+
+func RevokeManagementKey(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	err := model.RevokeManagementKey(id, c.GetInt(ctxkey.Id))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+	return
+}
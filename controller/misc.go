@@ -48,6 +48,15 @@ func GetStatus(c *gin.Context) {
 	return
 }
 
+// GetBufferStatus returns the depth of the write-behind buffer of logs, usages and quota deltas
+func GetBufferStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    model.GetBatchUpdaterStats(),
+	})
+}
+
 func GetNotice(c *gin.Context) {
 	config.OptionMapRWMutex.RLock()
 	defer config.OptionMapRWMutex.RUnlock()
@@ -11,7 +11,10 @@ import (
 	"github.com/songquanpeng/one-api/common/logger"
 	"github.com/songquanpeng/one-api/model"
 	relay "github.com/songquanpeng/one-api/relay"
+	"github.com/songquanpeng/one-api/relay/adaptor/kling"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/adaptor/replicate"
+	"github.com/songquanpeng/one-api/relay/adaptor/runway"
 	"github.com/songquanpeng/one-api/relay/apitype"
 	"github.com/songquanpeng/one-api/relay/billing/ratio"
 	"github.com/songquanpeng/one-api/relay/channeltype"
@@ -125,6 +128,9 @@ func InitModels() {
 		adaptor.Init(meta)
 		channelId2Models[i] = adaptor.GetModelList()
 	}
+	channelId2Models[channeltype.Kling] = kling.ModelList
+	channelId2Models[channeltype.Runway] = runway.ModelList
+	channelId2Models[channeltype.Replicate] = replicate.ModelList
 }
 
 func DashboardListModels(c *gin.Context) {
@@ -182,15 +188,27 @@ func ListAllModels(c *gin.Context) {
 func ListModels(c *gin.Context) {
 	ctx := c.Request.Context()
 	var availableModels []string
-	if c.GetString(ctxkey.AvailableModels) != "" {
-		availableModels = strings.Split(c.GetString(ctxkey.AvailableModels), ",")
+	userId := c.GetInt(ctxkey.Id)
+	var userGroup = "default"
+	if userId > 0 {
+		userGroup, _ = model.CacheGetUserGroup(ctx, userId)
+	}
+	virtualModelSet := make(map[string]bool)
+	for _, virtualModelName := range model.CacheGetVirtualModelNames(userGroup) {
+		virtualModelSet[virtualModelName] = true
+	}
+	tokenModels := c.GetString(ctxkey.AvailableModels)
+	if tokenModels != "" && !model.HasModelPattern(tokenModels) {
+		availableModels = strings.Split(tokenModels, ",")
 	} else {
-		userId := c.GetInt(ctxkey.Id)
-		var userGroup = "default"
-		if userId > 0 {
-			userGroup, _ = model.CacheGetUserGroup(ctx, userId)
-		}
 		availableModels, _ = model.CacheGetGroupModels(ctx, userGroup)
+		for virtualModelName := range virtualModelSet {
+			availableModels = append(availableModels, virtualModelName)
+		}
+		// the patterns of the token are matched against the models of the group
+		if tokenModels != "" {
+			availableModels = model.FilterAllowedModels(tokenModels, availableModels)
+		}
 	}
 	modelSet := make(map[string]bool)
 	for _, availableModel := range availableModels {
@@ -205,11 +223,15 @@ func ListModels(c *gin.Context) {
 	}
 	for modelName, ok := range modelSet {
 		if ok {
+			ownedBy := "custom"
+			if virtualModelSet[modelName] {
+				ownedBy = "virtual"
+			}
 			availableOpenAIModels = append(availableOpenAIModels, OpenAIModels{
 				Id:      modelName,
 				Object:  "model",
 				Created: 1626777600,
-				OwnedBy: "custom",
+				OwnedBy: ownedBy,
 				Root:    modelName,
 				Parent:  nil,
 			})
@@ -0,0 +1,139 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/billing/ratio"
+)
+
+func GetModelPrices(c *gin.Context) {
+	prices, err := model.GetModelPrices(c.Query("model"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    prices,
+	})
+}
+
+func GetModelPrice(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	price, err := model.GetModelPriceById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    price,
+	})
+}
+
+// GetEffectiveModelPrice returns the price version in effect for a model, and the ratios it bills a
+// request with prompt_tokens prompt tokens at
+func GetEffectiveModelPrice(c *gin.Context) {
+	channelType, _ := strconv.Atoi(c.Query("channel_type"))
+	promptTokens, _ := strconv.Atoi(c.Query("prompt_tokens"))
+	price := ratio.GetPrice(c.Query("model"), channelType)
+	if price == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "价格目录中没有该模型的有效价格",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"price_id": price.Id,
+			"tier":     price.Tier(promptTokens),
+			"ratios":   price.Ratios(promptTokens),
+		},
+	})
+}
+
+func AddModelPrice(c *gin.Context) {
+	price := model.ModelPrice{}
+	err := c.ShouldBindJSON(&price)
+	if err == nil {
+		err = price.Validate()
+	}
+	if err == nil {
+		err = price.Insert()
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.InitPriceCatalogCache()
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    price,
+	})
+}
+
+func UpdateModelPrice(c *gin.Context) {
+	price := model.ModelPrice{}
+	err := c.ShouldBindJSON(&price)
+	if err == nil {
+		err = price.Validate()
+	}
+	if err == nil {
+		err = price.Update()
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.InitPriceCatalogCache()
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    price,
+	})
+}
+
+func DeleteModelPrice(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	err := model.DeleteModelPriceById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.InitPriceCatalogCache()
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
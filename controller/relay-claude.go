@@ -31,7 +31,10 @@ func ClaudeMessages(c *gin.Context) {
 	ctx := c.Request.Context()
 	channelId := c.GetInt(ctxkey.ChannelId)
 	userId := c.GetInt(ctxkey.Id)
-	bizErr := relayTextHelper(c)
+	relay := func() *relay_model.ErrorWithStatusCode {
+		return relayTextHelper(c)
+	}
+	bizErr := relayWithChannelLimit(c, relay)
 	if bizErr == nil {
 		model.CacheSetRecentChannel(ctx, userId, c.GetString(ctxkey.RequestModel), channelId)
 		monitor.Emit(channelId, true)
@@ -42,7 +45,7 @@ func ClaudeMessages(c *gin.Context) {
 	group := c.GetString(ctxkey.Group)
 	originalModel := c.GetString(ctxkey.OriginalModel)
 
-	go processChannelRelayError(ctx, userId, channelId, channelName, bizErr)
+	go processChannelRelayError(ctx, userId, channelId, channelName, c.GetString(ctxkey.ChannelKeyHash), bizErr)
 	requestId := c.GetString(helper.RequestIdKey)
 	retry := true
 	if !shouldRetry(c, bizErr) {
@@ -62,15 +65,19 @@ func ClaudeMessages(c *gin.Context) {
 			break
 		}
 		logger.Infof(ctx, "using channel #%d to retry (remain times %d)", retryChannel.Id, len(excludedChannels))
-		middleware.SetupContextForSelectedChannel(c, retryChannel, originalModel)
+		if err = middleware.SetupContextForSelectedChannel(c, retryChannel, originalModel); err != nil {
+			logger.Errorf(ctx, "channel #%d skipped: %s", retryChannel.Id, err.Error())
+			excludedChannels = append(excludedChannels, retryChannel.Id)
+			continue
+		}
 		requestBody, err := common.GetRequestBody(c)
 		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
-		bizErr = relayTextHelper(c)
+		bizErr = relayWithChannelLimit(c, relay)
 		if bizErr == nil {
 			return
 		}
 		excludedChannels = append(excludedChannels, retryChannel.Id)
-		go processChannelRelayError(ctx, userId, retryChannel.Id, retryChannel.Name, bizErr)
+		go processChannelRelayError(ctx, userId, retryChannel.Id, retryChannel.Name, c.GetString(ctxkey.ChannelKeyHash), bizErr)
 	}
 
 	requestBody, _ := common.GetRequestBody(c)
@@ -212,6 +219,20 @@ func getAdaptor(apiType int) claude_adaptor.Adaptor {
 	}
 }
 
+// getClaudeQuota prices the usage of a Claude request, the cache writes with the 1h TTL are part of
+// CacheCreationInputTokens and priced apart
+func getClaudeQuota(usage *anthropic.Usage, ratio float64, completionRatio float64, cacheWriteRatio float64, cacheReadRatio float64) int64 {
+	cacheWrite1hTokens := usage.CacheWrite1hTokens()
+	quota := int64(math.Ceil((float64(usage.InputTokens) +
+		float64(usage.CacheCreationInputTokens-cacheWrite1hTokens)*cacheWriteRatio +
+		float64(cacheWrite1hTokens)*billingratio.GetCacheWrite1hRatio(cacheWriteRatio) +
+		float64(usage.CacheReadInputTokens)*cacheReadRatio + float64(usage.OutputTokens)*completionRatio) * ratio))
+	if ratio != 0 && quota <= 0 {
+		quota = 1
+	}
+	return quota
+}
+
 func postConsumeQuota(c *gin.Context, ctx context.Context, usage *anthropic.Usage, meta *meta.Meta, textRequest *anthropic.Request, ratio float64, modelRatio float64, groupRatio float64) {
 	if usage == nil {
 		logger.Error(ctx, "usage is nil, which is unexpected")
@@ -219,12 +240,25 @@ func postConsumeQuota(c *gin.Context, ctx context.Context, usage *anthropic.Usag
 	}
 	var quota int64
 	completionRatio := billingratio.GetCompletionRatio(textRequest.Model, meta.ChannelType)
-
-	quota = int64(math.Ceil((float64(usage.InputTokens) + float64(usage.CacheCreationInputTokens)*1.25 +
-		float64(usage.CacheReadInputTokens)*0.1 + float64(usage.OutputTokens)*completionRatio) * ratio))
-	if ratio != 0 && quota <= 0 {
-		quota = 1
+	cacheWriteRatio := billingratio.GetCacheWriteRatio(textRequest.Model, meta.ChannelType)
+	cacheReadRatio := billingratio.ClaudeCacheReadRatio
+	priceId := 0
+	if price := billingratio.GetPrice(textRequest.Model, meta.ChannelType); price != nil {
+		priceRatios := price.Ratios(usage.InputTokens + usage.CacheCreationInputTokens + usage.CacheReadInputTokens)
+		priceId = price.Id
+		modelRatio = priceRatios.Model
+		ratio = modelRatio * groupRatio
+		completionRatio = priceRatios.Completion
+		// a tier without cache prices keeps the default cache ratios
+		if priceRatios.CacheWrite > 0 {
+			cacheWriteRatio = priceRatios.CacheWrite
+		}
+		if priceRatios.CacheRead > 0 {
+			cacheReadRatio = priceRatios.CacheRead
+		}
 	}
+
+	quota = getClaudeQuota(usage, ratio, completionRatio, cacheWriteRatio, cacheReadRatio)
 	var extraLog string
 	// surfing cost
 	if c.GetString(ctxkey.SurfingContext) != "" {
@@ -247,13 +281,17 @@ func postConsumeQuota(c *gin.Context, ctx context.Context, usage *anthropic.Usag
 	if err != nil {
 		logger.Error(ctx, "error update user quota cache: "+err.Error())
 	}
+	if usage.CacheCreationInputTokens > 0 {
+		extraLog += fmt.Sprintf("缓存写入 %d tokens，缓存写入倍率 %.3f。", usage.CacheCreationInputTokens, cacheWriteRatio)
+	}
 	var logContent string
 	if extraLog != "" {
 		logContent = fmt.Sprintf("模型倍率 %.3f，分组倍率 %.3f，补全倍率 %.3f(%s)", modelRatio, groupRatio, completionRatio, extraLog)
 	} else {
 		logContent = fmt.Sprintf("模型倍率 %.3f，分组倍率 %.3f，补全倍率 %.3f", modelRatio, groupRatio, completionRatio)
 	}
-	model.RecordConsumeLog(ctx, meta.UserId, meta.ChannelId, usage.InputTokens+usage.CacheCreationInputTokens+usage.CacheReadInputTokens, usage.CacheReadInputTokens, usage.OutputTokens, textRequest.Model, meta.TokenName, quota, logContent)
+	model.RecordConsumeLog(ctx, meta.UserId, meta.ChannelId, meta.ChannelType, usage.InputTokens+usage.CacheCreationInputTokens+usage.CacheReadInputTokens, usage.CacheReadInputTokens, usage.CacheCreationInputTokens, usage.OutputTokens, textRequest.Model, meta.TokenName, quota, logContent, priceId)
+	model.RecordTokenModelUsage(meta.TokenId, meta.TokenModelQuotas, meta.OriginModelName, quota)
 	model.UpdateUserUsedQuotaAndRequestCount(meta.UserId, quota)
 	model.UpdateChannelUsedQuota(meta.ChannelId, quota)
 }
@@ -0,0 +1,20 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/songquanpeng/one-api/relay/adaptor/anthropic"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetClaudeQuota(t *testing.T) {
+	usage := &anthropic.Usage{InputTokens: 500, OutputTokens: 100, CacheCreationInputTokens: 300, CacheReadInputTokens: 200,
+		CacheCreation: &anthropic.CacheCreation{Ephemeral5mInputTokens: 200, Ephemeral1hInputTokens: 100}}
+	// 500 input + 200 writes * 1.25 + 100 writes with the 1h TTL * 2 + 200 reads * 0.1 + 100 output * 5
+	assert.Equal(t, int64(1470), getClaudeQuota(usage, 1, 5, 1.25, 0.1))
+	assert.Equal(t, int64(2940), getClaudeQuota(usage, 2, 5, 1.25, 0.1))
+
+	usage.CacheCreation = nil
+	assert.Equal(t, int64(500+375+20+500), getClaudeQuota(usage, 1, 5, 1.25, 0.1))
+	assert.Equal(t, int64(1), getClaudeQuota(&anthropic.Usage{InputTokens: 1}, 0.0001, 5, 1.25, 0.1))
+}
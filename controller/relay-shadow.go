@@ -0,0 +1,340 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/middleware"
+	dbmodel "github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/controller"
+	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+	"github.com/tidwall/gjson"
+)
+
+// only the first part of the primary response is kept for the similarity score
+const shadowCaptureLimit = 64 * 1024
+
+// keys copied from the primary request context into the shadow context
+var shadowContextKeys = []string{
+	ctxkey.Id,
+	ctxkey.Username,
+	ctxkey.Role,
+	ctxkey.TokenId,
+	ctxkey.TokenName,
+	ctxkey.Group,
+	ctxkey.RequestModel,
+	ctxkey.AvailableModels,
+	helper.RequestIdKey,
+}
+
+type shadowCaptureWriter struct {
+	gin.ResponseWriter
+	body *bytes.Buffer
+}
+
+func (w *shadowCaptureWriter) Write(b []byte) (int, error) {
+	w.capture(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *shadowCaptureWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *shadowCaptureWriter) capture(b []byte) {
+	if remain := shadowCaptureLimit - w.body.Len(); remain > 0 {
+		if len(b) > remain {
+			b = b[:remain]
+		}
+		w.body.Write(b)
+	}
+}
+
+// shadowResponseWriter keeps the response of a shadow replay, which never reaches the user
+type shadowResponseWriter struct {
+	header http.Header
+	body   bytes.Buffer
+	status int
+	size   int
+}
+
+func newShadowResponseWriter() *shadowResponseWriter {
+	return &shadowResponseWriter{header: make(http.Header), status: http.StatusOK, size: -1}
+}
+
+func (w *shadowResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *shadowResponseWriter) WriteHeader(code int) {
+	if code > 0 && !w.Written() {
+		w.status = code
+	}
+}
+
+func (w *shadowResponseWriter) WriteHeaderNow() {
+	if !w.Written() {
+		w.size = 0
+	}
+}
+
+func (w *shadowResponseWriter) Write(b []byte) (int, error) {
+	w.WriteHeaderNow()
+	n, err := w.body.Write(b)
+	w.size += n
+	return n, err
+}
+
+func (w *shadowResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *shadowResponseWriter) Status() int {
+	return w.status
+}
+
+func (w *shadowResponseWriter) Size() int {
+	return w.size
+}
+
+func (w *shadowResponseWriter) Written() bool {
+	return w.size != -1
+}
+
+func (w *shadowResponseWriter) Flush() {
+	w.WriteHeaderNow()
+}
+
+func (w *shadowResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, errors.New("a shadow response cannot be hijacked")
+}
+
+func (w *shadowResponseWriter) CloseNotify() <-chan bool {
+	return make(chan bool)
+}
+
+func (w *shadowResponseWriter) Pusher() http.Pusher {
+	return nil
+}
+
+type shadowJob struct {
+	channels         []*dbmodel.Channel
+	shadowContext    []*gin.Context
+	writers          []*shadowResponseWriter
+	primaryChannelId int
+	modelName        string
+	requestId        string
+	startTime        time.Time
+	capture          *shadowCaptureWriter
+}
+
+// prepareShadow samples the shadow configs of the requested model and, when at least
+// one of them is hit, captures the primary response. It must be called before the primary relay.
+func prepareShadow(c *gin.Context, relayMode int) *shadowJob {
+	if relayMode != relaymode.ChatCompletions && relayMode != relaymode.Completions {
+		return nil
+	}
+	if _, ok := c.Get(ctxkey.SpecificChannelId); ok {
+		return nil
+	}
+	modelName := c.GetString(ctxkey.RequestModel)
+	shadowConfigs := dbmodel.CacheGetShadowConfigs(modelName)
+	if len(shadowConfigs) == 0 {
+		return nil
+	}
+	primaryChannelId := c.GetInt(ctxkey.ChannelId)
+	job := &shadowJob{
+		primaryChannelId: primaryChannelId,
+		modelName:        modelName,
+		requestId:        c.GetString(helper.RequestIdKey),
+		startTime:        time.Now(),
+	}
+	for _, shadowConfig := range shadowConfigs {
+		if shadowConfig.ChannelId == primaryChannelId || rand.Float64()*100 >= shadowConfig.Percent {
+			continue
+		}
+		channel, err := dbmodel.CacheGetChannelById(shadowConfig.ChannelId)
+		if err != nil {
+			continue
+		}
+		job.channels = append(job.channels, channel)
+	}
+	if len(job.channels) == 0 {
+		return nil
+	}
+	job.capture = &shadowCaptureWriter{ResponseWriter: c.Writer, body: &bytes.Buffer{}}
+	c.Writer = job.capture
+	return job
+}
+
+// start copies everything the replay needs out of the gin context once the primary relay
+// succeeded, possibly on a retry, and runs the replay. It must be called before the
+// handler returns, because the context is recycled afterwards.
+func (job *shadowJob) start(c *gin.Context) {
+	// a retry may have moved the request to another channel or, for a virtual model, another model
+	job.primaryChannelId = c.GetInt(ctxkey.ChannelId)
+	job.modelName = c.GetString(ctxkey.RequestModel)
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		return
+	}
+	channels := job.channels
+	job.channels = nil
+	for _, channel := range channels {
+		if channel.Id == job.primaryChannelId {
+			continue
+		}
+		writer := newShadowResponseWriter()
+		shadowContext := newShadowContext(c, writer, requestBody)
+		if middleware.SetupContextForSelectedChannel(shadowContext, channel, job.modelName) != nil {
+			continue
+		}
+		job.channels = append(job.channels, channel)
+		job.shadowContext = append(job.shadowContext, shadowContext)
+		job.writers = append(job.writers, writer)
+	}
+	if len(job.channels) == 0 {
+		return
+	}
+	go runShadow(job)
+}
+
+// newShadowContext copies the primary context with only the keys of the user and the token,
+// writing to writer instead of the user
+func newShadowContext(c *gin.Context, writer *shadowResponseWriter, requestBody []byte) *gin.Context {
+	shadowContext := c.Copy()
+	shadowContext.Writer = writer
+	ctx := context.WithValue(context.Background(), helper.RequestIdKey, c.GetString(helper.RequestIdKey))
+	request := c.Request.Clone(ctx)
+	request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+	shadowContext.Request = request
+	shadowContext.Keys = make(map[string]any, len(shadowContextKeys)+1)
+	for _, key := range shadowContextKeys {
+		if value, ok := c.Get(key); ok {
+			shadowContext.Keys[key] = value
+		}
+	}
+	shadowContext.Keys[ctxkey.KeyRequestBody] = requestBody
+	return shadowContext
+}
+
+// runShadow replays the request on every sampled shadow channel and records the comparison.
+// The shadow responses are only recorded, they never reach the user and are never billed.
+func runShadow(job *shadowJob) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.SysErrorf("panic in shadow relay: %v", r)
+		}
+	}()
+	primaryLatency := time.Since(job.startTime).Milliseconds()
+	primaryText := extractResponseText(job.capture.body.Bytes())
+	primaryCompletionTokens := openai.CountTokenText(primaryText, job.modelName)
+	for i := range job.channels {
+		record := job.replay(i, controller.RelayShadowTextHelper)
+		if record == nil {
+			continue
+		}
+		record.PrimaryLatency = primaryLatency
+		record.PrimaryCompletionTokens = primaryCompletionTokens
+		if record.ShadowStatusCode == http.StatusOK {
+			record.Similarity = textSimilarity(primaryText, extractResponseText(job.writers[i].body.Bytes()))
+		}
+		dbmodel.RecordShadow(record)
+	}
+}
+
+// replay sends the request to the i-th shadow channel within its concurrency and RPM limits, it returns
+// nil without sending anything when the channel is at one of them, the shadow traffic never waits
+func (job *shadowJob) replay(i int, relay func(c *gin.Context) (*model.Usage, *model.ErrorWithStatusCode)) *dbmodel.ShadowRecord {
+	channel := job.channels[i]
+	if !dbmodel.TryAcquireChannel(channel) {
+		logger.SysLogf("shadow channel #%d is at its limits, request %s is not replayed", channel.Id, job.requestId)
+		return nil
+	}
+	defer dbmodel.ReleaseChannel(channel)
+	start := time.Now()
+	usage, bizErr := relay(job.shadowContext[i])
+	record := &dbmodel.ShadowRecord{
+		ShadowChannelId:  channel.Id,
+		PrimaryChannelId: job.primaryChannelId,
+		ModelName:        job.modelName,
+		RequestId:        job.requestId,
+		ShadowLatency:    time.Since(start).Milliseconds(),
+		ShadowStatusCode: http.StatusOK,
+	}
+	if bizErr != nil {
+		record.ShadowStatusCode = bizErr.StatusCode
+		record.ShadowError = bizErr.Error.Message
+	} else if usage != nil {
+		record.PromptTokens = usage.PromptTokens
+		record.CompletionTokens = usage.CompletionTokens
+	}
+	return record
+}
+
+// extractResponseText returns the generated text of an OpenAI style response,
+// either a single JSON body or a server-sent event stream.
+func extractResponseText(body []byte) string {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		parsed := gjson.ParseBytes(trimmed)
+		if content := parsed.Get("choices.0.message.content"); content.Exists() {
+			return content.String()
+		}
+		return parsed.Get("choices.0.text").String()
+	}
+	var builder strings.Builder
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 0, 64*1024), shadowCaptureLimit)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		data := gjson.Parse(strings.TrimPrefix(line, "data: "))
+		builder.WriteString(data.Get("choices.0.delta.content").String())
+		builder.WriteString(data.Get("choices.0.text").String())
+	}
+	return builder.String()
+}
+
+// textSimilarity is the Jaccard index of the lower-cased word sets of both texts
+func textSimilarity(a string, b string) float64 {
+	wordsA := strings.Fields(strings.ToLower(a))
+	wordsB := strings.Fields(strings.ToLower(b))
+	if len(wordsA) == 0 && len(wordsB) == 0 {
+		return 1
+	}
+	setA := make(map[string]bool, len(wordsA))
+	for _, word := range wordsA {
+		setA[word] = true
+	}
+	setB := make(map[string]bool, len(wordsB))
+	for _, word := range wordsB {
+		setB[word] = true
+	}
+	intersection := 0
+	for word := range setA {
+		if setB[word] {
+			intersection++
+		}
+	}
+	union := len(setA) + len(setB) - intersection
+	return float64(intersection) / float64(union)
+}
@@ -0,0 +1,87 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	dbmodel "github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewShadowContext(t *testing.T) {
+	primary, _ := gin.CreateTestContext(httptest.NewRecorder())
+	primary.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{}`))
+	primary.Set(ctxkey.Id, 1)
+	primary.Set(ctxkey.ChannelId, 2)
+
+	writer := newShadowResponseWriter()
+	shadowContext := newShadowContext(primary, writer, []byte(`{"model":"gpt-4o"}`))
+	assert.Equal(t, 1, shadowContext.GetInt(ctxkey.Id))
+	_, ok := shadowContext.Get(ctxkey.ChannelId)
+	assert.False(t, ok, "the keys of the primary channel are not copied")
+
+	shadowContext.JSON(http.StatusBadRequest, gin.H{"error": "bad"})
+	assert.Equal(t, http.StatusBadRequest, writer.Status())
+	assert.Equal(t, `{"error":"bad"}`, writer.body.String())
+	assert.Equal(t, http.StatusOK, primary.Writer.Status())
+	assert.False(t, primary.Writer.Written(), "the shadow response never reaches the user")
+}
+
+func TestShadowStartUsesFinalRequest(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{}`))
+	// the request was retried on the shadow channel, with the model a virtual model fell back to
+	c.Set(ctxkey.ChannelId, 4001)
+	c.Set(ctxkey.RequestModel, "gpt-4o-mini")
+	c.Set(ctxkey.KeyRequestBody, []byte(`{"model":"gpt-4o-mini"}`))
+	job := &shadowJob{
+		channels:         []*dbmodel.Channel{{Id: 4001}},
+		primaryChannelId: 4000,
+		modelName:        "gpt-4o",
+	}
+
+	job.start(c)
+	assert.Equal(t, 4001, job.primaryChannelId)
+	assert.Equal(t, "gpt-4o-mini", job.modelName)
+	assert.Empty(t, job.channels, "the channel that served the request is not shadowed")
+}
+
+func TestShadowReplayRespectsChannelLimits(t *testing.T) {
+	common.RedisEnabled = false
+	channel := &dbmodel.Channel{Id: 3001, MaxConcurrency: 1}
+	job := &shadowJob{
+		channels:      []*dbmodel.Channel{channel},
+		shadowContext: []*gin.Context{nil},
+		writers:       []*shadowResponseWriter{newShadowResponseWriter()},
+	}
+	replayed := 0
+	relay := func(c *gin.Context) (*model.Usage, *model.ErrorWithStatusCode) {
+		replayed++
+		assert.False(t, dbmodel.IsChannelAvailable(channel), "the replay holds a slot of the channel")
+		return &model.Usage{PromptTokens: 3, CompletionTokens: 5}, nil
+	}
+
+	record := job.replay(0, relay)
+	assert.Equal(t, 1, replayed)
+	assert.Equal(t, http.StatusOK, record.ShadowStatusCode)
+	assert.Equal(t, 5, record.CompletionTokens)
+	assert.True(t, dbmodel.IsChannelAvailable(channel), "the slot is released after the replay")
+
+	assert.True(t, dbmodel.TryAcquireChannel(channel))
+	defer dbmodel.ReleaseChannel(channel)
+	assert.Nil(t, job.replay(0, relay), "a channel at its limit is not replayed")
+	assert.Equal(t, 1, replayed)
+}
+
+func TestExtractResponseText(t *testing.T) {
+	assert.Equal(t, "hello world", extractResponseText([]byte(`{"choices":[{"message":{"content":"hello world"}}]}`)))
+	stream := "data: {\"choices\":[{\"delta\":{\"content\":\"hello \"}}]}\n\ndata: {\"choices\":[{\"delta\":{\"content\":\"world\"}}]}\n\ndata: [DONE]\n"
+	assert.Equal(t, "hello world", extractResponseText([]byte(stream)))
+	assert.InDelta(t, 2.0/3, textSimilarity("hello big world", "Hello world"), 1e-9)
+}
@@ -18,12 +18,16 @@ import (
 	"github.com/songquanpeng/one-api/relay/controller"
 	"github.com/songquanpeng/one-api/relay/model"
 	"github.com/songquanpeng/one-api/relay/relaymode"
+	"github.com/songquanpeng/one-api/relay/routing"
 	"io"
 	"net/http"
+	"time"
 )
 
 // https://platform.openai.com/docs/api-reference/chat
 
+const channelBusyErrorCode = "channel_busy"
+
 func relayHelper(c *gin.Context, relayMode int) *model.ErrorWithStatusCode {
 	var err *model.ErrorWithStatusCode
 	switch relayMode {
@@ -39,12 +43,39 @@ func relayHelper(c *gin.Context, relayMode int) *model.ErrorWithStatusCode {
 		err = controller.RelayRerankHelper(c, relayMode)
 	case relaymode.Proxy:
 		err = controller.RelayProxyHelper(c, relayMode)
+	case relaymode.Realtime:
+		err = controller.RelayRealtimeHelper(c)
+	case relaymode.Tasks:
+		err = controller.RelayTaskHelper(c)
 	default:
 		err = controller.RelayTextHelper(c)
 	}
 	return err
 }
 
+// relayWithChannelLimit holds a slot of the selected channel during the upstream request,
+// waiting in the channel queue when CHANNEL_LIMIT_QUEUE_TIMEOUT is set
+func relayWithChannelLimit(c *gin.Context, relay func() *model.ErrorWithStatusCode) *model.ErrorWithStatusCode {
+	channel, err := dbmodel.CacheGetChannelById(c.GetInt(ctxkey.ChannelId))
+	if err != nil || !channel.HasLimit() {
+		return relay()
+	}
+	timeout := time.Duration(config.ChannelLimitQueueTimeout) * time.Second
+	if !dbmodel.AcquireChannel(c.Request.Context(), channel, timeout) {
+		return &model.ErrorWithStatusCode{
+			IsChannelResponseError: true, // so that another channel is tried
+			StatusCode:             http.StatusTooManyRequests,
+			Error: model.Error{
+				Message: fmt.Sprintf("channel #%d is at its concurrency or rpm limit", channel.Id),
+				Type:    "one_api_error",
+				Code:    channelBusyErrorCode,
+			},
+		}
+	}
+	defer dbmodel.ReleaseChannel(channel)
+	return relay()
+}
+
 func Relay(c *gin.Context) {
 	ctx := c.Request.Context()
 	relayMode := relaymode.GetByPath(c.Request.URL.Path)
@@ -59,8 +90,15 @@ func Relay(c *gin.Context) {
 		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
 		logger.DebugForcef(ctx, "user id %d, request body: %s", userId, string(requestBody))
 	}
-	bizErr := relayHelper(c, relayMode)
+	shadow := prepareShadow(c, relayMode)
+	relay := func() *model.ErrorWithStatusCode {
+		return relayHelper(c, relayMode)
+	}
+	bizErr := relayWithChannelLimit(c, relay)
 	if bizErr == nil {
+		if shadow != nil {
+			shadow.start(c)
+		}
 		cacheRatio := billingratio.GetCacheRatio(c.GetString(ctxkey.RequestModel), c.GetInt(ctxkey.Channel))
 		if cacheRatio < 1 {
 			dbmodel.CacheSetRecentChannel(ctx, userId, c.GetString(ctxkey.RequestModel), channelId)
@@ -72,7 +110,7 @@ func Relay(c *gin.Context) {
 	channelName := c.GetString(ctxkey.ChannelName)
 	group := c.GetString(ctxkey.Group)
 	originalModel := c.GetString(ctxkey.OriginalModel)
-	go processChannelRelayError(ctx, userId, channelId, channelName, bizErr)
+	go processChannelRelayError(ctx, userId, channelId, channelName, c.GetString(ctxkey.ChannelKeyHash), bizErr)
 	requestId := c.GetString(helper.RequestIdKey)
 	retry := true
 	if !shouldRetry(c, bizErr) {
@@ -82,7 +120,23 @@ func Relay(c *gin.Context) {
 	excludedChannels := make([]int, 0)
 	excludedChannels = append(excludedChannels, channelId)
 	for retry {
-		retryChannel, err := dbmodel.CacheGetRandomSatisfiedChannel(group, originalModel, excludedChannels)
+		var retryChannel *dbmodel.Channel
+		var err error
+		if channelIds, ok := c.Get(ctxkey.RoutingChannelIds); ok {
+			// a routing rule pinned the request to a channel set, never retry outside of it
+			retryChannel = dbmodel.CacheGetRandomChannelByIds(channelIds.([]int), originalModel, excludedChannels)
+		} else if virtualModel := dbmodel.CacheGetVirtualModel(group, c.GetString(ctxkey.VirtualModel)); virtualModel != nil {
+			// stay on the current model while it has channels left, then fall back along the chain
+			var servedModel string
+			servedModel, retryChannel = dbmodel.CacheGetVirtualModelChannel(group, virtualModel, originalModel, excludedChannels)
+			if retryChannel != nil && servedModel != originalModel {
+				logger.Infof(ctx, "virtual model %s falls back from %s to %s", virtualModel.Name, originalModel, servedModel)
+				err = routing.ServeVirtualModel(c, virtualModel.Name, originalModel, servedModel)
+				originalModel = servedModel
+			}
+		} else {
+			retryChannel, err = dbmodel.CacheGetRandomSatisfiedChannel(group, originalModel, excludedChannels)
+		}
 		if err != nil {
 			logger.Errorf(ctx, "CacheGetRandomSatisfiedChannel failed: %+v", err)
 			break
@@ -92,15 +146,22 @@ func Relay(c *gin.Context) {
 			break
 		}
 		logger.Infof(ctx, "using channel #%d to retry (remain times %d)", retryChannel.Id, len(excludedChannels))
-		middleware.SetupContextForSelectedChannel(c, retryChannel, originalModel)
+		if err = middleware.SetupContextForSelectedChannel(c, retryChannel, originalModel); err != nil {
+			logger.Errorf(ctx, "channel #%d skipped: %s", retryChannel.Id, err.Error())
+			excludedChannels = append(excludedChannels, retryChannel.Id)
+			continue
+		}
 		requestBody, err := common.GetRequestBody(c)
 		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
-		bizErr = relayHelper(c, relayMode)
+		bizErr = relayWithChannelLimit(c, relay)
 		if bizErr == nil {
+			if shadow != nil {
+				shadow.start(c)
+			}
 			return
 		}
 		excludedChannels = append(excludedChannels, retryChannel.Id)
-		go processChannelRelayError(ctx, userId, retryChannel.Id, retryChannel.Name, bizErr)
+		go processChannelRelayError(ctx, userId, retryChannel.Id, retryChannel.Name, c.GetString(ctxkey.ChannelKeyHash), bizErr)
 	}
 
 	requestBody, _ := common.GetRequestBody(c)
@@ -119,6 +180,7 @@ func Relay(c *gin.Context) {
 	})
 
 	if bizErr.Code != "insufficient_user_quota" {
+		dbmodel.RecordUsageError(userId, originalModel, c.GetString(ctxkey.TokenName), c.GetInt(ctxkey.Channel))
 		go logRespError(ctx, userId, originalModel, excludedChannels, bizErr.StatusCode, responseError, string(requestBody), requestId, c.Request.URL.Path)
 	}
 }
@@ -164,9 +226,25 @@ func shouldRetry(c *gin.Context, bizError *model.ErrorWithStatusCode) bool {
 	return true
 }
 
-func processChannelRelayError(ctx context.Context, userId int, channelId int, channelName string, err *model.ErrorWithStatusCode) {
+func processChannelRelayError(ctx context.Context, userId int, channelId int, channelName string, keyHash string, err *model.ErrorWithStatusCode) {
 	logger.Errorf(ctx, "relay error (channel id %d, user id: %d): %s", channelId, userId, err.Message)
+	if err.Code == channelBusyErrorCode {
+		// the channel was never called
+		return
+	}
 	// https://platform.openai.com/docs/guides/error-codes/api-errors
+	if keyHash != "" {
+		// multi-key channel, only the key in use is disabled or cooled down
+		if monitor.ShouldDisableChannel(err, err.StatusCode) {
+			monitor.DisableChannelKey(channelId, channelName, keyHash, err.Message)
+		} else {
+			if monitor.ShouldCooldownChannelKey(err) {
+				dbmodel.CooldownChannelKey(channelId, keyHash)
+			}
+			monitor.Emit(channelId, false)
+		}
+		return
+	}
 	if monitor.ShouldDisableChannel(err, err.StatusCode) {
 		monitor.DisableChannel(channelId, channelName, err.Message)
 	} else {
@@ -0,0 +1,728 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/model"
+)
+
+const (
+	scimSchemaUser           = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimSchemaGroup          = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	scimSchemaListResponse   = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	scimSchemaError          = "urn:ietf:params:scim:api:messages:2.0:Error"
+	scimSchemaServiceConfig  = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	scimSchemaResourceType   = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	scimDefaultCount         = 100
+	scimMaxCount             = 1000
+	scimContentType          = "application/scim+json"
+	scimUserAttributePrefix  = scimSchemaUser + ":"
+	scimGroupAttributePrefix = scimSchemaGroup + ":"
+)
+
+type ScimName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type ScimMultiValue struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type ScimMeta struct {
+	ResourceType string `json:"resourceType"`
+	Created      string `json:"created,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	Location     string `json:"location"`
+}
+
+type ScimUserResource struct {
+	Schemas     []string         `json:"schemas"`
+	Id          string           `json:"id,omitempty"`
+	ExternalId  string           `json:"externalId,omitempty"`
+	UserName    string           `json:"userName"`
+	DisplayName string           `json:"displayName,omitempty"`
+	Name        *ScimName        `json:"name,omitempty"`
+	Emails      []ScimMultiValue `json:"emails,omitempty"`
+	Active      *bool            `json:"active,omitempty"`
+	Groups      []ScimMultiValue `json:"groups,omitempty"`
+	Meta        *ScimMeta        `json:"meta,omitempty"`
+}
+
+type ScimGroupResource struct {
+	Schemas     []string         `json:"schemas"`
+	Id          string           `json:"id,omitempty"`
+	ExternalId  string           `json:"externalId,omitempty"`
+	DisplayName string           `json:"displayName"`
+	Members     []ScimMultiValue `json:"members"`
+	Meta        *ScimMeta        `json:"meta,omitempty"`
+}
+
+type ScimPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+type ScimPatchRequest struct {
+	Schemas    []string             `json:"schemas"`
+	Operations []ScimPatchOperation `json:"Operations"`
+}
+
+func scimJSON(c *gin.Context, status int, obj any) {
+	c.Header("Content-Type", scimContentType)
+	c.JSON(status, obj)
+}
+
+func scimError(c *gin.Context, status int, scimType string, detail string) {
+	body := gin.H{
+		"schemas": []string{scimSchemaError},
+		"status":  strconv.Itoa(status),
+		"detail":  detail,
+	}
+	if scimType != "" {
+		body["scimType"] = scimType
+	}
+	scimJSON(c, status, body)
+}
+
+func scimModelError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, model.ErrScimNotFound):
+		scimError(c, http.StatusNotFound, "", err.Error())
+	case errors.Is(err, model.ErrScimConflict):
+		scimError(c, http.StatusConflict, "uniqueness", err.Error())
+	case errors.Is(err, model.ErrScimForbidden):
+		scimError(c, http.StatusForbidden, "", err.Error())
+	default:
+		scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+	}
+}
+
+func scimLocation(resourceType string, id int) string {
+	return fmt.Sprintf("%s/scim/v2/%ss/%d", strings.TrimSuffix(config.ServerAddress, "/"), resourceType, id)
+}
+
+func scimTime(timestamp int64) string {
+	if timestamp == 0 {
+		return ""
+	}
+	return time.Unix(timestamp, 0).UTC().Format(time.RFC3339)
+}
+
+// getScimPage returns the offset and the limit from the 1-based startIndex and count parameters
+func getScimPage(c *gin.Context) (startIndex int, count int) {
+	startIndex, err := strconv.Atoi(c.Query("startIndex"))
+	if err != nil || startIndex < 1 {
+		startIndex = 1
+	}
+	count, err = strconv.Atoi(c.Query("count"))
+	if err != nil || count < 0 {
+		count = scimDefaultCount
+	}
+	if count > scimMaxCount {
+		count = scimMaxCount
+	}
+	return startIndex, count
+}
+
+func scimListResponse(c *gin.Context, total int64, startIndex int, resources []any) {
+	scimJSON(c, http.StatusOK, gin.H{
+		"schemas":      []string{scimSchemaListResponse},
+		"totalResults": total,
+		"startIndex":   startIndex,
+		"itemsPerPage": len(resources),
+		"Resources":    resources,
+	})
+}
+
+func toScimUser(user *model.User) (*ScimUserResource, error) {
+	active := user.Status == model.UserStatusEnabled
+	resource := &ScimUserResource{
+		Schemas:     []string{scimSchemaUser},
+		Id:          strconv.Itoa(user.Id),
+		ExternalId:  user.OidcId,
+		UserName:    user.Username,
+		DisplayName: user.DisplayName,
+		Active:      &active,
+		Meta: &ScimMeta{
+			ResourceType: "User",
+			Created:      scimTime(user.CreateAt),
+			Location:     scimLocation("User", user.Id),
+		},
+	}
+	if user.DisplayName != "" {
+		resource.Name = &ScimName{Formatted: user.DisplayName}
+	}
+	if user.Email != "" {
+		resource.Emails = []ScimMultiValue{{Value: user.Email, Type: "work", Primary: true}}
+	}
+	groups, err := model.GetUserScimGroups(user.Id)
+	if err != nil {
+		return nil, err
+	}
+	for _, group := range groups {
+		resource.Groups = append(resource.Groups, ScimMultiValue{Value: strconv.Itoa(group.Id), Display: group.DisplayName})
+	}
+	return resource, nil
+}
+
+// applyScimUser copies the attributes of the resource to the user and tells whether the user is active
+func applyScimUser(user *model.User, resource *ScimUserResource) bool {
+	user.Username = strings.TrimSpace(resource.UserName)
+	user.OidcId = resource.ExternalId
+	user.DisplayName = resource.DisplayName
+	if user.DisplayName == "" && resource.Name != nil {
+		user.DisplayName = resource.Name.Formatted
+		if user.DisplayName == "" {
+			user.DisplayName = strings.TrimSpace(resource.Name.GivenName + " " + resource.Name.FamilyName)
+		}
+	}
+	user.Email = ""
+	for _, email := range resource.Emails {
+		if user.Email == "" || email.Primary {
+			user.Email = email.Value
+		}
+	}
+	return resource.Active == nil || *resource.Active
+}
+
+func getScimUserParam(c *gin.Context) (*model.User, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		scimError(c, http.StatusNotFound, "", model.ErrScimNotFound.Error())
+		return nil, false
+	}
+	user, err := model.GetScimUser(id)
+	if err != nil {
+		scimModelError(c, err)
+		return nil, false
+	}
+	return user, true
+}
+
+func respondScimUser(c *gin.Context, status int, id int) {
+	user, err := model.GetScimUser(id)
+	if err != nil {
+		scimModelError(c, err)
+		return
+	}
+	resource, err := toScimUser(user)
+	if err != nil {
+		scimError(c, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+	scimJSON(c, status, resource)
+}
+
+func ScimListUsers(c *gin.Context) {
+	attribute, value, err := model.ParseScimFilter(c.Query("filter"))
+	if err != nil {
+		scimError(c, http.StatusBadRequest, "invalidFilter", err.Error())
+		return
+	}
+	startIndex, count := getScimPage(c)
+	users, total, err := model.GetScimUsers(attribute, value, startIndex-1, count)
+	if err != nil {
+		scimError(c, http.StatusBadRequest, "invalidFilter", err.Error())
+		return
+	}
+	resources := make([]any, 0, len(users))
+	for _, user := range users {
+		resource, err := toScimUser(user)
+		if err != nil {
+			scimError(c, http.StatusInternalServerError, "", err.Error())
+			return
+		}
+		resources = append(resources, resource)
+	}
+	scimListResponse(c, total, startIndex, resources)
+}
+
+func ScimGetUser(c *gin.Context) {
+	user, ok := getScimUserParam(c)
+	if !ok {
+		return
+	}
+	respondScimUser(c, http.StatusOK, user.Id)
+}
+
+func ScimCreateUser(c *gin.Context) {
+	resource := ScimUserResource{}
+	if err := c.ShouldBindJSON(&resource); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+	user := model.User{}
+	if !applyScimUser(&user, &resource) {
+		user.Status = model.UserStatusDisabled
+	}
+	if err := model.ProvisionScimUser(&user); err != nil {
+		scimModelError(c, err)
+		return
+	}
+	respondScimUser(c, http.StatusCreated, user.Id)
+}
+
+func ScimReplaceUser(c *gin.Context) {
+	user, ok := getScimUserParam(c)
+	if !ok {
+		return
+	}
+	resource := ScimUserResource{}
+	if err := c.ShouldBindJSON(&resource); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+	active := applyScimUser(user, &resource)
+	if err := model.UpdateScimUser(user, active); err != nil {
+		scimModelError(c, err)
+		return
+	}
+	respondScimUser(c, http.StatusOK, user.Id)
+}
+
+func ScimPatchUser(c *gin.Context) {
+	user, ok := getScimUserParam(c)
+	if !ok {
+		return
+	}
+	request := ScimPatchRequest{}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+	current, err := toScimUser(user)
+	if err != nil {
+		scimError(c, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+	attributes := make(map[string]any)
+	data, _ := json.Marshal(current)
+	_ = json.Unmarshal(data, &attributes)
+	for _, operation := range request.Operations {
+		if err := applyScimUserPatch(attributes, operation); err != nil {
+			scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+			return
+		}
+	}
+	resource := ScimUserResource{}
+	data, _ = json.Marshal(attributes)
+	if err := json.Unmarshal(data, &resource); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+		return
+	}
+	active := applyScimUser(user, &resource)
+	if err := model.UpdateScimUser(user, active); err != nil {
+		scimModelError(c, err)
+		return
+	}
+	respondScimUser(c, http.StatusOK, user.Id)
+}
+
+// scimUserAttributes maps the lower case names of the patchable user attributes to their names
+var scimUserAttributes = map[string]string{
+	"username":    "userName",
+	"displayname": "displayName",
+	"externalid":  "externalId",
+	"active":      "active",
+	"name":        "name",
+	"emails":      "emails",
+}
+
+// applyScimUserPatch applies a PATCH operation to the attributes of a user, the attributes
+// that are not stored are ignored as identity providers push them regardless
+func applyScimUserPatch(attributes map[string]any, operation ScimPatchOperation) error {
+	op := strings.ToLower(operation.Op)
+	if op != "add" && op != "replace" && op != "remove" {
+		return fmt.Errorf("不支持的操作：%s", operation.Op)
+	}
+	var value any
+	if len(operation.Value) > 0 {
+		if err := json.Unmarshal(operation.Value, &value); err != nil {
+			return err
+		}
+	}
+	if operation.Path == "" {
+		values, ok := value.(map[string]any)
+		if !ok {
+			return errors.New("缺少 path 时 value 必须是对象")
+		}
+		for path, item := range values {
+			setScimUserAttribute(attributes, op, path, item)
+		}
+		return nil
+	}
+	setScimUserAttribute(attributes, op, operation.Path, value)
+	return nil
+}
+
+func setScimUserAttribute(attributes map[string]any, op string, path string, value any) {
+	if strings.HasPrefix(path, "urn:") {
+		if !strings.HasPrefix(path, scimUserAttributePrefix) {
+			return
+		}
+		path = strings.TrimPrefix(path, scimUserAttributePrefix)
+	}
+	attribute, subAttribute, _ := strings.Cut(path, ".")
+	if i := strings.Index(attribute, "["); i >= 0 {
+		// such as emails[type eq "work"].value, the user has a single email
+		if !strings.EqualFold(attribute[:i], "emails") || (subAttribute != "" && !strings.EqualFold(subAttribute, "value")) {
+			return
+		}
+		if op == "remove" {
+			delete(attributes, "emails")
+			return
+		}
+		if email, ok := value.(string); ok {
+			attributes["emails"] = []any{map[string]any{"value": email, "primary": true}}
+		}
+		return
+	}
+	name, ok := scimUserAttributes[strings.ToLower(attribute)]
+	if !ok {
+		return
+	}
+	if subAttribute != "" {
+		if name != "name" {
+			return
+		}
+		nested, _ := attributes[name].(map[string]any)
+		if nested == nil {
+			nested = make(map[string]any)
+		}
+		if op == "remove" {
+			delete(nested, subAttribute)
+		} else {
+			nested[subAttribute] = value
+		}
+		attributes[name] = nested
+		return
+	}
+	if op == "remove" {
+		delete(attributes, name)
+		return
+	}
+	if s, ok := value.(string); ok && name == "active" {
+		// some identity providers send booleans as strings
+		value = strings.EqualFold(s, "true")
+	}
+	attributes[name] = value
+}
+
+func ScimDeleteUser(c *gin.Context) {
+	user, ok := getScimUserParam(c)
+	if !ok {
+		return
+	}
+	if err := model.DeprovisionScimUser(user, true); err != nil {
+		scimModelError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func toScimGroup(group *model.ScimGroup) (*ScimGroupResource, error) {
+	memberIds, err := model.GetScimGroupMemberIds(group.Id)
+	if err != nil {
+		return nil, err
+	}
+	resource := &ScimGroupResource{
+		Schemas:     []string{scimSchemaGroup},
+		Id:          strconv.Itoa(group.Id),
+		ExternalId:  group.ExternalId,
+		DisplayName: group.DisplayName,
+		Members:     make([]ScimMultiValue, 0, len(memberIds)),
+		Meta: &ScimMeta{
+			ResourceType: "Group",
+			Created:      scimTime(group.CreatedTime),
+			LastModified: scimTime(group.UpdatedTime),
+			Location:     scimLocation("Group", group.Id),
+		},
+	}
+	for _, memberId := range memberIds {
+		resource.Members = append(resource.Members, ScimMultiValue{
+			Value:   strconv.Itoa(memberId),
+			Display: model.GetUsernameById(memberId),
+		})
+	}
+	return resource, nil
+}
+
+func getScimMemberIds(members []ScimMultiValue) ([]int, error) {
+	ids := make([]int, 0, len(members))
+	for _, member := range members {
+		id, err := strconv.Atoi(member.Value)
+		if err != nil {
+			return nil, fmt.Errorf("无效的成员：%s", member.Value)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func getScimGroupParam(c *gin.Context) (*model.ScimGroup, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		scimError(c, http.StatusNotFound, "", model.ErrScimNotFound.Error())
+		return nil, false
+	}
+	group, err := model.GetScimGroupById(id)
+	if err != nil {
+		scimModelError(c, err)
+		return nil, false
+	}
+	return group, true
+}
+
+func respondScimGroup(c *gin.Context, status int, id int) {
+	group, err := model.GetScimGroupById(id)
+	if err != nil {
+		scimModelError(c, err)
+		return
+	}
+	resource, err := toScimGroup(group)
+	if err != nil {
+		scimError(c, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+	scimJSON(c, status, resource)
+}
+
+func ScimListGroups(c *gin.Context) {
+	attribute, value, err := model.ParseScimFilter(c.Query("filter"))
+	if err != nil {
+		scimError(c, http.StatusBadRequest, "invalidFilter", err.Error())
+		return
+	}
+	startIndex, count := getScimPage(c)
+	groups, total, err := model.GetScimGroups(attribute, value, startIndex-1, count)
+	if err != nil {
+		scimError(c, http.StatusBadRequest, "invalidFilter", err.Error())
+		return
+	}
+	resources := make([]any, 0, len(groups))
+	for _, group := range groups {
+		resource, err := toScimGroup(group)
+		if err != nil {
+			scimError(c, http.StatusInternalServerError, "", err.Error())
+			return
+		}
+		resources = append(resources, resource)
+	}
+	scimListResponse(c, total, startIndex, resources)
+}
+
+func ScimGetGroup(c *gin.Context) {
+	group, ok := getScimGroupParam(c)
+	if !ok {
+		return
+	}
+	respondScimGroup(c, http.StatusOK, group.Id)
+}
+
+func ScimCreateGroup(c *gin.Context) {
+	resource := ScimGroupResource{}
+	if err := c.ShouldBindJSON(&resource); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+	memberIds, err := getScimMemberIds(resource.Members)
+	if err != nil {
+		scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+		return
+	}
+	group := model.ScimGroup{
+		DisplayName: strings.TrimSpace(resource.DisplayName),
+		ExternalId:  resource.ExternalId,
+	}
+	if err := group.Insert(memberIds); err != nil {
+		scimModelError(c, err)
+		return
+	}
+	respondScimGroup(c, http.StatusCreated, group.Id)
+}
+
+func ScimReplaceGroup(c *gin.Context) {
+	group, ok := getScimGroupParam(c)
+	if !ok {
+		return
+	}
+	resource := ScimGroupResource{}
+	if err := c.ShouldBindJSON(&resource); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+	memberIds, err := getScimMemberIds(resource.Members)
+	if err != nil {
+		scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+		return
+	}
+	group.DisplayName = strings.TrimSpace(resource.DisplayName)
+	group.ExternalId = resource.ExternalId
+	if err := group.Update(); err != nil {
+		scimModelError(c, err)
+		return
+	}
+	if err := group.SetMembers(memberIds); err != nil {
+		scimModelError(c, err)
+		return
+	}
+	respondScimGroup(c, http.StatusOK, group.Id)
+}
+
+func ScimPatchGroup(c *gin.Context) {
+	group, ok := getScimGroupParam(c)
+	if !ok {
+		return
+	}
+	request := ScimPatchRequest{}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+	for _, operation := range request.Operations {
+		if err := applyScimGroupPatch(group, operation); err != nil {
+			scimModelError(c, err)
+			return
+		}
+	}
+	respondScimGroup(c, http.StatusOK, group.Id)
+}
+
+// applyScimGroupPatch applies a PATCH operation, members are changed one operation at a time
+// so that large groups are never sent whole
+func applyScimGroupPatch(group *model.ScimGroup, operation ScimPatchOperation) error {
+	op := strings.ToLower(operation.Op)
+	if op != "add" && op != "replace" && op != "remove" {
+		return fmt.Errorf("不支持的操作：%s", operation.Op)
+	}
+	path := strings.TrimPrefix(operation.Path, scimGroupAttributePrefix)
+	if path == "" {
+		values := make(map[string]json.RawMessage)
+		if err := json.Unmarshal(operation.Value, &values); err != nil {
+			return errors.New("缺少 path 时 value 必须是对象")
+		}
+		for name, value := range values {
+			err := applyScimGroupPatch(group, ScimPatchOperation{Op: op, Path: name, Value: value})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	attribute, filter, _ := strings.Cut(path, "[")
+	switch strings.ToLower(attribute) {
+	case "displayname", "externalid":
+		var value string
+		if op != "remove" {
+			if err := json.Unmarshal(operation.Value, &value); err != nil {
+				return err
+			}
+		}
+		if strings.EqualFold(attribute, "displayName") {
+			group.DisplayName = strings.TrimSpace(value)
+		} else {
+			group.ExternalId = value
+		}
+		return group.Update()
+	case "members":
+		if filter != "" {
+			// such as members[value eq "42"]
+			if op != "remove" {
+				return fmt.Errorf("不支持的操作：%s %s", operation.Op, path)
+			}
+			_, value, err := model.ParseScimFilter(strings.TrimSuffix(filter, "]"))
+			if err != nil {
+				return err
+			}
+			memberId, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("无效的成员：%s", value)
+			}
+			return group.RemoveMembers([]int{memberId})
+		}
+		var members []ScimMultiValue
+		if len(operation.Value) > 0 {
+			if err := json.Unmarshal(operation.Value, &members); err != nil {
+				return err
+			}
+		}
+		memberIds, err := getScimMemberIds(members)
+		if err != nil {
+			return err
+		}
+		switch op {
+		case "add":
+			return group.AddMembers(memberIds)
+		case "replace":
+			return group.SetMembers(memberIds)
+		}
+		if len(operation.Value) == 0 {
+			return group.SetMembers(nil)
+		}
+		return group.RemoveMembers(memberIds)
+	}
+	return nil
+}
+
+func ScimDeleteGroup(c *gin.Context) {
+	group, ok := getScimGroupParam(c)
+	if !ok {
+		return
+	}
+	if err := group.Delete(); err != nil {
+		scimModelError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func GetScimServiceProviderConfig(c *gin.Context) {
+	scimJSON(c, http.StatusOK, gin.H{
+		"schemas":        []string{scimSchemaServiceConfig},
+		"patch":          gin.H{"supported": true},
+		"bulk":           gin.H{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         gin.H{"supported": true, "maxResults": scimMaxCount},
+		"changePassword": gin.H{"supported": false},
+		"sort":           gin.H{"supported": false},
+		"etag":           gin.H{"supported": false},
+		"authenticationSchemes": []gin.H{{
+			"type":        "oauthbearertoken",
+			"name":        "OAuth Bearer Token",
+			"description": "Authentication with the SCIM token set in the options",
+		}},
+	})
+}
+
+func GetScimResourceTypes(c *gin.Context) {
+	resources := []any{
+		gin.H{
+			"schemas":  []string{scimSchemaResourceType},
+			"id":       "User",
+			"name":     "User",
+			"endpoint": "/Users",
+			"schema":   scimSchemaUser,
+		},
+		gin.H{
+			"schemas":  []string{scimSchemaResourceType},
+			"id":       "Group",
+			"name":     "Group",
+			"endpoint": "/Groups",
+			"schema":   scimSchemaGroup,
+		},
+	}
+	scimListResponse(c, int64(len(resources)), 1, resources)
+}
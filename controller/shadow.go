@@ -0,0 +1,166 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/model"
+)
+
+func GetAllShadowConfigs(c *gin.Context) {
+	configs, err := model.GetAllShadowConfigs()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    configs,
+	})
+	return
+}
+
+func validateShadowConfig(shadowConfig *model.ShadowConfig) error {
+	if shadowConfig.ModelName == "" {
+		return errors.New("模型名称不能为空")
+	}
+	if shadowConfig.Percent < 0 || shadowConfig.Percent > 100 {
+		return errors.New("影子流量比例必须在 0 到 100 之间")
+	}
+	if _, err := model.GetChannelById(shadowConfig.ChannelId, false); err != nil {
+		return errors.New("影子渠道不存在")
+	}
+	return nil
+}
+
+func AddShadowConfig(c *gin.Context) {
+	shadowConfig := model.ShadowConfig{}
+	err := c.ShouldBindJSON(&shadowConfig)
+	if err == nil {
+		err = validateShadowConfig(&shadowConfig)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if shadowConfig.Status == 0 {
+		shadowConfig.Status = model.ShadowStatusEnabled
+	}
+	err = shadowConfig.Insert()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.InitShadowConfigCache()
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    shadowConfig,
+	})
+	return
+}
+
+func UpdateShadowConfig(c *gin.Context) {
+	shadowConfig := model.ShadowConfig{}
+	err := c.ShouldBindJSON(&shadowConfig)
+	if err == nil {
+		err = validateShadowConfig(&shadowConfig)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	err = shadowConfig.Update()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.InitShadowConfigCache()
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    shadowConfig,
+	})
+	return
+}
+
+func DeleteShadowConfig(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	err := model.DeleteShadowConfigById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.InitShadowConfigCache()
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+	return
+}
+
+func GetShadowRecords(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	channelId, _ := strconv.Atoi(c.Query("channel"))
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	records, err := model.GetShadowRecords(channelId, startTimestamp, endTimestamp, p*config.ItemsPerPage, config.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    records,
+	})
+	return
+}
+
+func GetShadowReport(c *gin.Context) {
+	channelId, _ := strconv.Atoi(c.Query("channel"))
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	reports, err := model.GetShadowReport(channelId, startTimestamp, endTimestamp)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    reports,
+	})
+	return
+}
@@ -0,0 +1,109 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/controller"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+)
+
+func abortWithTaskError(c *gin.Context, statusCode int, err error) {
+	c.JSON(statusCode, gin.H{
+		"error": relaymodel.Error{
+			Message: helper.MessageWithRequestId(err.Error(), c.GetString(helper.RequestIdKey)),
+			Type:    "one_api_error",
+		},
+	})
+}
+
+// GetTask returns the status of a task of the user, for the clients polling it
+func GetTask(c *gin.Context) {
+	task, err := model.GetUserTaskByTaskId(c.GetInt(ctxkey.Id), c.Param("id"))
+	if err != nil {
+		abortWithTaskError(c, http.StatusNotFound, errors.New("任务不存在"))
+		return
+	}
+	c.JSON(http.StatusOK, controller.GetTaskView(task))
+}
+
+// GetTaskContent serves a result of a succeeded task, from TASK_RESULT_DIR when it was downloaded,
+// proxied with the credentials of the channel when the upstream needs them, or by a redirect
+func GetTaskContent(c *gin.Context) {
+	task, err := model.GetUserTaskByTaskId(c.GetInt(ctxkey.Id), c.Param("id"))
+	if err != nil {
+		abortWithTaskError(c, http.StatusNotFound, errors.New("任务不存在"))
+		return
+	}
+	index, _ := strconv.Atoi(c.Query("index"))
+	urls := task.GetResultURLs()
+	if task.Status != relaymodel.TaskStatusSucceeded || index < 0 || index >= len(urls) {
+		abortWithTaskError(c, http.StatusNotFound, errors.New("任务结果不存在"))
+		return
+	}
+	if task.Cached {
+		path := controller.GetTaskResultPath(task, index)
+		if _, err = os.Stat(path); err == nil {
+			c.File(path)
+			return
+		}
+	}
+	if !task.URLsNeedAuth {
+		c.Redirect(http.StatusFound, urls[index])
+		return
+	}
+	meta, _, err := controller.GetTaskMeta(task)
+	if err != nil {
+		abortWithTaskError(c, http.StatusInternalServerError, err)
+		return
+	}
+	resp, err := controller.OpenTaskResult(c.Request.Context(), task, meta, urls[index])
+	if err != nil {
+		abortWithTaskError(c, http.StatusBadGateway, err)
+		return
+	}
+	defer resp.Body.Close()
+	c.DataFromReader(http.StatusOK, resp.ContentLength, resp.Header.Get("Content-Type"), resp.Body, nil)
+}
+
+func getTasks(c *gin.Context, userId int) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	num := config.ItemsPerPage
+	if c.Query("num") != "" {
+		if n, err := strconv.Atoi(c.Query("num")); err == nil && n > 0 {
+			num = n
+		}
+	}
+	tasks, err := model.GetAllTasks(p*num, num, userId, c.Query("status"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    tasks,
+	})
+}
+
+func GetAllTasks(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	getTasks(c, userId)
+}
+
+func GetUserTasks(c *gin.Context) {
+	getTasks(c, c.GetInt(ctxkey.Id))
+}
@@ -167,6 +167,14 @@ func GetToken(c *gin.Context) {
 		})
 		return
 	}
+	token.ModelQuotaUsages, err = model.GetTokenModelQuotas(token)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -190,12 +198,21 @@ func GetTokenStatus(c *gin.Context) {
 	if expiredAt == -1 {
 		expiredAt = 0
 	}
+	modelQuotas, err := model.GetTokenModelQuotas(token)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"object":          "credit_summary",
 		"total_granted":   token.RemainQuota,
 		"total_used":      0, // not supported currently
 		"total_available": token.RemainQuota,
 		"expires_at":      expiredAt * 1000,
+		"model_quotas":    modelQuotas,
 	})
 }
 
@@ -209,6 +226,16 @@ func validateToken(c *gin.Context, token model.Token) error {
 			return fmt.Errorf("无效的网段：%s", err.Error())
 		}
 	}
+	if token.Models != nil {
+		if err := model.ValidateTokenModels(*token.Models); err != nil {
+			return err
+		}
+	}
+	if token.ModelQuotas != nil {
+		if _, err := model.ParseTokenModelQuotas(*token.ModelQuotas); err != nil {
+			return fmt.Errorf("无效的模型额度：%s", err.Error())
+		}
+	}
 	return nil
 }
 
@@ -242,6 +269,7 @@ func AddToken(c *gin.Context) {
 		UnlimitedQuota: token.UnlimitedQuota,
 		Models:         token.Models,
 		Subnet:         token.Subnet,
+		ModelQuotas:    token.ModelQuotas,
 	}
 	err = cleanToken.Insert()
 	if err != nil {
@@ -331,6 +359,7 @@ func UpdateToken(c *gin.Context) {
 		cleanToken.UnlimitedQuota = token.UnlimitedQuota
 		cleanToken.Models = token.Models
 		cleanToken.Subnet = token.Subnet
+		cleanToken.ModelQuotas = token.ModelQuotas
 	}
 	err = cleanToken.Update()
 	if err != nil {
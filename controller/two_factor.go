@@ -0,0 +1,301 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/totp"
+	"github.com/songquanpeng/one-api/model"
+)
+
+const (
+	sessionTwoFactorKey = "two_factor" // whether the login passed the two-factor challenge
+	// the pending challenge of a login that passed the first factor
+	sessionTwoFactorUserIdKey   = "two_factor_user_id"
+	sessionTwoFactorExpireKey   = "two_factor_expire"
+	sessionTwoFactorAttemptsKey = "two_factor_attempts"
+
+	twoFactorChallengeTimeout     = 5 * 60 // unit is second
+	twoFactorChallengeMaxAttempts = 5
+)
+
+type TwoFactorRequest struct {
+	Code string `json:"code"`
+}
+
+func clearTwoFactorChallenge(session sessions.Session) {
+	session.Delete(sessionTwoFactorUserIdKey)
+	session.Delete(sessionTwoFactorExpireKey)
+	session.Delete(sessionTwoFactorAttemptsKey)
+}
+
+// startTwoFactorChallenge keeps the user that passed the first factor in the session until the code is verified
+func startTwoFactorChallenge(user *model.User, c *gin.Context) {
+	session := sessions.Default(c)
+	session.Clear()
+	session.Set(sessionTwoFactorUserIdKey, user.Id)
+	session.Set(sessionTwoFactorExpireKey, helper.GetTimestamp()+twoFactorChallengeTimeout)
+	session.Set(sessionTwoFactorAttemptsKey, 0)
+	err := session.Save()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "无法保存会话信息，请重试",
+			"success": false,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "请输入两步验证码",
+		"success": false,
+		"data": gin.H{
+			"two_factor_required": true,
+		},
+	})
+}
+
+// VerifyTwoFactorLogin completes the login challenged by SetupLogin with a TOTP or recovery code
+func VerifyTwoFactorLogin(c *gin.Context) {
+	session := sessions.Default(c)
+	userId, _ := session.Get(sessionTwoFactorUserIdKey).(int)
+	expire, _ := session.Get(sessionTwoFactorExpireKey).(int64)
+	attempts, _ := session.Get(sessionTwoFactorAttemptsKey).(int)
+	if userId == 0 || expire < helper.GetTimestamp() || attempts >= twoFactorChallengeMaxAttempts {
+		clearTwoFactorChallenge(session)
+		_ = session.Save()
+		c.JSON(http.StatusOK, gin.H{
+			"message": "两步验证已过期，请重新登录",
+			"success": false,
+		})
+		return
+	}
+	var req TwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "无效的参数",
+			"success": false,
+		})
+		return
+	}
+	usedRecoveryCode, err := model.VerifyTwoFactor(userId, req.Code)
+	if err != nil {
+		session.Set(sessionTwoFactorAttemptsKey, attempts+1)
+		_ = session.Save()
+		model.RecordTwoFactorEvent(userId, c.ClientIP(), fmt.Sprintf("登录验证失败（第 %d 次）", attempts+1))
+		c.JSON(http.StatusOK, gin.H{
+			"message": err.Error(),
+			"success": false,
+		})
+		return
+	}
+	if usedRecoveryCode {
+		model.RecordTwoFactorEvent(userId, c.ClientIP(), "使用恢复码登录")
+	} else {
+		model.RecordTwoFactorEvent(userId, c.ClientIP(), "登录验证成功")
+	}
+	user, err := model.GetUserById(userId, false)
+	if err != nil || user.Status != model.UserStatusEnabled {
+		clearTwoFactorChallenge(session)
+		_ = session.Save()
+		c.JSON(http.StatusOK, gin.H{
+			"message": "用户已被封禁",
+			"success": false,
+		})
+		return
+	}
+	setupLoginSession(user, c, true)
+}
+
+func GetSelfTwoFactor(c *gin.Context) {
+	twoFactor, err := model.GetTwoFactor(c.GetInt(ctxkey.Id))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"enabled":             twoFactor.Enabled,
+			"enabled_time":        twoFactor.EnabledTime,
+			"recovery_codes_left": twoFactor.RecoveryCodesLeft(),
+			"required":            config.AdminTwoFactorRequired && c.GetInt(ctxkey.Role) >= model.RoleAdminUser,
+		},
+	})
+}
+
+// SetupSelfTwoFactor returns a new secret, and its otpauth URI to show as a QR code
+func SetupSelfTwoFactor(c *gin.Context) {
+	id := c.GetInt(ctxkey.Id)
+	secret, err := model.SetupTwoFactor(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.RecordTwoFactorEvent(id, c.ClientIP(), "生成新密钥")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"secret": secret,
+			"uri":    totp.URI(config.SystemName, c.GetString(ctxkey.Username), secret),
+		},
+	})
+}
+
+// EnableSelfTwoFactor confirms the secret with a code and returns the recovery codes, only shown once
+func EnableSelfTwoFactor(c *gin.Context) {
+	id := c.GetInt(ctxkey.Id)
+	var req TwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	codes, err := model.EnableTwoFactor(id, req.Code)
+	if err != nil {
+		model.RecordTwoFactorEvent(id, c.ClientIP(), "启用失败")
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.RecordTwoFactorEvent(id, c.ClientIP(), "已启用")
+	// the current login now counts as verified, as the code was just checked
+	session := sessions.Default(c)
+	if session.Get("id") != nil {
+		session.Set(sessionTwoFactorKey, true)
+		_ = session.Save()
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"recovery_codes": codes,
+		},
+	})
+}
+
+// verifySelfTwoFactor checks the code required to change the second factor of the user
+func verifySelfTwoFactor(c *gin.Context, id int) bool {
+	var req TwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return false
+	}
+	if _, err := model.VerifyTwoFactor(id, req.Code); err != nil {
+		model.RecordTwoFactorEvent(id, c.ClientIP(), "验证失败")
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return false
+	}
+	return true
+}
+
+func DisableSelfTwoFactor(c *gin.Context) {
+	id := c.GetInt(ctxkey.Id)
+	if config.AdminTwoFactorRequired && c.GetInt(ctxkey.Role) >= model.RoleAdminUser {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "管理员必须启用两步验证",
+		})
+		return
+	}
+	if !verifySelfTwoFactor(c, id) {
+		return
+	}
+	if err := model.DisableTwoFactor(id); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.RecordTwoFactorEvent(id, c.ClientIP(), "已停用")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+func RegenerateSelfRecoveryCodes(c *gin.Context) {
+	id := c.GetInt(ctxkey.Id)
+	if !verifySelfTwoFactor(c, id) {
+		return
+	}
+	codes, err := model.RegenerateRecoveryCodes(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.RecordTwoFactorEvent(id, c.ClientIP(), "重新生成恢复码")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"recovery_codes": codes,
+		},
+	})
+}
+
+// ResetUserTwoFactor removes the second factor of a user who lost it, the user sets it up again
+func ResetUserTwoFactor(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	user, err := model.GetUserById(id, false)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	myRole := c.GetInt(ctxkey.Role)
+	if myRole <= user.Role && myRole != model.RoleRootUser {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无权重置同权限等级或更高权限等级的用户的两步验证",
+		})
+		return
+	}
+	if err = model.DisableTwoFactor(id); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.RecordTwoFactorEvent(id, c.ClientIP(), "被管理员 "+c.GetString(ctxkey.Username)+" 重置")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
@@ -0,0 +1,171 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/model"
+)
+
+const (
+	defaultUsageRangeDays = 7
+	maxUsageRangeDays     = 366
+	defaultUsageTopN      = 10
+)
+
+// UsageChange is the relative change of the usage from the previous period, nil when the previous period has none
+type UsageChange struct {
+	Requests     *float64 `json:"requests"`
+	InputTokens  *float64 `json:"input_tokens"`
+	OutputTokens *float64 `json:"output_tokens"`
+	Quota        *float64 `json:"quota"`
+}
+
+func relativeChange(current int64, previous int64) *float64 {
+	if previous == 0 {
+		return nil
+	}
+	change := float64(current-previous) / float64(previous)
+	return &change
+}
+
+func getUsageStats(c *gin.Context, userIds []int) {
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	if endTimestamp == 0 {
+		endTimestamp = helper.GetTimestamp()
+	}
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	if startTimestamp == 0 {
+		startTimestamp = endTimestamp - defaultUsageRangeDays*24*3600
+	}
+	granularity := c.DefaultQuery("granularity", model.UsageGranularityDay)
+	var err error
+	if startTimestamp >= endTimestamp {
+		err = errors.New("结束时间必须晚于开始时间")
+	} else if endTimestamp-startTimestamp > maxUsageRangeDays*24*3600 {
+		err = errors.New("时间范围不能超过一年")
+	} else if granularity != model.UsageGranularityHour && granularity != model.UsageGranularityDay && granularity != model.UsageGranularityMonth {
+		err = errors.New("时间粒度只能是 hour、day 或 month")
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	topN, _ := strconv.Atoi(c.Query("top"))
+	if topN <= 0 {
+		topN = defaultUsageTopN
+	}
+	groupBy := c.Query("group_by")
+	filter := &model.UsageFilter{
+		UserIds:   userIds,
+		ModelName: c.Query("model_name"),
+		TokenName: c.Query("token_name"),
+		StartHour: model.UsageHourOf(startTimestamp),
+		EndHour:   model.UsageHourOf(endTimestamp - 1),
+	}
+	report, err := model.GetUsageReport(filter, granularity, groupBy, topN)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	// the previous period is as long as the range and ends where it starts
+	previousFilter := *filter
+	previousFilter.StartHour = model.UsageHourOf(startTimestamp - (endTimestamp - startTimestamp))
+	previousFilter.EndHour = model.UsageHourOf(startTimestamp - 1)
+	previous, err := model.GetUsageReport(&previousFilter, granularity, "", 0)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"start_timestamp": startTimestamp,
+			"end_timestamp":   endTimestamp,
+			"granularity":     granularity,
+			"group_by":        groupBy,
+			"series":          report.Series,
+			"top":             report.Top,
+			"total":           report.Total,
+			"previous":        previous.Total,
+			"change": UsageChange{
+				Requests:     relativeChange(report.Total.Requests, previous.Total.Requests),
+				InputTokens:  relativeChange(report.Total.InputTokens, previous.Total.InputTokens),
+				OutputTokens: relativeChange(report.Total.OutputTokens, previous.Total.OutputTokens),
+				Quota:        relativeChange(report.Total.Quota, previous.Total.Quota),
+			},
+		},
+	})
+}
+
+// GetAllUsageStats returns the usage analytics of a user or of the members of a team
+func GetAllUsageStats(c *gin.Context) {
+	if teamId, _ := strconv.Atoi(c.Query("team_id")); teamId != 0 {
+		getTeamUsageStats(c, teamId)
+		return
+	}
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	if userId == 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "需要指定用户或团队",
+		})
+		return
+	}
+	getUsageStats(c, []int{userId})
+}
+
+// GetUserUsageStats returns the usage analytics of the user, or of a team the user is a member of
+func GetUserUsageStats(c *gin.Context) {
+	userId := c.GetInt(ctxkey.Id)
+	teamId, _ := strconv.Atoi(c.Query("team_id"))
+	if teamId == 0 {
+		getUsageStats(c, []int{userId})
+		return
+	}
+	teams, err := model.GetUserScimGroups(userId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	for _, team := range teams {
+		if team.Id == teamId {
+			getTeamUsageStats(c, teamId)
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": false,
+		"message": "无权查看该团队的用量",
+	})
+}
+
+// getTeamUsageStats returns the usage of the members of a SCIM group
+func getTeamUsageStats(c *gin.Context, teamId int) {
+	userIds, err := model.GetScimGroupMemberIds(teamId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	getUsageStats(c, userIds)
+}
@@ -63,13 +63,24 @@ func Login(c *gin.Context) {
 	SetupLogin(&user, c)
 }
 
-// setup session & cookies and then return user info
+// SetupLogin challenges the users having enabled two-factor authentication, and logs the others in
 func SetupLogin(user *model.User, c *gin.Context) {
+	if model.IsTwoFactorEnabled(user.Id) {
+		startTwoFactorChallenge(user, c)
+		return
+	}
+	setupLoginSession(user, c, false)
+}
+
+// setup session & cookies and then return user info
+func setupLoginSession(user *model.User, c *gin.Context, twoFactorVerified bool) {
 	session := sessions.Default(c)
+	clearTwoFactorChallenge(session)
 	session.Set("id", user.Id)
 	session.Set("username", user.Username)
 	session.Set("role", user.Role)
 	session.Set("status", user.Status)
+	session.Set(sessionTwoFactorKey, twoFactorVerified)
 	err := session.Save()
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -261,13 +272,16 @@ func GetUser(c *gin.Context) {
 		return
 	}
 	myRole := c.GetInt(ctxkey.Role)
-	if myRole <= user.Role && myRole != model.RoleRootUser {
+	// holders of users:read through a custom role may look at common users
+	if user.Role >= model.RoleAdminUser && myRole <= user.Role && myRole != model.RoleRootUser {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
 			"message": "无权获取同级或更高等级用户的信息",
 		})
 		return
 	}
+	// the access token is a full credential of its user, it is only shown to the user itself
+	user.AccessToken = ""
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -276,6 +290,22 @@ func GetUser(c *gin.Context) {
 	return
 }
 
+// canGrantRole tells whether a user of myRole may give role to another user, the admin roles are only
+// given by a higher role while the holders of users:manage through a custom role manage common users
+func canGrantRole(myRole int, role int) bool {
+	return myRole == model.RoleRootUser || role < model.RoleAdminUser || myRole > role
+}
+
+// canManageUser tells whether the caller may change the user, which is never itself unless it is root,
+// nor a user holding a scope the caller does not hold
+func canManageUser(c *gin.Context, userId int, role int) bool {
+	myId, myRole := c.GetInt(ctxkey.Id), c.GetInt(ctxkey.Role)
+	if myRole == model.RoleRootUser {
+		return true
+	}
+	return userId != myId && canGrantRole(myRole, role) && model.CacheUserCoversScopes(myId, myRole, userId, role)
+}
+
 //func GetUserDashboard(c *gin.Context) {
 //	id := c.GetInt(ctxkey.Id)
 //	now := time.Now()
@@ -373,6 +403,10 @@ func GetSelf(c *gin.Context) {
 		})
 		return
 	}
+	if c.GetInt(ctxkey.ManagementKeyId) != 0 {
+		// a management key would otherwise give away the full powers of its user
+		user.AccessToken = ""
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -410,14 +444,14 @@ func UpdateUser(c *gin.Context) {
 		return
 	}
 	myRole := c.GetInt(ctxkey.Role)
-	if myRole <= originUser.Role && myRole != model.RoleRootUser {
+	if !canManageUser(c, originUser.Id, originUser.Role) {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
 			"message": "无权更新同权限等级或更高权限等级的用户信息",
 		})
 		return
 	}
-	if myRole <= updatedUser.Role && myRole != model.RoleRootUser {
+	if !canGrantRole(myRole, updatedUser.Role) {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
 			"message": "无权将其他用户权限等级提升到大于等于自己的权限等级",
@@ -506,8 +540,7 @@ func DeleteUser(c *gin.Context) {
 		})
 		return
 	}
-	myRole := c.GetInt("role")
-	if myRole <= originUser.Role {
+	if originUser.Role == model.RoleRootUser || !canManageUser(c, originUser.Id, originUser.Role) {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
 			"message": "无权删除同权限等级或更高权限等级的用户",
@@ -571,8 +604,7 @@ func CreateUser(c *gin.Context) {
 	if user.DisplayName == "" {
 		user.DisplayName = "Password User"
 	}
-	myRole := c.GetInt("role")
-	if user.Role >= myRole {
+	if !canGrantRole(c.GetInt(ctxkey.Role), user.Role) {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
 			"message": "无法创建权限大于等于自己的用户",
@@ -630,7 +662,7 @@ func ManageUser(c *gin.Context) {
 		return
 	}
 	myRole := c.GetInt("role")
-	if myRole <= user.Role && myRole != model.RoleRootUser {
+	if !canManageUser(c, user.Id, user.Role) {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
 			"message": "无权更新同权限等级或更高权限等级的用户信息",
@@ -814,6 +846,13 @@ func AdminTopUp(c *gin.Context) {
 		})
 		return
 	}
+	if req.UserId == c.GetInt(ctxkey.Id) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无法为自己充值",
+		})
+		return
+	}
 	err = model.IncreaseUserQuota(req.UserId, int64(req.Quota))
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
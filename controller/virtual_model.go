@@ -0,0 +1,132 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/model"
+)
+
+func GetAllVirtualModels(c *gin.Context) {
+	virtualModels, err := model.GetAllVirtualModels()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    virtualModels,
+	})
+	return
+}
+
+func GetVirtualModel(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	virtualModel, err := model.GetVirtualModelById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    virtualModel,
+	})
+	return
+}
+
+func AddVirtualModel(c *gin.Context) {
+	virtualModel := model.VirtualModel{}
+	err := c.ShouldBindJSON(&virtualModel)
+	if err == nil {
+		err = virtualModel.Validate()
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if virtualModel.Status == 0 {
+		virtualModel.Status = model.VirtualModelStatusEnabled
+	}
+	err = virtualModel.Insert()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.InitVirtualModelCache()
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    virtualModel,
+	})
+	return
+}
+
+func UpdateVirtualModel(c *gin.Context) {
+	virtualModel := model.VirtualModel{}
+	err := c.ShouldBindJSON(&virtualModel)
+	if err == nil {
+		err = virtualModel.Validate()
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	err = virtualModel.Update()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.InitVirtualModelCache()
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    virtualModel,
+	})
+	return
+}
+
+func DeleteVirtualModel(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	err := model.DeleteVirtualModelById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.InitVirtualModelCache()
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+	return
+}
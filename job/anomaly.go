@@ -0,0 +1,48 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/model"
+)
+
+// AnomalyDetectionJob 定时检测令牌和用户的用量异常，按策略提醒、禁用令牌或封禁用户，仅在主节点运行
+func AnomalyDetectionJob() {
+	if !config.IsMasterNode {
+		return
+	}
+	time.AfterFunc(time.Duration(config.AnomalyCheckInterval)*time.Second, func() {
+		if config.AnomalyDetectionEnabled {
+			detectAnomalies()
+		}
+		pruneTokenIps()
+		AnomalyDetectionJob()
+	})
+}
+
+func detectAnomalies() {
+	ctx := context.Background()
+	flagged, err := model.DetectAnomalies(ctx, time.Now())
+	if err != nil {
+		logger.Error(ctx, "DetectAnomalies error: "+err.Error())
+	}
+	if flagged > 0 {
+		logger.Info(ctx, fmt.Sprintf("flagged %d anomalies", flagged))
+	}
+}
+
+func pruneTokenIps() {
+	ctx := context.Background()
+	before := time.Now().AddDate(0, 0, -config.TokenIpRetentionDays).Unix()
+	deleted, err := model.DeleteOldTokenIps(before)
+	if err != nil {
+		logger.Error(ctx, "DeleteOldTokenIps error: "+err.Error())
+	}
+	if deleted > 0 {
+		logger.Info(ctx, fmt.Sprintf("deleted %d token ips", deleted))
+	}
+}
@@ -0,0 +1,50 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/message"
+	"github.com/songquanpeng/one-api/model"
+)
+
+// ChannelReconcileJob 每天0点30分对账前一天各渠道的上游余额消耗与计费额度，消耗明显高于计费时提醒，仅在主节点运行
+func ChannelReconcileJob() {
+	if !config.IsMasterNode {
+		return
+	}
+	now := time.Now()
+	next := time.Date(now.Year(), now.Month(), now.Day(), 0, 30, 0, 0, time.Local)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	time.AfterFunc(next.Sub(now), func() {
+		reconcileChannels(time.Now())
+		ChannelReconcileJob()
+	})
+}
+
+func reconcileChannels(now time.Time) {
+	ctx := context.Background()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	reconciliations, err := model.GetChannelReconciliations(0, today.AddDate(0, 0, -1), today)
+	if err != nil {
+		logger.Error(ctx, "GetChannelReconciliations error: "+err.Error())
+		return
+	}
+	for _, r := range reconciliations {
+		if !r.Alert {
+			continue
+		}
+		subject := fmt.Sprintf("渠道「%s」（#%d）对账异常", r.ChannelName, r.ChannelId)
+		content := fmt.Sprintf("渠道「%s」（#%d）在 %s 的上游余额消耗为 $%.4f，计费额度为 $%.4f，差额 $%.4f",
+			r.ChannelName, r.ChannelId, r.Day, r.Drawdown, r.Billed, -r.Margin)
+		err := message.Notify(message.ByAll, subject, "", content)
+		if err != nil {
+			logger.Error(ctx, fmt.Sprintf("failed to notify the reconciliation of channel #%d: %s", r.ChannelId, err.Error()))
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/model"
+)
+
+// ExportScheduleJob 定时检查导出计划，将已结束周期的数据写入 EXPORT_DIR，仅在主节点运行
+func ExportScheduleJob() {
+	if !config.IsMasterNode || config.ExportDir == "" {
+		return
+	}
+	time.AfterFunc(time.Duration(config.ExportCheckInterval)*time.Second, func() {
+		runExportSchedules(time.Now())
+		ExportScheduleJob()
+	})
+}
+
+func runExportSchedules(now time.Time) {
+	ctx := context.Background()
+	schedules, err := model.GetEnabledExportSchedules()
+	if err != nil {
+		logger.Error(ctx, "GetEnabledExportSchedules error: "+err.Error())
+		return
+	}
+	for _, schedule := range schedules {
+		runExportSchedule(ctx, schedule, now)
+	}
+}
+
+// runExportSchedule 导出上次导出之后所有已结束的周期，首次运行只导出上一个周期
+func runExportSchedule(ctx context.Context, schedule *model.ExportSchedule, now time.Time) {
+	currentStart := schedule.PeriodStart(now)
+	var start time.Time
+	if schedule.LastExportTime == 0 {
+		start = schedule.NextPeriodStart(currentStart, -1)
+	} else {
+		start = schedule.PeriodStart(time.Unix(schedule.LastExportTime, 0))
+	}
+	for start.Before(currentStart) {
+		end := schedule.NextPeriodStart(start, 1)
+		path, err := writeExportFile(schedule, start, end)
+		if err != nil {
+			logger.Errorf(ctx, "export schedule %d error: %s", schedule.Id, err.Error())
+			schedule.LastError = err.Error()
+			_ = schedule.UpdateRun()
+			return
+		}
+		schedule.LastExportTime = end.Unix()
+		schedule.LastFile = path
+		schedule.LastError = ""
+		if err = schedule.UpdateRun(); err != nil {
+			logger.Errorf(ctx, "update export schedule %d error: %s", schedule.Id, err.Error())
+			return
+		}
+		logger.Info(ctx, fmt.Sprintf("export schedule %d wrote %s", schedule.Id, path))
+		start = end
+	}
+}
+
+// writeExportFile 先写入临时文件，完成后再重命名，避免读取到不完整的导出文件
+func writeExportFile(schedule *model.ExportSchedule, start time.Time, end time.Time) (string, error) {
+	if err := os.MkdirAll(config.ExportDir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(config.ExportDir, fmt.Sprintf("%d_%s_%s.%s", schedule.Id, schedule.Table, start.Format("20060102"), schedule.Format))
+	file, err := os.CreateTemp(config.ExportDir, ".export-*")
+	if err != nil {
+		return "", err
+	}
+	err = model.WriteExport(file, func() {}, schedule.Table, schedule.Format, schedule.Query(start.Unix(), end.Unix()))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return "", err
+	}
+	return path, nil
+}
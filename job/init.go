@@ -5,4 +5,8 @@ func Init() {
 	ExpireCache()
 	QuotaJob()
 	ExpireHistoryLogs()
+	TaskPollJob()
+	ExportScheduleJob()
+	AnomalyDetectionJob()
+	ChannelReconcileJob()
 }
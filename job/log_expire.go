@@ -2,9 +2,13 @@ package job
 
 import (
 	"context"
+	"fmt"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/archive"
+	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/logger"
 	"github.com/songquanpeng/one-api/model"
-	"time"
 )
 
 func ExpireHistoryLogs() {
@@ -23,24 +27,37 @@ func ExpireHistoryLogs() {
 	waitDuration := next.Sub(now)
 	// 设置定时器
 	time.AfterFunc(waitDuration, func() {
-		// 计算6个月前的timestamp
-		sixMonthAgo := time.Now().AddDate(0, -6, 0).Unix()
-		rows, err := model.DeleteOldLog(sixMonthAgo)
+		sink, err := archive.NewSinkFromConfig()
 		if err != nil {
-			logger.Error(ctx, "Error deleting old log: "+err.Error())
-		} else {
-			logger.Info(ctx, "Deleted old log rows: "+string(rows))
+			logger.Error(ctx, "Error creating log archive sink: "+err.Error())
 		}
+		// 计算6个月前的timestamp
+		sixMonthAgo := time.Now().AddDate(0, -6, 0).Unix()
+		expireLogs(ctx, sink, model.ArchiveTableLogs, sixMonthAgo, model.DeleteOldLog)
 		// 计算7天前的timestamp
 		sevenDayAgo := time.Now().AddDate(0, 0, -7).Unix()
-		rows, err = model.DeleteExpiredFailedLog(sevenDayAgo)
-		if err != nil {
-			logger.Error(ctx, "Error deleting expired failed log: "+err.Error())
-		} else {
-			logger.Info(ctx, "Deleted expired failed log rows: "+string(rows))
-		}
+		expireLogs(ctx, sink, model.ArchiveTableFailedLogs, sevenDayAgo, model.DeleteExpiredFailedLog)
 
 		// 完成后继续调度下一次执行
 		ExpireHistoryLogs()
 	})
 }
+
+// expireLogs 配置了归档时先归档再删除，归档失败时保留数据等待下次执行；未配置归档时直接删除
+func expireLogs(ctx context.Context, sink archive.Sink, table string, before int64, deleteFunc func(int64) (int64, error)) {
+	if sink == nil && config.LogArchiveSink != "" {
+		return
+	}
+	var rows int64
+	var err error
+	if sink != nil {
+		rows, err = model.ArchiveLogs(ctx, sink, table, before)
+	} else {
+		rows, err = deleteFunc(before)
+	}
+	if err != nil {
+		logger.Error(ctx, fmt.Sprintf("Error expiring %s: %s", table, err.Error()))
+		return
+	}
+	logger.Info(ctx, fmt.Sprintf("Expired %s rows: %d", table, rows))
+}
@@ -0,0 +1,36 @@
+package job
+
+import (
+	"context"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/controller"
+)
+
+// TaskPollJob 定时轮询未完成的异步任务，任务完成或超时后结算额度，仅在主节点运行
+func TaskPollJob() {
+	if !config.IsMasterNode {
+		return
+	}
+	time.AfterFunc(time.Duration(config.TaskPollInterval)*time.Second, func() {
+		pollTasks()
+		TaskPollJob()
+	})
+}
+
+func pollTasks() {
+	ctx := context.Background()
+	tasks, err := model.GetUnfinishedTasks(100)
+	if err != nil {
+		logger.Error(ctx, "GetUnfinishedTasks error: "+err.Error())
+		return
+	}
+	for _, task := range tasks {
+		if err = controller.UpdateTask(ctx, task); err != nil {
+			logger.Warnf(ctx, "update task %s error: %s", task.TaskId, err.Error())
+		}
+	}
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
 	"embed"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-contrib/sessions/cookie"
@@ -57,6 +62,24 @@ func main() {
 		}
 	}()
 
+	if *env.ReencryptChannels {
+		count, err := model.ReencryptChannelSecrets()
+		if err != nil {
+			logger.FatalLog("failed to re-encrypt channels: " + err.Error())
+		}
+		logger.SysLogf("%d channels re-encrypted under master key version %d", count, common.SecretMasterKeyVersion)
+		return
+	}
+	if common.SecretEncryptionEnabled() && config.IsMasterNode {
+		// encrypt the rows written before the master key was configured or rotated
+		count, err := model.ReencryptChannelSecrets()
+		if err != nil {
+			logger.SysError("failed to migrate channel secrets: " + err.Error())
+		} else if count > 0 {
+			logger.SysLogf("%d channels migrated to master key version %d", count, common.SecretMasterKeyVersion)
+		}
+	}
+
 	// Initialize Redis
 	err = common.InitRedisClient()
 	if err != nil {
@@ -71,6 +94,12 @@ func main() {
 	logger.SysLog("memory cache enabled")
 	logger.SysLog(fmt.Sprintf("sync frequency: %d seconds", config.SyncFrequency))
 	model.InitChannelCache()
+	model.InitChannelKeyCache()
+	model.InitShadowConfigCache()
+	model.InitRoutingRuleCache()
+	model.InitVirtualModelCache()
+	model.InitPriceCatalogCache()
+	model.InitRoleCache()
 	go model.SyncOptions(config.SyncFrequency)
 	go model.SyncChannelCache(config.SyncFrequency)
 	if os.Getenv("CHANNEL_TEST_FREQUENCY") != "" {
@@ -80,11 +109,18 @@ func main() {
 		}
 		go controller.AutomaticallyTestChannels(frequency)
 	}
+	if os.Getenv("CHANNEL_UPDATE_FREQUENCY") != "" {
+		frequency, err := strconv.Atoi(os.Getenv("CHANNEL_UPDATE_FREQUENCY"))
+		if err != nil {
+			logger.FatalLog("failed to parse CHANNEL_UPDATE_FREQUENCY: " + err.Error())
+		}
+		go controller.AutomaticallyUpdateChannels(frequency)
+	}
 	if os.Getenv("BATCH_UPDATE_ENABLED") == "true" {
 		config.BatchUpdateEnabled = true
 		logger.SysLog("batch update enabled with interval " + strconv.Itoa(config.BatchUpdateInterval) + "s")
-		model.InitBatchUpdater()
 	}
+	model.InitBatchUpdater()
 	if config.EnableMetric {
 		logger.SysLog("metric enabled, will disable channel if too much request failed")
 	}
@@ -113,9 +149,28 @@ func main() {
 	if port == "" {
 		port = strconv.Itoa(*env.Port)
 	}
-	logger.SysLogf("server started on http://localhost:%s", port)
-	err = server.Run(":" + port)
-	if err != nil {
-		logger.FatalLog("failed to start HTTP server: " + err.Error())
+	srv := &http.Server{
+		Addr:    ":" + port,
+		Handler: server,
+	}
+	go func() {
+		logger.SysLogf("server started on http://localhost:%s", port)
+		err := srv.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			logger.FatalLog("failed to start HTTP server: " + err.Error())
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	logger.SysLog("shutting down server...")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.ShutdownTimeout)*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.SysError("failed to shut down HTTP server: " + err.Error())
 	}
+	// the requests are done, write the buffered logs, usages and quota deltas
+	model.StopBatchUpdater()
+	logger.SysLog("server exited")
 }
@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/tidwall/gjson"
+)
+
+// the response is only kept to read its success flag and the id of a created target
+const auditResponseLimit = 64 * 1024
+
+type auditResponseWriter struct {
+	gin.ResponseWriter
+	body *bytes.Buffer
+}
+
+func (w *auditResponseWriter) Write(data []byte) (int, error) {
+	if w.body.Len() < auditResponseLimit {
+		w.body.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *auditResponseWriter) WriteString(s string) (int, error) {
+	if w.body.Len() < auditResponseLimit {
+		w.body.WriteString(s)
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+func getAuditTargetId(c *gin.Context, body []byte, target *model.AuditTarget) string {
+	if target.Self {
+		return strconv.Itoa(c.GetInt(ctxkey.Id))
+	}
+	if id := c.Param("id"); id != "" {
+		return id
+	}
+	if target.IdField == "" {
+		return ""
+	}
+	if id := gjson.GetBytes(body, target.IdField); id.Exists() {
+		return id.String()
+	}
+	return c.Query(target.IdField)
+}
+
+// getAuditRequestSnapshot stands for the state of the targets that cannot be loaded
+func getAuditRequestSnapshot(c *gin.Context, body []byte, response []byte) map[string]any {
+	snapshot := make(map[string]any)
+	if len(body) > 0 {
+		_ = json.Unmarshal(body, &snapshot)
+	}
+	for name, values := range c.Request.URL.Query() {
+		if _, ok := snapshot[name]; !ok && len(values) > 0 {
+			snapshot[name] = values[0]
+		}
+	}
+	if result := gjson.GetBytes(response, "data"); result.Exists() && result.Type != gjson.JSON {
+		snapshot["result"] = result.Value()
+	}
+	return snapshot
+}
+
+// Audit records the mutating requests of the route in the audit log, with the state of the target
+// before and after the request. It must follow the auth middleware so that the actor is known.
+func Audit(targetType string) func(c *gin.Context) {
+	return audit(targetType, false)
+}
+
+// AuditGet is Audit for the routes that change state on a GET, which Audit skips
+func AuditGet(targetType string) func(c *gin.Context) {
+	return audit(targetType, true)
+}
+
+func audit(targetType string, withGet bool) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		method := c.Request.Method
+		if (method == http.MethodGet && !withGet) || method == http.MethodHead || method == http.MethodOptions {
+			c.Next()
+			return
+		}
+		target, ok := model.AuditTargets[targetType]
+		if !ok {
+			target = &model.AuditTarget{}
+		}
+		ctx := c.Request.Context()
+		body, err := common.GetRequestBody(c)
+		if err != nil {
+			body = nil
+		}
+		c.Request.Body = io.NopCloser(bytes.NewBuffer(body))
+		targetId := getAuditTargetId(c, body, target)
+		var before map[string]any
+		if target.Load != nil && targetId != "" {
+			if loaded, err := target.Load(ctx, targetId); err == nil {
+				before = model.ToAuditSnapshot(loaded)
+			}
+		}
+
+		writer := &auditResponseWriter{ResponseWriter: c.Writer, body: &bytes.Buffer{}}
+		c.Writer = writer
+		c.Next()
+
+		response := writer.body.Bytes()
+		// responses without a success flag, such as the SCIM ones, are told by their status
+		successFlag := gjson.GetBytes(response, "success")
+		success := writer.Status() < http.StatusBadRequest && (!successFlag.Exists() || successFlag.Bool())
+		if targetId == "" {
+			targetId = gjson.GetBytes(response, "data.id").String()
+		}
+		if targetId == "" {
+			targetId = gjson.GetBytes(response, "id").String()
+		}
+		var after map[string]any
+		if target.Load != nil && targetId != "" {
+			if loaded, err := target.Load(ctx, targetId); err == nil {
+				after = model.ToAuditSnapshot(loaded)
+			}
+		} else {
+			after = getAuditRequestSnapshot(c, body, response)
+		}
+		model.RecordAuditLog(&model.AuditLog{
+			CreatedAt:  helper.GetTimestamp(),
+			UserId:     c.GetInt(ctxkey.Id),
+			Username:   c.GetString(ctxkey.Username),
+			Ip:         c.ClientIP(),
+			Action:     c.Request.Method + " " + c.FullPath(),
+			TargetType: targetType,
+			TargetId:   targetId,
+			Diff:       model.DiffAuditSnapshots(before, after),
+			Success:    success,
+			RequestId:  c.GetString(helper.RequestIdKey),
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common/blacklist"
+	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/ctxkey"
 	"github.com/songquanpeng/one-api/common/network"
 	"github.com/songquanpeng/one-api/model"
@@ -39,6 +40,9 @@ func TokenAuthClaude() func(c *gin.Context) {
 			abortWithMessageClaude(c, http.StatusForbidden, "用户已被封禁")
 			return
 		}
+		if config.AnomalyDetectionEnabled {
+			model.RecordTokenIp(token.Id, token.UserId, c.ClientIP())
+		}
 		requestModel, err := getRequestModel(c)
 		if err != nil && shouldCheckModel(c) {
 			abortWithMessageClaude(c, http.StatusBadRequest, err.Error())
@@ -55,14 +59,23 @@ func TokenAuthClaude() func(c *gin.Context) {
 		c.Set(ctxkey.RequestModel, requestModel)
 		if token.Models != nil && *token.Models != "" {
 			c.Set(ctxkey.AvailableModels, *token.Models)
-			if requestModel != "" && !isModelInList(requestModel, *token.Models) {
+			if requestModel != "" && !token.AllowsModel(requestModel) {
 				abortWithMessageClaude(c, http.StatusForbidden, fmt.Sprintf("该令牌无权使用模型：%s", requestModel))
 				return
 			}
 		}
+		if requestModel != "" {
+			if err := token.CheckModelQuota(requestModel, 0); err != nil {
+				abortWithMessageClaude(c, http.StatusForbidden, err.Error())
+				return
+			}
+		}
 		c.Set(ctxkey.Id, token.UserId)
 		c.Set(ctxkey.TokenId, token.Id)
 		c.Set(ctxkey.TokenName, token.Name)
+		if token.ModelQuotas != nil && *token.ModelQuotas != "" {
+			c.Set(ctxkey.TokenModelQuotas, *token.ModelQuotas)
+		}
 		if len(parts) > 1 {
 			if model.IsAdmin(token.UserId) {
 				c.Set(ctxkey.SpecificChannelId, parts[1])
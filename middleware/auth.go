@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -8,17 +9,22 @@ import (
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common/blacklist"
+	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/ctxkey"
 	"github.com/songquanpeng/one-api/common/network"
 	"github.com/songquanpeng/one-api/model"
 )
 
-func authHelper(c *gin.Context, minRole int) {
+// authHelper authenticates the session, the management key or the access token, then checks the role
+// of the user, or the permission scope when scope is not empty. Management keys must also grant keyScope.
+func authHelper(c *gin.Context, minRole int, scope string, keyScope string) {
 	session := sessions.Default(c)
 	username := session.Get("username")
 	role := session.Get("role")
 	id := session.Get("id")
 	status := session.Get("status")
+	var managementKey *model.ManagementKey
+	sessionLogin := username != nil
 	if username == nil {
 		// Check access token
 		accessToken := c.Request.Header.Get("Authorization")
@@ -30,7 +36,22 @@ func authHelper(c *gin.Context, minRole int) {
 			c.Abort()
 			return
 		}
-		user := model.ValidateAccessToken(accessToken)
+		var user *model.User
+		if key := strings.TrimPrefix(accessToken, "Bearer "); model.IsManagementKey(key) {
+			var err error
+			managementKey, err = model.ValidateManagementKey(c.Request.Context(), key, c.ClientIP())
+			if err != nil {
+				c.JSON(http.StatusOK, gin.H{
+					"success": false,
+					"message": "无权进行此操作，" + err.Error(),
+				})
+				c.Abort()
+				return
+			}
+			user, _ = model.GetUserById(managementKey.UserId, false)
+		} else {
+			user = model.ValidateAccessToken(accessToken)
+		}
 		if user != nil && user.Username != "" {
 			// Token is valid
 			username = user.Username
@@ -57,7 +78,26 @@ func authHelper(c *gin.Context, minRole int) {
 		c.Abort()
 		return
 	}
-	if role.(int) < minRole {
+	if scope != "" || minRole >= model.RoleAdminUser {
+		if err := checkTwoFactor(c, session, sessionLogin, id.(int), role.(int)); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			c.Abort()
+			return
+		}
+	}
+	if scope != "" {
+		if !model.CacheUserHasScope(id.(int), role.(int), scope) {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": fmt.Sprintf("无权进行此操作，缺少权限 %s", scope),
+			})
+			c.Abort()
+			return
+		}
+	} else if role.(int) < minRole {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
 			"message": "无权进行此操作，权限不足",
@@ -65,27 +105,113 @@ func authHelper(c *gin.Context, minRole int) {
 		c.Abort()
 		return
 	}
+	if managementKey != nil && !managementKey.Allows(c.Request.Method, keyScope) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无权进行此操作，管理密钥权限不足",
+		})
+		c.Abort()
+		return
+	}
 	c.Set("username", username)
 	c.Set("role", role)
 	c.Set("id", id)
+	if managementKey != nil {
+		c.Set(ctxkey.ManagementKeyId, managementKey.Id)
+	}
 	c.Next()
 }
 
+// TwoFactorCodeHeader carries the TOTP code of the requests of an admin authenticated by an access token
+// or a management key
+const TwoFactorCodeHeader = "X-Two-Factor-Code"
+
+// checkTwoFactor tells whether an admin may use the admin routes when two-factor authentication
+// is required for admins: a session must have passed the challenge, and the requests authenticated
+// by an access token or a management key must carry a code, a stolen credential alone is not enough
+func checkTwoFactor(c *gin.Context, session sessions.Session, sessionLogin bool, id int, role int) error {
+	if !config.AdminTwoFactorRequired || role < model.RoleAdminUser {
+		return nil
+	}
+	if sessionLogin {
+		if verified, _ := session.Get("two_factor").(bool); !verified {
+			return errors.New("管理员必须先启用两步验证才能进行此操作")
+		}
+		return nil
+	}
+	if !model.IsTwoFactorEnabled(id) {
+		return errors.New("管理员必须先启用两步验证才能进行此操作")
+	}
+	code := c.GetHeader(TwoFactorCodeHeader)
+	if code == "" {
+		return fmt.Errorf("管理员使用 access token 或管理密钥时必须在 %s 请求头中提供两步验证码", TwoFactorCodeHeader)
+	}
+	if err := model.CheckTwoFactorCode(id, code); err != nil {
+		return fmt.Errorf("两步验证失败：%s", err.Error())
+	}
+	return nil
+}
+
 func UserAuth() func(c *gin.Context) {
 	return func(c *gin.Context) {
-		authHelper(c, model.RoleCommonUser)
+		authHelper(c, model.RoleCommonUser, "", "")
 	}
 }
 
 func AdminAuth() func(c *gin.Context) {
 	return func(c *gin.Context) {
-		authHelper(c, model.RoleAdminUser)
+		authHelper(c, model.RoleAdminUser, "", "")
 	}
 }
 
 func RootAuth() func(c *gin.Context) {
 	return func(c *gin.Context) {
-		authHelper(c, model.RoleRootUser)
+		authHelper(c, model.RoleRootUser, "", "")
+	}
+}
+
+// ScopeAuth lets in the users granted the permission scope, by their role or by a custom role
+func ScopeAuth(scope string) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		authHelper(c, model.RoleCommonUser, scope, scope)
+	}
+}
+
+// ReadWriteScopeAuth is ScopeAuth with readScope for GET requests and writeScope for the others
+func ReadWriteScopeAuth(readScope string, writeScope string) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		scope := writeScope
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			scope = readScope
+		}
+		authHelper(c, model.RoleCommonUser, scope, scope)
+	}
+}
+
+// UserKeyScopeAuth is UserAuth for routes that management keys reach with readScope for GET
+// requests and writeScope for the others
+func UserKeyScopeAuth(readScope string, writeScope string) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		scope := writeScope
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			scope = readScope
+		}
+		authHelper(c, model.RoleCommonUser, "", scope)
+	}
+}
+
+// DenyManagementKey rejects the requests authenticated by a management key, it follows an auth middleware
+func DenyManagementKey() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if c.GetInt(ctxkey.ManagementKeyId) != 0 {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无权进行此操作，管理密钥不能用于此操作",
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
 	}
 }
 
@@ -107,6 +233,9 @@ func TryTokenAuth() func(c *gin.Context) {
 		c.Set(ctxkey.Id, token.UserId)
 		c.Set(ctxkey.TokenId, token.Id)
 		c.Set(ctxkey.TokenName, token.Name)
+		if token.ModelQuotas != nil && *token.ModelQuotas != "" {
+			c.Set(ctxkey.TokenModelQuotas, *token.ModelQuotas)
+		}
 		if token.Models != nil && *token.Models != "" {
 			c.Set(ctxkey.AvailableModels, *token.Models)
 		}
@@ -121,6 +250,9 @@ func TokenAuth() func(c *gin.Context) {
 	return func(c *gin.Context) {
 		ctx := c.Request.Context()
 		key := c.Request.Header.Get("Authorization")
+		if key == "" {
+			key = getWebSocketProtocolKey(c)
+		}
 		key = strings.TrimPrefix(key, "Bearer ")
 		key = strings.TrimPrefix(key, "sk-")
 		parts := strings.Split(key, "-")
@@ -145,6 +277,9 @@ func TokenAuth() func(c *gin.Context) {
 			abortWithMessage(c, http.StatusForbidden, "用户已被封禁")
 			return
 		}
+		if config.AnomalyDetectionEnabled {
+			model.RecordTokenIp(token.Id, token.UserId, c.ClientIP())
+		}
 		requestModel, err := getRequestModel(c)
 		if err != nil && shouldCheckModel(c) {
 			abortWithMessage(c, http.StatusBadRequest, err.Error())
@@ -161,14 +296,23 @@ func TokenAuth() func(c *gin.Context) {
 		c.Set(ctxkey.RequestModel, requestModel)
 		if token.Models != nil && *token.Models != "" {
 			c.Set(ctxkey.AvailableModels, *token.Models)
-			if requestModel != "" && !isModelInList(requestModel, *token.Models) {
+			if requestModel != "" && !token.AllowsModel(requestModel) {
 				abortWithMessage(c, http.StatusForbidden, fmt.Sprintf("该令牌无权使用模型：%s", requestModel))
 				return
 			}
 		}
+		if requestModel != "" {
+			if err := token.CheckModelQuota(requestModel, 0); err != nil {
+				abortWithMessage(c, http.StatusForbidden, err.Error())
+				return
+			}
+		}
 		c.Set(ctxkey.Id, token.UserId)
 		c.Set(ctxkey.TokenId, token.Id)
 		c.Set(ctxkey.TokenName, token.Name)
+		if token.ModelQuotas != nil && *token.ModelQuotas != "" {
+			c.Set(ctxkey.TokenModelQuotas, *token.ModelQuotas)
+		}
 		if len(parts) > 1 {
 			if model.IsAdmin(token.UserId) {
 				c.Set(ctxkey.SpecificChannelId, parts[1])
@@ -200,5 +344,11 @@ func shouldCheckModel(c *gin.Context) bool {
 	if strings.HasPrefix(c.Request.URL.Path, "/v1/audio") {
 		return true
 	}
+	if strings.HasPrefix(c.Request.URL.Path, "/v1/realtime") {
+		return true
+	}
+	if c.Request.Method == http.MethodPost && strings.HasPrefix(c.Request.URL.Path, "/v1/tasks") {
+		return true
+	}
 	return false
 }
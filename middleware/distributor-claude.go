@@ -36,7 +36,9 @@ func DistributeClaude() func(c *gin.Context) {
 			if recentChannelId > 0 {
 				channel, err = model.CacheGetChannelById(recentChannelId)
 				if err == nil {
-					SetupContextForSelectedChannel(c, channel, requestModel)
+					if !setupContextOrAbort(c, channel, requestModel, abortWithMessageClaude) {
+						return
+					}
 					c.Next()
 					return
 				}
@@ -52,7 +54,9 @@ func DistributeClaude() func(c *gin.Context) {
 				return
 			}
 		}
-		SetupContextForSelectedChannel(c, channel, requestModel)
+		if !setupContextOrAbort(c, channel, requestModel, abortWithMessageClaude) {
+			return
+		}
 		c.Next()
 	}
 }
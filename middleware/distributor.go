@@ -6,7 +6,9 @@ import (
 	"github.com/songquanpeng/one-api/common/ctxkey"
 	"github.com/songquanpeng/one-api/common/logger"
 	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/monitor"
 	"github.com/songquanpeng/one-api/relay/channeltype"
+	"github.com/songquanpeng/one-api/relay/routing"
 	"net/http"
 	"strconv"
 )
@@ -37,11 +39,82 @@ func Distribute() func(c *gin.Context) {
 		} else {
 			requestModel = c.GetString(ctxkey.RequestModel)
 			var err error
+			if rule := routing.Match(c, userGroup, requestModel); rule != nil {
+				logger.Infof(c.Request.Context(), "routing rule #%d %s matched, action: %s", rule.Id, rule.Name, rule.Action)
+				switch rule.Action {
+				case model.RoutingActionReject:
+					message := rule.RejectMessage
+					if message == "" {
+						message = fmt.Sprintf("当前请求被路由规则拒绝，模型：%s", requestModel)
+					}
+					abortWithMessage(c, http.StatusForbidden, message)
+					return
+				case model.RoutingActionRewrite:
+					if err = routing.CheckTokenModel(c, rule.TargetModel); err != nil {
+						abortWithMessage(c, http.StatusForbidden, err.Error())
+						return
+					}
+					err = routing.RewriteModel(c, requestModel, rule.TargetModel)
+					if err != nil {
+						abortWithMessage(c, http.StatusBadRequest, "路由规则改写模型失败："+err.Error())
+						return
+					}
+					requestModel = rule.TargetModel
+				case model.RoutingActionRoute:
+					channelIds := rule.GetChannelIds()
+					c.Set(ctxkey.RoutingChannelIds, channelIds)
+					// a virtual model is served by the first model of its chain the routed channels support
+					modelNames := []string{requestModel}
+					virtualModel := model.CacheGetVirtualModel(userGroup, requestModel)
+					if virtualModel != nil {
+						modelNames = virtualModel.GetModels()
+					}
+					var servedModel string
+					servedModel, channel = model.CacheGetRoutedChannel(channelIds, modelNames, nil)
+					if channel == nil {
+						abortWithMessage(c, http.StatusServiceUnavailable, fmt.Sprintf("路由规则 %s 指定的渠道均不可用或不支持模型 %s", rule.Name, requestModel))
+						return
+					}
+					if virtualModel != nil {
+						err = routing.ServeVirtualModel(c, requestModel, requestModel, servedModel)
+						if err != nil {
+							abortWithMessage(c, http.StatusBadRequest, "虚拟模型改写失败："+err.Error())
+							return
+						}
+						requestModel = servedModel
+					}
+					if !setupContextOrAbort(c, channel, requestModel, abortWithMessage) {
+						return
+					}
+					c.Next()
+					return
+				}
+			}
+			if virtualModel := model.CacheGetVirtualModel(userGroup, requestModel); virtualModel != nil {
+				var servedModel string
+				servedModel, channel = model.CacheGetVirtualModelChannel(userGroup, virtualModel, "", nil)
+				if channel == nil {
+					abortWithMessage(c, http.StatusServiceUnavailable, fmt.Sprintf("虚拟模型 %s 的所有候选模型在当前分组 %s 下均无可用渠道", requestModel, userGroup))
+					return
+				}
+				err = routing.ServeVirtualModel(c, requestModel, requestModel, servedModel)
+				if err != nil {
+					abortWithMessage(c, http.StatusBadRequest, "虚拟模型改写失败："+err.Error())
+					return
+				}
+				if !setupContextOrAbort(c, channel, servedModel, abortWithMessage) {
+					return
+				}
+				c.Next()
+				return
+			}
 			recentChannelId := model.CacheGetRecentChannel(c.Request.Context(), userId, requestModel)
 			if recentChannelId > 0 {
 				channel, err = model.CacheGetChannelById(recentChannelId)
 				if err == nil {
-					SetupContextForSelectedChannel(c, channel, requestModel)
+					if !setupContextOrAbort(c, channel, requestModel, abortWithMessage) {
+						return
+					}
 					c.Next()
 					return
 				}
@@ -57,12 +130,30 @@ func Distribute() func(c *gin.Context) {
 				return
 			}
 		}
-		SetupContextForSelectedChannel(c, channel, requestModel)
+		if !setupContextOrAbort(c, channel, requestModel, abortWithMessage) {
+			return
+		}
 		c.Next()
 	}
 }
 
-func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel, modelName string) {
+// setupContextOrAbort sets up the context for the channel, or aborts the request when the channel has no usable key
+func setupContextOrAbort(c *gin.Context, channel *model.Channel, modelName string, abort func(*gin.Context, int, string)) bool {
+	if err := SetupContextForSelectedChannel(c, channel, modelName); err != nil {
+		abort(c, http.StatusServiceUnavailable, fmt.Sprintf("渠道 #%d 的所有密钥均已被禁用，请稍后重试", channel.Id))
+		return false
+	}
+	return true
+}
+
+// SetupContextForSelectedChannel prepares the context to relay with the channel. A channel whose keys are
+// all disabled is disabled as well and model.ErrChannelKeysDisabled is returned.
+func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel, modelName string) error {
+	key, keyHash, err := channel.SelectKey()
+	if err != nil {
+		go monitor.DisableChannel(channel.Id, channel.Name, "所有密钥均已被禁用")
+		return err
+	}
 	c.Set(ctxkey.Channel, channel.Type)
 	c.Set(ctxkey.ChannelId, channel.Id)
 	c.Set(ctxkey.ChannelName, channel.Name)
@@ -72,7 +163,8 @@ func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel, mode
 	}
 	c.Set(ctxkey.ModelMapping, channel.GetModelMapping())
 	c.Set(ctxkey.OriginalModel, modelName) // for retry
-	c.Request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", channel.Key))
+	c.Set(ctxkey.ChannelKeyHash, keyHash)
+	c.Request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", key))
 	c.Set(ctxkey.BaseURL, channel.GetBaseURL())
 	cfg, _ := channel.LoadConfig()
 	// this is for backward compatibility
@@ -101,4 +193,5 @@ func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel, mode
 		}
 	}
 	c.Set(ctxkey.Config, cfg)
+	return nil
 }
@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+)
+
+// ScimAuth authenticates the identity provider by the bearer token of the SCIM endpoint,
+// the endpoint is disabled while no token is set
+func ScimAuth() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		token := strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer ")
+		if config.ScimToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(config.ScimToken)) != 1 {
+			c.Header("Content-Type", "application/scim+json")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"schemas": []string{"urn:ietf:params:scim:api:messages:2.0:Error"},
+				"status":  strconv.Itoa(http.StatusUnauthorized),
+				"detail":  "invalid SCIM bearer token",
+			})
+			return
+		}
+		// the changes are recorded in the audit log as made by the identity provider
+		c.Set(ctxkey.Username, "scim")
+		c.Next()
+	}
+}
@@ -62,12 +62,14 @@ func getRequestModel(c *gin.Context) (string, error) {
 	return modelRequest.Model, nil
 }
 
-func isModelInList(modelName string, models string) bool {
-	modelList := strings.Split(models, ",")
-	for _, model := range modelList {
-		if modelName == model {
-			return true
+// getWebSocketProtocolKey returns the key browsers pass in the Sec-WebSocket-Protocol header of a
+// realtime session, as they cannot set the Authorization header of a WebSocket
+func getWebSocketProtocolKey(c *gin.Context) string {
+	for _, protocol := range strings.Split(c.Request.Header.Get("Sec-WebSocket-Protocol"), ",") {
+		protocol = strings.TrimSpace(protocol)
+		if strings.HasPrefix(protocol, "openai-insecure-api-key.") {
+			return strings.TrimPrefix(protocol, "openai-insecure-api-key.")
 		}
 	}
-	return false
+	return ""
 }
@@ -0,0 +1,398 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/blacklist"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/message"
+)
+
+const (
+	AnomalyTypeSpike    = "spike"
+	AnomalyTypeLimit    = "limit"
+	AnomalyTypeNewModel = "new_model"
+	AnomalyTypeNewIp    = "new_ip"
+)
+
+const (
+	AnomalyActionAlert        = "alert"
+	AnomalyActionDisableToken = "disable_token"
+	AnomalyActionBanUser      = "ban_user"
+	AnomalyActionNone         = "none" // the anomalies of the type are not detected
+)
+
+const (
+	AnomalyStatusOpen     = 1
+	AnomalyStatusResolved = 2
+)
+
+// the spend of an hour is compared with the hourly average of this many hours before
+const anomalyBaselineHours = 7 * 24
+
+// AnomalyEvent is an anomaly flagged on a token, or on a user when TokenName is empty
+type AnomalyEvent struct {
+	Id        int    `json:"id"`
+	CreatedAt int64  `json:"created_at" gorm:"bigint;index"`
+	Type      string `json:"type" gorm:"type:varchar(16);index"`
+	UserId    int    `json:"user_id" gorm:"index"`
+	TokenId   int    `json:"token_id" gorm:"default:0"`
+	TokenName string `json:"token_name" gorm:"type:varchar(128);default:''"`
+	Subject   string `json:"subject" gorm:"type:varchar(128);default:''"` // the hour, the new model or the new ip
+	Quota     int64  `json:"quota" gorm:"default:0"`                      // the spend of the hour
+	Baseline  int64  `json:"baseline" gorm:"default:0"`                   // the hourly average, or the limit
+	Action    string `json:"action" gorm:"type:varchar(16)"`
+	Status    int    `json:"status" gorm:"default:1"`
+}
+
+// GetAnomalyAction returns the action of the policy for the type of anomaly, alert by default
+func GetAnomalyAction(anomalyType string) string {
+	if action, ok := config.AnomalyPolicy[anomalyType]; ok {
+		return action
+	}
+	return AnomalyActionAlert
+}
+
+func (event *AnomalyEvent) Description() string {
+	target := fmt.Sprintf("用户 #%d", event.UserId)
+	if event.TokenId != 0 {
+		target = fmt.Sprintf("用户 #%d 的令牌 %s（#%d）", event.UserId, event.TokenName, event.TokenId)
+	} else if event.TokenName != "" {
+		target = fmt.Sprintf("用户 #%d 的令牌 %s", event.UserId, event.TokenName)
+	}
+	switch event.Type {
+	case AnomalyTypeSpike:
+		return fmt.Sprintf("%s 在 %s 消耗 %s，是过去一周每小时平均 %s 的 %.0f 倍以上", target, event.Subject,
+			common.ShowQuota(event.Quota), common.ShowQuota(event.Baseline), config.AnomalySpikeFactor)
+	case AnomalyTypeLimit:
+		return fmt.Sprintf("%s 在 %s 消耗 %s，超过每小时上限 %s", target, event.Subject, common.ShowQuota(event.Quota), common.ShowQuota(event.Baseline))
+	case AnomalyTypeNewModel:
+		return fmt.Sprintf("%s 开始使用新模型 %s", target, event.Subject)
+	case AnomalyTypeNewIp:
+		return fmt.Sprintf("%s 开始从新 IP %s 调用", target, event.Subject)
+	}
+	return target
+}
+
+type hourSpend struct {
+	UserId    int
+	TokenName string
+	Hour      int
+	Quota     int64
+}
+
+type spendKey struct {
+	userId    int
+	tokenName string
+}
+
+// checkSpend flags the spend of an hour against the hourly average and the limit, the limit being ignored when 0.
+// Without spend before (known is false) there is no average to compare with and only the limit is checked.
+func checkSpend(spend *hourSpend, baseline int64, known bool, limit int64) []*AnomalyEvent {
+	var events []*AnomalyEvent
+	newEvent := func(anomalyType string, baseline int64) *AnomalyEvent {
+		return &AnomalyEvent{
+			Type:      anomalyType,
+			UserId:    spend.UserId,
+			TokenName: spend.TokenName,
+			Subject:   UsagePeriod(spend.Hour, UsageGranularityHour),
+			Quota:     spend.Quota,
+			Baseline:  baseline,
+		}
+	}
+	if known && spend.Quota >= config.AnomalySpikeMinQuota && float64(spend.Quota) > float64(baseline)*config.AnomalySpikeFactor {
+		events = append(events, newEvent(AnomalyTypeSpike, baseline))
+	}
+	if limit > 0 && spend.Quota > limit {
+		events = append(events, newEvent(AnomalyTypeLimit, limit))
+	}
+	return events
+}
+
+// detectSpendAnomalies compares the spend of the tokens and of the users in the hours from startHour with
+// their hourly average from baselineStartHour until before startHour
+func detectSpendAnomalies(startHour int, baselineStartHour int) ([]*AnomalyEvent, error) {
+	var spends []*hourSpend
+	err := DB.Model(&Usage{}).Select("user_id, token_name, hour, SUM(quota) AS quota").Where("hour >= ?", startHour).
+		Group("user_id, token_name, hour").Scan(&spends).Error
+	if err != nil || len(spends) == 0 {
+		return nil, err
+	}
+	type userHour struct{ userId, hour int }
+	userIdSet := make(map[int]bool)
+	userSpends := make(map[userHour]*hourSpend)
+	for _, spend := range spends {
+		userIdSet[spend.UserId] = true
+		key := userHour{userId: spend.UserId, hour: spend.Hour}
+		if _, ok := userSpends[key]; !ok {
+			userSpends[key] = &hourSpend{UserId: spend.UserId, Hour: spend.Hour}
+		}
+		userSpends[key].Quota += spend.Quota
+	}
+	userIds := make([]int, 0, len(userIdSet))
+	for userId := range userIdSet {
+		userIds = append(userIds, userId)
+	}
+	var baselines []*hourSpend
+	err = DB.Model(&Usage{}).Select("user_id, token_name, SUM(quota) AS quota").
+		Where("hour >= ? AND hour < ? AND user_id IN ?", baselineStartHour, startHour, userIds).
+		Group("user_id, token_name").Scan(&baselines).Error
+	if err != nil {
+		return nil, err
+	}
+	tokenBaselines := make(map[spendKey]int64)
+	userBaselines := make(map[int]int64)
+	for _, baseline := range baselines {
+		tokenBaselines[spendKey{userId: baseline.UserId, tokenName: baseline.TokenName}] += baseline.Quota
+		userBaselines[baseline.UserId] += baseline.Quota
+	}
+	var events []*AnomalyEvent
+	for _, spend := range spends {
+		// the spend without a token is only counted for its user
+		if spend.TokenName == "" {
+			continue
+		}
+		// as for the new models, a new token is not compared with the average of the hours before it
+		baseline, known := tokenBaselines[spendKey{userId: spend.UserId, tokenName: spend.TokenName}]
+		events = append(events, checkSpend(spend, baseline/anomalyBaselineHours, known, config.AnomalyTokenHourlyQuotaLimit)...)
+	}
+	for _, spend := range userSpends {
+		baseline, known := userBaselines[spend.UserId]
+		events = append(events, checkSpend(spend, baseline/anomalyBaselineHours, known, config.AnomalyUserHourlyQuotaLimit)...)
+	}
+	return events, nil
+}
+
+// detectNewModels flags the models a token uses from startHour that it did not use in the hours before,
+// the tokens without usage before are new and not flagged
+func detectNewModels(startHour int, baselineStartHour int) ([]*AnomalyEvent, error) {
+	type tokenModel struct {
+		UserId    int
+		TokenName string
+		ModelName string
+	}
+	var recent []*tokenModel
+	err := DB.Model(&Usage{}).Distinct("user_id", "token_name", "model_name").Where("hour >= ?", startHour).Scan(&recent).Error
+	if err != nil || len(recent) == 0 {
+		return nil, err
+	}
+	userIdSet := make(map[int]bool)
+	for _, m := range recent {
+		userIdSet[m.UserId] = true
+	}
+	userIds := make([]int, 0, len(userIdSet))
+	for userId := range userIdSet {
+		userIds = append(userIds, userId)
+	}
+	var known []*tokenModel
+	err = DB.Model(&Usage{}).Distinct("user_id", "token_name", "model_name").
+		Where("hour >= ? AND hour < ? AND user_id IN ?", baselineStartHour, startHour, userIds).Scan(&known).Error
+	if err != nil {
+		return nil, err
+	}
+	knownTokens := make(map[spendKey]bool)
+	knownModels := make(map[tokenModel]bool)
+	for _, m := range known {
+		knownTokens[spendKey{userId: m.UserId, tokenName: m.TokenName}] = true
+		knownModels[*m] = true
+	}
+	var events []*AnomalyEvent
+	for _, m := range recent {
+		if m.TokenName != "" && knownTokens[spendKey{userId: m.UserId, tokenName: m.TokenName}] && !knownModels[*m] {
+			events = append(events, &AnomalyEvent{Type: AnomalyTypeNewModel, UserId: m.UserId, TokenName: m.TokenName, Subject: m.ModelName})
+		}
+	}
+	return events, nil
+}
+
+func detectNewIps(since int64) ([]*AnomalyEvent, error) {
+	tokenIps, err := GetNewTokenIps(since)
+	if err != nil {
+		return nil, err
+	}
+	events := make([]*AnomalyEvent, 0, len(tokenIps))
+	for _, tokenIp := range tokenIps {
+		token, err := GetTokenById(tokenIp.TokenId)
+		if err != nil {
+			continue
+		}
+		events = append(events, &AnomalyEvent{Type: AnomalyTypeNewIp, UserId: tokenIp.UserId, TokenId: token.Id, TokenName: token.Name, Subject: tokenIp.Ip})
+	}
+	return events, nil
+}
+
+// DetectAnomalies flags the anomalies of the hour before now and of the current hour, and takes the action
+// of the policy for the ones not flagged yet
+func DetectAnomalies(ctx context.Context, now time.Time) (int, error) {
+	start := now.Add(-time.Hour)
+	startHour := getHourOf(start.Unix())
+	baselineStartHour := getHourOf(start.Add(-anomalyBaselineHours * time.Hour).Unix())
+	var events []*AnomalyEvent
+	if GetAnomalyAction(AnomalyTypeSpike) != AnomalyActionNone || GetAnomalyAction(AnomalyTypeLimit) != AnomalyActionNone {
+		spendEvents, err := detectSpendAnomalies(startHour, baselineStartHour)
+		if err != nil {
+			return 0, err
+		}
+		events = append(events, spendEvents...)
+	}
+	if GetAnomalyAction(AnomalyTypeNewModel) != AnomalyActionNone {
+		modelEvents, err := detectNewModels(startHour, baselineStartHour)
+		if err != nil {
+			return 0, err
+		}
+		events = append(events, modelEvents...)
+	}
+	if GetAnomalyAction(AnomalyTypeNewIp) != AnomalyActionNone {
+		ipEvents, err := detectNewIps(start.Unix())
+		if err != nil {
+			return 0, err
+		}
+		events = append(events, ipEvents...)
+	}
+	flagged := 0
+	for _, event := range events {
+		event.Action = GetAnomalyAction(event.Type)
+		if event.Action == AnomalyActionNone || isAnomalyFlagged(event, now.Add(-anomalyBaselineHours*time.Hour).Unix()) {
+			continue
+		}
+		if event.TokenName != "" && event.TokenId == 0 {
+			// the usage is counted by token name, which several tokens of the user may share
+			var tokenIds []int
+			DB.Model(&Token{}).Where("user_id = ? AND name = ?", event.UserId, event.TokenName).Pluck("id", &tokenIds)
+			if len(tokenIds) == 1 {
+				event.TokenId = tokenIds[0]
+			}
+		}
+		event.CreatedAt = now.Unix()
+		event.Status = AnomalyStatusOpen
+		if err := DB.Create(event).Error; err != nil {
+			return flagged, err
+		}
+		flagged++
+		if err := event.takeAction(); err != nil {
+			logger.Errorf(ctx, "failed to take action %s on anomaly #%d: %s", event.Action, event.Id, err.Error())
+		}
+	}
+	return flagged, nil
+}
+
+func isAnomalyFlagged(event *AnomalyEvent, since int64) bool {
+	var count int64
+	DB.Model(&AnomalyEvent{}).Where("type = ? AND user_id = ? AND token_name = ? AND subject = ? AND created_at >= ?",
+		event.Type, event.UserId, event.TokenName, event.Subject, since).Count(&count)
+	return count > 0
+}
+
+// takeAction disables the token, or every token of the user with its name when the name is shared,
+// or the tokens of the user for the anomalies of a user, or bans the user, the root user is alerted in any case
+func (event *AnomalyEvent) takeAction() error {
+	description := event.Description()
+	var err error
+	switch event.Action {
+	case AnomalyActionDisableToken:
+		if event.TokenId != 0 {
+			err = disableAnomalyToken(event.TokenId)
+		} else if event.TokenName != "" {
+			err = disableAnomalyTokensByName(event.UserId, event.TokenName)
+		} else {
+			_, err = DisableUserTokens(event.UserId)
+		}
+		description += "，已禁用令牌"
+	case AnomalyActionBanUser:
+		err = banAnomalyUser(event.UserId)
+		description += "，已封禁用户"
+	}
+	if err == nil && event.Action != AnomalyActionAlert {
+		RecordLog(event.UserId, LogTypeManage, "异常检测："+description)
+	}
+	if config.RootUserEmail != "" {
+		if notifyErr := message.Notify(message.ByEmail, "用量异常提醒", "", description); notifyErr != nil {
+			logger.SysError("failed to notify the anomaly: " + notifyErr.Error())
+		}
+	}
+	return err
+}
+
+func disableAnomalyToken(tokenId int) error {
+	token, err := GetTokenById(tokenId)
+	if err != nil {
+		return err
+	}
+	err = DB.Model(&Token{}).Where("id = ?", tokenId).Update("status", TokenStatusDisabled).Error
+	if err != nil {
+		return err
+	}
+	TokenKeyCache.Del([]byte(token.Key))
+	TokenIdCache.Del([]byte(strconv.Itoa(token.Id)))
+	return nil
+}
+
+func disableAnomalyTokensByName(userId int, tokenName string) error {
+	var tokenIds []int
+	err := DB.Model(&Token{}).Where("user_id = ? AND name = ?", userId, tokenName).Pluck("id", &tokenIds).Error
+	if err != nil {
+		return err
+	}
+	for _, tokenId := range tokenIds {
+		if err = disableAnomalyToken(tokenId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func banAnomalyUser(userId int) error {
+	user, err := GetUserById(userId, false)
+	if err != nil {
+		return err
+	}
+	if user.Role == RoleRootUser {
+		return errors.New("无法封禁超级管理员用户")
+	}
+	blacklist.BanUser(userId)
+	err = DB.Model(&User{}).Where("id = ?", userId).Update("status", UserStatusDisabled).Error
+	if err != nil {
+		return err
+	}
+	purgeUserStatusCache(userId)
+	return nil
+}
+
+func GetAnomalyEvents(anomalyType string, userId int, status int, startIdx int, num int) (events []*AnomalyEvent, total int64, err error) {
+	tx := DB.Model(&AnomalyEvent{})
+	if anomalyType != "" {
+		tx = tx.Where("type = ?", anomalyType)
+	}
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	if status != 0 {
+		tx = tx.Where("status = ?", status)
+	}
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&events).Error
+	return events, total, err
+}
+
+func GetAnomalyEventById(id int) (*AnomalyEvent, error) {
+	event := AnomalyEvent{}
+	err := DB.First(&event, "id = ?", id).Error
+	return &event, err
+}
+
+// ResolveAnomalyEvent marks the event as handled, the token or the user is not enabled again
+func ResolveAnomalyEvent(id int) error {
+	result := DB.Model(&AnomalyEvent{}).Where("id = ?", id).Update("status", AnomalyStatusResolved)
+	if result.Error == nil && result.RowsAffected == 0 {
+		return errors.New("异常事件不存在")
+	}
+	return result.Error
+}
@@ -0,0 +1,49 @@
+package model
+
+import "testing"
+
+func TestCheckSpend(t *testing.T) {
+	spend := &hourSpend{UserId: 1, TokenName: "ci", Hour: 2025030110, Quota: 3000000}
+	events := checkSpend(spend, 100000, true, 0)
+	if len(events) != 1 || events[0].Type != AnomalyTypeSpike || events[0].Baseline != 100000 || events[0].Subject != "2025-03-01 10:00" {
+		t.Fatalf("expected a spike, got %+v", events)
+	}
+	if events := checkSpend(spend, 1000000, true, 0); len(events) != 0 {
+		t.Fatalf("expected no anomaly under the spike factor, got %+v", events)
+	}
+	small := &hourSpend{UserId: 1, TokenName: "ci", Hour: 2025030110, Quota: 1000}
+	if events := checkSpend(small, 0, true, 0); len(events) != 0 {
+		t.Fatalf("expected no spike under the minimum quota, got %+v", events)
+	}
+	events = checkSpend(spend, 1000000, true, 2000000)
+	if len(events) != 1 || events[0].Type != AnomalyTypeLimit || events[0].Baseline != 2000000 {
+		t.Fatalf("expected the limit to be exceeded, got %+v", events)
+	}
+	// a new token has no average to compare with, only its limit is checked
+	if events := checkSpend(spend, 0, false, 0); len(events) != 0 {
+		t.Fatalf("expected no spike without spend before, got %+v", events)
+	}
+	events = checkSpend(spend, 0, false, 2000000)
+	if len(events) != 1 || events[0].Type != AnomalyTypeLimit {
+		t.Fatalf("expected the limit to be checked without spend before, got %+v", events)
+	}
+}
+
+func TestAnomalyTokenActions(t *testing.T) {
+	recorder := useDryRunDB(t)
+
+	event := &AnomalyEvent{Type: AnomalyTypeSpike, UserId: 1, TokenName: "ci", Action: AnomalyActionDisableToken}
+	if err := event.takeAction(); err != nil {
+		t.Fatal(err)
+	}
+	if recorder.find(`FROM "tokens"`, `user_id = 1 AND name = 'ci'`) == "" {
+		t.Fatal("the tokens sharing the name are not looked up")
+	}
+
+	if _, err := DeleteOldTokenIps(1000); err != nil {
+		t.Fatal(err)
+	}
+	if recorder.find(`DELETE FROM "token_ips"`, "last_seen < 1000", `token_id NOT IN (SELECT "id" FROM "tokens"`) == "" {
+		t.Fatal("the old addresses are not deleted")
+	}
+}
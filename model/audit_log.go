@@ -0,0 +1,300 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+	"gorm.io/gorm"
+)
+
+const (
+	AuditTargetChannel       = "channel"
+	AuditTargetChannelKey    = "channel_key"
+	AuditTargetProvider      = "channel_provider"
+	AuditTargetOption        = "option"
+	AuditTargetModelOption   = "model_option"
+	AuditTargetUser          = "user"
+	AuditTargetSelf          = "self"
+	AuditTargetUserQuota     = "user_quota"
+	AuditTargetUserRole      = "user_role"
+	AuditTargetToken         = "token"
+	AuditTargetRedemption    = "redemption"
+	AuditTargetRole          = "role"
+	AuditTargetRoutingRule   = "routing_rule"
+	AuditTargetVirtualModel  = "virtual_model"
+	AuditTargetShadowConfig  = "shadow_config"
+	AuditTargetManagementKey = "management_key"
+	AuditTargetLog           = "log"
+	AuditTargetScimGroup     = "scim_group"
+	AuditTargetTwoFactor     = "two_factor"
+	AuditTargetModelPrice    = "model_price"
+
+	AuditTargetExportSchedule = "export_schedule"
+	AuditTargetOrder          = "order"
+	AuditTargetAnomalyEvent   = "anomaly_event"
+)
+
+const auditMaskedValue = "****"
+
+// AuditLog is a structured record of an administrative change. Diff maps every changed
+// top level field of the target to its value before and after the change, secrets masked.
+type AuditLog struct {
+	Id         int    `json:"id"`
+	CreatedAt  int64  `json:"created_at" gorm:"bigint;index"`
+	UserId     int    `json:"user_id" gorm:"index"`
+	Username   string `json:"username" gorm:"type:varchar(64);default:''"`
+	Ip         string `json:"ip" gorm:"type:varchar(64);default:''"`
+	Action     string `json:"action" gorm:"type:varchar(128);index"` // method and route, such as PUT /api/channel/
+	TargetType string `json:"target_type" gorm:"type:varchar(32);index:idx_audit_target,priority:1"`
+	TargetId   string `json:"target_id" gorm:"type:varchar(128);index:idx_audit_target,priority:2"`
+	Diff       string `json:"diff" gorm:"type:text"`
+	Success    bool   `json:"success"`
+	RequestId  string `json:"request_id" gorm:"type:varchar(64);default:''"`
+}
+
+// AuditFieldDiff is the change of one field
+type AuditFieldDiff struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+// AuditTarget tells the audit middleware how to find and load the target of a change
+type AuditTarget struct {
+	IdField string                                            // body or query field holding the id when the route has no :id param
+	Load    func(ctx context.Context, id string) (any, error) // nil when the state of the target cannot be loaded
+	Self    bool                                              // the target is the user of the request
+}
+
+func loadById[T any](get func(int) (T, error)) func(context.Context, string) (any, error) {
+	return func(ctx context.Context, id string) (any, error) {
+		intId, err := strconv.Atoi(id)
+		if err != nil {
+			return nil, err
+		}
+		return get(intId)
+	}
+}
+
+var AuditTargets = map[string]*AuditTarget{
+	AuditTargetChannel: {IdField: "id", Load: loadById(func(id int) (*Channel, error) {
+		return GetChannelById(id, true)
+	})},
+	AuditTargetChannelKey: {IdField: "key_hash"},
+	AuditTargetProvider:   {IdField: "id"},
+	AuditTargetOption: {IdField: "key", Load: func(ctx context.Context, key string) (any, error) {
+		config.OptionMapRWMutex.RLock()
+		defer config.OptionMapRWMutex.RUnlock()
+		value, ok := config.OptionMap[key]
+		if !ok {
+			return nil, nil
+		}
+		return map[string]any{key: value}, nil
+	}},
+	AuditTargetModelOption: {IdField: "model", Load: func(ctx context.Context, id string) (any, error) {
+		return GetModelConfig(ctx, id)
+	}},
+	AuditTargetUser: {IdField: "id", Load: loadById(func(id int) (*User, error) {
+		return GetUserById(id, false)
+	})},
+	AuditTargetSelf: {Self: true, Load: loadById(func(id int) (*User, error) {
+		return GetUserById(id, false)
+	})},
+	AuditTargetUserQuota: {IdField: "user_id", Load: loadById(func(id int) (*User, error) {
+		return GetUserById(id, false)
+	})},
+	AuditTargetUserRole: {IdField: "user_id", Load: loadById(func(id int) (map[string]any, error) {
+		roleIds, err := GetUserRoleIds(id)
+		return map[string]any{"role_ids": roleIds}, err
+	})},
+	AuditTargetToken:         {IdField: "id", Load: loadById(GetTokenById)},
+	AuditTargetRedemption:    {IdField: "id", Load: loadById(GetRedemptionById)},
+	AuditTargetRole:          {IdField: "id", Load: loadById(GetRoleById)},
+	AuditTargetRoutingRule:   {IdField: "id", Load: loadById(GetRoutingRuleById)},
+	AuditTargetVirtualModel:  {IdField: "id", Load: loadById(GetVirtualModelById)},
+	AuditTargetShadowConfig:  {IdField: "id", Load: loadById(GetShadowConfigById)},
+	AuditTargetManagementKey: {IdField: "id"},
+	AuditTargetLog:           {IdField: "target_timestamp"},
+	AuditTargetScimGroup:     {Load: loadById(GetScimGroupById)},
+	AuditTargetTwoFactor: {Load: loadById(func(id int) (map[string]any, error) {
+		twoFactor, err := GetTwoFactor(id)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"enabled": twoFactor.Enabled, "recovery_codes_left": twoFactor.RecoveryCodesLeft()}, nil
+	})},
+	AuditTargetModelPrice: {IdField: "id", Load: loadById(GetModelPriceById)},
+
+	AuditTargetExportSchedule: {IdField: "id", Load: loadById(GetExportScheduleById)},
+	// the orders of the user are settled, the quota of the user is what changes
+	AuditTargetOrder: {IdField: "userId", Load: loadById(func(id int) (*User, error) {
+		return GetUserById(id, false)
+	})},
+	AuditTargetAnomalyEvent: {IdField: "id", Load: loadById(GetAnomalyEventById)},
+}
+
+// isAuditSecretField tells whether the value of a field, or of an option, is a credential
+func isAuditSecretField(name string) bool {
+	name = strings.ToLower(name)
+	switch name {
+	case "key", "sk", "ak", "vertex_ai_adc", "aff_code":
+		return true
+	}
+	return strings.Contains(name, "secret") || strings.Contains(name, "password") ||
+		strings.HasSuffix(name, "token") || strings.HasSuffix(name, "_key") || strings.HasSuffix(name, "apikey")
+}
+
+// MaskAuditValue replaces the credentials nested in value, JSON objects encoded as strings included
+func MaskAuditValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		masked := make(map[string]any, len(v))
+		for name, item := range v {
+			if isAuditSecretField(name) {
+				if s, ok := item.(string); ok && s == "" {
+					masked[name] = s
+				} else {
+					masked[name] = auditMaskedValue
+				}
+				continue
+			}
+			masked[name] = MaskAuditValue(item)
+		}
+		return masked
+	case []any:
+		masked := make([]any, len(v))
+		for i, item := range v {
+			masked[i] = MaskAuditValue(item)
+		}
+		return masked
+	case string:
+		if strings.HasPrefix(strings.TrimSpace(v), "{") {
+			var object map[string]any
+			if json.Unmarshal([]byte(v), &object) == nil {
+				data, _ := json.Marshal(MaskAuditValue(object))
+				return string(data)
+			}
+		}
+	}
+	return value
+}
+
+// ToAuditSnapshot turns the target into a map of its top level fields
+func ToAuditSnapshot(target any) map[string]any {
+	if target == nil {
+		return nil
+	}
+	data, err := json.Marshal(target)
+	if err != nil {
+		return nil
+	}
+	var snapshot map[string]any
+	if json.Unmarshal(data, &snapshot) != nil {
+		return nil
+	}
+	return snapshot
+}
+
+// DiffAuditSnapshots returns the changed fields, masked, as JSON. A nil snapshot stands for a
+// target that does not exist, before a creation or after a deletion.
+func DiffAuditSnapshots(before map[string]any, after map[string]any) string {
+	diff := make(map[string]AuditFieldDiff)
+	for name, value := range before {
+		afterValue, ok := after[name]
+		if !ok || !auditValueEqual(value, afterValue) {
+			diff[name] = AuditFieldDiff{Before: value, After: afterValue}
+		}
+	}
+	for name, value := range after {
+		if _, ok := before[name]; !ok {
+			diff[name] = AuditFieldDiff{Before: nil, After: value}
+		}
+	}
+	masked := make(map[string]AuditFieldDiff, len(diff))
+	for name, fieldDiff := range diff {
+		if isAuditSecretField(name) {
+			// the change of a credential is recorded, never its value
+			masked[name] = AuditFieldDiff{Before: maskAuditSecret(fieldDiff.Before), After: maskAuditSecret(fieldDiff.After)}
+			continue
+		}
+		masked[name] = AuditFieldDiff{Before: MaskAuditValue(fieldDiff.Before), After: MaskAuditValue(fieldDiff.After)}
+	}
+	data, _ := json.Marshal(masked)
+	return string(data)
+}
+
+func maskAuditSecret(value any) any {
+	if value == nil || value == "" {
+		return value
+	}
+	return auditMaskedValue
+}
+
+func auditValueEqual(a any, b any) bool {
+	dataA, _ := json.Marshal(a)
+	dataB, _ := json.Marshal(b)
+	return string(dataA) == string(dataB)
+}
+
+func RecordAuditLog(auditLog *AuditLog) {
+	err := LOG_DB.Create(auditLog).Error
+	if err != nil {
+		logger.SysError("failed to record audit log: " + err.Error())
+	}
+}
+
+// AuditLogQuery filters the audit log, zero values match everything
+type AuditLogQuery struct {
+	UserId         int
+	Username       string
+	Action         string
+	TargetType     string
+	TargetId       string
+	StartTimestamp int64
+	EndTimestamp   int64
+}
+
+func (query *AuditLogQuery) apply(tx *gorm.DB) *gorm.DB {
+	if query.UserId != 0 {
+		tx = tx.Where("user_id = ?", query.UserId)
+	}
+	if query.Username != "" {
+		tx = tx.Where("username = ?", query.Username)
+	}
+	if query.Action != "" {
+		tx = tx.Where("action LIKE ?", "%"+query.Action+"%")
+	}
+	if query.TargetType != "" {
+		tx = tx.Where("target_type = ?", query.TargetType)
+	}
+	if query.TargetId != "" {
+		tx = tx.Where("target_id = ?", query.TargetId)
+	}
+	if query.StartTimestamp != 0 {
+		tx = tx.Where("created_at >= ?", query.StartTimestamp)
+	}
+	if query.EndTimestamp != 0 {
+		tx = tx.Where("created_at <= ?", query.EndTimestamp)
+	}
+	return tx
+}
+
+func GetAuditLogs(query *AuditLogQuery, startIdx int, num int) (auditLogs []*AuditLog, total int64, err error) {
+	tx := query.apply(LOG_DB.Model(&AuditLog{}))
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&auditLogs).Error
+	return auditLogs, total, err
+}
+
+// ExportAuditLogs calls fn with the matching audit logs in batches, oldest first
+func ExportAuditLogs(query *AuditLogQuery, fn func(auditLogs []*AuditLog) error) error {
+	var auditLogs []*AuditLog
+	return query.apply(LOG_DB.Model(&AuditLog{})).Order("id asc").FindInBatches(&auditLogs, 1000, func(tx *gorm.DB, batch int) error {
+		return fn(auditLogs)
+	}).Error
+}
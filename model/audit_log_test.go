@@ -0,0 +1,37 @@
+package model
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDiffAuditSnapshots(t *testing.T) {
+	before := map[string]any{"id": 1, "name": "old", "key": "sk-secret", "config": `{"region":"us","sk":"secret-sk"}`}
+	after := map[string]any{"id": 1, "name": "new", "key": "sk-rotated", "config": `{"region":"eu","sk":"secret-sk"}`}
+	diff := DiffAuditSnapshots(before, after)
+	if strings.Contains(diff, "secret") || strings.Contains(diff, "rotated") {
+		t.Fatalf("expected the secrets to be masked, got %s", diff)
+	}
+	var fields map[string]AuditFieldDiff
+	if err := json.Unmarshal([]byte(diff), &fields); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fields["id"]; ok {
+		t.Fatal("expected the unchanged fields to be left out")
+	}
+	if fields["name"].Before != "old" || fields["name"].After != "new" {
+		t.Fatalf("unexpected name diff %v", fields["name"])
+	}
+	if fields["key"].Before != auditMaskedValue || fields["key"].After != auditMaskedValue {
+		t.Fatalf("expected the key change to be recorded masked, got %v", fields["key"])
+	}
+	if !strings.Contains(fields["config"].After.(string), `"region":"eu"`) {
+		t.Fatalf("expected the config to be kept, got %v", fields["config"])
+	}
+
+	option := DiffAuditSnapshots(map[string]any{"SMTPToken": "a"}, map[string]any{"SMTPToken": "b"})
+	if strings.Contains(option, `"a"`) || strings.Contains(option, `"b"`) {
+		t.Fatalf("expected the option value to be masked, got %s", option)
+	}
+}
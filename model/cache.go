@@ -276,6 +276,12 @@ func SyncChannelCache(frequency int) {
 		time.Sleep(time.Duration(frequency) * time.Second)
 		logger.SysLog("syncing channels from database")
 		InitChannelCache()
+		InitChannelKeyCache()
+		InitShadowConfigCache()
+		InitRoutingRuleCache()
+		InitVirtualModelCache()
+		InitPriceCatalogCache()
+		InitRoleCache()
 	}
 }
 
@@ -313,8 +319,8 @@ func CacheGetOrderedChannels(group string, model string, excludedChannelIds []in
 
 func CacheGetRandomSatisfiedChannel(group string, model string, excludedChannelIds []int) (*Channel, error) {
 	channelSyncLock.RLock()
-	defer channelSyncLock.RUnlock()
 	if len(group2model2channels[group][model]) == 0 {
+		channelSyncLock.RUnlock()
 		return nil, errors.New("channel not found")
 	}
 	validChannels := make([]*Channel, 0)
@@ -333,10 +339,13 @@ func CacheGetRandomSatisfiedChannel(group string, model string, excludedChannelI
 			validChannels = append(validChannels, channel)
 		}
 	}
+	channelSyncLock.RUnlock()
 
 	if len(validChannels) == 0 {
 		return nil, nil
 	}
+	// the limits are read from Redis outside of the lock
+	validChannels = preferAvailableChannels(validChannels)
 	endIdx := len(validChannels)
 	// choose by priority
 	firstChannel := validChannels[0]
@@ -3,6 +3,7 @@ package model
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/helper"
@@ -38,6 +39,9 @@ type Channel struct {
 	Priority           *int64  `json:"priority" gorm:"bigint;default:0"`
 	Config             string  `json:"config"`
 	SystemPrompt       *string `json:"system_prompt" gorm:"type:text"`
+	KeyStrategy        string  `json:"key_strategy" gorm:"type:varchar(16);default:''"` // empty means one key per channel
+	MaxConcurrency     int     `json:"max_concurrency" gorm:"default:0"`                // 0 means no limit
+	MaxRPM             int     `json:"max_rpm" gorm:"column:max_rpm;default:0"`         // 0 means no limit
 }
 
 type ChannelConfig struct {
@@ -50,6 +54,26 @@ type ChannelConfig struct {
 	Plugin            string `json:"plugin,omitempty"`
 	VertexAIProjectID string `json:"vertex_ai_project_id,omitempty"`
 	VertexAIADC       string `json:"vertex_ai_adc,omitempty"`
+	// PromptCache adds cache_control breakpoints to the requests converted for Claude
+	PromptCache         bool   `json:"prompt_cache,omitempty"`
+	PromptCacheMessages int    `json:"prompt_cache_messages,omitempty"` // the number of the last messages to cache
+	PromptCacheModels   string `json:"prompt_cache_models,omitempty"`   // comma separated, empty means all models
+}
+
+// PromptCacheEnabled tells whether the prompt cache is enabled for the model on the channel
+func (cfg ChannelConfig) PromptCacheEnabled(modelName string) bool {
+	if !cfg.PromptCache {
+		return false
+	}
+	if cfg.PromptCacheModels == "" {
+		return true
+	}
+	for _, m := range strings.Split(cfg.PromptCacheModels, ",") {
+		if strings.TrimSpace(m) == modelName {
+			return true
+		}
+	}
+	return false
 }
 
 func GetAllChannels(startIdx int, num int, scope string) ([]*Channel, error) {
@@ -90,8 +114,27 @@ func GetChannelById(id int, selectAll bool) (*Channel, error) {
 	return &channel, err
 }
 
+// GetChannelTypeById returns the type of the channel, from the cache when the channel is enabled
+func GetChannelTypeById(id int) int {
+	if id == 0 {
+		return 0
+	}
+	if channel, err := CacheGetChannelById(id); err == nil {
+		return channel.Type
+	}
+	channelType := 0
+	DB.Model(&Channel{}).Where("id = ?", id).Select("type").Scan(&channelType)
+	return channelType
+}
+
 func BatchInsertChannels(channels []Channel) error {
 	var err error
+	for i := range channels {
+		err = channels[i].EncryptSecrets()
+		if err != nil {
+			return err
+		}
+	}
 	err = DB.Create(&channels).Error
 	if err != nil {
 		return err
@@ -134,6 +177,10 @@ func (channel *Channel) GetModelMapping() map[string]string {
 
 func (channel *Channel) Insert() error {
 	var err error
+	err = channel.EncryptSecrets()
+	if err != nil {
+		return err
+	}
 	err = DB.Create(channel).Error
 	if err != nil {
 		return err
@@ -145,6 +192,10 @@ func (channel *Channel) Insert() error {
 func (channel *Channel) Update() error {
 	var err error
 	channel.UsedQuota = 0
+	err = channel.EncryptSecrets()
+	if err != nil {
+		return err
+	}
 	err = DB.Model(channel).Updates(channel).Error
 	if err != nil {
 		return err
@@ -193,7 +244,8 @@ func (channel *Channel) LoadConfig() (ChannelConfig, error) {
 	if err != nil {
 		return cfg, err
 	}
-	return cfg, nil
+	err = decryptChannelConfigSecrets(&cfg)
+	return cfg, err
 }
 
 func UpdateChannelStatusById(id int, status int) {
@@ -0,0 +1,349 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"gorm.io/gorm"
+)
+
+const (
+	ChannelKeyStrategyRoundRobin = "round_robin"
+	ChannelKeyStrategyLeastUsed  = "least_used"
+)
+
+const (
+	ChannelKeyStatusEnabled          = 1
+	ChannelKeyStatusManuallyDisabled = 2
+	ChannelKeyStatusAutoDisabled     = 3
+)
+
+// ChannelKey keeps the status and usage of one key of a multi-key channel.
+// The key itself only lives in Channel.Key, it is referenced here by its hash.
+type ChannelKey struct {
+	Id             int    `json:"id"`
+	ChannelId      int    `json:"channel_id" gorm:"uniqueIndex:idx_channel_key_hash"`
+	KeyHash        string `json:"key_hash" gorm:"type:varchar(64);uniqueIndex:idx_channel_key_hash"`
+	Status         int    `json:"status" gorm:"default:1"`
+	DisabledReason string `json:"disabled_reason" gorm:"type:varchar(512);default:''"`
+	DisabledTime   int64  `json:"disabled_time" gorm:"bigint"`
+	RequestCount   int64  `json:"request_count" gorm:"bigint;default:0"`
+	FailureCount   int64  `json:"failure_count" gorm:"bigint;default:0"`
+	LastUsedTime   int64  `json:"last_used_time" gorm:"bigint"`
+}
+
+// ChannelKeyStatus is the admin view of a key of a multi-key channel
+type ChannelKeyStatus struct {
+	ChannelKey
+	Index         int    `json:"index"`
+	MaskedKey     string `json:"masked_key"`
+	CooldownUntil int64  `json:"cooldown_until"`
+}
+
+func HashChannelKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:16])
+}
+
+func MaskChannelKey(key string) string {
+	if len(key) <= 8 {
+		return "****"
+	}
+	return key[:4] + "****" + key[len(key)-4:]
+}
+
+// GetKeys returns the newline separated keys of the channel, decrypted
+func (channel *Channel) GetKeys() []string {
+	keys := make([]string, 0)
+	for _, key := range strings.Split(channel.DecryptedKey(), "\n") {
+		key = strings.TrimSpace(key)
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (channel *Channel) IsMultiKey() bool {
+	return channel.KeyStrategy != "" && len(channel.GetKeys()) > 1
+}
+
+type channelKeyState struct {
+	record        *ChannelKey
+	cooldownUntil int64
+}
+
+var channelId2keyStates = make(map[int]map[string]*channelKeyState)
+var channelId2keyCursor = make(map[int]int)
+var channelKeyLock sync.Mutex
+
+// InitChannelKeyCache creates the missing key records of the multi-key channels and reloads their status.
+// The cooldowns are kept in memory only, they survive the reload but not a restart.
+func InitChannelKeyCache() {
+	channelSyncLock.RLock()
+	multiKeyChannels := make([]*Channel, 0)
+	for _, channel := range channelId2channel {
+		if channel.IsMultiKey() {
+			multiKeyChannels = append(multiKeyChannels, channel)
+		}
+	}
+	channelSyncLock.RUnlock()
+
+	var records []*ChannelKey
+	err := DB.Find(&records).Error
+	if err != nil {
+		logger.SysError("failed to load channel keys: " + err.Error())
+		return
+	}
+	existing := make(map[int]map[string]*ChannelKey)
+	for _, record := range records {
+		if existing[record.ChannelId] == nil {
+			existing[record.ChannelId] = make(map[string]*ChannelKey)
+		}
+		existing[record.ChannelId][record.KeyHash] = record
+	}
+	for _, channel := range multiKeyChannels {
+		for _, key := range channel.GetKeys() {
+			keyHash := HashChannelKey(key)
+			if _, ok := existing[channel.Id][keyHash]; ok {
+				continue
+			}
+			record := &ChannelKey{ChannelId: channel.Id, KeyHash: keyHash, Status: ChannelKeyStatusEnabled}
+			if err := DB.Create(record).Error; err != nil {
+				logger.SysError("failed to create channel key: " + err.Error())
+				continue
+			}
+			if existing[channel.Id] == nil {
+				existing[channel.Id] = make(map[string]*ChannelKey)
+			}
+			existing[channel.Id][keyHash] = record
+		}
+	}
+
+	channelKeyLock.Lock()
+	defer channelKeyLock.Unlock()
+	newChannelId2keyStates := make(map[int]map[string]*channelKeyState)
+	for channelId, hash2record := range existing {
+		newChannelId2keyStates[channelId] = make(map[string]*channelKeyState)
+		for keyHash, record := range hash2record {
+			state := &channelKeyState{record: record}
+			if old, ok := channelId2keyStates[channelId][keyHash]; ok {
+				state.cooldownUntil = old.cooldownUntil
+				// the counters in the database lag behind the batch updater
+				if old.record.RequestCount > record.RequestCount {
+					record.RequestCount = old.record.RequestCount
+				}
+			}
+			newChannelId2keyStates[channelId][keyHash] = state
+		}
+	}
+	channelId2keyStates = newChannelId2keyStates
+}
+
+func getChannelKeyState(channelId int, keyHash string) *channelKeyState {
+	if channelId2keyStates[channelId] == nil {
+		channelId2keyStates[channelId] = make(map[string]*channelKeyState)
+	}
+	state, ok := channelId2keyStates[channelId][keyHash]
+	if !ok {
+		// the channel was edited after the last sync, the record is created by the next one
+		state = &channelKeyState{record: &ChannelKey{ChannelId: channelId, KeyHash: keyHash, Status: ChannelKeyStatusEnabled}}
+		channelId2keyStates[channelId][keyHash] = state
+	}
+	return state
+}
+
+// GetKeyByHash returns the key of the channel with the hash, for the requests that have to be made
+// with the key of an earlier request. An unknown hash returns the first key.
+func (channel *Channel) GetKeyByHash(keyHash string) string {
+	keys := channel.GetKeys()
+	for _, key := range keys {
+		if HashChannelKey(key) == keyHash {
+			return key
+		}
+	}
+	if len(keys) > 0 {
+		return keys[0]
+	}
+	return channel.DecryptedKey()
+}
+
+// ErrChannelKeysDisabled is returned by SelectKey when no key of the channel is enabled
+var ErrChannelKeysDisabled = errors.New("every key of the channel is disabled")
+
+// SelectKey picks the key used for the next request and returns it with its hash.
+// Single key channels return channel.Key and an empty hash. Disabled keys are never picked,
+// keys cooling down are only picked when every enabled key is cooling down.
+func (channel *Channel) SelectKey() (string, string, error) {
+	keys := channel.GetKeys()
+	if channel.KeyStrategy == "" || len(keys) <= 1 {
+		return channel.DecryptedKey(), "", nil
+	}
+	now := helper.GetTimestamp()
+	channelKeyLock.Lock()
+	candidates := make([]int, 0, len(keys))
+	cooling := make([]int, 0)
+	states := make([]*channelKeyState, len(keys))
+	for i, key := range keys {
+		states[i] = getChannelKeyState(channel.Id, HashChannelKey(key))
+		if states[i].record.Status != ChannelKeyStatusEnabled {
+			continue
+		}
+		if states[i].cooldownUntil > now {
+			cooling = append(cooling, i)
+			continue
+		}
+		candidates = append(candidates, i)
+	}
+	if len(candidates) == 0 {
+		candidates = cooling
+	}
+	if len(candidates) == 0 {
+		channelKeyLock.Unlock()
+		return "", "", ErrChannelKeysDisabled
+	}
+	idx := candidates[0]
+	switch channel.KeyStrategy {
+	case ChannelKeyStrategyLeastUsed:
+		for _, i := range candidates {
+			if states[i].record.RequestCount < states[idx].record.RequestCount {
+				idx = i
+			}
+		}
+	default:
+		cursor := channelId2keyCursor[channel.Id]
+		idx = candidates[cursor%len(candidates)]
+		channelId2keyCursor[channel.Id] = cursor + 1
+	}
+	record := states[idx].record
+	record.RequestCount++
+	record.LastUsedTime = now
+	recordId := record.Id
+	channelKeyLock.Unlock()
+
+	if recordId != 0 {
+		UpdateChannelKeyRequestCount(recordId, 1)
+	}
+	return keys[idx], record.KeyHash, nil
+}
+
+func UpdateChannelKeyRequestCount(id int, count int64) {
+	if config.BatchUpdateEnabled {
+		addNewRecord(BatchUpdateTypeChannelKeyRequestCount, id, count)
+		return
+	}
+	updateChannelKeyRequestCount(id, count)
+}
+
+func updateChannelKeyRequestCount(id int, count int64) {
+	err := DB.Model(&ChannelKey{}).Where("id = ?", id).Updates(map[string]any{
+		"request_count":  gorm.Expr("request_count + ?", count),
+		"last_used_time": helper.GetTimestamp(),
+	}).Error
+	if err != nil {
+		logger.SysError("failed to update channel key request count: " + err.Error())
+	}
+}
+
+// CooldownChannelKey rests the key for config.ChannelKeyCooldownSeconds and counts the failure
+func CooldownChannelKey(channelId int, keyHash string) {
+	channelKeyLock.Lock()
+	state := getChannelKeyState(channelId, keyHash)
+	state.cooldownUntil = helper.GetTimestamp() + int64(config.ChannelKeyCooldownSeconds)
+	state.record.FailureCount++
+	recordId := state.record.Id
+	channelKeyLock.Unlock()
+	if recordId == 0 {
+		return
+	}
+	err := DB.Model(&ChannelKey{}).Where("id = ?", recordId).Update("failure_count", gorm.Expr("failure_count + 1")).Error
+	if err != nil {
+		logger.SysError("failed to update channel key failure count: " + err.Error())
+	}
+}
+
+// UpdateChannelKeyStatus enables or disables one key without touching the channel
+func UpdateChannelKeyStatus(channelId int, keyHash string, status int, reason string) error {
+	channelKeyLock.Lock()
+	state := getChannelKeyState(channelId, keyHash)
+	state.record.Status = status
+	state.record.DisabledReason = reason
+	state.record.DisabledTime = 0
+	if status != ChannelKeyStatusEnabled {
+		state.record.DisabledTime = helper.GetTimestamp()
+	} else {
+		state.cooldownUntil = 0
+	}
+	if status == ChannelKeyStatusAutoDisabled {
+		state.record.FailureCount++
+	}
+	if state.record.Id == 0 {
+		// the record is created while holding the lock, so that its id is known to the next change
+		err := DB.Create(state.record).Error
+		channelKeyLock.Unlock()
+		return err
+	}
+	record := *state.record
+	channelKeyLock.Unlock()
+
+	return DB.Model(&ChannelKey{}).Where("id = ?", record.Id).Updates(map[string]any{
+		"status":          record.Status,
+		"disabled_reason": record.DisabledReason,
+		"disabled_time":   record.DisabledTime,
+		"failure_count":   record.FailureCount,
+	}).Error
+}
+
+func CacheCountEnabledChannelKeys(channel *Channel) int {
+	channelKeyLock.Lock()
+	defer channelKeyLock.Unlock()
+	count := 0
+	for _, key := range channel.GetKeys() {
+		if getChannelKeyState(channel.Id, HashChannelKey(key)).record.Status == ChannelKeyStatusEnabled {
+			count++
+		}
+	}
+	return count
+}
+
+// GetChannelKeyStatuses returns the status and usage of every key of the channel, in key order
+func GetChannelKeyStatuses(channel *Channel) []*ChannelKeyStatus {
+	var records []*ChannelKey
+	err := DB.Where("channel_id = ?", channel.Id).Find(&records).Error
+	if err != nil {
+		logger.SysError("failed to load channel keys: " + err.Error())
+	}
+	hash2record := make(map[string]*ChannelKey, len(records))
+	for _, record := range records {
+		hash2record[record.KeyHash] = record
+	}
+	channelKeyLock.Lock()
+	defer channelKeyLock.Unlock()
+	statuses := make([]*ChannelKeyStatus, 0)
+	for i, key := range channel.GetKeys() {
+		keyHash := HashChannelKey(key)
+		status := &ChannelKeyStatus{
+			Index:     i,
+			MaskedKey: MaskChannelKey(key),
+		}
+		if record, ok := hash2record[keyHash]; ok {
+			status.ChannelKey = *record
+		} else {
+			status.ChannelKey = ChannelKey{ChannelId: channel.Id, KeyHash: keyHash, Status: ChannelKeyStatusEnabled}
+		}
+		if state, ok := channelId2keyStates[channel.Id][keyHash]; ok {
+			status.CooldownUntil = state.cooldownUntil
+			if state.record.RequestCount > status.RequestCount {
+				status.RequestCount = state.record.RequestCount
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
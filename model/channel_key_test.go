@@ -0,0 +1,87 @@
+package model
+
+import (
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestChannelSelectKey(t *testing.T) {
+	channel := &Channel{Id: 1001, Key: "sk-aaaa0001\nsk-bbbb0002\n\nsk-cccc0003\n", KeyStrategy: ChannelKeyStrategyRoundRobin}
+	seen := make(map[string]int)
+	for i := 0; i < 6; i++ {
+		key, keyHash, _ := channel.SelectKey()
+		if keyHash != HashChannelKey(key) {
+			t.Fatalf("unexpected hash %s for key %s", keyHash, key)
+		}
+		seen[key]++
+	}
+	if len(seen) != 3 || seen["sk-aaaa0001"] != 2 {
+		t.Fatalf("expected keys to rotate evenly, got %v", seen)
+	}
+
+	CooldownChannelKey(channel.Id, HashChannelKey("sk-aaaa0001"))
+	CooldownChannelKey(channel.Id, HashChannelKey("sk-bbbb0002"))
+	for i := 0; i < 3; i++ {
+		if key, _, _ := channel.SelectKey(); key != "sk-cccc0003" {
+			t.Fatalf("expected the only key not cooling down, got %s", key)
+		}
+	}
+
+	single := &Channel{Id: 1002, Key: "sk-single", KeyStrategy: ChannelKeyStrategyLeastUsed}
+	if key, keyHash, _ := single.SelectKey(); key != "sk-single" || keyHash != "" {
+		t.Fatalf("expected single key channel to use its key, got %s %s", key, keyHash)
+	}
+}
+
+func TestChannelSelectKeyLeastUsed(t *testing.T) {
+	channel := &Channel{Id: 1003, Key: "sk-aaaa0001\nsk-bbbb0002", KeyStrategy: ChannelKeyStrategyLeastUsed}
+	first, _, _ := channel.SelectKey()
+	second, _, _ := channel.SelectKey()
+	if first == second {
+		t.Fatalf("expected least used key to alternate, got %s twice", first)
+	}
+}
+
+func TestChannelSelectKeyAllDisabled(t *testing.T) {
+	useDryRunDB(t)
+	channel := &Channel{Id: 1004, Key: "sk-aaaa0001\nsk-bbbb0002", KeyStrategy: ChannelKeyStrategyRoundRobin}
+	for _, key := range channel.GetKeys() {
+		if err := UpdateChannelKeyStatus(channel.Id, HashChannelKey(key), ChannelKeyStatusAutoDisabled, "invalid key"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if key, _, err := channel.SelectKey(); err != ErrChannelKeysDisabled {
+		t.Fatalf("expected no key to be picked, got %s", key)
+	}
+	if err := UpdateChannelKeyStatus(channel.Id, HashChannelKey("sk-bbbb0002"), ChannelKeyStatusEnabled, ""); err != nil {
+		t.Fatal(err)
+	}
+	if key, _, err := channel.SelectKey(); err != nil || key != "sk-bbbb0002" {
+		t.Fatalf("expected the enabled key, got %s %v", key, err)
+	}
+}
+
+func TestUpdateChannelKeyStatusKeepsCreatedId(t *testing.T) {
+	recorder := useDryRunDB(t)
+	// the dry run returns no id, the created record is given one as the database would
+	err := DB.Callback().Create().After("gorm:create").Register("test:id", func(tx *gorm.DB) {
+		tx.Statement.SetColumn("Id", 7)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyHash := HashChannelKey("sk-aaaa0001")
+	if err := UpdateChannelKeyStatus(1005, keyHash, ChannelKeyStatusAutoDisabled, "invalid key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateChannelKeyStatus(1005, keyHash, ChannelKeyStatusEnabled, ""); err != nil {
+		t.Fatal(err)
+	}
+	if recorder.find(`INSERT INTO "channel_keys"`) == "" {
+		t.Fatal("the record of the key is not created")
+	}
+	if recorder.find(`UPDATE "channel_keys"`, `id = 7`) == "" {
+		t.Fatal("the record of the key is created again instead of updated")
+	}
+}
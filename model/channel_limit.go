@@ -0,0 +1,286 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// the in flight counter in Redis expires in case an instance dies while holding a slot
+const channelConcurrencyKeyExpiration = 10 * time.Minute
+const channelLimitPollInterval = 50 * time.Millisecond
+
+// releaseChannelScript decrements the in flight counter only while it exists, an expired counter is not
+// recreated without a TTL, and never below 0
+var releaseChannelScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+local count = redis.call("DECR", KEYS[1])
+if count < 0 then
+	count = 0
+	redis.call("SET", KEYS[1], 0)
+end
+redis.call("EXPIRE", KEYS[1], ARGV[1])
+return count
+`)
+
+type channelLimitCounter struct {
+	inflight int
+	requests []int64 // unix milliseconds of the requests of the last minute
+	queued   int
+}
+
+// ChannelOccupancy is the current load of a channel against its limits
+type ChannelOccupancy struct {
+	ChannelId      int    `json:"channel_id"`
+	ChannelName    string `json:"channel_name"`
+	MaxConcurrency int    `json:"max_concurrency"`
+	Concurrency    int    `json:"concurrency"`
+	MaxRPM         int    `json:"max_rpm"`
+	RPM            int    `json:"rpm"`
+	Queued         int    `json:"queued"`
+}
+
+var channelLimitCounters = make(map[int]*channelLimitCounter)
+var channelLimitLock sync.Mutex
+
+func (channel *Channel) HasLimit() bool {
+	return channel.MaxConcurrency > 0 || channel.MaxRPM > 0
+}
+
+func getChannelLimitCounter(channelId int) *channelLimitCounter {
+	counter, ok := channelLimitCounters[channelId]
+	if !ok {
+		counter = &channelLimitCounter{}
+		channelLimitCounters[channelId] = counter
+	}
+	return counter
+}
+
+func (counter *channelLimitCounter) trim(now int64) {
+	i := 0
+	for i < len(counter.requests) && counter.requests[i] <= now-60*1000 {
+		i++
+	}
+	counter.requests = counter.requests[i:]
+}
+
+func channelConcurrencyKey(channelId int) string {
+	return fmt.Sprintf("channel_concurrency:%d", channelId)
+}
+
+func channelRPMKey(channelId int, minute int64) string {
+	return fmt.Sprintf("channel_rpm:%d:%d", channelId, minute)
+}
+
+// IsChannelAvailable tells whether the channel is below its limits, without taking a slot
+func IsChannelAvailable(channel *Channel) bool {
+	if !channel.HasLimit() {
+		return true
+	}
+	occupancy := GetChannelOccupancy(channel)
+	if channel.MaxConcurrency > 0 && occupancy.Concurrency >= channel.MaxConcurrency {
+		return false
+	}
+	if channel.MaxRPM > 0 && occupancy.RPM >= channel.MaxRPM {
+		return false
+	}
+	return true
+}
+
+// preferAvailableChannels drops the channels at their limits, unless all of them are,
+// in which case the request waits in the queue of the selected channel
+func preferAvailableChannels(channels []*Channel) []*Channel {
+	available := make([]*Channel, 0, len(channels))
+	for _, channel := range channels {
+		if IsChannelAvailable(channel) {
+			available = append(available, channel)
+		}
+	}
+	if len(available) == 0 {
+		return channels
+	}
+	return available
+}
+
+// SortChannelsByAvailability moves the channels at their limits to the end, keeping the order otherwise
+func SortChannelsByAvailability(channels []*Channel) []*Channel {
+	available := make([]*Channel, 0, len(channels))
+	busy := make([]*Channel, 0)
+	for _, channel := range channels {
+		if IsChannelAvailable(channel) {
+			available = append(available, channel)
+		} else {
+			busy = append(busy, channel)
+		}
+	}
+	return append(available, busy...)
+}
+
+// TryAcquireChannel takes a concurrency slot and counts a request against the RPM limit,
+// it returns false without side effects when the channel is at one of its limits
+func TryAcquireChannel(channel *Channel) bool {
+	if !channel.HasLimit() {
+		return true
+	}
+	if common.RedisEnabled {
+		return redisTryAcquireChannel(channel)
+	}
+	channelLimitLock.Lock()
+	defer channelLimitLock.Unlock()
+	counter := getChannelLimitCounter(channel.Id)
+	now := time.Now().UnixMilli()
+	counter.trim(now)
+	if channel.MaxConcurrency > 0 && counter.inflight >= channel.MaxConcurrency {
+		return false
+	}
+	if channel.MaxRPM > 0 && len(counter.requests) >= channel.MaxRPM {
+		return false
+	}
+	counter.inflight++
+	counter.requests = append(counter.requests, now)
+	return true
+}
+
+func redisTryAcquireChannel(channel *Channel) bool {
+	ctx := context.Background()
+	if channel.MaxRPM > 0 {
+		key := channelRPMKey(channel.Id, time.Now().Unix()/60)
+		count, err := common.RDB.Incr(ctx, key).Result()
+		if err != nil {
+			logger.SysError("failed to count channel rpm: " + err.Error())
+			return true
+		}
+		common.RDB.Expire(ctx, key, 2*time.Minute)
+		if count > int64(channel.MaxRPM) {
+			common.RDB.Decr(ctx, key)
+			return false
+		}
+	}
+	if channel.MaxConcurrency > 0 {
+		key := channelConcurrencyKey(channel.Id)
+		count, err := common.RDB.Incr(ctx, key).Result()
+		if err != nil {
+			logger.SysError("failed to count channel concurrency: " + err.Error())
+			return true
+		}
+		common.RDB.Expire(ctx, key, channelConcurrencyKeyExpiration)
+		if count > int64(channel.MaxConcurrency) {
+			common.RDB.Decr(ctx, key)
+			if channel.MaxRPM > 0 {
+				common.RDB.Decr(ctx, channelRPMKey(channel.Id, time.Now().Unix()/60))
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// AcquireChannel is TryAcquireChannel waiting up to timeout in the queue of the channel.
+// The queue is bounded by config.ChannelLimitQueueSize, a full queue fails immediately.
+func AcquireChannel(ctx context.Context, channel *Channel, timeout time.Duration) bool {
+	if TryAcquireChannel(channel) {
+		return true
+	}
+	if timeout <= 0 {
+		return false
+	}
+	channelLimitLock.Lock()
+	counter := getChannelLimitCounter(channel.Id)
+	if counter.queued >= config.ChannelLimitQueueSize {
+		channelLimitLock.Unlock()
+		return false
+	}
+	counter.queued++
+	channelLimitLock.Unlock()
+	defer func() {
+		channelLimitLock.Lock()
+		counter.queued--
+		channelLimitLock.Unlock()
+	}()
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(channelLimitPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-deadline.C:
+			return false
+		case <-ticker.C:
+			if TryAcquireChannel(channel) {
+				return true
+			}
+		}
+	}
+}
+
+// ReleaseChannel gives back the concurrency slot taken by AcquireChannel
+func ReleaseChannel(channel *Channel) {
+	if channel.MaxConcurrency <= 0 {
+		return
+	}
+	if common.RedisEnabled {
+		key := channelConcurrencyKey(channel.Id)
+		err := releaseChannelScript.Run(context.Background(), common.RDB, []string{key}, int(channelConcurrencyKeyExpiration.Seconds())).Err()
+		if err != nil {
+			logger.SysError("failed to release channel concurrency: " + err.Error())
+		}
+		return
+	}
+	channelLimitLock.Lock()
+	defer channelLimitLock.Unlock()
+	counter := getChannelLimitCounter(channel.Id)
+	if counter.inflight > 0 {
+		counter.inflight--
+	}
+}
+
+func GetChannelOccupancy(channel *Channel) *ChannelOccupancy {
+	occupancy := &ChannelOccupancy{
+		ChannelId:      channel.Id,
+		ChannelName:    channel.Name,
+		MaxConcurrency: channel.MaxConcurrency,
+		MaxRPM:         channel.MaxRPM,
+	}
+	channelLimitLock.Lock()
+	counter := getChannelLimitCounter(channel.Id)
+	counter.trim(time.Now().UnixMilli())
+	occupancy.Concurrency = counter.inflight
+	occupancy.RPM = len(counter.requests)
+	occupancy.Queued = counter.queued
+	channelLimitLock.Unlock()
+	if common.RedisEnabled {
+		ctx := context.Background()
+		occupancy.Concurrency, _ = common.RDB.Get(ctx, channelConcurrencyKey(channel.Id)).Int()
+		occupancy.RPM, _ = common.RDB.Get(ctx, channelRPMKey(channel.Id, time.Now().Unix()/60)).Int()
+	}
+	return occupancy
+}
+
+// CacheGetChannelOccupancies returns the occupancy of every enabled channel with a limit
+func CacheGetChannelOccupancies() []*ChannelOccupancy {
+	channelSyncLock.RLock()
+	channels := make([]*Channel, 0)
+	for _, channel := range channelId2channel {
+		if channel.HasLimit() {
+			channels = append(channels, channel)
+		}
+	}
+	channelSyncLock.RUnlock()
+	occupancies := make([]*ChannelOccupancy, 0, len(channels))
+	for _, channel := range channels {
+		occupancies = append(occupancies, GetChannelOccupancy(channel))
+	}
+	return occupancies
+}
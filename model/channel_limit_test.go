@@ -0,0 +1,46 @@
+package model
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/songquanpeng/one-api/common"
+)
+
+func TestChannelLimit(t *testing.T) {
+	common.RedisEnabled = false
+	channel := &Channel{Id: 2001, MaxConcurrency: 2, MaxRPM: 3}
+	if !TryAcquireChannel(channel) || !TryAcquireChannel(channel) {
+		t.Fatal("expected the first two requests to get a slot")
+	}
+	if TryAcquireChannel(channel) || IsChannelAvailable(channel) {
+		t.Fatal("expected the channel to be at its concurrency limit")
+	}
+	ReleaseChannel(channel)
+	if !AcquireChannel(context.Background(), channel, 0) {
+		t.Fatal("expected a released slot to be reusable")
+	}
+	ReleaseChannel(channel)
+	ReleaseChannel(channel)
+	if TryAcquireChannel(channel) {
+		t.Fatal("expected the channel to be at its rpm limit")
+	}
+	occupancy := GetChannelOccupancy(channel)
+	if occupancy.Concurrency != 0 || occupancy.RPM != 3 {
+		t.Fatalf("unexpected occupancy %+v", occupancy)
+	}
+
+	limited := &Channel{Id: 2002, MaxConcurrency: 1}
+	TryAcquireChannel(limited)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		ReleaseChannel(limited)
+	}()
+	if !AcquireChannel(context.Background(), limited, time.Second) {
+		t.Fatal("expected the queued request to get the released slot")
+	}
+	if AcquireChannel(context.Background(), limited, 100*time.Millisecond) {
+		t.Fatal("expected the queued request to time out")
+	}
+}
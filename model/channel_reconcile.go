@@ -0,0 +1,191 @@
+package model
+
+import (
+	"sort"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// ChannelBalanceRecord is a poll of the upstream balance of a channel, with the quota billed on it at the time
+// and the deltas since the previous poll
+type ChannelBalanceRecord struct {
+	Id          int     `json:"id"`
+	ChannelId   int     `json:"channel_id" gorm:"index:idx_channel_balance,priority:1"`
+	Balance     float64 `json:"balance"` // in USD
+	UsedQuota   int64   `json:"used_quota" gorm:"bigint"`
+	Drawdown    float64 `json:"drawdown"` // the balance spent upstream since the previous poll
+	BilledQuota int64   `json:"billed_quota" gorm:"bigint"`
+	Skipped     bool    `json:"skipped"` // the balance was topped up or the used quota reset, the interval isn't compared
+	CreatedAt   int64   `json:"created_at" gorm:"bigint;index:idx_channel_balance,priority:2"`
+}
+
+// track sets the deltas of the record since the last one
+func (record *ChannelBalanceRecord) track(last *ChannelBalanceRecord) {
+	if record.Balance > last.Balance || record.UsedQuota < last.UsedQuota {
+		record.Skipped = true
+		return
+	}
+	record.Drawdown = last.Balance - record.Balance
+	record.BilledQuota = record.UsedQuota - last.UsedQuota
+}
+
+// RecordChannelBalance records a poll of the balance of the channel in USD for the reconciliation
+func RecordChannelBalance(channelId int, balance float64) error {
+	record := ChannelBalanceRecord{
+		ChannelId: channelId,
+		Balance:   balance,
+		CreatedAt: helper.GetTimestamp(),
+	}
+	err := DB.Model(&Channel{}).Select("used_quota").Where("id = ?", channelId).Scan(&record.UsedQuota).Error
+	if err != nil {
+		return err
+	}
+	var last ChannelBalanceRecord
+	err = DB.Where("channel_id = ?", channelId).Order("id desc").Limit(1).Find(&last).Error
+	if err != nil {
+		return err
+	}
+	if last.Id != 0 {
+		record.track(&last)
+	}
+	return DB.Create(&record).Error
+}
+
+// ChannelReconciliation compares what a channel spent upstream in a day with what we billed on it, in USD
+type ChannelReconciliation struct {
+	Day         string  `json:"day"`
+	ChannelId   int     `json:"channel_id"`
+	ChannelName string  `json:"channel_name"`
+	Drawdown    float64 `json:"drawdown"`
+	Billed      float64 `json:"billed"` // from the used quota of the channel
+	Logged      float64 `json:"logged"` // from the consume logs of the channel, over the whole day
+	Margin      float64 `json:"margin"` // billed minus drawdown
+	MarginRate  float64 `json:"margin_rate"`
+	Skipped     int     `json:"skipped"` // the polls not compared
+	Alert       bool    `json:"alert"`
+}
+
+type reconcileKey struct {
+	day       string
+	channelId int
+}
+
+func (r *ChannelReconciliation) finish() {
+	r.Margin = r.Billed - r.Drawdown
+	r.MarginRate = 0
+	if r.Billed > 0 {
+		r.MarginRate = r.Margin / r.Billed
+	}
+	over := r.Drawdown - r.Billed
+	r.Alert = over >= config.ChannelReconcileAlertMinUSD && over > r.Billed*config.ChannelReconcileAlertRatio
+}
+
+// foldChannelReconciliations sums the polls by channel and local day, sorted by day and channel
+func foldChannelReconciliations(records []*ChannelBalanceRecord, logged map[reconcileKey]int64) []*ChannelReconciliation {
+	var reconciliations []*ChannelReconciliation
+	byKey := make(map[reconcileKey]*ChannelReconciliation)
+	for _, record := range records {
+		key := reconcileKey{day: time.Unix(record.CreatedAt, 0).Format("2006-01-02"), channelId: record.ChannelId}
+		r, ok := byKey[key]
+		if !ok {
+			r = &ChannelReconciliation{
+				Day:       key.day,
+				ChannelId: key.channelId,
+				Logged:    float64(logged[key]) / config.QuotaPerUnit,
+			}
+			byKey[key] = r
+			reconciliations = append(reconciliations, r)
+		}
+		if record.Skipped {
+			r.Skipped++
+			continue
+		}
+		r.Drawdown += record.Drawdown
+		r.Billed += float64(record.BilledQuota) / config.QuotaPerUnit
+	}
+	for _, r := range reconciliations {
+		r.finish()
+	}
+	sort.SliceStable(reconciliations, func(i, j int) bool {
+		if reconciliations[i].Day != reconciliations[j].Day {
+			return reconciliations[i].Day < reconciliations[j].Day
+		}
+		return reconciliations[i].ChannelId < reconciliations[j].ChannelId
+	})
+	return reconciliations
+}
+
+// SumChannelReconciliations returns the total of the reconciliations
+func SumChannelReconciliations(reconciliations []*ChannelReconciliation) *ChannelReconciliation {
+	total := &ChannelReconciliation{}
+	for _, r := range reconciliations {
+		total.Drawdown += r.Drawdown
+		total.Billed += r.Billed
+		total.Logged += r.Logged
+		total.Skipped += r.Skipped
+	}
+	total.finish()
+	return total
+}
+
+// getLoggedChannelQuota sums the consume logs of the channels by local day from start until before end
+func getLoggedChannelQuota(channelId int, start time.Time, end time.Time) (map[reconcileKey]int64, error) {
+	logged := make(map[reconcileKey]int64)
+	for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+		var rows []struct {
+			ChannelId int
+			Quota     int64
+		}
+		tx := LOG_DB.Model(&Log{}).Select("channel_id, SUM(quota) AS quota").
+			Where("type = ? AND created_at >= ? AND created_at < ?", LogTypeConsume, day.Unix(), day.AddDate(0, 0, 1).Unix())
+		if channelId != 0 {
+			tx = tx.Where("channel_id = ?", channelId)
+		}
+		err := tx.Group("channel_id").Scan(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			logged[reconcileKey{day: day.Format("2006-01-02"), channelId: row.ChannelId}] = row.Quota
+		}
+	}
+	return logged, nil
+}
+
+// GetChannelReconciliations reconciles the polled channels by local day for the days from start until before end,
+// a channelId of 0 means all channels
+func GetChannelReconciliations(channelId int, start time.Time, end time.Time) ([]*ChannelReconciliation, error) {
+	start = time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.Local)
+	var records []*ChannelBalanceRecord
+	tx := DB.Where("created_at >= ? AND created_at < ?", start.Unix(), end.Unix())
+	if channelId != 0 {
+		tx = tx.Where("channel_id = ?", channelId)
+	}
+	err := tx.Order("id asc").Find(&records).Error
+	if err != nil {
+		return nil, err
+	}
+	logged, err := getLoggedChannelQuota(channelId, start, end)
+	if err != nil {
+		return nil, err
+	}
+	reconciliations := foldChannelReconciliations(records, logged)
+	var channels []*Channel
+	if len(reconciliations) > 0 {
+		err = DB.Select("id", "name").Find(&channels).Error
+		if err != nil {
+			logger.SysError("failed to get channel names: " + err.Error())
+		}
+	}
+	names := make(map[int]string, len(channels))
+	for _, channel := range channels {
+		names[channel.Id] = channel.Name
+	}
+	for _, r := range reconciliations {
+		r.ChannelName = names[r.ChannelId]
+	}
+	return reconciliations, nil
+}
@@ -0,0 +1,33 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFoldChannelReconciliations(t *testing.T) {
+	day := time.Date(2025, 3, 1, 10, 0, 0, 0, time.Local)
+	first := &ChannelBalanceRecord{ChannelId: 1, Balance: 100, UsedQuota: 0, CreatedAt: day.Unix()}
+	spent := &ChannelBalanceRecord{ChannelId: 1, Balance: 90, UsedQuota: 2500000, CreatedAt: day.Add(time.Hour).Unix()}
+	spent.track(first)
+	if spent.Skipped || spent.Drawdown != 10 || spent.BilledQuota != 2500000 {
+		t.Fatalf("unexpected deltas %+v", spent)
+	}
+	toppedUp := &ChannelBalanceRecord{ChannelId: 1, Balance: 150, UsedQuota: 3000000, CreatedAt: day.Add(2 * time.Hour).Unix()}
+	toppedUp.track(spent)
+	if !toppedUp.Skipped || toppedUp.Drawdown != 0 || toppedUp.BilledQuota != 0 {
+		t.Fatalf("expected the top-up to be skipped, got %+v", toppedUp)
+	}
+	logged := map[reconcileKey]int64{{day: "2025-03-01", channelId: 1}: 3000000}
+	reconciliations := foldChannelReconciliations([]*ChannelBalanceRecord{first, spent, toppedUp}, logged)
+	if len(reconciliations) != 1 {
+		t.Fatalf("unexpected reconciliations %+v", reconciliations)
+	}
+	r := reconciliations[0]
+	if r.Day != "2025-03-01" || r.Drawdown != 10 || r.Billed != 5 || r.Logged != 6 || r.Margin != -5 || r.MarginRate != -1 || r.Skipped != 1 {
+		t.Fatalf("unexpected reconciliation %+v", r)
+	}
+	if !r.Alert {
+		t.Fatalf("expected an alert when the drawdown is twice the billed quota")
+	}
+}
@@ -0,0 +1,115 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// the fields of ChannelConfig holding upstream credentials
+var channelConfigSecretFields = []string{"sk", "ak", "vertex_ai_adc"}
+
+// DecryptedKey returns the plaintext of Channel.Key, it must only be called on the relay path
+func (channel *Channel) DecryptedKey() string {
+	key, err := common.DecryptSecret(channel.Key)
+	if err != nil {
+		logger.SysError(fmt.Sprintf("failed to decrypt key of channel #%d: %s", channel.Id, err.Error()))
+		return ""
+	}
+	return key
+}
+
+func transformChannelConfigSecrets(config string, transform func(string) (string, error)) (string, error) {
+	if config == "" {
+		return config, nil
+	}
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(config), &fields); err != nil {
+		return "", err
+	}
+	changed := false
+	for _, field := range channelConfigSecretFields {
+		value, ok := fields[field].(string)
+		if !ok || value == "" {
+			continue
+		}
+		transformed, err := transform(value)
+		if err != nil {
+			return "", err
+		}
+		if transformed != value {
+			fields[field] = transformed
+			changed = true
+		}
+	}
+	if !changed {
+		return config, nil
+	}
+	data, err := json.Marshal(fields)
+	return string(data), err
+}
+
+func decryptChannelConfigSecrets(cfg *ChannelConfig) error {
+	for _, value := range []*string{&cfg.SK, &cfg.AK, &cfg.VertexAIADC} {
+		plaintext, err := common.DecryptSecret(*value)
+		if err != nil {
+			return err
+		}
+		*value = plaintext
+	}
+	return nil
+}
+
+// EncryptSecrets encrypts the key and the config credentials before they are written,
+// values already encrypted are left untouched
+func (channel *Channel) EncryptSecrets() error {
+	key, err := common.EncryptSecret(channel.Key)
+	if err != nil {
+		return err
+	}
+	config, err := transformChannelConfigSecrets(channel.Config, common.EncryptSecret)
+	if err != nil {
+		return err
+	}
+	channel.Key = key
+	channel.Config = config
+	return nil
+}
+
+// ReencryptChannelSecrets moves the credentials of every channel to the current master key,
+// plaintext rows included. It returns the number of channels rewritten.
+func ReencryptChannelSecrets() (int, error) {
+	if !common.SecretEncryptionEnabled() {
+		return 0, fmt.Errorf("CHANNEL_SECRET_KEYS is not configured")
+	}
+	var channels []*Channel
+	err := DB.Select("id", "key", "config").Find(&channels).Error
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, channel := range channels {
+		key, err := common.ReencryptSecret(channel.Key)
+		if err != nil {
+			return count, fmt.Errorf("channel #%d: %w", channel.Id, err)
+		}
+		config, err := transformChannelConfigSecrets(channel.Config, common.ReencryptSecret)
+		if err != nil {
+			return count, fmt.Errorf("channel #%d: %w", channel.Id, err)
+		}
+		if key == channel.Key && config == channel.Config {
+			continue
+		}
+		err = DB.Model(&Channel{}).Where("id = ?", channel.Id).Updates(map[string]any{
+			"key":    key,
+			"config": config,
+		}).Error
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
@@ -0,0 +1,76 @@
+package model
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/songquanpeng/one-api/common"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// sqlRecorder is a gorm logger keeping the statements of a dry run
+type sqlRecorder struct {
+	mu         sync.Mutex
+	statements []string
+}
+
+func (r *sqlRecorder) LogMode(logger.LogLevel) logger.Interface      { return r }
+func (r *sqlRecorder) Info(context.Context, string, ...interface{})  {}
+func (r *sqlRecorder) Warn(context.Context, string, ...interface{})  {}
+func (r *sqlRecorder) Error(context.Context, string, ...interface{}) {}
+
+func (r *sqlRecorder) Trace(_ context.Context, _ time.Time, fc func() (string, int64), _ error) {
+	sql, _ := fc()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.statements = append(r.statements, sql)
+}
+
+// find returns the first statement containing all the parts
+func (r *sqlRecorder) find(parts ...string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, statement := range r.statements {
+		matched := true
+		for _, part := range parts {
+			matched = matched && strings.Contains(statement, part)
+		}
+		if matched {
+			return statement
+		}
+	}
+	return ""
+}
+
+func (r *sqlRecorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.statements)
+}
+
+// useDryRunDB replaces DB and LOG_DB for the test by a dry run, the queries return no rows
+// and their statements are recorded. Redis is disabled meanwhile and the local caches are initialized.
+func useDryRunDB(t *testing.T) *sqlRecorder {
+	recorder := &sqlRecorder{}
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=127.0.0.1"}), &gorm.Config{
+		DryRun:                 true,
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+		Logger:                 recorder,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if UsernamesCache == nil {
+		InitPool()
+	}
+	oldDB, oldLogDB, oldRedisEnabled := DB, LOG_DB, common.RedisEnabled
+	DB, LOG_DB, common.RedisEnabled = db, db, false
+	t.Cleanup(func() { DB, LOG_DB, common.RedisEnabled = oldDB, oldLogDB, oldRedisEnabled })
+	return recorder
+}
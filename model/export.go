@@ -0,0 +1,341 @@
+package model
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/helper"
+	"gorm.io/gorm"
+)
+
+const (
+	ExportTableLogs         = "logs"
+	ExportTableFailedLogs   = "failed_logs"
+	ExportTableUsages       = "usages"
+	ExportTableQuotaRecords = "quota_records"
+)
+
+const (
+	ExportFormatCSV   = "csv"
+	ExportFormatJSONL = "jsonl"
+)
+
+const (
+	ExportPeriodDay   = "day"
+	ExportPeriodWeek  = "week"
+	ExportPeriodMonth = "month"
+)
+
+const (
+	ExportScheduleStatusEnabled  = 1
+	ExportScheduleStatusDisabled = 2
+)
+
+const exportBatchSize = 1000
+
+// ExportQuery filters the exported rows like the log search does, zero values match everything.
+// The filters a table has no column for are ignored.
+type ExportQuery struct {
+	UserId         int
+	Username       string
+	LogType        int
+	ModelName      string
+	TokenName      string
+	Channel        int // the channel of the logs, the channel type of the usages
+	StartTimestamp int64
+	EndTimestamp   int64
+}
+
+func (query *ExportQuery) apply(tx *gorm.DB, table string) *gorm.DB {
+	if query.UserId != 0 {
+		tx = tx.Where("user_id = ?", query.UserId)
+	}
+	if query.ModelName != "" && table != ExportTableQuotaRecords {
+		tx = tx.Where("model_name = ?", query.ModelName)
+	}
+	switch table {
+	case ExportTableLogs:
+		if query.Username != "" {
+			tx = tx.Where("username = ?", query.Username)
+		}
+		if query.LogType != LogTypeUnknown {
+			tx = tx.Where("type = ?", query.LogType)
+		}
+		if query.TokenName != "" {
+			tx = tx.Where("token_name = ?", query.TokenName)
+		}
+		if query.Channel != 0 {
+			tx = tx.Where("channel_id = ?", query.Channel)
+		}
+	case ExportTableUsages:
+		if query.TokenName != "" {
+			tx = tx.Where("token_name = ?", query.TokenName)
+		}
+		if query.Channel != 0 {
+			tx = tx.Where("channel_type = ?", query.Channel)
+		}
+	}
+	// the usages are kept by the hour, the quota records by their creation
+	switch table {
+	case ExportTableUsages:
+		if query.StartTimestamp != 0 {
+			tx = tx.Where("hour >= ?", getHourOf(query.StartTimestamp))
+		}
+		if query.EndTimestamp != 0 {
+			tx = tx.Where("hour <= ?", getHourOf(query.EndTimestamp))
+		}
+	case ExportTableQuotaRecords:
+		if query.StartTimestamp != 0 {
+			tx = tx.Where("created_time >= ?", query.StartTimestamp)
+		}
+		if query.EndTimestamp != 0 {
+			tx = tx.Where("created_time <= ?", query.EndTimestamp)
+		}
+	default:
+		if query.StartTimestamp != 0 {
+			tx = tx.Where("created_at >= ?", query.StartTimestamp)
+		}
+		if query.EndTimestamp != 0 {
+			tx = tx.Where("created_at <= ?", query.EndTimestamp)
+		}
+	}
+	return tx
+}
+
+// IsExportTable tells whether the table can be exported
+func IsExportTable(table string) bool {
+	switch table {
+	case ExportTableLogs, ExportTableUsages, ExportTableQuotaRecords:
+		return true
+	case ExportTableFailedLogs:
+		// failed_logs is not migrated, it only exists where it was created
+		return LOG_DB.Migrator().HasTable(&FailedLog{})
+	}
+	return false
+}
+
+// exportRows calls fn with the matching rows of the table in batches, oldest first.
+// The batches are read after the last id of the previous one, so the memory stays constant.
+func exportRows(table string, query *ExportQuery, fn func(rows any) error) error {
+	switch table {
+	case ExportTableLogs:
+		return exportInBatches[Log](query.apply(LOG_DB.Model(&Log{}), table), fn)
+	case ExportTableFailedLogs:
+		return exportInBatches[FailedLog](query.apply(LOG_DB.Model(&FailedLog{}), table), fn)
+	case ExportTableUsages:
+		return exportInBatches[Usage](query.apply(DB.Model(&Usage{}), table), fn)
+	case ExportTableQuotaRecords:
+		return exportInBatches[QuotaRecord](query.apply(DB.Model(&QuotaRecord{}), table), fn)
+	}
+	return fmt.Errorf("不支持导出的数据：%s", table)
+}
+
+func exportInBatches[T any](tx *gorm.DB, fn func(rows any) error) error {
+	var rows []*T
+	return tx.Order("id asc").FindInBatches(&rows, exportBatchSize, func(tx *gorm.DB, batch int) error {
+		return fn(rows)
+	}).Error
+}
+
+// exportColumns returns the json names of the fields of a row, they are the header of the CSV
+func exportColumns(rowType reflect.Type) []string {
+	columns := make([]string, 0, rowType.NumField())
+	for i := 0; i < rowType.NumField(); i++ {
+		if !rowType.Field(i).IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(rowType.Field(i).Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = rowType.Field(i).Name
+		}
+		columns = append(columns, name)
+	}
+	return columns
+}
+
+func exportRecord(row reflect.Value) []string {
+	record := make([]string, 0, row.NumField())
+	for i := 0; i < row.NumField(); i++ {
+		field := row.Type().Field(i)
+		if !field.IsExported() || field.Tag.Get("json") == "-" {
+			continue
+		}
+		record = append(record, fmt.Sprint(row.Field(i).Interface()))
+	}
+	return record
+}
+
+func exportRowType(table string) reflect.Type {
+	switch table {
+	case ExportTableFailedLogs:
+		return reflect.TypeOf(FailedLog{})
+	case ExportTableUsages:
+		return reflect.TypeOf(Usage{})
+	case ExportTableQuotaRecords:
+		return reflect.TypeOf(QuotaRecord{})
+	}
+	return reflect.TypeOf(Log{})
+}
+
+// WriteExport writes the matching rows of the table to w as CSV or JSONL, flush is called after each batch
+func WriteExport(w io.Writer, flush func(), table string, format string, query *ExportQuery) error {
+	if !IsExportTable(table) {
+		return fmt.Errorf("不支持导出的数据：%s", table)
+	}
+	if format != ExportFormatCSV && format != ExportFormatJSONL {
+		return errors.New("不支持的导出格式")
+	}
+	if format == ExportFormatJSONL {
+		encoder := json.NewEncoder(w)
+		return exportRows(table, query, func(rows any) error {
+			values := reflect.ValueOf(rows)
+			for i := 0; i < values.Len(); i++ {
+				if err := encoder.Encode(values.Index(i).Interface()); err != nil {
+					return err
+				}
+			}
+			flush()
+			return nil
+		})
+	}
+	writer := csv.NewWriter(w)
+	if err := writer.Write(exportColumns(exportRowType(table))); err != nil {
+		return err
+	}
+	err := exportRows(table, query, func(rows any) error {
+		values := reflect.ValueOf(rows)
+		for i := 0; i < values.Len(); i++ {
+			if err := writer.Write(exportRecord(values.Index(i).Elem())); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		flush()
+		return writer.Error()
+	})
+	if err != nil {
+		return err
+	}
+	// flushes the header when there is no row
+	writer.Flush()
+	return writer.Error()
+}
+
+// ExportSchedule exports the rows of a table each period into EXPORT_DIR
+type ExportSchedule struct {
+	Id             int    `json:"id"`
+	Name           string `json:"name" gorm:"type:varchar(128)"`
+	Table          string `json:"table" gorm:"column:export_table;type:varchar(32)"`
+	Format         string `json:"format" gorm:"type:varchar(16);default:'csv'"`
+	Period         string `json:"period" gorm:"type:varchar(16);default:'day'"`
+	Status         int    `json:"status" gorm:"default:1"`
+	UserId         int    `json:"user_id" gorm:"default:0"`
+	ModelName      string `json:"model_name" gorm:"type:varchar(128);default:''"`
+	TokenName      string `json:"token_name" gorm:"type:varchar(128);default:''"`
+	Channel        int    `json:"channel" gorm:"default:0"`
+	LastExportTime int64  `json:"last_export_time" gorm:"bigint;default:0"` // the end of the last exported period
+	LastFile       string `json:"last_file" gorm:"type:varchar(255);default:''"`
+	LastError      string `json:"last_error" gorm:"type:varchar(512);default:''"`
+	CreatedTime    int64  `json:"created_time" gorm:"bigint"`
+}
+
+func (schedule *ExportSchedule) Validate() error {
+	if !IsExportTable(schedule.Table) {
+		return fmt.Errorf("不支持导出的数据：%s", schedule.Table)
+	}
+	if schedule.Format == "" {
+		schedule.Format = ExportFormatCSV
+	}
+	if schedule.Format != ExportFormatCSV && schedule.Format != ExportFormatJSONL {
+		return errors.New("不支持的导出格式")
+	}
+	if schedule.Period == "" {
+		schedule.Period = ExportPeriodDay
+	}
+	if schedule.Period != ExportPeriodDay && schedule.Period != ExportPeriodWeek && schedule.Period != ExportPeriodMonth {
+		return errors.New("导出周期只能是 day、week 或 month")
+	}
+	return nil
+}
+
+// Query returns the filters of the schedule for the period from start until before end
+func (schedule *ExportSchedule) Query(start int64, end int64) *ExportQuery {
+	return &ExportQuery{
+		UserId:         schedule.UserId,
+		ModelName:      schedule.ModelName,
+		TokenName:      schedule.TokenName,
+		Channel:        schedule.Channel,
+		StartTimestamp: start,
+		EndTimestamp:   end - 1,
+	}
+}
+
+// PeriodStart returns the start of the period of the schedule containing t, in local time
+func (schedule *ExportSchedule) PeriodStart(t time.Time) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	switch schedule.Period {
+	case ExportPeriodWeek:
+		// weeks start on Monday
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case ExportPeriodMonth:
+		return day.AddDate(0, 0, 1-day.Day())
+	}
+	return day
+}
+
+// NextPeriodStart returns the start of the period n periods after the one starting at start
+func (schedule *ExportSchedule) NextPeriodStart(start time.Time, n int) time.Time {
+	switch schedule.Period {
+	case ExportPeriodWeek:
+		return start.AddDate(0, 0, 7*n)
+	case ExportPeriodMonth:
+		return start.AddDate(0, n, 0)
+	}
+	return start.AddDate(0, 0, n)
+}
+
+func GetAllExportSchedules() ([]*ExportSchedule, error) {
+	var schedules []*ExportSchedule
+	err := DB.Order("id asc").Find(&schedules).Error
+	return schedules, err
+}
+
+func GetEnabledExportSchedules() ([]*ExportSchedule, error) {
+	var schedules []*ExportSchedule
+	err := DB.Where("status = ?", ExportScheduleStatusEnabled).Order("id asc").Find(&schedules).Error
+	return schedules, err
+}
+
+func GetExportScheduleById(id int) (*ExportSchedule, error) {
+	schedule := ExportSchedule{}
+	err := DB.First(&schedule, "id = ?", id).Error
+	return &schedule, err
+}
+
+func (schedule *ExportSchedule) Insert() error {
+	schedule.CreatedTime = helper.GetTimestamp()
+	return DB.Create(schedule).Error
+}
+
+// Update saves the settings of the schedule, the state of its last run is kept
+func (schedule *ExportSchedule) Update() error {
+	return DB.Model(schedule).Select("*").Omit("id", "created_time", "last_export_time", "last_file", "last_error").Updates(schedule).Error
+}
+
+// UpdateRun saves the state of the last run of the schedule
+func (schedule *ExportSchedule) UpdateRun() error {
+	return DB.Model(schedule).Select("last_export_time", "last_file", "last_error").Updates(schedule).Error
+}
+
+func DeleteExportScheduleById(id int) error {
+	return DB.Delete(&ExportSchedule{}, "id = ?", id).Error
+}
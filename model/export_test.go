@@ -0,0 +1,44 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExportRecord(t *testing.T) {
+	columns := exportColumns(reflect.TypeOf(QuotaRecord{}))
+	record := exportRecord(reflect.ValueOf(QuotaRecord{Id: 1, UserId: 2, GrantId: "x", Quota: 500}))
+	if len(columns) != len(record) || columns[0] != "id" || columns[3] != "grant_id" || record[3] != "x" || record[7] != "500" {
+		t.Fatalf("unexpected columns %v and record %v", columns, record)
+	}
+}
+
+func TestExportSchedulePeriods(t *testing.T) {
+	// a Wednesday
+	now := time.Date(2025, 3, 12, 15, 4, 5, 0, time.Local)
+	cases := []struct {
+		period   string
+		start    time.Time
+		previous time.Time
+	}{
+		{ExportPeriodDay, time.Date(2025, 3, 12, 0, 0, 0, 0, time.Local), time.Date(2025, 3, 11, 0, 0, 0, 0, time.Local)},
+		{ExportPeriodWeek, time.Date(2025, 3, 10, 0, 0, 0, 0, time.Local), time.Date(2025, 3, 3, 0, 0, 0, 0, time.Local)},
+		{ExportPeriodMonth, time.Date(2025, 3, 1, 0, 0, 0, 0, time.Local), time.Date(2025, 2, 1, 0, 0, 0, 0, time.Local)},
+	}
+	for _, c := range cases {
+		schedule := &ExportSchedule{Period: c.period}
+		start := schedule.PeriodStart(now)
+		if !start.Equal(c.start) {
+			t.Errorf("%s starts at %v, want %v", c.period, start, c.start)
+		}
+		if previous := schedule.NextPeriodStart(start, -1); !previous.Equal(c.previous) {
+			t.Errorf("%s previous starts at %v, want %v", c.period, previous, c.previous)
+		}
+	}
+	// a week starting on Monday is found from its Sunday
+	sunday := time.Date(2025, 3, 16, 23, 0, 0, 0, time.Local)
+	if start := (&ExportSchedule{Period: ExportPeriodWeek}).PeriodStart(sunday); start.Day() != 10 {
+		t.Errorf("the week of Sunday starts on %v", start)
+	}
+}
@@ -22,9 +22,12 @@ type Log struct {
 	Quota            int    `json:"quota" gorm:"default:0"`
 	PromptTokens     int    `json:"prompt_tokens" gorm:"default:0"`
 	CachedTokens     int    `json:"cached_tokens" gorm:"default:0"`
+	CacheWriteTokens int    `json:"cache_write_tokens" gorm:"default:0"`
 	CompletionTokens int    `json:"completion_tokens" gorm:"default:0"`
 	ChannelId        int    `json:"channel" gorm:"index"`
 	Duration         int64  `json:"duration" gorm:"default:0"`
+	PriceId          int    `json:"price_id" gorm:"default:0"` // the version of the price catalog billed, 0 for the ratios
+	ChannelType      int    `json:"-" gorm:"-"`                // only for the usage aggregation, given by the relay
 }
 
 const (
@@ -109,7 +112,7 @@ func RecordTopupLog(userId int, content string, quota int) {
 	}
 }
 
-func RecordConsumeLog(ctx context.Context, userId int, channelId int, promptTokens int, cachedTokens int, completionTokens int, modelName string, tokenName string, quota int64, content string) {
+func RecordConsumeLog(ctx context.Context, userId int, channelId int, channelType int, promptTokens int, cachedTokens int, cacheWriteTokens int, completionTokens int, modelName string, tokenName string, quota int64, content string, priceId int) {
 	logger.Info(ctx, fmt.Sprintf("record consume log: userId=%d, channelId=%d, promptTokens=%d, completionTokens=%d, modelName=%s, tokenName=%s, quota=%d, content=%s", userId, channelId, promptTokens, completionTokens, modelName, tokenName, quota, content))
 	if !config.LogConsumeEnabled {
 		return
@@ -122,11 +125,14 @@ func RecordConsumeLog(ctx context.Context, userId int, channelId int, promptToke
 		Content:          content,
 		PromptTokens:     promptTokens,
 		CachedTokens:     cachedTokens,
+		CacheWriteTokens: cacheWriteTokens,
 		CompletionTokens: completionTokens,
 		TokenName:        tokenName,
 		ModelName:        modelName,
 		Quota:            int(quota),
 		ChannelId:        channelId,
+		PriceId:          priceId,
+		ChannelType:      channelType,
 	}
 	st := ctx.Value(helper.StartTimeKey)
 	if st != nil {
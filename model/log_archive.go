@@ -0,0 +1,280 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/archive"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/tidwall/gjson"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	ArchiveTableLogs       = "logs"
+	ArchiveTableFailedLogs = "failed_logs"
+)
+
+// archives are partitioned by UTC day, one gzip compressed JSONL object per table and day
+const archiveDay = 24 * time.Hour
+
+// ArchiveQuery filters the rows of an archived day, empty fields match everything
+type ArchiveQuery struct {
+	UserId    int
+	Username  string
+	ModelName string
+	TokenName string
+}
+
+func archiveKey(table string, day time.Time) string {
+	return fmt.Sprintf("%s/%s/%s.jsonl.gz", table, day.Format("2006-01"), day.Format("2006-01-02"))
+}
+
+// ParseArchiveDay parses a day formatted as 2006-01-02
+func ParseArchiveDay(day string) (time.Time, error) {
+	return time.ParseInLocation("2006-01-02", day, time.UTC)
+}
+
+func archiveTableModel(table string) (any, error) {
+	switch table {
+	case ArchiveTableLogs:
+		return &Log{}, nil
+	case ArchiveTableFailedLogs:
+		return &FailedLog{}, nil
+	}
+	return nil, fmt.Errorf("unknown archive table: %s", table)
+}
+
+// ArchiveLogs exports the rows of table created before the UTC day of before to the sink, one object
+// per day, and deletes them once the stored object is verified. It returns the number of deleted rows.
+func ArchiveLogs(ctx context.Context, sink archive.Sink, table string, before int64) (int64, error) {
+	tableModel, err := archiveTableModel(table)
+	if err != nil {
+		return 0, err
+	}
+	if !LOG_DB.Migrator().HasTable(tableModel) {
+		return 0, nil
+	}
+	cutoff := time.Unix(before, 0).UTC().Truncate(archiveDay)
+	var oldest int64
+	err = LOG_DB.Model(tableModel).Where("created_at < ?", cutoff.Unix()).Select("coalesce(min(created_at), 0)").Scan(&oldest).Error
+	if err != nil || oldest == 0 {
+		return 0, err
+	}
+	var total int64
+	for day := time.Unix(oldest, 0).UTC().Truncate(archiveDay); day.Before(cutoff); day = day.Add(archiveDay) {
+		var deleted int64
+		switch table {
+		case ArchiveTableLogs:
+			deleted, err = archiveLogsOfDay[Log](ctx, sink, table, day)
+		case ArchiveTableFailedLogs:
+			deleted, err = archiveLogsOfDay[FailedLog](ctx, sink, table, day)
+		}
+		if err != nil {
+			return total, fmt.Errorf("%s: %w", day.Format("2006-01-02"), err)
+		}
+		if deleted > 0 {
+			logger.SysLogf("archived %d rows of %s created on %s", deleted, table, day.Format("2006-01-02"))
+		}
+		total += deleted
+	}
+	return total, nil
+}
+
+func archiveLogsOfDay[T any](ctx context.Context, sink archive.Sink, table string, day time.Time) (int64, error) {
+	start, end := day.Unix(), day.Add(archiveDay).Unix()
+	var maxId int
+	err := LOG_DB.Model(new(T)).Where("created_at >= ? AND created_at < ?", start, end).Select("coalesce(max(id), 0)").Scan(&maxId).Error
+	if err != nil || maxId == 0 {
+		return 0, err
+	}
+	// rows inserted while archiving are left for the next run
+	rowsOfDay := func() *gorm.DB {
+		return LOG_DB.Model(new(T)).Where("created_at >= ? AND created_at < ? AND id <= ?", start, end, maxId)
+	}
+	var count int64
+	if err = rowsOfDay().Count(&count).Error; err != nil {
+		return 0, err
+	}
+
+	// the object is written to a temporary file, the rows of a day are never all held in memory
+	file, err := os.CreateTemp("", "log-archive-*.jsonl.gz")
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+	}()
+	writer := archive.NewJSONLWriter(file)
+
+	// the day may already be archived, by a run that failed to delete or before a late insert
+	key := archiveKey(table, day)
+	archivedIds := make(map[int64]bool)
+	existing, err := sink.Get(ctx, key)
+	if err == nil {
+		err = archive.ScanJSONL(existing, func(line json.RawMessage) error {
+			archivedIds[gjson.GetBytes(line, "id").Int()] = true
+			return writer.Write(line)
+		})
+		_ = existing.Close()
+		if err != nil {
+			return 0, fmt.Errorf("existing archive %s: %w", key, err)
+		}
+	} else if !errors.Is(err, archive.ErrNotExist) {
+		return 0, err
+	}
+
+	var exported int64
+	var rows []*T
+	err = rowsOfDay().Order("id asc").FindInBatches(&rows, 1000, func(tx *gorm.DB, batch int) error {
+		for _, row := range rows {
+			data, err := json.Marshal(row)
+			if err != nil {
+				return err
+			}
+			exported++
+			if !archivedIds[gjson.GetBytes(data, "id").Int()] {
+				if err = writer.Write(data); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return 0, err
+	}
+	if exported != count {
+		return 0, fmt.Errorf("exported %d rows but %d were counted", exported, count)
+	}
+
+	if err = writer.Close(); err != nil {
+		return 0, err
+	}
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if err = sink.Put(ctx, key, file, size); err != nil {
+		return 0, err
+	}
+	// read the object back, nothing is deleted unless every row made it to the sink
+	var stored int64
+	err = scanArchive(ctx, sink, key, func(line json.RawMessage) error {
+		stored++
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("stored archive %s: %w", key, err)
+	}
+	if stored != writer.Lines {
+		return 0, fmt.Errorf("stored archive %s has %d rows, %d expected", key, stored, writer.Lines)
+	}
+	result := rowsOfDay().Delete(new(T))
+	return result.RowsAffected, result.Error
+}
+
+func scanArchive(ctx context.Context, sink archive.Sink, key string, fn func(line json.RawMessage) error) error {
+	object, err := sink.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+	return archive.ScanJSONL(object, fn)
+}
+
+// scanArchivedLogs calls fn with every row of an archived day, the line is only valid until fn returns
+func scanArchivedLogs(ctx context.Context, sink archive.Sink, table string, day string, fn func(line json.RawMessage) error) error {
+	if _, err := archiveTableModel(table); err != nil {
+		return err
+	}
+	date, err := ParseArchiveDay(day)
+	if err != nil {
+		return err
+	}
+	return scanArchive(ctx, sink, archiveKey(table, date), fn)
+}
+
+// RestoreArchivedLogs inserts the rows of an archived day back into the table, keeping their ids.
+// Rows still present are skipped so that a day can be restored twice.
+func RestoreArchivedLogs(ctx context.Context, sink archive.Sink, table string, day string) (int64, error) {
+	if table == ArchiveTableFailedLogs {
+		return restoreArchivedRows[FailedLog](ctx, sink, table, day)
+	}
+	return restoreArchivedRows[Log](ctx, sink, table, day)
+}
+
+const restoreBatchSize = 100
+
+func restoreArchivedRows[T any](ctx context.Context, sink archive.Sink, table string, day string) (int64, error) {
+	var restored int64
+	rows := make([]*T, 0, restoreBatchSize)
+	insert := func() error {
+		if len(rows) == 0 {
+			return nil
+		}
+		result := LOG_DB.Clauses(clause.OnConflict{DoNothing: true}).Create(rows)
+		restored += result.RowsAffected
+		rows = rows[:0]
+		return result.Error
+	}
+	err := scanArchivedLogs(ctx, sink, table, day, func(line json.RawMessage) error {
+		row := new(T)
+		if err := json.Unmarshal(line, row); err != nil {
+			return err
+		}
+		rows = append(rows, row)
+		if len(rows) < restoreBatchSize {
+			return nil
+		}
+		return insert()
+	})
+	if err == nil {
+		err = insert()
+	}
+	return restored, err
+}
+
+// QueryArchivedLogs returns a page of the rows of an archived day matching the query, and the number of matches.
+// The day is scanned as a stream, only the rows of the page are kept.
+func QueryArchivedLogs(ctx context.Context, sink archive.Sink, table string, day string, query ArchiveQuery, startIdx int, num int) ([]json.RawMessage, int, error) {
+	modelField := "model_name"
+	if table == ArchiveTableFailedLogs {
+		modelField = "model"
+	}
+	page := make([]json.RawMessage, 0)
+	total := 0
+	err := scanArchivedLogs(ctx, sink, table, day, func(line json.RawMessage) error {
+		if query.UserId != 0 && gjson.GetBytes(line, "user_id").Int() != int64(query.UserId) {
+			return nil
+		}
+		if query.Username != "" && gjson.GetBytes(line, "username").String() != query.Username {
+			return nil
+		}
+		if query.ModelName != "" && gjson.GetBytes(line, modelField).String() != query.ModelName {
+			return nil
+		}
+		if query.TokenName != "" && gjson.GetBytes(line, "token_name").String() != query.TokenName {
+			return nil
+		}
+		if total >= startIdx && (num <= 0 || total < startIdx+num) {
+			page = append(page, append(json.RawMessage(nil), line...))
+		}
+		total++
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return page, total, nil
+}
@@ -0,0 +1,45 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/archive"
+)
+
+func TestQueryArchivedLogs(t *testing.T) {
+	ctx := context.Background()
+	sink := archive.NewLocalSink(t.TempDir())
+	var buf bytes.Buffer
+	writer := archive.NewJSONLWriter(&buf)
+	for i := 1; i <= 10; i++ {
+		if err := writer.Write([]byte(fmt.Sprintf(`{"id":%d,"user_id":%d}`, i, i%2))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	if err := sink.Put(ctx, archiveKey(ArchiveTableLogs, day), &buf, int64(buf.Len())); err != nil {
+		t.Fatal(err)
+	}
+
+	page, total, err := QueryArchivedLogs(ctx, sink, ArchiveTableLogs, "2025-03-01", ArchiveQuery{UserId: 1}, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 5 || len(page) != 2 || string(page[0]) != `{"id":3,"user_id":1}` || string(page[1]) != `{"id":5,"user_id":1}` {
+		t.Fatalf("unexpected page %q of %d rows", page, total)
+	}
+	page, total, err = QueryArchivedLogs(ctx, sink, ArchiveTableLogs, "2025-03-01", ArchiveQuery{}, 8, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 10 || len(page) != 2 {
+		t.Fatalf("unexpected page %q of %d rows", page, total)
+	}
+}
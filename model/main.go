@@ -195,6 +195,82 @@ func InitDB(envName string) (db *gorm.DB, err error) {
 		if err != nil {
 			return nil, err
 		}
+		err = db.AutoMigrate(&ShadowConfig{})
+		if err != nil {
+			return nil, err
+		}
+		err = db.AutoMigrate(&ShadowRecord{})
+		if err != nil {
+			return nil, err
+		}
+		err = db.AutoMigrate(&RoutingRule{})
+		if err != nil {
+			return nil, err
+		}
+		err = db.AutoMigrate(&VirtualModel{})
+		if err != nil {
+			return nil, err
+		}
+		err = db.AutoMigrate(&ChannelKey{})
+		if err != nil {
+			return nil, err
+		}
+		err = db.AutoMigrate(&Role{})
+		if err != nil {
+			return nil, err
+		}
+		err = db.AutoMigrate(&UserRole{})
+		if err != nil {
+			return nil, err
+		}
+		err = db.AutoMigrate(&ManagementKey{})
+		if err != nil {
+			return nil, err
+		}
+		err = db.AutoMigrate(&AuditLog{})
+		if err != nil {
+			return nil, err
+		}
+		err = db.AutoMigrate(&ScimGroup{})
+		if err != nil {
+			return nil, err
+		}
+		err = db.AutoMigrate(&ScimGroupMember{})
+		if err != nil {
+			return nil, err
+		}
+		err = db.AutoMigrate(&TwoFactor{})
+		if err != nil {
+			return nil, err
+		}
+		err = db.AutoMigrate(&TokenModelUsage{})
+		if err != nil {
+			return nil, err
+		}
+		err = db.AutoMigrate(&ModelPrice{})
+		if err != nil {
+			return nil, err
+		}
+		err = db.AutoMigrate(&Task{})
+		if err != nil {
+			return nil, err
+		}
+		err = db.AutoMigrate(&ExportSchedule{})
+		if err != nil {
+			return nil, err
+		}
+		err = db.AutoMigrate(&TokenIp{})
+		if err != nil {
+			return nil, err
+		}
+		err = db.AutoMigrate(&AnomalyEvent{})
+		if err != nil {
+			return nil, err
+		}
+		err = db.AutoMigrate(&ChannelBalanceRecord{})
+		if err != nil {
+			return nil, err
+		}
 		logger.SysLog("database migrated")
 		return db, err
 	} else {
@@ -0,0 +1,197 @@
+package model
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/network"
+	"github.com/songquanpeng/one-api/common/random"
+)
+
+const ManagementKeyPrefix = "mk-"
+
+const (
+	ManagementKeyStatusEnabled = 1
+	ManagementKeyStatusRevoked = 2
+)
+
+// scopes only meaningful for management keys, a key may also hold any of AllScopes
+// that its user has
+const (
+	KeyScopeReadOnly    = "read-only" // GET requests of the routes open to every user, the read scopes are granted explicitly
+	KeyScopeTokensRead  = "tokens:read"
+	KeyScopeTokensWrite = "tokens:write"
+)
+
+// the last used time is written at most once per interval, unit is second
+const managementKeyLastUsedInterval = 60
+
+// ManagementKey is a named credential for the management API with a subset of the powers of
+// its user. Only the hash of the key is stored, the key itself is shown once at creation.
+type ManagementKey struct {
+	Id           int    `json:"id"`
+	UserId       int    `json:"user_id" gorm:"index"`
+	Name         string `json:"name" gorm:"type:varchar(64)"`
+	KeyHash      string `json:"-" gorm:"type:char(64);uniqueIndex"`
+	KeyPrefix    string `json:"key_prefix" gorm:"type:varchar(16)"`
+	Scopes       string `json:"scopes" gorm:"type:varchar(1024)"`               // comma separated
+	AllowIps     string `json:"allow_ips" gorm:"type:varchar(1024);default:''"` // comma separated IPs or subnets, empty means any
+	Status       int    `json:"status" gorm:"default:1"`
+	CreatedTime  int64  `json:"created_time" gorm:"bigint"`
+	ExpiredTime  int64  `json:"expired_time" gorm:"bigint;default:-1"` // -1 means never expired
+	LastUsedTime int64  `json:"last_used_time" gorm:"bigint"`
+	LastUsedIp   string `json:"last_used_ip" gorm:"type:varchar(64);default:''"`
+}
+
+func HashManagementKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func IsManagementKey(key string) bool {
+	return strings.HasPrefix(key, ManagementKeyPrefix)
+}
+
+func (key *ManagementKey) GetScopes() []string {
+	return splitRuleList(key.Scopes)
+}
+
+// normalizeAllowIps turns single IPs into subnets so that network.IsIpInSubnets accepts them
+func normalizeAllowIps(allowIps string) (string, error) {
+	items := splitRuleList(allowIps)
+	for i, item := range items {
+		if strings.Contains(item, "/") {
+			if _, _, err := net.ParseCIDR(item); err != nil {
+				return "", fmt.Errorf("无效的 IP 或网段：%s", item)
+			}
+			continue
+		}
+		ip := net.ParseIP(item)
+		if ip == nil {
+			return "", fmt.Errorf("无效的 IP 或网段：%s", item)
+		}
+		if ip.To4() != nil {
+			items[i] = item + "/32"
+		} else {
+			items[i] = item + "/128"
+		}
+	}
+	return strings.Join(items, ","), nil
+}
+
+// Validate checks the key before creation, admin scopes can only be given to a key of a user holding them
+func (key *ManagementKey) Validate(permissions *UserPermissions) error {
+	if key.Name == "" {
+		return errors.New("名称不能为空")
+	}
+	scopes := key.GetScopes()
+	if len(scopes) == 0 {
+		return errors.New("至少需要一个权限")
+	}
+	for _, scope := range scopes {
+		switch scope {
+		case KeyScopeReadOnly, KeyScopeTokensRead, KeyScopeTokensWrite, ScopeLogsRead:
+			continue
+		}
+		if !isInRuleList(AllScopes, scope) {
+			return fmt.Errorf("未知的权限：%s", scope)
+		}
+		if !isInRuleList(permissions.Scopes, scope) {
+			return fmt.Errorf("当前用户没有权限：%s", scope)
+		}
+	}
+	if key.ExpiredTime != -1 && key.ExpiredTime < helper.GetTimestamp() {
+		return errors.New("过期时间不能早于当前时间")
+	}
+	allowIps, err := normalizeAllowIps(key.AllowIps)
+	if err != nil {
+		return err
+	}
+	key.AllowIps = allowIps
+	return nil
+}
+
+// Insert generates the key, stores its hash and returns the key
+func (key *ManagementKey) Insert() (string, error) {
+	plaintext := ManagementKeyPrefix + random.GenerateKey()
+	key.KeyHash = HashManagementKey(plaintext)
+	key.KeyPrefix = plaintext[:len(ManagementKeyPrefix)+6]
+	key.Status = ManagementKeyStatusEnabled
+	key.CreatedTime = helper.GetTimestamp()
+	err := DB.Create(key).Error
+	return plaintext, err
+}
+
+func GetUserManagementKeys(userId int) ([]*ManagementKey, error) {
+	var keys []*ManagementKey
+	err := DB.Where("user_id = ?", userId).Order("id desc").Find(&keys).Error
+	return keys, err
+}
+
+func RevokeManagementKey(id int, userId int) error {
+	result := DB.Model(&ManagementKey{}).Where("id = ? AND user_id = ?", id, userId).Update("status", ManagementKeyStatusRevoked)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("密钥不存在")
+	}
+	return nil
+}
+
+// ValidateManagementKey returns the enabled, unexpired key usable from ip, and tracks its use
+func ValidateManagementKey(ctx context.Context, plaintext string, ip string) (*ManagementKey, error) {
+	key := &ManagementKey{}
+	err := DB.Where("key_hash = ?", HashManagementKey(plaintext)).First(key).Error
+	if err != nil {
+		return nil, errors.New("管理密钥无效")
+	}
+	if key.Status != ManagementKeyStatusEnabled {
+		return nil, errors.New("管理密钥已被撤销")
+	}
+	now := helper.GetTimestamp()
+	if key.ExpiredTime != -1 && key.ExpiredTime < now {
+		return nil, errors.New("管理密钥已过期")
+	}
+	if key.AllowIps != "" && !network.IsIpInSubnets(ctx, ip, key.AllowIps) {
+		return nil, fmt.Errorf("管理密钥不允许从 %s 使用", ip)
+	}
+	if now-key.LastUsedTime >= managementKeyLastUsedInterval || key.LastUsedIp != ip {
+		err = DB.Model(&ManagementKey{}).Where("id = ?", key.Id).Updates(map[string]any{
+			"last_used_time": now,
+			"last_used_ip":   ip,
+		}).Error
+		if err != nil {
+			logger.SysError("failed to update management key last used time: " + err.Error())
+		}
+	}
+	return key, nil
+}
+
+// Allows tells whether the key grants a request of method to a route requiring scope,
+// an empty scope being a route open to every user
+func (key *ManagementKey) Allows(method string, scope string) bool {
+	scopes := key.GetScopes()
+	if scope != "" && isInRuleList(scopes, scope) {
+		return true
+	}
+	if scope == KeyScopeTokensRead && isInRuleList(scopes, KeyScopeTokensWrite) {
+		return true
+	}
+	for write, read := range impliedScopes {
+		if scope == read && isInRuleList(scopes, write) {
+			return true
+		}
+	}
+	if method != "GET" && method != "HEAD" {
+		return false
+	}
+	return scope == "" && isInRuleList(scopes, KeyScopeReadOnly)
+}
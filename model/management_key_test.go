@@ -0,0 +1,42 @@
+package model
+
+import "testing"
+
+func TestManagementKeyAllows(t *testing.T) {
+	key := &ManagementKey{Scopes: "read-only,tokens:write"}
+	cases := []struct {
+		method string
+		scope  string
+		want   bool
+	}{
+		{"GET", "", true},
+		{"PUT", "", false},
+		{"POST", KeyScopeTokensWrite, true},
+		{"GET", KeyScopeTokensRead, true},
+		{"GET", ScopeLogsRead, false},
+		{"GET", ScopeUsersRead, false},
+		{"GET", ScopeChannelsWrite, false},
+		{"DELETE", ScopeChannelsWrite, false},
+	}
+	for _, c := range cases {
+		if got := key.Allows(c.method, c.scope); got != c.want {
+			t.Errorf("Allows(%s, %q) = %v, want %v", c.method, c.scope, got, c.want)
+		}
+	}
+	if !(&ManagementKey{Scopes: "logs:read"}).Allows("GET", ScopeLogsRead) {
+		t.Error("expected a logs:read key to be granted the log routes")
+	}
+	if (&ManagementKey{Scopes: "logs:read"}).Allows("GET", KeyScopeTokensRead) {
+		t.Error("expected a logs:read key to be denied the token routes")
+	}
+}
+
+func TestNormalizeAllowIps(t *testing.T) {
+	allowIps, err := normalizeAllowIps("10.0.0.1, 192.168.0.0/16,::1")
+	if err != nil || allowIps != "10.0.0.1/32,192.168.0.0/16,::1/128" {
+		t.Fatalf("unexpected %q, %v", allowIps, err)
+	}
+	if _, err = normalizeAllowIps("10.0.0.300"); err == nil {
+		t.Fatal("expected an invalid ip to be rejected")
+	}
+}
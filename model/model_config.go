@@ -15,6 +15,7 @@ type ModelConfig struct {
 	ModelName       string  `json:"model_name"`
 	ModelRatio      float64 `json:"model_ratio"`
 	CacheRatio      float64 `json:"cache_ratio"`
+	CacheWriteRatio float64 `json:"cache_write_ratio" gorm:"default:0"` // 0 keeps the default of the model
 	CompletionRatio float64 `json:"completion_ratio"`
 	Desc            string  `json:"desc"`
 	DescEn          string  `json:"desc_en"`
@@ -81,7 +82,7 @@ func RefreshModelConfigCache(ctx context.Context) {
 		return
 	}
 	for _, model := range models {
-		ratio.RefreshModelConfigCache(ctx, model.Model, model.ModelRatio, model.CacheRatio, model.CompletionRatio)
+		ratio.RefreshModelConfigCache(ctx, model.Model, model.ModelRatio, model.CacheRatio, model.CacheWriteRatio, model.CompletionRatio)
 	}
 }
 
@@ -188,7 +189,7 @@ func SaveModelConfig(ctx context.Context, modelConfig *ModelConfig) error {
 	if err != nil {
 		return err
 	}
-	ratio.RefreshModelConfigCache(ctx, modelConfig.Model, modelConfig.ModelRatio, modelConfig.CacheRatio, modelConfig.CompletionRatio)
+	ratio.RefreshModelConfigCache(ctx, modelConfig.Model, modelConfig.ModelRatio, modelConfig.CacheRatio, modelConfig.CacheWriteRatio, modelConfig.CompletionRatio)
 	return nil
 }
 
@@ -197,7 +198,7 @@ func DeleteModelConfig(ctx context.Context, model string) error {
 	if err != nil {
 		return err
 	}
-	ratio.RefreshModelConfigCache(ctx, model, -1, -1, -1)
+	ratio.RefreshModelConfigCache(ctx, model, -1, -1, -1, -1)
 	return err
 }
 
@@ -2,6 +2,7 @@ package model
 
 import (
 	"context"
+	"encoding/json"
 	"strconv"
 	"strings"
 	"time"
@@ -34,6 +35,7 @@ func InitOptionMap() {
 	config.OptionMap["WeChatAuthEnabled"] = strconv.FormatBool(config.WeChatAuthEnabled)
 	config.OptionMap["TurnstileCheckEnabled"] = strconv.FormatBool(config.TurnstileCheckEnabled)
 	config.OptionMap["RegisterEnabled"] = strconv.FormatBool(config.RegisterEnabled)
+	config.OptionMap["AdminTwoFactorRequired"] = strconv.FormatBool(config.AdminTwoFactorRequired)
 	config.OptionMap["AutomaticDisableChannelEnabled"] = strconv.FormatBool(config.AutomaticDisableChannelEnabled)
 	config.OptionMap["AutomaticEnableChannelEnabled"] = strconv.FormatBool(config.AutomaticEnableChannelEnabled)
 	config.OptionMap["ApproximateTokenEnabled"] = strconv.FormatBool(config.ApproximateTokenEnabled)
@@ -67,11 +69,25 @@ func InitOptionMap() {
 	config.OptionMap["MessagePusherToken"] = ""
 	config.OptionMap["TurnstileSiteKey"] = ""
 	config.OptionMap["TurnstileSecretKey"] = ""
+	config.OptionMap["OidcScopes"] = config.OidcScopes
+	config.OptionMap["OidcClaim"] = ""
+	config.OptionMap["OidcGroupMapping"] = "{}"
+	config.OptionMap["OidcRoleMapping"] = "{}"
+	config.OptionMap["ScimToken"] = ""
+	config.OptionMap["ScimGroupMapping"] = "{}"
 	config.OptionMap["QuotaForNewUser"] = strconv.FormatInt(config.QuotaForNewUser, 10)
 	config.OptionMap["QuotaForInviter"] = strconv.FormatInt(config.QuotaForInviter, 10)
 	config.OptionMap["QuotaForInvitee"] = strconv.FormatInt(config.QuotaForInvitee, 10)
 	config.OptionMap["QuotaRemindThreshold"] = strconv.FormatInt(config.QuotaRemindThreshold, 10)
 	config.OptionMap["PreConsumedQuota"] = strconv.FormatInt(config.PreConsumedQuota, 10)
+	config.OptionMap["AnomalyDetectionEnabled"] = strconv.FormatBool(config.AnomalyDetectionEnabled)
+	config.OptionMap["AnomalySpikeFactor"] = strconv.FormatFloat(config.AnomalySpikeFactor, 'f', -1, 64)
+	config.OptionMap["AnomalySpikeMinQuota"] = strconv.FormatInt(config.AnomalySpikeMinQuota, 10)
+	config.OptionMap["AnomalyTokenHourlyQuotaLimit"] = strconv.FormatInt(config.AnomalyTokenHourlyQuotaLimit, 10)
+	config.OptionMap["AnomalyUserHourlyQuotaLimit"] = strconv.FormatInt(config.AnomalyUserHourlyQuotaLimit, 10)
+	config.OptionMap["AnomalyPolicy"] = "{}"
+	config.OptionMap["ChannelReconcileAlertRatio"] = strconv.FormatFloat(config.ChannelReconcileAlertRatio, 'f', -1, 64)
+	config.OptionMap["ChannelReconcileAlertMinUSD"] = strconv.FormatFloat(config.ChannelReconcileAlertMinUSD, 'f', -1, 64)
 	config.OptionMap["ModelRatio"] = billingratio.ModelRatio2JSONString()
 	config.OptionMap["GroupRatio"] = billingratio.GroupRatio2JSONString()
 	config.OptionMap["CompletionRatio"] = billingratio.CompletionRatio2JSONString()
@@ -159,6 +175,8 @@ func updateOptionMap(key string, value string) (err error) {
 			config.DisplayInCurrencyEnabled = boolValue
 		case "DisplayTokenStatEnabled":
 			config.DisplayTokenStatEnabled = boolValue
+		case "AnomalyDetectionEnabled":
+			config.AnomalyDetectionEnabled = boolValue
 		}
 	}
 	switch key {
@@ -201,6 +219,14 @@ func updateOptionMap(key string, value string) (err error) {
 		config.OidcTokenEndpoint = value
 	case "OidcUserinfoEndpoint":
 		config.OidcUserinfoEndpoint = value
+	case "OidcScopes":
+		config.OidcScopes = value
+	case "OidcClaim":
+		config.OidcClaim = value
+	case "OidcGroupMapping":
+		config.OidcGroupMapping, err = parseStringMapping(value)
+	case "OidcRoleMapping":
+		config.OidcRoleMapping, err = parseStringMapping(value)
 	case "Footer":
 		config.Footer = value
 	case "SystemName":
@@ -221,6 +247,12 @@ func updateOptionMap(key string, value string) (err error) {
 		config.TurnstileSiteKey = value
 	case "TurnstileSecretKey":
 		config.TurnstileSecretKey = value
+	case "AdminTwoFactorRequired":
+		config.AdminTwoFactorRequired = value == "true"
+	case "ScimToken":
+		config.ScimToken = value
+	case "ScimGroupMapping":
+		config.ScimGroupMapping, err = parseStringMapping(value)
 	case "QuotaForNewUser":
 		config.QuotaForNewUser, _ = strconv.ParseInt(value, 10, 64)
 	case "QuotaForInviter":
@@ -231,6 +263,20 @@ func updateOptionMap(key string, value string) (err error) {
 		config.QuotaRemindThreshold, _ = strconv.ParseInt(value, 10, 64)
 	case "PreConsumedQuota":
 		config.PreConsumedQuota, _ = strconv.ParseInt(value, 10, 64)
+	case "AnomalySpikeFactor":
+		config.AnomalySpikeFactor, _ = strconv.ParseFloat(value, 64)
+	case "AnomalySpikeMinQuota":
+		config.AnomalySpikeMinQuota, _ = strconv.ParseInt(value, 10, 64)
+	case "AnomalyTokenHourlyQuotaLimit":
+		config.AnomalyTokenHourlyQuotaLimit, _ = strconv.ParseInt(value, 10, 64)
+	case "AnomalyUserHourlyQuotaLimit":
+		config.AnomalyUserHourlyQuotaLimit, _ = strconv.ParseInt(value, 10, 64)
+	case "ChannelReconcileAlertRatio":
+		config.ChannelReconcileAlertRatio, _ = strconv.ParseFloat(value, 64)
+	case "ChannelReconcileAlertMinUSD":
+		config.ChannelReconcileAlertMinUSD, _ = strconv.ParseFloat(value, 64)
+	case "AnomalyPolicy":
+		config.AnomalyPolicy, err = parseStringMapping(value)
 	case "RetryTimes":
 		config.RetryTimes, _ = strconv.Atoi(value)
 	case "ModelRatio":
@@ -263,3 +309,13 @@ func updateOptionMap(key string, value string) (err error) {
 	}
 	return err
 }
+
+// parseStringMapping parses the JSON object of the mapping options, an empty value being an empty mapping
+func parseStringMapping(value string) (map[string]string, error) {
+	mapping := make(map[string]string)
+	if value == "" {
+		return mapping, nil
+	}
+	err := json.Unmarshal([]byte(value), &mapping)
+	return mapping, err
+}
@@ -0,0 +1,185 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay/billing/ratio"
+)
+
+// ModelPrice is a version of the price of a model in the price catalog. Prices are in USD, per 1M
+// tokens or per image. A version is kept once it takes effect, so that the logs keep pointing at the
+// price they were billed at: a new price is added as a new version, and the version in effect is
+// closed by its effective_to.
+type ModelPrice struct {
+	Id               int     `json:"id"`
+	Model            string  `json:"model" gorm:"type:varchar(128);index"`
+	ChannelType      int     `json:"channel_type" gorm:"default:0"`        // 0 means any channel type
+	EffectiveFrom    int64   `json:"effective_from" gorm:"bigint;index"`   // unix timestamp
+	EffectiveTo      int64   `json:"effective_to" gorm:"bigint;default:0"` // unix timestamp, 0 means open ended
+	Tiers            string  `json:"tiers" gorm:"type:text"`               // JSON array of ratio.PriceTier
+	AudioInputPrice  float64 `json:"audio_input_price" gorm:"default:0"`   // per 1M tokens
+	AudioOutputPrice float64 `json:"audio_output_price" gorm:"default:0"`  // per 1M tokens
+	ImagePrice       float64 `json:"image_price" gorm:"default:0"`         // per image
+	AudioMinutePrice float64 `json:"audio_minute_price" gorm:"default:0"`  // per minute of input audio
+	CharacterPrice   float64 `json:"character_price" gorm:"default:0"`     // per 1M characters of input text
+	Remark           string  `json:"remark" gorm:"type:varchar(255);default:''"`
+	CreatedTime      int64   `json:"created_time" gorm:"bigint"`
+}
+
+func (price *ModelPrice) parseTiers() ([]ratio.PriceTier, error) {
+	tiers := make([]ratio.PriceTier, 0)
+	if strings.TrimSpace(price.Tiers) == "" {
+		return tiers, nil
+	}
+	err := json.Unmarshal([]byte(price.Tiers), &tiers)
+	return tiers, err
+}
+
+func (price *ModelPrice) Validate() error {
+	price.Model = strings.TrimSpace(price.Model)
+	if price.Model == "" {
+		return errors.New("模型不能为空")
+	}
+	if price.EffectiveTo != 0 && price.EffectiveTo <= price.EffectiveFrom {
+		return errors.New("失效时间必须晚于生效时间")
+	}
+	tiers, err := price.parseTiers()
+	if err != nil {
+		return fmt.Errorf("无效的阶梯价格：%s", err.Error())
+	}
+	if len(tiers) == 0 && price.ImagePrice <= 0 && price.AudioMinutePrice <= 0 && price.CharacterPrice <= 0 {
+		return errors.New("至少需要一个阶梯价格、图片价格、每分钟音频价格或字符价格")
+	}
+	seen := make(map[int]bool)
+	hasBaseTier := false
+	for _, tier := range tiers {
+		if tier.MinPromptTokens < 0 || tier.Input < 0 || tier.Output < 0 || tier.CacheRead < 0 || tier.CacheWrite < 0 {
+			return errors.New("价格不能为负数")
+		}
+		if seen[tier.MinPromptTokens] {
+			return fmt.Errorf("重复的阶梯：%d", tier.MinPromptTokens)
+		}
+		seen[tier.MinPromptTokens] = true
+		hasBaseTier = hasBaseTier || tier.MinPromptTokens == 0
+	}
+	if len(tiers) > 0 && !hasBaseTier {
+		return errors.New("阶梯价格必须包含从 0 开始的阶梯")
+	}
+	if price.AudioInputPrice < 0 || price.AudioOutputPrice < 0 || price.ImagePrice < 0 ||
+		price.AudioMinutePrice < 0 || price.CharacterPrice < 0 {
+		return errors.New("价格不能为负数")
+	}
+	return nil
+}
+
+func (price *ModelPrice) toRatioPrice() (*ratio.Price, error) {
+	tiers, err := price.parseTiers()
+	if err != nil {
+		return nil, err
+	}
+	return &ratio.Price{
+		Id:            price.Id,
+		Model:         price.Model,
+		ChannelType:   price.ChannelType,
+		EffectiveFrom: price.EffectiveFrom,
+		EffectiveTo:   price.EffectiveTo,
+		Tiers:         tiers,
+		AudioInput:    price.AudioInputPrice,
+		AudioOutput:   price.AudioOutputPrice,
+		Image:         price.ImagePrice,
+		AudioMinute:   price.AudioMinutePrice,
+		Character:     price.CharacterPrice,
+	}, nil
+}
+
+func GetModelPrices(modelName string) ([]*ModelPrice, error) {
+	var prices []*ModelPrice
+	tx := DB.Order("model asc, effective_from desc, id desc")
+	if modelName != "" {
+		tx = tx.Where("model = ?", modelName)
+	}
+	err := tx.Find(&prices).Error
+	return prices, err
+}
+
+func GetModelPriceById(id int) (*ModelPrice, error) {
+	price := ModelPrice{}
+	err := DB.First(&price, "id = ?", id).Error
+	return &price, err
+}
+
+// Insert adds a version, which cannot take effect in the past as requests were already billed
+func (price *ModelPrice) Insert() error {
+	price.Id = 0
+	price.CreatedTime = helper.GetTimestamp()
+	if price.EffectiveFrom < price.CreatedTime {
+		price.EffectiveFrom = price.CreatedTime
+	}
+	if price.EffectiveTo != 0 && price.EffectiveTo <= price.EffectiveFrom {
+		return errors.New("失效时间必须晚于生效时间")
+	}
+	return DB.Create(price).Error
+}
+
+// Update changes a version not yet in effect. The version in effect can only be closed, by
+// moving its effective_to, so that it keeps describing what the past requests were billed.
+func (price *ModelPrice) Update() error {
+	old, err := GetModelPriceById(price.Id)
+	if err != nil {
+		return err
+	}
+	now := helper.GetTimestamp()
+	if old.EffectiveFrom > now {
+		if price.EffectiveFrom < now {
+			return errors.New("生效时间不能早于当前时间")
+		}
+		return DB.Model(price).Select("*").Omit("id", "created_time").Updates(price).Error
+	}
+	if price.EffectiveTo != 0 && price.EffectiveTo < now {
+		return errors.New("失效时间不能早于当前时间")
+	}
+	if old.EffectiveTo != 0 && old.EffectiveTo <= now {
+		return errors.New("已失效的价格版本不能修改")
+	}
+	old.EffectiveTo = price.EffectiveTo
+	old.Remark = price.Remark
+	*price = *old
+	return DB.Model(price).Select("effective_to", "remark").Updates(price).Error
+}
+
+// DeleteModelPriceById deletes a version not yet in effect
+func DeleteModelPriceById(id int) error {
+	price, err := GetModelPriceById(id)
+	if err != nil {
+		return err
+	}
+	if price.EffectiveFrom <= helper.GetTimestamp() {
+		return errors.New("已生效的价格版本不能删除，请设置失效时间")
+	}
+	return DB.Delete(&ModelPrice{}, "id = ?", id).Error
+}
+
+func InitPriceCatalogCache() {
+	var prices []*ModelPrice
+	// the versions that ended are only kept for the logs
+	err := DB.Where("effective_to = 0 OR effective_to > ?", helper.GetTimestamp()).Find(&prices).Error
+	if err != nil {
+		logger.SysError("failed to load price catalog: " + err.Error())
+		return
+	}
+	catalog := make([]*ratio.Price, 0, len(prices))
+	for _, price := range prices {
+		ratioPrice, err := price.toRatioPrice()
+		if err != nil {
+			logger.SysError(fmt.Sprintf("invalid price %d of model %s: %s", price.Id, price.Model, err.Error()))
+			continue
+		}
+		catalog = append(catalog, ratioPrice)
+	}
+	ratio.SetPriceCatalog(catalog)
+}
@@ -0,0 +1,253 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"gorm.io/gorm"
+)
+
+// permission scopes of the management API, read scopes are implied by the matching write scope
+const (
+	ScopeChannelsRead      = "channels:read"
+	ScopeChannelsWrite     = "channels:write"
+	ScopeUsersRead         = "users:read"
+	ScopeUsersManage       = "users:manage"
+	ScopeLogsRead          = "logs:read"
+	ScopeLogsWrite         = "logs:write"
+	ScopeRedemptionsRead   = "redemptions:read"
+	ScopeRedemptionsCreate = "redemptions:create"
+	ScopeRedemptionsWrite  = "redemptions:write"
+	ScopeOptionsRead       = "options:read"
+	ScopeOptionsWrite      = "options:write"
+	ScopeRoutingRead       = "routing:read"
+	ScopeRoutingWrite      = "routing:write"
+	ScopeOrdersWrite       = "orders:write"
+	ScopeStatusRead        = "status:read"
+	ScopeRolesManage       = "roles:manage"
+	ScopeAuditRead         = "audit:read"
+)
+
+var AllScopes = []string{
+	ScopeChannelsRead, ScopeChannelsWrite,
+	ScopeUsersRead, ScopeUsersManage,
+	ScopeLogsRead, ScopeLogsWrite,
+	ScopeRedemptionsRead, ScopeRedemptionsCreate, ScopeRedemptionsWrite,
+	ScopeOptionsRead, ScopeOptionsWrite,
+	ScopeRoutingRead, ScopeRoutingWrite,
+	ScopeOrdersWrite,
+	ScopeStatusRead,
+	ScopeRolesManage,
+	ScopeAuditRead,
+}
+
+// the read scope granted along with a write scope
+var impliedScopes = map[string]string{
+	ScopeChannelsWrite:     ScopeChannelsRead,
+	ScopeUsersManage:       ScopeUsersRead,
+	ScopeLogsWrite:         ScopeLogsRead,
+	ScopeRedemptionsCreate: ScopeRedemptionsRead,
+	ScopeRedemptionsWrite:  ScopeRedemptionsRead,
+	ScopeOptionsWrite:      ScopeOptionsRead,
+	ScopeRoutingWrite:      ScopeRoutingRead,
+}
+
+// Role is a named set of permission scopes. Roles are granted on top of User.Role:
+// root users have every scope, admin users every scope but roles:manage, and common
+// users only the scopes of the roles assigned to them.
+type Role struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name" gorm:"type:varchar(64);uniqueIndex"`
+	Description string `json:"description" gorm:"type:varchar(512);default:''"`
+	Scopes      string `json:"scopes" gorm:"type:varchar(1024)"` // comma separated
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+}
+
+type UserRole struct {
+	Id     int `json:"id"`
+	UserId int `json:"user_id" gorm:"uniqueIndex:idx_user_role"`
+	RoleId int `json:"role_id" gorm:"uniqueIndex:idx_user_role;index"`
+}
+
+// UserPermissions is what a user is effectively allowed to do
+type UserPermissions struct {
+	UserId int      `json:"user_id"`
+	Role   int      `json:"role"`
+	Roles  []*Role  `json:"roles"`
+	Scopes []string `json:"scopes"`
+}
+
+func (role *Role) GetScopes() []string {
+	return splitRuleList(role.Scopes)
+}
+
+func (role *Role) Validate() error {
+	if role.Name == "" {
+		return errors.New("角色名称不能为空")
+	}
+	for _, scope := range role.GetScopes() {
+		if !isInRuleList(AllScopes, scope) {
+			return fmt.Errorf("未知的权限：%s", scope)
+		}
+	}
+	return nil
+}
+
+func GetAllRoles() ([]*Role, error) {
+	var roles []*Role
+	err := DB.Order("id asc").Find(&roles).Error
+	return roles, err
+}
+
+func GetRoleById(id int) (*Role, error) {
+	role := Role{}
+	err := DB.First(&role, "id = ?", id).Error
+	return &role, err
+}
+
+func (role *Role) Insert() error {
+	role.CreatedTime = helper.GetTimestamp()
+	return DB.Create(role).Error
+}
+
+func (role *Role) Update() error {
+	return DB.Model(role).Select("name", "description", "scopes").Updates(role).Error
+}
+
+func DeleteRoleById(id int) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&UserRole{}, "role_id = ?", id).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Role{}, "id = ?", id).Error
+	})
+}
+
+func GetUserRoleIds(userId int) ([]int, error) {
+	var roleIds []int
+	err := DB.Model(&UserRole{}).Where("user_id = ?", userId).Pluck("role_id", &roleIds).Error
+	return roleIds, err
+}
+
+// SetUserRoles replaces the roles assigned to the user
+func SetUserRoles(userId int, roleIds []int) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&UserRole{}, "user_id = ?", userId).Error; err != nil {
+			return err
+		}
+		for _, roleId := range roleIds {
+			var count int64
+			if err := tx.Model(&Role{}).Where("id = ?", roleId).Count(&count).Error; err != nil {
+				return err
+			}
+			if count == 0 {
+				return fmt.Errorf("角色 #%d 不存在", roleId)
+			}
+			if err := tx.Create(&UserRole{UserId: userId, RoleId: roleId}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+var roleId2role map[int]*Role
+var userId2roleIds map[int][]int
+var roleSyncLock sync.RWMutex
+
+func InitRoleCache() {
+	var roles []*Role
+	err := DB.Find(&roles).Error
+	if err != nil {
+		logger.SysError("failed to load roles: " + err.Error())
+		return
+	}
+	var userRoles []*UserRole
+	err = DB.Find(&userRoles).Error
+	if err != nil {
+		logger.SysError("failed to load user roles: " + err.Error())
+		return
+	}
+	newRoleId2role := make(map[int]*Role, len(roles))
+	for _, role := range roles {
+		newRoleId2role[role.Id] = role
+	}
+	newUserId2roleIds := make(map[int][]int)
+	for _, userRole := range userRoles {
+		newUserId2roleIds[userRole.UserId] = append(newUserId2roleIds[userRole.UserId], userRole.RoleId)
+	}
+	roleSyncLock.Lock()
+	roleId2role = newRoleId2role
+	userId2roleIds = newUserId2roleIds
+	roleSyncLock.Unlock()
+}
+
+func builtinScopes(userRole int) []string {
+	switch {
+	case userRole >= RoleRootUser:
+		return AllScopes
+	case userRole >= RoleAdminUser:
+		scopes := make([]string, 0, len(AllScopes))
+		for _, scope := range AllScopes {
+			if scope != ScopeRolesManage {
+				scopes = append(scopes, scope)
+			}
+		}
+		return scopes
+	}
+	return nil
+}
+
+// CacheGetUserPermissions returns the scopes granted by User.Role and by the assigned roles
+func CacheGetUserPermissions(userId int, userRole int) *UserPermissions {
+	permissions := &UserPermissions{
+		UserId: userId,
+		Role:   userRole,
+		Roles:  make([]*Role, 0),
+	}
+	scopes := make(map[string]bool)
+	for _, scope := range builtinScopes(userRole) {
+		scopes[scope] = true
+	}
+	roleSyncLock.RLock()
+	for _, roleId := range userId2roleIds[userId] {
+		role, ok := roleId2role[roleId]
+		if !ok {
+			continue
+		}
+		permissions.Roles = append(permissions.Roles, role)
+		for _, scope := range role.GetScopes() {
+			scopes[scope] = true
+			if implied, ok := impliedScopes[scope]; ok {
+				scopes[implied] = true
+			}
+		}
+	}
+	roleSyncLock.RUnlock()
+	permissions.Scopes = make([]string, 0, len(scopes))
+	for scope := range scopes {
+		permissions.Scopes = append(permissions.Scopes, scope)
+	}
+	sort.Strings(permissions.Scopes)
+	return permissions
+}
+
+func CacheUserHasScope(userId int, userRole int, scope string) bool {
+	return isInRuleList(CacheGetUserPermissions(userId, userRole).Scopes, scope)
+}
+
+// CacheUserCoversScopes tells whether the user holds every scope of the target user, so that acting on
+// the target cannot hand the user a scope it does not have
+func CacheUserCoversScopes(userId int, userRole int, targetId int, targetRole int) bool {
+	scopes := CacheGetUserPermissions(userId, userRole).Scopes
+	for _, scope := range CacheGetUserPermissions(targetId, targetRole).Scopes {
+		if !isInRuleList(scopes, scope) {
+			return false
+		}
+	}
+	return true
+}
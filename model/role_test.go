@@ -0,0 +1,58 @@
+package model
+
+import "testing"
+
+func TestCacheGetUserPermissions(t *testing.T) {
+	roleSyncLock.Lock()
+	roleId2role = map[int]*Role{1: {Id: 1, Name: "support", Scopes: "logs:read, channels:write"}}
+	userId2roleIds = map[int][]int{7: {1, 2}}
+	roleSyncLock.Unlock()
+
+	if CacheUserHasScope(8, RoleCommonUser, ScopeLogsRead) {
+		t.Fatal("expected a common user without roles to have no scope")
+	}
+	if !CacheUserHasScope(7, RoleCommonUser, ScopeLogsRead) || !CacheUserHasScope(7, RoleCommonUser, ScopeChannelsRead) {
+		t.Fatal("expected the scopes of the role and the implied read scope")
+	}
+	if CacheUserHasScope(7, RoleCommonUser, ScopeUsersManage) {
+		t.Fatal("expected no scope outside of the role")
+	}
+	if len(CacheGetUserPermissions(7, RoleCommonUser).Roles) != 1 {
+		t.Fatal("expected the missing role to be skipped")
+	}
+	if CacheUserHasScope(8, RoleAdminUser, ScopeRolesManage) || !CacheUserHasScope(8, RoleAdminUser, ScopeOptionsWrite) {
+		t.Fatal("expected admins to have every scope but roles:manage")
+	}
+	if !CacheUserHasScope(8, RoleRootUser, ScopeRolesManage) {
+		t.Fatal("expected root to have every scope")
+	}
+}
+
+func TestCacheUserCoversScopes(t *testing.T) {
+	roleSyncLock.Lock()
+	roleId2role = map[int]*Role{
+		1: {Id: 1, Name: "support", Scopes: "users:manage"},
+		2: {Id: 2, Name: "security", Scopes: "roles:manage, channels:write"},
+		3: {Id: 3, Name: "ops", Scopes: "users:manage, channels:write"},
+	}
+	userId2roleIds = map[int][]int{7: {1}, 8: {2}, 9: {3}}
+	roleSyncLock.Unlock()
+	t.Cleanup(func() {
+		roleSyncLock.Lock()
+		roleId2role, userId2roleIds = nil, nil
+		roleSyncLock.Unlock()
+	})
+
+	if !CacheUserCoversScopes(7, RoleCommonUser, 10, RoleCommonUser) {
+		t.Fatal("expected a user without scopes to be covered")
+	}
+	if CacheUserCoversScopes(7, RoleCommonUser, 8, RoleCommonUser) || CacheUserCoversScopes(7, RoleCommonUser, 9, RoleCommonUser) {
+		t.Fatal("expected users:manage not to cover the broader scopes of the target")
+	}
+	if !CacheUserCoversScopes(9, RoleCommonUser, 7, RoleCommonUser) {
+		t.Fatal("expected the scopes of the target to be covered")
+	}
+	if CacheUserCoversScopes(1, RoleAdminUser, 8, RoleCommonUser) || !CacheUserCoversScopes(1, RoleAdminUser, 9, RoleCommonUser) {
+		t.Fatal("expected admins to cover every scope but roles:manage")
+	}
+}
@@ -0,0 +1,350 @@
+package model
+
+import (
+	"errors"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+const (
+	RoutingRuleStatusEnabled  = 1
+	RoutingRuleStatusDisabled = 2
+)
+
+const (
+	RoutingActionRoute   = "route"
+	RoutingActionRewrite = "rewrite"
+	RoutingActionReject  = "reject"
+)
+
+const (
+	RoutingStreamAny        = 0
+	RoutingStreamOnly       = 1
+	RoutingStreamExcluded   = 2
+	routingTimeWindowLayout = "15:04"
+)
+
+// RoutingRule is a declarative rule evaluated before the ability lookup.
+// Every non-empty condition must match; rules are evaluated by descending priority
+// and the first matching rule wins.
+type RoutingRule struct {
+	Id       int    `json:"id"`
+	Name     string `json:"name" gorm:"type:varchar(128)"`
+	Priority int    `json:"priority" gorm:"default:0"`
+	Status   int    `json:"status" gorm:"default:1"`
+	// conditions
+	TokenIds        string `json:"token_ids" gorm:"type:varchar(1024);default:''"` // comma separated
+	Groups          string `json:"groups" gorm:"type:varchar(1024);default:''"`    // comma separated
+	ModelPattern    string `json:"model_pattern" gorm:"type:varchar(1024);default:''"`
+	MinPromptTokens int    `json:"min_prompt_tokens" gorm:"default:0"`
+	MaxPromptTokens int    `json:"max_prompt_tokens" gorm:"default:0"` // 0 means no limit
+	StreamMode      int    `json:"stream_mode" gorm:"default:0"`
+	HeaderName      string `json:"header_name" gorm:"type:varchar(128);default:''"`
+	HeaderValue     string `json:"header_value" gorm:"type:varchar(512);default:''"` // glob, empty means present
+	TimeStart       string `json:"time_start" gorm:"type:varchar(8);default:''"`     // HH:MM, server time
+	TimeEnd         string `json:"time_end" gorm:"type:varchar(8);default:''"`       // HH:MM, may wrap midnight
+	Weekdays        string `json:"weekdays" gorm:"type:varchar(32);default:''"`      // comma separated, 0 is Sunday
+	// action
+	Action        string `json:"action" gorm:"type:varchar(16)"`
+	ChannelIds    string `json:"channel_ids" gorm:"type:varchar(1024);default:''"` // comma separated, for route
+	TargetModel   string `json:"target_model" gorm:"type:varchar(128);default:''"` // for rewrite
+	RejectMessage string `json:"reject_message" gorm:"type:varchar(512);default:''"`
+	CreatedTime   int64  `json:"created_time" gorm:"bigint"`
+}
+
+// RoutingRequest is what a routing rule is matched against.
+// EstimatePromptTokens is only called when a rule has a prompt size condition.
+type RoutingRequest struct {
+	TokenId              int
+	Group                string
+	Model                string
+	IsStream             bool
+	Header               http.Header
+	Time                 time.Time
+	EstimatePromptTokens func() int
+
+	promptTokens *int
+}
+
+func (r *RoutingRequest) getPromptTokens() int {
+	if r.promptTokens == nil {
+		promptTokens := 0
+		if r.EstimatePromptTokens != nil {
+			promptTokens = r.EstimatePromptTokens()
+		}
+		r.promptTokens = &promptTokens
+	}
+	return *r.promptTokens
+}
+
+func splitRuleList(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func isInRuleList(items []string, value string) bool {
+	for _, item := range items {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func isChannelExcluded(excludedChannelIds []int, id int) bool {
+	for _, excludedChannelId := range excludedChannelIds {
+		if excludedChannelId == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (rule *RoutingRule) GetChannelIds() []int {
+	ids := make([]int, 0)
+	for _, item := range splitRuleList(rule.ChannelIds) {
+		id, err := strconv.Atoi(item)
+		if err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Validate checks the action and conditions, normalizing the time window
+func (rule *RoutingRule) Validate() error {
+	switch rule.Action {
+	case RoutingActionRoute:
+		if len(rule.GetChannelIds()) == 0 {
+			return errors.New("route action requires channel_ids")
+		}
+	case RoutingActionRewrite:
+		if rule.TargetModel == "" {
+			return errors.New("rewrite action requires target_model")
+		}
+	case RoutingActionReject:
+	default:
+		return errors.New("unknown routing action: " + rule.Action)
+	}
+	for _, pattern := range splitRuleList(rule.ModelPattern) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.New("invalid model pattern: " + pattern)
+		}
+	}
+	if (rule.TimeStart == "") != (rule.TimeEnd == "") {
+		return errors.New("time_start and time_end must be set together")
+	}
+	if rule.TimeStart != "" {
+		start, err := time.Parse(routingTimeWindowLayout, rule.TimeStart)
+		if err != nil {
+			return errors.New("invalid time_start, expect HH:MM")
+		}
+		end, err := time.Parse(routingTimeWindowLayout, rule.TimeEnd)
+		if err != nil {
+			return errors.New("invalid time_end, expect HH:MM")
+		}
+		// normalize so that the windows can be compared as strings
+		rule.TimeStart = start.Format(routingTimeWindowLayout)
+		rule.TimeEnd = end.Format(routingTimeWindowLayout)
+	}
+	return nil
+}
+
+func (rule *RoutingRule) Matches(req *RoutingRequest) bool {
+	if tokenIds := splitRuleList(rule.TokenIds); len(tokenIds) > 0 {
+		if !isInRuleList(tokenIds, strconv.Itoa(req.TokenId)) {
+			return false
+		}
+	}
+	if groups := splitRuleList(rule.Groups); len(groups) > 0 {
+		if !isInRuleList(groups, req.Group) {
+			return false
+		}
+	}
+	if patterns := splitRuleList(rule.ModelPattern); len(patterns) > 0 {
+		matched := false
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, req.Model); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	switch rule.StreamMode {
+	case RoutingStreamOnly:
+		if !req.IsStream {
+			return false
+		}
+	case RoutingStreamExcluded:
+		if req.IsStream {
+			return false
+		}
+	}
+	if rule.HeaderName != "" {
+		value := req.Header.Get(rule.HeaderName)
+		if value == "" {
+			return false
+		}
+		if rule.HeaderValue != "" {
+			if ok, _ := path.Match(rule.HeaderValue, value); !ok {
+				return false
+			}
+		}
+	}
+	if !rule.inTimeWindow(req.Time) {
+		return false
+	}
+	if rule.MinPromptTokens > 0 || rule.MaxPromptTokens > 0 {
+		promptTokens := req.getPromptTokens()
+		if promptTokens < rule.MinPromptTokens {
+			return false
+		}
+		if rule.MaxPromptTokens > 0 && promptTokens > rule.MaxPromptTokens {
+			return false
+		}
+	}
+	return true
+}
+
+func (rule *RoutingRule) inTimeWindow(now time.Time) bool {
+	if weekdays := splitRuleList(rule.Weekdays); len(weekdays) > 0 {
+		if !isInRuleList(weekdays, strconv.Itoa(int(now.Weekday()))) {
+			return false
+		}
+	}
+	if rule.TimeStart == "" || rule.TimeEnd == "" {
+		return true
+	}
+	current := now.Format(routingTimeWindowLayout)
+	if rule.TimeStart <= rule.TimeEnd {
+		return current >= rule.TimeStart && current < rule.TimeEnd
+	}
+	// the window wraps midnight, e.g. 22:00-06:00
+	return current >= rule.TimeStart || current < rule.TimeEnd
+}
+
+func GetAllRoutingRules() ([]*RoutingRule, error) {
+	var rules []*RoutingRule
+	err := DB.Order("priority desc, id asc").Find(&rules).Error
+	return rules, err
+}
+
+func GetRoutingRuleById(id int) (*RoutingRule, error) {
+	rule := RoutingRule{}
+	err := DB.First(&rule, "id = ?", id).Error
+	return &rule, err
+}
+
+func (rule *RoutingRule) Insert() error {
+	rule.CreatedTime = helper.GetTimestamp()
+	return DB.Create(rule).Error
+}
+
+func (rule *RoutingRule) Update() error {
+	return DB.Model(rule).Select("*").Omit("id", "created_time").Updates(rule).Error
+}
+
+func DeleteRoutingRuleById(id int) error {
+	return DB.Delete(&RoutingRule{}, "id = ?", id).Error
+}
+
+var routingRules []*RoutingRule
+var routingRuleSyncLock sync.RWMutex
+
+func InitRoutingRuleCache() {
+	var rules []*RoutingRule
+	err := DB.Where("status = ?", RoutingRuleStatusEnabled).Order("priority desc, id asc").Find(&rules).Error
+	if err != nil {
+		logger.SysError("failed to load routing rules: " + err.Error())
+		return
+	}
+	routingRuleSyncLock.Lock()
+	routingRules = rules
+	routingRuleSyncLock.Unlock()
+}
+
+// CacheMatchRoutingRule returns the first enabled rule matching the request, or nil
+func CacheMatchRoutingRule(req *RoutingRequest) *RoutingRule {
+	routingRuleSyncLock.RLock()
+	defer routingRuleSyncLock.RUnlock()
+	for _, rule := range routingRules {
+		if rule.Matches(req) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// SupportsModel tells whether the model is among the models of the channel
+func (channel *Channel) SupportsModel(name string) bool {
+	return isInRuleList(splitRuleList(channel.Models), name)
+}
+
+// CacheGetChannelsByIds returns the enabled channels among ids supporting the model, ordered by priority and weight
+func CacheGetChannelsByIds(ids []int, modelName string, excludedChannelIds []int) []*Channel {
+	channelSyncLock.RLock()
+	channels := make([]*Channel, 0, len(ids))
+	for _, id := range ids {
+		if isChannelExcluded(excludedChannelIds, id) {
+			continue
+		}
+		if channel, ok := channelId2channel[id]; ok && channel.SupportsModel(modelName) {
+			channels = append(channels, channel)
+		}
+	}
+	channelSyncLock.RUnlock()
+	sort.SliceStable(channels, func(i, j int) bool {
+		if channels[i].GetPriority() == channels[j].GetPriority() {
+			return getChannelWeight(channels[i]) > getChannelWeight(channels[j])
+		}
+		return channels[i].GetPriority() > channels[j].GetPriority()
+	})
+	return channels
+}
+
+// CacheGetRandomChannelByIds picks a channel among ids supporting the model the same way CacheGetRandomSatisfiedChannel does
+func CacheGetRandomChannelByIds(ids []int, modelName string, excludedChannelIds []int) *Channel {
+	channels := CacheGetChannelsByIds(ids, modelName, excludedChannelIds)
+	if len(channels) == 0 {
+		return nil
+	}
+	channels = preferAvailableChannels(channels)
+	endIdx := len(channels)
+	if channels[0].GetPriority() > 0 {
+		for i := range channels {
+			if channels[i].GetPriority() != channels[0].GetPriority() {
+				endIdx = i
+				break
+			}
+		}
+	}
+	return channels[calcIdxByWeight(channels, endIdx)]
+}
+
+// CacheGetRoutedChannel picks a channel among ids for the first of the models one of them supports,
+// such as the chain of a virtual model
+func CacheGetRoutedChannel(ids []int, modelNames []string, excludedChannelIds []int) (string, *Channel) {
+	for _, modelName := range modelNames {
+		if channel := CacheGetRandomChannelByIds(ids, modelName, excludedChannelIds); channel != nil {
+			return modelName, channel
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,90 @@
+package model
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRoutingRuleMatches(t *testing.T) {
+	rule := &RoutingRule{
+		Groups:          "default,vip",
+		ModelPattern:    "gpt-4o*,claude-*-sonnet",
+		MinPromptTokens: 1000,
+		StreamMode:      RoutingStreamOnly,
+		HeaderName:      "X-Team",
+		HeaderValue:     "search-*",
+		TimeStart:       "22:00",
+		TimeEnd:         "06:00",
+		Action:          RoutingActionRewrite,
+		TargetModel:     "gpt-4o-128k",
+	}
+	if err := rule.Validate(); err != nil {
+		t.Fatalf("unexpected validate error: %v", err)
+	}
+	newRequest := func() *RoutingRequest {
+		header := http.Header{}
+		header.Set("X-Team", "search-prod")
+		return &RoutingRequest{
+			Group:                "vip",
+			Model:                "claude-3-7-sonnet",
+			IsStream:             true,
+			Header:               header,
+			Time:                 time.Date(2025, 1, 1, 23, 30, 0, 0, time.Local),
+			EstimatePromptTokens: func() int { return 2000 },
+		}
+	}
+	if !rule.Matches(newRequest()) {
+		t.Fatal("expected rule to match")
+	}
+	cases := map[string]func(req *RoutingRequest){
+		"group":  func(req *RoutingRequest) { req.Group = "free" },
+		"model":  func(req *RoutingRequest) { req.Model = "deepseek-chat" },
+		"stream": func(req *RoutingRequest) { req.IsStream = false },
+		"header": func(req *RoutingRequest) { req.Header.Set("X-Team", "ops") },
+		"time":   func(req *RoutingRequest) { req.Time = time.Date(2025, 1, 1, 12, 0, 0, 0, time.Local) },
+		"prompt": func(req *RoutingRequest) { req.EstimatePromptTokens = func() int { return 10 } },
+	}
+	for name, mutate := range cases {
+		req := newRequest()
+		mutate(req)
+		if rule.Matches(req) {
+			t.Errorf("expected rule not to match when %s differs", name)
+		}
+	}
+}
+
+func TestRoutingRuleValidate(t *testing.T) {
+	if err := (&RoutingRule{Action: RoutingActionRoute}).Validate(); err == nil {
+		t.Error("expected route rule without channels to be invalid")
+	}
+	if err := (&RoutingRule{Action: RoutingActionReject, TimeStart: "8:00"}).Validate(); err == nil {
+		t.Error("expected half time window to be invalid")
+	}
+	rule := &RoutingRule{Action: RoutingActionReject, TimeStart: "8:00", TimeEnd: "9:30"}
+	if err := rule.Validate(); err != nil || rule.TimeStart != "08:00" {
+		t.Errorf("expected normalized time window, got %q, %v", rule.TimeStart, err)
+	}
+}
+
+func TestCacheGetRoutedChannel(t *testing.T) {
+	weight := uint(1)
+	original := channelId2channel
+	channelId2channel = map[int]*Channel{
+		1: {Id: 1, Models: "gpt-4o-mini", Weight: &weight},
+		2: {Id: 2, Models: "gpt-4o, gpt-4.1", Weight: &weight},
+	}
+	defer func() { channelId2channel = original }()
+
+	if channel := CacheGetRandomChannelByIds([]int{1}, "gpt-4o", nil); channel != nil {
+		t.Fatalf("channel #%d does not support the model", channel.Id)
+	}
+	servedModel, channel := CacheGetRoutedChannel([]int{1, 2}, []string{"o3", "gpt-4.1", "gpt-4o-mini"}, nil)
+	if servedModel != "gpt-4.1" || channel == nil || channel.Id != 2 {
+		t.Fatalf("unexpected %s on %+v", servedModel, channel)
+	}
+	servedModel, channel = CacheGetRoutedChannel([]int{1, 2}, []string{"gpt-4.1", "gpt-4o-mini"}, []int{2})
+	if servedModel != "gpt-4o-mini" || channel == nil || channel.Id != 1 {
+		t.Fatalf("unexpected %s on %+v", servedModel, channel)
+	}
+}
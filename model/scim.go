@@ -0,0 +1,465 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/blacklist"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrScimNotFound  = errors.New("资源不存在")
+	ErrScimConflict  = errors.New("资源冲突")
+	ErrScimForbidden = errors.New("身份提供方不能修改管理员用户")
+)
+
+// ScimGroup is a group pushed by the identity provider through SCIM, its members are given
+// the group mapped from its display name
+type ScimGroup struct {
+	Id          int    `json:"id"`
+	DisplayName string `json:"display_name" gorm:"type:varchar(128);uniqueIndex"`
+	ExternalId  string `json:"external_id" gorm:"type:varchar(128);default:''"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+	UpdatedTime int64  `json:"updated_time" gorm:"bigint"`
+}
+
+type ScimGroupMember struct {
+	GroupId int `json:"group_id" gorm:"primaryKey;autoIncrement:false"`
+	UserId  int `json:"user_id" gorm:"primaryKey;autoIncrement:false;index"`
+}
+
+// MapScimGroup returns the group given to the members of an identity provider group, a group
+// without mapping keeps its name when such a group exists
+func MapScimGroup(displayName string) (string, bool) {
+	if group, ok := config.ScimGroupMapping[displayName]; ok {
+		return group, true
+	}
+	if _, ok := billingratio.GroupRatio[displayName]; ok {
+		return displayName, true
+	}
+	return "", false
+}
+
+// ParseScimFilter parses the only filter form identity providers send for lookups, attribute eq "value"
+func ParseScimFilter(filter string) (attribute string, value string, err error) {
+	filter = strings.TrimSpace(filter)
+	if filter == "" {
+		return "", "", nil
+	}
+	parts := strings.SplitN(filter, " ", 3)
+	if len(parts) != 3 || !strings.EqualFold(parts[1], "eq") {
+		return "", "", fmt.Errorf("不支持的过滤条件：%s", filter)
+	}
+	value = strings.TrimSpace(parts[2])
+	if unquoted, err := strconv.Unquote(value); err == nil {
+		value = unquoted
+	}
+	return parts[0], value, nil
+}
+
+// scimUserColumns maps the filterable SCIM attributes of users to columns
+var scimUserColumns = map[string]string{
+	"id":           "id",
+	"username":     "username",
+	"externalid":   "oidc_id",
+	"emails":       "email",
+	"emails.value": "email",
+	"displayname":  "display_name",
+}
+
+var scimGroupColumns = map[string]string{
+	"id":          "id",
+	"displayname": "display_name",
+	"externalid":  "external_id",
+}
+
+func scimFilterColumn(columns map[string]string, attribute string) (string, error) {
+	column, ok := columns[strings.ToLower(attribute)]
+	if !ok {
+		return "", fmt.Errorf("不支持的过滤属性：%s", attribute)
+	}
+	return column, nil
+}
+
+// GetScimUsers lists the users not deleted, filtered by the attribute when it is not empty
+func GetScimUsers(attribute string, value string, startIdx int, num int) (users []*User, total int64, err error) {
+	tx := DB.Model(&User{}).Where("status != ?", UserStatusDeleted)
+	if attribute != "" {
+		column, err := scimFilterColumn(scimUserColumns, attribute)
+		if err != nil {
+			return nil, 0, err
+		}
+		tx = tx.Where(column+" = ?", value)
+	}
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Omit("password").Order("id asc").Limit(num).Offset(startIdx).Find(&users).Error
+	return users, total, err
+}
+
+func GetScimUser(id int) (*User, error) {
+	user := &User{}
+	err := DB.Omit("password").Where("id = ? AND status != ?", id, UserStatusDeleted).First(user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrScimNotFound
+	}
+	return user, err
+}
+
+// checkScimManaged refuses the changes of the identity provider to the admins, they are managed in one-api only
+func checkScimManaged(user *User) error {
+	if user.Role >= RoleAdminUser {
+		return ErrScimForbidden
+	}
+	return nil
+}
+
+// checkScimUserConflict looks for another user with the username or the external id, the deleted users
+// are renamed and have no external id left
+func checkScimUserConflict(user *User) error {
+	var count int64
+	err := DB.Model(&User{}).Where("username = ? AND id != ?", user.Username, user.Id).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("%w，用户名 %s 已存在", ErrScimConflict, user.Username)
+	}
+	if user.OidcId != "" {
+		err = DB.Model(&User{}).Where("oidc_id = ? AND id != ? AND status != ?", user.OidcId, user.Id, UserStatusDeleted).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("%w，externalId %s 已被其他用户使用", ErrScimConflict, user.OidcId)
+		}
+	}
+	return nil
+}
+
+// ProvisionScimUser creates the user pushed by the identity provider. The external id is stored
+// as the OIDC id, so that the user signs in through OIDC with the same account.
+func ProvisionScimUser(user *User) error {
+	if user.Username == "" {
+		return errors.New("userName 不能为空")
+	}
+	if err := checkScimUserConflict(user); err != nil {
+		return err
+	}
+	active := user.Status != UserStatusDisabled
+	user.Status = UserStatusEnabled
+	user.Role = RoleCommonUser
+	if err := user.Insert(0); err != nil {
+		return err
+	}
+	if !active {
+		return DeprovisionScimUser(user, false)
+	}
+	return nil
+}
+
+// UpdateScimUser saves the attributes managed by the identity provider, and deprovisions
+// the user when it is no longer active
+func UpdateScimUser(user *User, active bool) error {
+	if user.Username == "" {
+		return errors.New("userName 不能为空")
+	}
+	if err := checkScimManaged(user); err != nil {
+		return err
+	}
+	if err := checkScimUserConflict(user); err != nil {
+		return err
+	}
+	err := DB.Model(&User{}).Where("id = ?", user.Id).Updates(map[string]any{
+		"username":     user.Username,
+		"display_name": user.DisplayName,
+		"email":        user.Email,
+		"oidc_id":      user.OidcId,
+	}).Error
+	if err != nil {
+		return err
+	}
+	SetUsernamePool(user.Id, user.Username)
+	if !active && user.Status == UserStatusEnabled {
+		return DeprovisionScimUser(user, false)
+	}
+	if active && user.Status == UserStatusDisabled {
+		if err = DB.Model(&User{}).Where("id = ?", user.Id).Update("status", UserStatusEnabled).Error; err != nil {
+			return err
+		}
+		user.Status = UserStatusEnabled
+		blacklist.UnbanUser(user.Id)
+		purgeUserStatusCache(user.Id)
+		RecordLog(user.Id, LogTypeManage, "身份提供方重新启用了该用户")
+	}
+	return nil
+}
+
+// DeprovisionScimUser disables, or deletes, the user and disables all of its tokens.
+// The tokens stay disabled when the user is enabled again.
+func DeprovisionScimUser(user *User, deleteUser bool) error {
+	if err := checkScimManaged(user); err != nil {
+		return err
+	}
+	var err error
+	if deleteUser {
+		err = user.Delete()
+		if err == nil {
+			// frees the external id for the user to be provisioned again
+			err = DB.Model(&User{}).Where("id = ?", user.Id).Update("oidc_id", "").Error
+		}
+		if err == nil {
+			err = DB.Where("user_id = ?", user.Id).Delete(&ScimGroupMember{}).Error
+		}
+	} else {
+		blacklist.BanUser(user.Id)
+		user.Status = UserStatusDisabled
+		err = DB.Model(&User{}).Where("id = ?", user.Id).Update("status", UserStatusDisabled).Error
+	}
+	if err != nil {
+		return err
+	}
+	purgeUserStatusCache(user.Id)
+	count, err := DisableUserTokens(user.Id)
+	if err != nil {
+		return err
+	}
+	RecordLog(user.Id, LogTypeManage, fmt.Sprintf("身份提供方停用了该用户，禁用了 %d 个令牌", count))
+	return nil
+}
+
+func purgeUserStatusCache(userId int) {
+	if !common.RedisEnabled {
+		return
+	}
+	if err := common.RedisDel(fmt.Sprintf("user_enabled:%d", userId)); err != nil {
+		logger.SysError("Redis del user enabled error: " + err.Error())
+	}
+}
+
+// DisableUserTokens disables the enabled tokens of the user and drops them from the local cache
+func DisableUserTokens(userId int) (int, error) {
+	var tokens []*Token
+	err := DB.Select("id", "key").Where("user_id = ? AND status = ?", userId, TokenStatusEnabled).Find(&tokens).Error
+	if err != nil || len(tokens) == 0 {
+		return 0, err
+	}
+	err = DB.Model(&Token{}).Where("user_id = ? AND status = ?", userId, TokenStatusEnabled).Update("status", TokenStatusDisabled).Error
+	if err != nil {
+		return 0, err
+	}
+	for _, token := range tokens {
+		TokenKeyCache.Del([]byte(token.Key))
+		TokenIdCache.Del([]byte(strconv.Itoa(token.Id)))
+	}
+	return len(tokens), nil
+}
+
+func GetScimGroups(attribute string, value string, startIdx int, num int) (groups []*ScimGroup, total int64, err error) {
+	tx := DB.Model(&ScimGroup{})
+	if attribute != "" {
+		column, err := scimFilterColumn(scimGroupColumns, attribute)
+		if err != nil {
+			return nil, 0, err
+		}
+		tx = tx.Where(column+" = ?", value)
+	}
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id asc").Limit(num).Offset(startIdx).Find(&groups).Error
+	return groups, total, err
+}
+
+func GetScimGroupById(id int) (*ScimGroup, error) {
+	group := &ScimGroup{}
+	err := DB.First(group, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrScimNotFound
+	}
+	return group, err
+}
+
+func GetScimGroupMemberIds(groupId int) ([]int, error) {
+	var userIds []int
+	err := DB.Model(&ScimGroupMember{}).Where("group_id = ?", groupId).Order("user_id asc").Pluck("user_id", &userIds).Error
+	return userIds, err
+}
+
+// GetUserScimGroups returns the SCIM groups of the user, oldest first
+func GetUserScimGroups(userId int) ([]*ScimGroup, error) {
+	var groups []*ScimGroup
+	err := DB.Where("id IN (?)", DB.Model(&ScimGroupMember{}).Select("group_id").Where("user_id = ?", userId)).
+		Order("id asc").Find(&groups).Error
+	return groups, err
+}
+
+func validateScimGroup(group *ScimGroup) error {
+	if group.DisplayName == "" {
+		return errors.New("displayName 不能为空")
+	}
+	var count int64
+	err := DB.Model(&ScimGroup{}).Where("display_name = ? AND id != ?", group.DisplayName, group.Id).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("%w，组 %s 已存在", ErrScimConflict, group.DisplayName)
+	}
+	return nil
+}
+
+func (group *ScimGroup) Insert(memberIds []int) error {
+	if err := validateScimGroup(group); err != nil {
+		return err
+	}
+	group.CreatedTime = helper.GetTimestamp()
+	group.UpdatedTime = group.CreatedTime
+	if err := DB.Create(group).Error; err != nil {
+		return err
+	}
+	return group.SetMembers(memberIds)
+}
+
+// Update saves the group, the group of its members is updated when the display name changes
+func (group *ScimGroup) Update() error {
+	if err := validateScimGroup(group); err != nil {
+		return err
+	}
+	group.UpdatedTime = helper.GetTimestamp()
+	err := DB.Model(group).Select("display_name", "external_id", "updated_time").Updates(group).Error
+	if err != nil {
+		return err
+	}
+	memberIds, err := GetScimGroupMemberIds(group.Id)
+	if err != nil {
+		return err
+	}
+	return syncScimUserGroups(memberIds)
+}
+
+func (group *ScimGroup) Delete() error {
+	memberIds, err := GetScimGroupMemberIds(group.Id)
+	if err != nil {
+		return err
+	}
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("group_id = ?", group.Id).Delete(&ScimGroupMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(group).Error
+	})
+	if err != nil {
+		return err
+	}
+	return syncScimUserGroups(memberIds)
+}
+
+// SetMembers replaces the members of the group
+func (group *ScimGroup) SetMembers(userIds []int) error {
+	oldIds, err := GetScimGroupMemberIds(group.Id)
+	if err != nil {
+		return err
+	}
+	userIds, err = existingUserIds(userIds)
+	if err != nil {
+		return err
+	}
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("group_id = ?", group.Id).Delete(&ScimGroupMember{}).Error; err != nil {
+			return err
+		}
+		members := make([]ScimGroupMember, 0, len(userIds))
+		for _, userId := range userIds {
+			members = append(members, ScimGroupMember{GroupId: group.Id, UserId: userId})
+		}
+		if len(members) == 0 {
+			return nil
+		}
+		return tx.Create(&members).Error
+	})
+	if err != nil {
+		return err
+	}
+	return syncScimUserGroups(append(oldIds, userIds...))
+}
+
+func (group *ScimGroup) AddMembers(userIds []int) error {
+	userIds, err := existingUserIds(userIds)
+	if err != nil {
+		return err
+	}
+	for _, userId := range userIds {
+		member := ScimGroupMember{GroupId: group.Id, UserId: userId}
+		if err := DB.Where(member).FirstOrCreate(&member).Error; err != nil {
+			return err
+		}
+	}
+	return syncScimUserGroups(userIds)
+}
+
+func (group *ScimGroup) RemoveMembers(userIds []int) error {
+	if len(userIds) == 0 {
+		return nil
+	}
+	err := DB.Where("group_id = ? AND user_id IN ?", group.Id, userIds).Delete(&ScimGroupMember{}).Error
+	if err != nil {
+		return err
+	}
+	return syncScimUserGroups(userIds)
+}
+
+// existingUserIds drops the ids of the users that do not exist or are deleted
+func existingUserIds(userIds []int) ([]int, error) {
+	if len(userIds) == 0 {
+		return nil, nil
+	}
+	var ids []int
+	err := DB.Model(&User{}).Where("id IN ? AND status != ?", uniqueInts(userIds), UserStatusDeleted).Order("id asc").Pluck("id", &ids).Error
+	return ids, err
+}
+
+func uniqueInts(values []int) []int {
+	seen := make(map[int]bool, len(values))
+	unique := make([]int, 0, len(values))
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
+
+// syncScimUserGroups gives each user the group mapped from its oldest mapped SCIM group,
+// or the default group when none is mapped. The group of the admins is left alone.
+func syncScimUserGroups(userIds []int) error {
+	for _, userId := range uniqueInts(userIds) {
+		groups, err := GetUserScimGroups(userId)
+		if err != nil {
+			return err
+		}
+		group := "default"
+		for _, scimGroup := range groups {
+			if mapped, ok := MapScimGroup(scimGroup.DisplayName); ok {
+				group = mapped
+				break
+			}
+		}
+		err = DB.Model(&User{}).Where("id = ? AND status != ? AND role < ?", userId, UserStatusDeleted, RoleAdminUser).Update("group", group).Error
+		if err != nil {
+			return err
+		}
+		UserGroupCache.Del([]byte(strconv.Itoa(userId)))
+	}
+	return nil
+}
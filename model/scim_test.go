@@ -0,0 +1,79 @@
+package model
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/songquanpeng/one-api/common/blacklist"
+	"github.com/songquanpeng/one-api/common/config"
+)
+
+func TestParseScimFilter(t *testing.T) {
+	attribute, value, err := ParseScimFilter(`userName eq "alice smith@example.com"`)
+	if err != nil || attribute != "userName" || value != "alice smith@example.com" {
+		t.Fatalf("unexpected filter %q %q %v", attribute, value, err)
+	}
+	if attribute, _, err = ParseScimFilter(""); err != nil || attribute != "" {
+		t.Fatal("expected an empty filter to match everything")
+	}
+	if _, _, err = ParseScimFilter(`userName sw "a"`); err == nil {
+		t.Fatal("expected operators other than eq to be rejected")
+	}
+	if _, err = scimFilterColumn(scimUserColumns, "password"); err == nil {
+		t.Fatal("expected unknown attributes to be rejected")
+	}
+}
+
+func TestMapScimGroup(t *testing.T) {
+	config.ScimGroupMapping = map[string]string{"Engineering": "vip"}
+	defer func() { config.ScimGroupMapping = map[string]string{} }()
+	if group, ok := MapScimGroup("Engineering"); !ok || group != "vip" {
+		t.Fatalf("expected the mapped group, got %q", group)
+	}
+	if group, ok := MapScimGroup("svip"); !ok || group != "svip" {
+		t.Fatalf("expected an existing group to keep its name, got %q", group)
+	}
+	if _, ok := MapScimGroup("Sales"); ok {
+		t.Fatal("expected an unknown group to be unmapped")
+	}
+}
+
+func TestScimReprovisionDeletedUser(t *testing.T) {
+	recorder := useDryRunDB(t)
+	user := &User{Id: 7, Username: "alice", OidcId: "ext-1", Role: RoleCommonUser, Status: UserStatusEnabled}
+	t.Cleanup(func() { blacklist.UnbanUser(user.Id) })
+	if err := DeprovisionScimUser(user, true); err != nil {
+		t.Fatal(err)
+	}
+	if recorder.find(`UPDATE "users" SET "oidc_id"=''`, "id = 7") == "" {
+		t.Fatalf("expected the external id of the deleted user to be cleared, got %v", recorder.statements)
+	}
+	if err := checkScimUserConflict(&User{Username: "alice", OidcId: "ext-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if recorder.find("oidc_id = 'ext-1'", "status != 3") == "" {
+		t.Fatalf("expected the deleted users to be left out of the conflict check, got %v", recorder.statements)
+	}
+}
+
+func TestScimRefusesAdmins(t *testing.T) {
+	recorder := useDryRunDB(t)
+	for _, role := range []int{RoleAdminUser, RoleRootUser} {
+		admin := &User{Id: 1, Username: "admin", Role: role, Status: UserStatusEnabled}
+		if err := UpdateScimUser(admin, true); !errors.Is(err, ErrScimForbidden) {
+			t.Fatalf("expected the update of role %d to be refused, got %v", role, err)
+		}
+		if err := DeprovisionScimUser(admin, true); !errors.Is(err, ErrScimForbidden) {
+			t.Fatalf("expected the deletion of role %d to be refused, got %v", role, err)
+		}
+	}
+	if recorder.count() != 0 {
+		t.Fatalf("expected nothing to be written, got %v", recorder.statements)
+	}
+	if err := syncScimUserGroups([]int{1}); err != nil {
+		t.Fatal(err)
+	}
+	if recorder.find(`UPDATE "users" SET "group"`, "role < 10") == "" {
+		t.Fatalf("expected the admins to keep their group, got %v", recorder.statements)
+	}
+}
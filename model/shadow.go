@@ -0,0 +1,159 @@
+package model
+
+import (
+	"sync"
+
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+const (
+	ShadowStatusEnabled  = 1
+	ShadowStatusDisabled = 2
+)
+
+// ShadowConfig copies a percentage of the requests for a model to a candidate channel.
+// The shadow response is discarded and never billed to the user.
+type ShadowConfig struct {
+	Id          int     `json:"id"`
+	ModelName   string  `json:"model_name" gorm:"type:varchar(128);index"`
+	ChannelId   int     `json:"channel_id" gorm:"index"`
+	Percent     float64 `json:"percent" gorm:"default:0"` // 0-100
+	Status      int     `json:"status" gorm:"default:1"`
+	CreatedTime int64   `json:"created_time" gorm:"bigint"`
+}
+
+// ShadowRecord is the comparison of one primary request with its shadow copy.
+type ShadowRecord struct {
+	Id                      int     `json:"id"`
+	CreatedAt               int64   `json:"created_at" gorm:"bigint;index:idx_shadow_channel_created,priority:2"`
+	ShadowChannelId         int     `json:"shadow_channel_id" gorm:"index:idx_shadow_channel_created,priority:1"`
+	PrimaryChannelId        int     `json:"primary_channel_id"`
+	ModelName               string  `json:"model_name" gorm:"type:varchar(128)"`
+	RequestId               string  `json:"request_id" gorm:"type:varchar(128)"`
+	PrimaryLatency          int64   `json:"primary_latency"` // in milliseconds
+	ShadowLatency           int64   `json:"shadow_latency"`  // in milliseconds
+	ShadowStatusCode        int     `json:"shadow_status_code"`
+	ShadowError             string  `json:"shadow_error" gorm:"type:text"`
+	PromptTokens            int     `json:"prompt_tokens"`
+	CompletionTokens        int     `json:"completion_tokens"`
+	PrimaryCompletionTokens int     `json:"primary_completion_tokens"`
+	Similarity              float64 `json:"similarity"`
+}
+
+type ShadowReport struct {
+	ShadowChannelId    int     `json:"shadow_channel_id" gorm:"column:shadow_channel_id"`
+	ModelName          string  `json:"model_name" gorm:"column:model_name"`
+	RequestCount       int     `json:"request_count" gorm:"column:request_count"`
+	ErrorCount         int     `json:"error_count" gorm:"column:error_count"`
+	AvgPrimaryLatency  float64 `json:"avg_primary_latency" gorm:"column:avg_primary_latency"`
+	AvgShadowLatency   float64 `json:"avg_shadow_latency" gorm:"column:avg_shadow_latency"`
+	PromptTokens       int64   `json:"prompt_tokens" gorm:"column:prompt_tokens"`
+	CompletionTokens   int64   `json:"completion_tokens" gorm:"column:completion_tokens"`
+	PrimaryCompletions int64   `json:"primary_completion_tokens" gorm:"column:primary_completion_tokens"`
+	AvgSimilarity      float64 `json:"avg_similarity" gorm:"column:avg_similarity"`
+	FirstRequestAt     int64   `json:"first_request_at" gorm:"column:first_request_at"`
+	LastRequestAt      int64   `json:"last_request_at" gorm:"column:last_request_at"`
+}
+
+func GetAllShadowConfigs() ([]*ShadowConfig, error) {
+	var configs []*ShadowConfig
+	err := DB.Order("id desc").Find(&configs).Error
+	return configs, err
+}
+
+func GetShadowConfigById(id int) (*ShadowConfig, error) {
+	shadowConfig := ShadowConfig{}
+	err := DB.First(&shadowConfig, "id = ?", id).Error
+	return &shadowConfig, err
+}
+
+func (shadowConfig *ShadowConfig) Insert() error {
+	shadowConfig.CreatedTime = helper.GetTimestamp()
+	return DB.Create(shadowConfig).Error
+}
+
+func (shadowConfig *ShadowConfig) Update() error {
+	return DB.Model(shadowConfig).Select("model_name", "channel_id", "percent", "status").Updates(shadowConfig).Error
+}
+
+func DeleteShadowConfigById(id int) error {
+	return DB.Delete(&ShadowConfig{}, "id = ?", id).Error
+}
+
+func RecordShadow(record *ShadowRecord) {
+	record.CreatedAt = helper.GetTimestamp()
+	err := LOG_DB.Create(record).Error
+	if err != nil {
+		logger.SysError("failed to record shadow result: " + err.Error())
+	}
+}
+
+func GetShadowRecords(channelId int, startTimestamp int64, endTimestamp int64, startIdx int, num int) (records []*ShadowRecord, err error) {
+	tx := LOG_DB.Model(&ShadowRecord{})
+	if channelId != 0 {
+		tx = tx.Where("shadow_channel_id = ?", channelId)
+	}
+	if startTimestamp != 0 {
+		tx = tx.Where("created_at >= ?", startTimestamp)
+	}
+	if endTimestamp != 0 {
+		tx = tx.Where("created_at <= ?", endTimestamp)
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&records).Error
+	return records, err
+}
+
+// GetShadowReport aggregates the shadow records per shadow channel and model
+func GetShadowReport(channelId int, startTimestamp int64, endTimestamp int64) (reports []*ShadowReport, err error) {
+	tx := LOG_DB.Model(&ShadowRecord{}).Select(`shadow_channel_id, model_name,
+		count(1) as request_count,
+		sum(case when shadow_status_code <> 200 then 1 else 0 end) as error_count,
+		avg(primary_latency) as avg_primary_latency,
+		avg(shadow_latency) as avg_shadow_latency,
+		sum(prompt_tokens) as prompt_tokens,
+		sum(completion_tokens) as completion_tokens,
+		sum(primary_completion_tokens) as primary_completion_tokens,
+		avg(case when shadow_status_code = 200 then similarity end) as avg_similarity,
+		min(created_at) as first_request_at,
+		max(created_at) as last_request_at`)
+	if channelId != 0 {
+		tx = tx.Where("shadow_channel_id = ?", channelId)
+	}
+	if startTimestamp != 0 {
+		tx = tx.Where("created_at >= ?", startTimestamp)
+	}
+	if endTimestamp != 0 {
+		tx = tx.Where("created_at <= ?", endTimestamp)
+	}
+	err = tx.Group("shadow_channel_id, model_name").Order("shadow_channel_id desc").Scan(&reports).Error
+	return reports, err
+}
+
+var model2shadowConfigs map[string][]*ShadowConfig
+var shadowSyncLock sync.RWMutex
+
+func InitShadowConfigCache() {
+	var configs []*ShadowConfig
+	err := DB.Where("status = ?", ShadowStatusEnabled).Find(&configs).Error
+	if err != nil {
+		logger.SysError("failed to load shadow configs: " + err.Error())
+		return
+	}
+	newModel2shadowConfigs := make(map[string][]*ShadowConfig)
+	for _, shadowConfig := range configs {
+		if shadowConfig.Percent <= 0 {
+			continue
+		}
+		newModel2shadowConfigs[shadowConfig.ModelName] = append(newModel2shadowConfigs[shadowConfig.ModelName], shadowConfig)
+	}
+	shadowSyncLock.Lock()
+	model2shadowConfigs = newModel2shadowConfigs
+	shadowSyncLock.Unlock()
+}
+
+func CacheGetShadowConfigs(modelName string) []*ShadowConfig {
+	shadowSyncLock.RLock()
+	defer shadowSyncLock.RUnlock()
+	return model2shadowConfigs[modelName]
+}
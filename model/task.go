@@ -0,0 +1,122 @@
+package model
+
+import (
+	"encoding/json"
+
+	"github.com/songquanpeng/one-api/common/helper"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+)
+
+// Task is an asynchronous task, like a video generation, submitted to a channel and polled until
+// it reaches a final state. Its quota is pre-consumed on submit, and settled or refunded then.
+type Task struct {
+	Id             int    `json:"id"`
+	TaskId         string `json:"task_id" gorm:"type:varchar(64);uniqueIndex"` // the id the user polls with
+	UserId         int    `json:"user_id" gorm:"index"`
+	TokenId        int    `json:"token_id" gorm:"index"`
+	TokenName      string `json:"token_name" gorm:"default:''"`
+	ChannelId      int    `json:"channel_id" gorm:"index"`
+	ChannelType    int    `json:"channel_type"`
+	KeyHash        string `json:"-" gorm:"type:varchar(64);default:''"` // the key of a multi-key channel the task was submitted with
+	Model          string `json:"model" gorm:"type:varchar(128)"`
+	ActualModel    string `json:"actual_model" gorm:"type:varchar(128)"` // the model name after mapping
+	Action         string `json:"action" gorm:"type:varchar(32)"`
+	UpstreamTaskId string `json:"upstream_task_id" gorm:"type:varchar(191);index"`
+	Status         string `json:"status" gorm:"type:varchar(32);index"`
+	Progress       int    `json:"progress" gorm:"default:0"`
+	ResultURLs     string `json:"result_urls" gorm:"type:text"` // JSON array of the upstream urls
+	URLsNeedAuth   bool   `json:"urls_need_auth" gorm:"default:false"`
+	Cached         bool   `json:"cached" gorm:"default:false"` // the results were downloaded to TASK_RESULT_DIR
+	FailReason     string `json:"fail_reason" gorm:"type:text"`
+	Quota          int64  `json:"quota" gorm:"bigint;default:0"` // pre-consumed until the task is finished, billed after
+	Units          int    `json:"units" gorm:"default:1"`        // seconds of video or tasks the pre-consumed quota is for
+	PriceId        int    `json:"price_id" gorm:"default:0"`
+	CreatedTime    int64  `json:"created_time" gorm:"bigint;index"`
+	UpdatedTime    int64  `json:"updated_time" gorm:"bigint"`
+	FinishedTime   int64  `json:"finished_time" gorm:"bigint;default:0"`
+}
+
+var unfinishedTaskStatuses = []string{relaymodel.TaskStatusQueued, relaymodel.TaskStatusInProgress}
+
+func (task *Task) IsFinished() bool {
+	return task.Status == relaymodel.TaskStatusSucceeded || task.Status == relaymodel.TaskStatusFailed
+}
+
+func (task *Task) GetResultURLs() []string {
+	urls := make([]string, 0)
+	if task.ResultURLs != "" {
+		_ = json.Unmarshal([]byte(task.ResultURLs), &urls)
+	}
+	return urls
+}
+
+func (task *Task) Insert() error {
+	task.CreatedTime = helper.GetTimestamp()
+	task.UpdatedTime = task.CreatedTime
+	return DB.Create(task).Error
+}
+
+func GetTaskByTaskId(taskId string) (*Task, error) {
+	task := Task{}
+	err := DB.First(&task, "task_id = ?", taskId).Error
+	return &task, err
+}
+
+func GetUserTaskByTaskId(userId int, taskId string) (*Task, error) {
+	task := Task{}
+	err := DB.First(&task, "task_id = ? AND user_id = ?", taskId, userId).Error
+	return &task, err
+}
+
+func GetAllTasks(startIdx int, num int, userId int, status string) ([]*Task, error) {
+	var tasks []*Task
+	tx := DB.Order("id desc")
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	if status != "" {
+		tx = tx.Where("status = ?", status)
+	}
+	err := tx.Limit(num).Offset(startIdx).Find(&tasks).Error
+	return tasks, err
+}
+
+// GetUnfinishedTasks returns the unfinished tasks, the least recently polled first
+func GetUnfinishedTasks(limit int) ([]*Task, error) {
+	var tasks []*Task
+	err := DB.Where("status IN ?", unfinishedTaskStatuses).Order("updated_time asc").Limit(limit).Find(&tasks).Error
+	return tasks, err
+}
+
+// UpdateTaskProgress records the progress of an unfinished task
+func UpdateTaskProgress(id int, status string, progress int) error {
+	return DB.Model(&Task{}).Where("id = ? AND status IN ?", id, unfinishedTaskStatuses).Updates(map[string]any{
+		"status":       status,
+		"progress":     progress,
+		"updated_time": helper.GetTimestamp(),
+	}).Error
+}
+
+// FinishTask moves an unfinished task to its final state. It reports false when the task was already
+// finished, by another poller, so that a task is only settled once.
+func FinishTask(task *Task) (bool, error) {
+	task.UpdatedTime = helper.GetTimestamp()
+	task.FinishedTime = task.UpdatedTime
+	result := DB.Model(&Task{}).Where("id = ? AND status IN ?", task.Id, unfinishedTaskStatuses).Updates(map[string]any{
+		"status":         task.Status,
+		"progress":       task.Progress,
+		"result_urls":    task.ResultURLs,
+		"urls_need_auth": task.URLsNeedAuth,
+		"cached":         task.Cached,
+		"fail_reason":    task.FailReason,
+		"quota":          task.Quota,
+		"updated_time":   task.UpdatedTime,
+		"finished_time":  task.FinishedTime,
+	})
+	return result.RowsAffected == 1, result.Error
+}
+
+// SetTaskCached records that the results of the task were downloaded to TASK_RESULT_DIR
+func SetTaskCached(id int) error {
+	return DB.Model(&Task{}).Where("id = ?", id).Update("cached", true).Error
+}
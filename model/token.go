@@ -31,8 +31,11 @@ type Token struct {
 	RemainQuota    int64   `json:"remain_quota" gorm:"bigint;default:0"`
 	UnlimitedQuota bool    `json:"unlimited_quota" gorm:"default:false"`
 	UsedQuota      int64   `json:"used_quota" gorm:"bigint;default:0"` // used quota
-	Models         *string `json:"models" gorm:"type:text"`            // allowed models
+	Models         *string `json:"models" gorm:"type:text"`            // allowed models, patterns with * and ?, prefixed with ! to deny
 	Subnet         *string `json:"subnet" gorm:"default:''"`           // allowed subnet
+	ModelQuotas    *string `json:"model_quotas" gorm:"type:text"`      // JSON object of model patterns to quota
+
+	ModelQuotaUsages []*TokenModelQuota `json:"model_quota_usages,omitempty" gorm:"-"`
 }
 
 func GetAllUserTokens(userId int, startIdx int, num int, order string) ([]*Token, error) {
@@ -141,7 +144,7 @@ func (t *Token) Insert() error {
 // Update Make sure your token's fields is completed, because this will update non-zero values
 func (t *Token) Update() error {
 	var err error
-	err = DB.Model(t).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota", "models", "subnet").Updates(t).Error
+	err = DB.Model(t).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota", "models", "subnet", "model_quotas").Updates(t).Error
 	return err
 }
 
@@ -153,6 +156,9 @@ func (t *Token) SelectUpdate() error {
 func (t *Token) Delete() error {
 	var err error
 	err = DB.Delete(t).Error
+	if err == nil {
+		err = DB.Where("token_id = ?", t.Id).Delete(&TokenModelUsage{}).Error
+	}
 	return err
 }
 
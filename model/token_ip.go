@@ -0,0 +1,72 @@
+package model
+
+import (
+	"sync"
+
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"gorm.io/gorm/clause"
+)
+
+// TokenIp is an address a token was used from, for the detection of new addresses
+type TokenIp struct {
+	Id        int    `json:"id"`
+	TokenId   int    `json:"token_id" gorm:"uniqueIndex:idx_token_ip,priority:1"`
+	Ip        string `json:"ip" gorm:"type:varchar(64);uniqueIndex:idx_token_ip,priority:2"`
+	UserId    int    `json:"user_id" gorm:"index"`
+	FirstSeen int64  `json:"first_seen" gorm:"bigint;index"`
+	LastSeen  int64  `json:"last_seen" gorm:"bigint"`
+}
+
+type tokenIpKey struct {
+	tokenId int
+	ip      string
+}
+
+var batchTokenIps = make(map[tokenIpKey]*TokenIp)
+var batchTokenIpsLock sync.Mutex
+
+// RecordTokenIp buffers the use of the token from the address, it is written by the batch updater
+func RecordTokenIp(tokenId int, userId int, ip string) {
+	now := helper.GetTimestamp()
+	key := tokenIpKey{tokenId: tokenId, ip: ip}
+	batchTokenIpsLock.Lock()
+	defer batchTokenIpsLock.Unlock()
+	if tokenIp, ok := batchTokenIps[key]; ok {
+		tokenIp.LastSeen = now
+		return
+	}
+	batchTokenIps[key] = &TokenIp{TokenId: tokenId, Ip: ip, UserId: userId, FirstSeen: now, LastSeen: now}
+}
+
+func flushTokenIps() {
+	batchTokenIpsLock.Lock()
+	tokenIps := batchTokenIps
+	batchTokenIps = make(map[tokenIpKey]*TokenIp)
+	batchTokenIpsLock.Unlock()
+	for _, tokenIp := range tokenIps {
+		result := DB.Model(&TokenIp{}).Where("token_id = ? AND ip = ?", tokenIp.TokenId, tokenIp.Ip).Update("last_seen", tokenIp.LastSeen)
+		if result.Error == nil && result.RowsAffected == 0 {
+			// another node may have created it meanwhile
+			result = DB.Clauses(clause.OnConflict{DoNothing: true}).Create(tokenIp)
+		}
+		if result.Error != nil {
+			logger.SysError("failed to save token ip: " + result.Error.Error())
+		}
+	}
+}
+
+// GetNewTokenIps returns the addresses first seen since the timestamp, of the tokens used from other
+// addresses before
+func GetNewTokenIps(since int64) ([]*TokenIp, error) {
+	var tokenIps []*TokenIp
+	err := DB.Where("first_seen >= ? AND token_id IN (?)", since,
+		DB.Model(&TokenIp{}).Select("token_id").Where("first_seen < ?", since)).Find(&tokenIps).Error
+	return tokenIps, err
+}
+
+// DeleteOldTokenIps removes the addresses not seen since the timestamp and the ones of deleted tokens
+func DeleteOldTokenIps(before int64) (int64, error) {
+	result := DB.Where("last_seen < ? OR token_id NOT IN (?)", before, DB.Model(&Token{}).Select("id")).Delete(&TokenIp{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,222 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/songquanpeng/one-api/common/logger"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TokenModelUsage is the quota a token used on the models of one pattern of its model quotas
+type TokenModelUsage struct {
+	TokenId   int    `json:"token_id" gorm:"primaryKey;autoIncrement:false"`
+	Model     string `json:"model" gorm:"primaryKey;type:varchar(191)"`
+	UsedQuota int64  `json:"used_quota" gorm:"bigint;default:0"`
+}
+
+// TokenModelQuota is a model quota of a token with its usage
+type TokenModelQuota struct {
+	Model     string `json:"model"`
+	Quota     int64  `json:"quota"`
+	UsedQuota int64  `json:"used_quota"`
+}
+
+func matchModelPattern(pattern string, name string) bool {
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
+
+// HasModelPattern reports whether the comma separated model list has a wildcard or a deny rule
+func HasModelPattern(models string) bool {
+	return strings.ContainsAny(models, "*?[!")
+}
+
+// IsModelAllowed checks the model against a comma separated list of model patterns, in the syntax of
+// path.Match like the routing rules. A pattern prefixed with ! denies the matching models, deny rules
+// take precedence over the others and a list made only of deny rules allows every other model.
+func IsModelAllowed(models string, name string) bool {
+	allowed, hasAllowRule := false, false
+	for _, item := range splitRuleList(models) {
+		if strings.HasPrefix(item, "!") {
+			if matchModelPattern(strings.TrimSpace(item[1:]), name) {
+				return false
+			}
+			continue
+		}
+		hasAllowRule = true
+		if !allowed && matchModelPattern(item, name) {
+			allowed = true
+		}
+	}
+	return allowed || !hasAllowRule
+}
+
+// FilterAllowedModels returns the models allowed by the comma separated list of model patterns
+func FilterAllowedModels(models string, names []string) []string {
+	allowed := make([]string, 0, len(names))
+	for _, name := range names {
+		if IsModelAllowed(models, name) {
+			allowed = append(allowed, name)
+		}
+	}
+	return allowed
+}
+
+func (token *Token) AllowsModel(name string) bool {
+	if token.Models == nil || *token.Models == "" {
+		return true
+	}
+	return IsModelAllowed(*token.Models, name)
+}
+
+// ValidateTokenModels checks the syntax of the model patterns of a token
+func ValidateTokenModels(models string) error {
+	for _, item := range splitRuleList(models) {
+		pattern := strings.TrimSpace(strings.TrimPrefix(item, "!"))
+		if pattern == "" {
+			return errors.New("模型不能为空")
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("无效的模型：%s", item)
+		}
+	}
+	return nil
+}
+
+// ParseTokenModelQuotas parses the model quotas of a token, a JSON object of model patterns to quota
+func ParseTokenModelQuotas(value string) (map[string]int64, error) {
+	quotas := make(map[string]int64)
+	if strings.TrimSpace(value) == "" {
+		return quotas, nil
+	}
+	if err := json.Unmarshal([]byte(value), &quotas); err != nil {
+		return nil, err
+	}
+	for pattern, quota := range quotas {
+		if strings.TrimSpace(pattern) == "" {
+			return nil, errors.New("模型不能为空")
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("无效的模型：%s", pattern)
+		}
+		if quota < 0 {
+			return nil, fmt.Errorf("模型 %s 的额度不能为负数", pattern)
+		}
+	}
+	return quotas, nil
+}
+
+// matchModelQuota returns the model quota of the token that applies to the model. An exact name
+// wins over the patterns, and the longest pattern wins among those matching.
+func (token *Token) matchModelQuota(name string) (string, int64, bool) {
+	if token.ModelQuotas == nil || *token.ModelQuotas == "" {
+		return "", 0, false
+	}
+	quotas, err := ParseTokenModelQuotas(*token.ModelQuotas)
+	if err != nil {
+		logger.SysError(fmt.Sprintf("invalid model quotas of token %d: %s", token.Id, err.Error()))
+		return "", 0, false
+	}
+	if quota, ok := quotas[name]; ok {
+		return name, quota, true
+	}
+	matched := ""
+	for pattern := range quotas {
+		if !matchModelPattern(pattern, name) {
+			continue
+		}
+		if len(pattern) > len(matched) || (len(pattern) == len(matched) && pattern < matched) {
+			matched = pattern
+		}
+	}
+	if matched == "" {
+		return "", 0, false
+	}
+	return matched, quotas[matched], true
+}
+
+func getTokenModelUsedQuota(tokenId int, pattern string) (int64, error) {
+	usage := TokenModelUsage{}
+	err := DB.Where("token_id = ? AND model = ?", tokenId, pattern).First(&usage).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	return usage.UsedQuota, err
+}
+
+// CheckModelQuota returns an error when using the quota on the model would exceed the model quota of the token
+func (token *Token) CheckModelQuota(name string, quota int64) error {
+	pattern, limit, ok := token.matchModelQuota(name)
+	if !ok {
+		return nil
+	}
+	used, err := getTokenModelUsedQuota(token.Id, pattern)
+	if err != nil {
+		return err
+	}
+	if used >= limit || used+quota > limit {
+		return fmt.Errorf("令牌在模型 %s 上的额度已用尽", name)
+	}
+	return nil
+}
+
+func CheckTokenModelQuota(tokenId int, name string, quota int64) error {
+	token, err := GetTokenById(tokenId)
+	if err != nil {
+		return err
+	}
+	return token.CheckModelQuota(name, quota)
+}
+
+// RecordTokenModelUsage adds the quota consumed on the model to the usage of the model quota of the token,
+// modelQuotas is the model quotas of the token as loaded with it for the request
+func RecordTokenModelUsage(tokenId int, modelQuotas string, name string, quota int64) {
+	if quota <= 0 || modelQuotas == "" {
+		return
+	}
+	token := &Token{Id: tokenId, ModelQuotas: &modelQuotas}
+	pattern, _, ok := token.matchModelQuota(name)
+	if !ok {
+		return
+	}
+	err := DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "token_id"}, {Name: "model"}},
+		DoUpdates: clause.Assignments(map[string]any{"used_quota": gorm.Expr("token_model_usages.used_quota + ?", quota)}),
+	}).Create(&TokenModelUsage{TokenId: tokenId, Model: pattern, UsedQuota: quota}).Error
+	if err != nil {
+		logger.SysError(fmt.Sprintf("failed to record model usage of token %d: %s", tokenId, err.Error()))
+	}
+}
+
+// GetTokenModelQuotas returns the model quotas of the token with their usage
+func GetTokenModelQuotas(token *Token) ([]*TokenModelQuota, error) {
+	result := make([]*TokenModelQuota, 0)
+	if token.ModelQuotas == nil || *token.ModelQuotas == "" {
+		return result, nil
+	}
+	quotas, err := ParseTokenModelQuotas(*token.ModelQuotas)
+	if err != nil {
+		return nil, err
+	}
+	var usages []*TokenModelUsage
+	if err = DB.Where("token_id = ?", token.Id).Find(&usages).Error; err != nil {
+		return nil, err
+	}
+	used := make(map[string]int64, len(usages))
+	for _, usage := range usages {
+		used[usage.Model] = usage.UsedQuota
+	}
+	for pattern, quota := range quotas {
+		result = append(result, &TokenModelQuota{Model: pattern, Quota: quota, UsedQuota: used[pattern]})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Model < result[j].Model
+	})
+	return result, nil
+}
@@ -0,0 +1,61 @@
+package model
+
+import "testing"
+
+func TestIsModelAllowed(t *testing.T) {
+	cases := []struct {
+		models string
+		name   string
+		want   bool
+	}{
+		{"gpt-4o*,claude-*-sonnet", "gpt-4o-mini", true},
+		{"gpt-4o*,claude-*-sonnet", "claude-3-7-sonnet", true},
+		{"gpt-4o*,claude-*-sonnet", "claude-3-opus", false},
+		{"gpt-4o*,!gpt-4o-mini", "gpt-4o-mini", false},
+		{"gpt-4o*,!gpt-4o-mini", "gpt-4o", true},
+		{"!o1*", "o1-preview", false},
+		{"!o1*", "gpt-4o", true},
+		{"gpt-4", "gpt-4-turbo", false},
+	}
+	for _, c := range cases {
+		if got := IsModelAllowed(c.models, c.name); got != c.want {
+			t.Errorf("IsModelAllowed(%q, %q) = %v, want %v", c.models, c.name, got, c.want)
+		}
+	}
+}
+
+func TestMatchModelQuota(t *testing.T) {
+	quotas := `{"o1": 100000, "gpt-4o*": 5000, "gpt-4o-mini*": 1000}`
+	token := &Token{ModelQuotas: &quotas}
+	cases := map[string]string{
+		"o1":               "o1",
+		"gpt-4o":           "gpt-4o*",
+		"gpt-4o-mini-2024": "gpt-4o-mini*",
+		"o1-mini":          "",
+	}
+	for name, want := range cases {
+		pattern, _, ok := token.matchModelQuota(name)
+		if ok != (want != "") || pattern != want {
+			t.Errorf("matchModelQuota(%q) = %q, %v, want %q", name, pattern, ok, want)
+		}
+	}
+	if _, err := ParseTokenModelQuotas(`{"o1": -1}`); err == nil {
+		t.Error("expected an error for a negative quota")
+	}
+}
+
+func TestRecordTokenModelUsage(t *testing.T) {
+	recorder := useDryRunDB(t)
+
+	RecordTokenModelUsage(1, "", "gpt-4o", 100)
+	if recorder.count() != 0 {
+		t.Fatal("the usage of a token without model quotas reads the database")
+	}
+	RecordTokenModelUsage(1, `{"gpt-4o*": 1000}`, "gpt-4o-mini", 100)
+	if recorder.find(`INSERT INTO "token_model_usages"`, `'gpt-4o*'`) == "" {
+		t.Fatal("the usage is not recorded on the matching pattern")
+	}
+	if recorder.find(`FROM "tokens"`) != "" {
+		t.Fatal("the token is loaded again to record its usage")
+	}
+}
@@ -0,0 +1,265 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/totp"
+	"gorm.io/gorm"
+)
+
+const twoFactorRecoveryCodeCount = 10
+
+// too many failed codes in a row lock the second factor of the user out, whatever the session
+const (
+	twoFactorMaxFailures   = 10
+	twoFactorLockoutPeriod = 15 * 60 // unit is second
+)
+
+// recovery codes leave out the characters easily mistaken for others
+const recoveryCodeChars = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// TwoFactor is the TOTP second factor of a user. The secret is encrypted at rest when CHANNEL_SECRET_KEYS
+// is set, stored in plaintext otherwise, and only the hashes of the unused recovery codes are stored.
+type TwoFactor struct {
+	UserId        int    `json:"user_id" gorm:"primaryKey;autoIncrement:false"`
+	Secret        string `json:"-" gorm:"type:text"`
+	Enabled       bool   `json:"enabled"`
+	RecoveryCodes string `json:"-" gorm:"type:text"` // comma separated hashes
+	LastUsedStep  int64  `json:"-" gorm:"bigint"`    // the time step of the last accepted code, codes cannot be replayed
+	EnabledTime   int64  `json:"enabled_time" gorm:"bigint"`
+	Failures      int    `json:"-" gorm:"default:0"`
+	LockedUntil   int64  `json:"-" gorm:"bigint;default:0"`
+}
+
+func (twoFactor *TwoFactor) checkLockout() error {
+	if wait := twoFactor.LockedUntil - helper.GetTimestamp(); wait > 0 {
+		return fmt.Errorf("两步验证失败次数过多，请 %d 分钟后再试", (wait+59)/60)
+	}
+	return nil
+}
+
+// recordResult counts the failed codes in a row, and locks the user out after too many
+func (twoFactor *TwoFactor) recordResult(ok bool) {
+	var updates map[string]any
+	switch {
+	case ok && twoFactor.Failures == 0:
+		return
+	case ok:
+		updates = map[string]any{"failures": 0}
+	case twoFactor.Failures+1 >= twoFactorMaxFailures:
+		updates = map[string]any{"failures": 0, "locked_until": helper.GetTimestamp() + twoFactorLockoutPeriod}
+	default:
+		updates = map[string]any{"failures": gorm.Expr("failures + 1")}
+	}
+	err := DB.Model(&TwoFactor{}).Where("user_id = ?", twoFactor.UserId).Updates(updates).Error
+	if err != nil {
+		logger.SysError("failed to record the two-factor result: " + err.Error())
+	}
+}
+
+func (twoFactor *TwoFactor) RecoveryCodesLeft() int {
+	return len(splitRuleList(twoFactor.RecoveryCodes))
+}
+
+// GetTwoFactor returns the second factor of the user, not enabled when the user never set it up
+func GetTwoFactor(userId int) (*TwoFactor, error) {
+	twoFactor := &TwoFactor{}
+	err := DB.First(twoFactor, "user_id = ?", userId).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &TwoFactor{UserId: userId}, nil
+	}
+	return twoFactor, err
+}
+
+func IsTwoFactorEnabled(userId int) bool {
+	var count int64
+	DB.Model(&TwoFactor{}).Where("user_id = ? AND enabled = ?", userId, true).Count(&count)
+	return count > 0
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomRecoveryCode() string {
+	code := make([]byte, 10)
+	for i := range code {
+		n, _ := rand.Int(rand.Reader, big.NewInt(int64(len(recoveryCodeChars))))
+		code[i] = recoveryCodeChars[n.Int64()]
+	}
+	return string(code)
+}
+
+// generateRecoveryCodes returns the codes to show to the user and their hashes to store
+func generateRecoveryCodes() ([]string, string) {
+	codes := make([]string, twoFactorRecoveryCodeCount)
+	hashes := make([]string, twoFactorRecoveryCodeCount)
+	for i := range codes {
+		code := randomRecoveryCode()
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashRecoveryCode(code)
+	}
+	return codes, strings.Join(hashes, ",")
+}
+
+// SetupTwoFactor generates a new secret for the user to enrol, it is only used once confirmed by EnableTwoFactor
+func SetupTwoFactor(userId int) (string, error) {
+	twoFactor, err := GetTwoFactor(userId)
+	if err != nil {
+		return "", err
+	}
+	if twoFactor.Enabled {
+		return "", errors.New("两步验证已启用")
+	}
+	secret := totp.GenerateSecret()
+	twoFactor.Secret, err = common.EncryptSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	twoFactor.RecoveryCodes = ""
+	twoFactor.LastUsedStep = 0
+	return secret, DB.Save(twoFactor).Error
+}
+
+// EnableTwoFactor enables the secret set up when the code matches it, and returns the recovery codes
+func EnableTwoFactor(userId int, code string) ([]string, error) {
+	twoFactor, err := GetTwoFactor(userId)
+	if err != nil {
+		return nil, err
+	}
+	if twoFactor.Enabled {
+		return nil, errors.New("两步验证已启用")
+	}
+	if twoFactor.Secret == "" {
+		return nil, errors.New("请先生成两步验证密钥")
+	}
+	secret, err := common.DecryptSecret(twoFactor.Secret)
+	if err != nil {
+		return nil, err
+	}
+	step, ok := totp.Validate(secret, code, time.Now(), twoFactor.LastUsedStep)
+	if !ok {
+		return nil, errors.New("验证码错误")
+	}
+	codes, hashes := generateRecoveryCodes()
+	err = DB.Model(twoFactor).Updates(map[string]any{
+		"enabled":        true,
+		"recovery_codes": hashes,
+		"last_used_step": step,
+		"enabled_time":   helper.GetTimestamp(),
+	}).Error
+	return codes, err
+}
+
+// VerifyTwoFactor checks a TOTP code, or else consumes a recovery code. It tells whether a
+// recovery code was used.
+func VerifyTwoFactor(userId int, code string) (bool, error) {
+	twoFactor, err := GetTwoFactor(userId)
+	if err != nil {
+		return false, err
+	}
+	if !twoFactor.Enabled {
+		return false, errors.New("未启用两步验证")
+	}
+	if err = twoFactor.checkLockout(); err != nil {
+		return false, err
+	}
+	usedRecoveryCode, err := verifyTwoFactor(twoFactor, code)
+	twoFactor.recordResult(err == nil)
+	return usedRecoveryCode, err
+}
+
+// CheckTwoFactorCode checks a TOTP code sent along an access token or a management key. The code is not
+// consumed, a script may send several requests with it during its time step.
+func CheckTwoFactorCode(userId int, code string) error {
+	twoFactor, err := GetTwoFactor(userId)
+	if err != nil {
+		return err
+	}
+	if !twoFactor.Enabled {
+		return errors.New("未启用两步验证")
+	}
+	if err = twoFactor.checkLockout(); err != nil {
+		return err
+	}
+	secret, err := common.DecryptSecret(twoFactor.Secret)
+	if err != nil {
+		return err
+	}
+	_, ok := totp.Validate(secret, code, time.Now(), 0)
+	twoFactor.recordResult(ok)
+	if !ok {
+		return errors.New("验证码错误")
+	}
+	return nil
+}
+
+func verifyTwoFactor(twoFactor *TwoFactor, code string) (bool, error) {
+	userId := twoFactor.UserId
+	secret, err := common.DecryptSecret(twoFactor.Secret)
+	if err != nil {
+		return false, err
+	}
+	if step, ok := totp.Validate(secret, code, time.Now(), twoFactor.LastUsedStep); ok {
+		// the condition keeps a code from being accepted by two concurrent requests
+		result := DB.Model(&TwoFactor{}).Where("user_id = ? AND last_used_step < ?", userId, step).Update("last_used_step", step)
+		if result.Error != nil {
+			return false, result.Error
+		}
+		if result.RowsAffected == 1 {
+			return false, nil
+		}
+		return false, errors.New("验证码错误")
+	}
+	hash := hashRecoveryCode(code)
+	hashes := splitRuleList(twoFactor.RecoveryCodes)
+	for i, item := range hashes {
+		if item != hash {
+			continue
+		}
+		remaining := strings.Join(append(hashes[:i:i], hashes[i+1:]...), ",")
+		result := DB.Model(&TwoFactor{}).Where("user_id = ? AND recovery_codes = ?", userId, twoFactor.RecoveryCodes).Update("recovery_codes", remaining)
+		if result.Error != nil {
+			return false, result.Error
+		}
+		if result.RowsAffected == 1 {
+			return true, nil
+		}
+		break
+	}
+	return false, errors.New("验证码错误")
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the user
+func RegenerateRecoveryCodes(userId int) ([]string, error) {
+	codes, hashes := generateRecoveryCodes()
+	result := DB.Model(&TwoFactor{}).Where("user_id = ? AND enabled = ?", userId, true).Update("recovery_codes", hashes)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, errors.New("未启用两步验证")
+	}
+	return codes, nil
+}
+
+func DisableTwoFactor(userId int) error {
+	return DB.Where("user_id = ?", userId).Delete(&TwoFactor{}).Error
+}
+
+// RecordTwoFactorEvent logs an event of the second factor of the user, in the logs of the user
+func RecordTwoFactorEvent(userId int, ip string, event string) {
+	RecordLog(userId, LogTypeSystem, "两步验证："+event+"，IP："+ip)
+}
@@ -0,0 +1,43 @@
+package model
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/songquanpeng/one-api/common/helper"
+)
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, hashes := generateRecoveryCodes()
+	hashList := strings.Split(hashes, ",")
+	if len(codes) != twoFactorRecoveryCodeCount || len(hashList) != twoFactorRecoveryCodeCount {
+		t.Fatalf("expected %d codes, got %d and %d hashes", twoFactorRecoveryCodeCount, len(codes), len(hashList))
+	}
+	for i, code := range codes {
+		if hashRecoveryCode(strings.ToUpper(code)) != hashList[i] || hashRecoveryCode(strings.ReplaceAll(code, "-", "")) != hashList[i] {
+			t.Fatalf("expected the hash of %s to ignore case and dashes", code)
+		}
+	}
+}
+
+func TestTwoFactorLockout(t *testing.T) {
+	recorder := useDryRunDB(t)
+	twoFactor := &TwoFactor{UserId: 3, Enabled: true, Failures: twoFactorMaxFailures - 2}
+	twoFactor.recordResult(false)
+	if recorder.find(`"failures"=failures + 1`) == "" {
+		t.Fatalf("expected the failure to be counted, got %v", recorder.statements)
+	}
+	twoFactor.Failures++
+	twoFactor.recordResult(false)
+	if recorder.find(`"locked_until"=`) == "" {
+		t.Fatalf("expected the user to be locked out, got %v", recorder.statements)
+	}
+	twoFactor.LockedUntil = helper.GetTimestamp() + twoFactorLockoutPeriod
+	if err := twoFactor.checkLockout(); err == nil {
+		t.Fatal("expected the codes to be refused while locked out")
+	}
+	twoFactor.LockedUntil = helper.GetTimestamp() - 1
+	if err := twoFactor.checkLockout(); err != nil {
+		t.Fatalf("expected the lockout to end, got %v", err)
+	}
+}
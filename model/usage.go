@@ -1,20 +1,29 @@
 package model
 
 import (
+	"fmt"
+	"sort"
 	"strconv"
 	"time"
+
+	"gorm.io/gorm"
 )
 
 type Usage struct {
-	Id           int    `json:"id"`
-	UserId       int    `json:"user_id" gorm:"index:idx_user_hour,priority:1"`
-	Hour         int    `json:"hour" gorm:"index:idx_user_hour,priority:2"`
-	ModelName    string `json:"model_name"`
-	TokenName    string `json:"token_name"`
-	Count        int    `json:"count"`
-	InputTokens  int    `json:"input_tokens"`
-	OutputTokens int    `json:"output_tokens"`
-	Quota        int    `json:"quota" gorm:"default:0"`
+	Id               int    `json:"id"`
+	UserId           int    `json:"user_id" gorm:"index:idx_user_hour,priority:1"`
+	Hour             int    `json:"hour" gorm:"index:idx_user_hour,priority:2"`
+	ModelName        string `json:"model_name"`
+	TokenName        string `json:"token_name"`
+	ChannelType      int    `json:"channel_type" gorm:"default:0"`
+	Count            int    `json:"count"`
+	ErrorCount       int    `json:"error_count" gorm:"default:0"`
+	InputTokens      int    `json:"input_tokens"`
+	CachedTokens     int    `json:"cached_tokens" gorm:"default:0"`
+	CacheWriteTokens int    `json:"cache_write_tokens" gorm:"default:0"`
+	OutputTokens     int    `json:"output_tokens"`
+	Quota            int    `json:"quota" gorm:"default:0"`
+	Duration         int64  `json:"duration" gorm:"default:0"` // the latency of the counted requests summed, unit is millisecond
 }
 
 func getHour() int {
@@ -24,34 +33,34 @@ func getHour() int {
 	return hour
 }
 
-func AddUsage(userId int, modelName string, hour int, tokenName string, count int, inputTokens int, outputTokens int, quota int) error {
-	if hour == 0 {
-		hour = getHour()
+func getHourOf(timestamp int64) int {
+	// yyyyMMddHH
+	hour, _ := strconv.Atoi(time.Unix(timestamp, 0).Format("2006010215"))
+	return hour
+}
+
+// AddUsage adds the counters of the usage to the row of its user, model, token, channel type and hour,
+// the row is only created when the update finds nothing
+func AddUsage(usage *Usage) error {
+	if usage.Hour == 0 {
+		usage.Hour = getHour()
 	}
-	var usage Usage
-	err := DB.Where("user_id = ? AND model_name = ? AND token_name = ? AND hour = ?", userId, modelName, tokenName, hour).First(&usage).Error
-	if err != nil {
-		// not found, insert
-		usage = Usage{
-			UserId:       userId,
-			Hour:         hour,
-			ModelName:    modelName,
-			TokenName:    tokenName,
-			Count:        count,
-			InputTokens:  inputTokens,
-			OutputTokens: outputTokens,
-			Quota:        quota,
-		}
-		err = DB.Create(&usage).Error
-	} else {
-		// found, update
-		usage.Count += count
-		usage.InputTokens += inputTokens
-		usage.OutputTokens += outputTokens
-		usage.Quota += quota
-		err = DB.Save(&usage).Error
+	result := DB.Model(&Usage{}).Where("user_id = ? AND model_name = ? AND token_name = ? AND channel_type = ? AND hour = ?",
+		usage.UserId, usage.ModelName, usage.TokenName, usage.ChannelType, usage.Hour).Updates(map[string]any{
+		"count":              gorm.Expr("count + ?", usage.Count),
+		"error_count":        gorm.Expr("error_count + ?", usage.ErrorCount),
+		"input_tokens":       gorm.Expr("input_tokens + ?", usage.InputTokens),
+		"cached_tokens":      gorm.Expr("cached_tokens + ?", usage.CachedTokens),
+		"cache_write_tokens": gorm.Expr("cache_write_tokens + ?", usage.CacheWriteTokens),
+		"output_tokens":      gorm.Expr("output_tokens + ?", usage.OutputTokens),
+		"quota":              gorm.Expr("quota + ?", usage.Quota),
+		"duration":           gorm.Expr("duration + ?", usage.Duration),
+	})
+	if result.Error != nil || result.RowsAffected > 0 {
+		return result.Error
 	}
-	return err
+	usage.Id = 0
+	return DB.Create(usage).Error
 }
 
 func GetUsage(userId int, modelName string, tokenName string, startHour int, endHour int) ([]Usage, error) {
@@ -66,3 +75,200 @@ func GetUsage(userId int, modelName string, tokenName string, startHour int, end
 	err := query.Find(&usages).Error
 	return usages, err
 }
+
+// UsageTokens are the tokens of the usage rows summed
+type UsageTokens struct {
+	InputTokens      int64 `json:"input_tokens"`
+	CachedTokens     int64 `json:"cached_tokens"`
+	CacheWriteTokens int64 `json:"cache_write_tokens"`
+	OutputTokens     int64 `json:"output_tokens"`
+}
+
+// SumUsageTokens sums the tokens of a user, or of one of its tokens, from startHour until before endHour
+func SumUsageTokens(userId int, tokenName string, startHour int, endHour int) (*UsageTokens, error) {
+	query := DB.Model(&Usage{}).Where("user_id = ? AND hour >= ? AND hour < ?", userId, startHour, endHour)
+	if tokenName != "" {
+		query = query.Where("token_name = ?", tokenName)
+	}
+	tokens := UsageTokens{}
+	err := query.Select("COALESCE(SUM(input_tokens), 0) AS input_tokens, COALESCE(SUM(cached_tokens), 0) AS cached_tokens, " +
+		"COALESCE(SUM(cache_write_tokens), 0) AS cache_write_tokens, COALESCE(SUM(output_tokens), 0) AS output_tokens").Scan(&tokens).Error
+	return &tokens, err
+}
+
+const (
+	UsageGranularityHour  = "hour"
+	UsageGranularityDay   = "day"
+	UsageGranularityMonth = "month"
+)
+
+// usageGroupColumns are the columns the usage can be broken down by
+var usageGroupColumns = map[string]string{
+	"model":        "model_name",
+	"token":        "token_name",
+	"channel_type": "channel_type",
+}
+
+// UsageFilter selects the usage of the users, from StartHour to EndHour included
+type UsageFilter struct {
+	UserIds   []int
+	ModelName string
+	TokenName string
+	StartHour int
+	EndHour   int
+}
+
+// UsageStat is the usage summed over a period and a key of the breakdown
+type UsageStat struct {
+	Period           string  `json:"period,omitempty"`
+	Key              string  `json:"key,omitempty"`
+	Requests         int64   `json:"requests"`
+	Errors           int64   `json:"errors"`
+	InputTokens      int64   `json:"input_tokens"`
+	CachedTokens     int64   `json:"cached_tokens"`
+	CacheWriteTokens int64   `json:"cache_write_tokens"`
+	OutputTokens     int64   `json:"output_tokens"`
+	Quota            int64   `json:"quota"`
+	ErrorRate        float64 `json:"error_rate"`
+	AvgLatency       float64 `json:"avg_latency"` // unit is millisecond
+	duration         int64
+}
+
+type usageStatRow struct {
+	Hour             int
+	GroupKey         string
+	Count            int64
+	ErrorCount       int64
+	InputTokens      int64
+	CachedTokens     int64
+	CacheWriteTokens int64
+	OutputTokens     int64
+	Quota            int64
+	Duration         int64
+}
+
+func (stat *UsageStat) add(row *usageStatRow) {
+	stat.Requests += row.Count + row.ErrorCount
+	stat.Errors += row.ErrorCount
+	stat.InputTokens += row.InputTokens
+	stat.CachedTokens += row.CachedTokens
+	stat.CacheWriteTokens += row.CacheWriteTokens
+	stat.OutputTokens += row.OutputTokens
+	stat.Quota += row.Quota
+	stat.duration += row.Duration
+}
+
+func (stat *UsageStat) finish() {
+	if stat.Requests > 0 {
+		stat.ErrorRate = float64(stat.Errors) / float64(stat.Requests)
+	}
+	if succeeded := stat.Requests - stat.Errors; succeeded > 0 {
+		stat.AvgLatency = float64(stat.duration) / float64(succeeded)
+	}
+}
+
+// UsagePeriod returns the period of the granularity an hour of the usage belongs to
+func UsagePeriod(hour int, granularity string) string {
+	t, err := time.ParseInLocation("2006010215", strconv.Itoa(hour), time.Local)
+	if err != nil {
+		return strconv.Itoa(hour)
+	}
+	switch granularity {
+	case UsageGranularityMonth:
+		return t.Format("2006-01")
+	case UsageGranularityDay:
+		return t.Format("2006-01-02")
+	default:
+		return t.Format("2006-01-02 15:00")
+	}
+}
+
+// UsageHourOf returns the hour of the usage a timestamp belongs to
+func UsageHourOf(timestamp int64) int {
+	return getHourOf(timestamp)
+}
+
+func getUsageStatRows(filter *UsageFilter, groupBy string) ([]*usageStatRow, error) {
+	groupColumn := "''"
+	if groupBy != "" {
+		column, ok := usageGroupColumns[groupBy]
+		if !ok {
+			return nil, fmt.Errorf("不支持的分组维度：%s", groupBy)
+		}
+		groupColumn = column
+	}
+	query := DB.Model(&Usage{}).Where("user_id IN ? AND hour >= ? AND hour <= ?", filter.UserIds, filter.StartHour, filter.EndHour)
+	if filter.ModelName != "" {
+		query = query.Where("model_name = ?", filter.ModelName)
+	}
+	if filter.TokenName != "" {
+		query = query.Where("token_name = ?", filter.TokenName)
+	}
+	var rows []*usageStatRow
+	err := query.Select("hour, " + groupColumn + " AS group_key, SUM(count) AS count, SUM(error_count) AS error_count, " +
+		"SUM(input_tokens) AS input_tokens, SUM(cached_tokens) AS cached_tokens, SUM(cache_write_tokens) AS cache_write_tokens, " +
+		"SUM(output_tokens) AS output_tokens, SUM(quota) AS quota, SUM(duration) AS duration").
+		Group("hour, " + groupColumn).Scan(&rows).Error
+	return rows, err
+}
+
+// foldUsageStats sums the rows by the period and the key, the stats are ordered by period then key
+func foldUsageStats(rows []*usageStatRow, period func(hour int) string) []*UsageStat {
+	type statKey struct{ period, key string }
+	stats := make(map[statKey]*UsageStat)
+	for _, row := range rows {
+		key := statKey{period: period(row.Hour), key: row.GroupKey}
+		stat, ok := stats[key]
+		if !ok {
+			stat = &UsageStat{Period: key.period, Key: key.key}
+			stats[key] = stat
+		}
+		stat.add(row)
+	}
+	result := make([]*UsageStat, 0, len(stats))
+	for _, stat := range stats {
+		stat.finish()
+		result = append(result, stat)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Period != result[j].Period {
+			return result[i].Period < result[j].Period
+		}
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
+
+// UsageReport is the usage of a range as a time series, its top keys and its total
+type UsageReport struct {
+	Series []*UsageStat `json:"series"`
+	Top    []*UsageStat `json:"top"`
+	Total  *UsageStat   `json:"total"`
+}
+
+// GetUsageReport returns the usage of the filter at the granularity, broken down by groupBy when it is
+// not empty, with the topN keys of the range by quota
+func GetUsageReport(filter *UsageFilter, granularity string, groupBy string, topN int) (*UsageReport, error) {
+	rows, err := getUsageStatRows(filter, groupBy)
+	if err != nil {
+		return nil, err
+	}
+	return buildUsageReport(rows, granularity, topN), nil
+}
+
+func buildUsageReport(rows []*usageStatRow, granularity string, topN int) *UsageReport {
+	report := &UsageReport{
+		Series: foldUsageStats(rows, func(hour int) string { return UsagePeriod(hour, granularity) }),
+		Top:    foldUsageStats(rows, func(int) string { return "" }),
+		Total:  &UsageStat{},
+	}
+	for _, row := range rows {
+		report.Total.add(row)
+	}
+	report.Total.finish()
+	sort.SliceStable(report.Top, func(i, j int) bool { return report.Top[i].Quota > report.Top[j].Quota })
+	if len(report.Top) > topN {
+		report.Top = report.Top[:topN]
+	}
+	return report
+}
@@ -0,0 +1,28 @@
+package model
+
+import "testing"
+
+func TestBuildUsageReport(t *testing.T) {
+	rows := []*usageStatRow{
+		{Hour: 2025030110, GroupKey: "gpt-4o", Count: 3, ErrorCount: 1, InputTokens: 100, Quota: 300, Duration: 900},
+		{Hour: 2025030123, GroupKey: "gpt-4o", Count: 1, Quota: 100, Duration: 100},
+		{Hour: 2025030201, GroupKey: "claude-sonnet-4", Count: 2, Quota: 1000, Duration: 400},
+	}
+	report := buildUsageReport(rows, UsageGranularityDay, 1)
+	if len(report.Series) != 2 || report.Series[0].Period != "2025-03-01" || report.Series[0].Key != "gpt-4o" {
+		t.Fatalf("unexpected series %+v", report.Series)
+	}
+	day := report.Series[0]
+	if day.Requests != 5 || day.Errors != 1 || day.Quota != 400 || day.ErrorRate != 0.2 || day.AvgLatency != 250 {
+		t.Fatalf("unexpected daily usage %+v", day)
+	}
+	if len(report.Top) != 1 || report.Top[0].Key != "claude-sonnet-4" {
+		t.Fatalf("unexpected top %+v", report.Top)
+	}
+	if report.Total.Requests != 7 || report.Total.Quota != 1400 {
+		t.Fatalf("unexpected total %+v", report.Total)
+	}
+	if period := UsagePeriod(2025030201, UsageGranularityMonth); period != "2025-03" {
+		t.Fatalf("unexpected month %s", period)
+	}
+}
@@ -3,6 +3,7 @@ package model
 import (
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/go-playground/validator/v10"
@@ -66,7 +67,7 @@ func GetMaxUserId() int {
 }
 
 func GetAllUsers(startIdx int, num int, order string) (users []*User, err error) {
-	query := DB.Limit(num).Offset(startIdx).Omit("password").Where("status != ?", UserStatusDeleted)
+	query := DB.Limit(num).Offset(startIdx).Omit("password", "access_token").Where("status != ?", UserStatusDeleted)
 
 	switch order {
 	case "quota":
@@ -85,9 +86,9 @@ func GetAllUsers(startIdx int, num int, order string) (users []*User, err error)
 
 func SearchUsers(keyword string) (users []*User, err error) {
 	if !common.UsingPostgreSQL {
-		err = DB.Omit("password").Where("id = ? or username LIKE ? or email LIKE ? or display_name LIKE ?", keyword, keyword+"%", keyword+"%", keyword+"%").Find(&users).Error
+		err = DB.Omit("password", "access_token").Where("id = ? or username LIKE ? or email LIKE ? or display_name LIKE ?", keyword, keyword+"%", keyword+"%", keyword+"%").Find(&users).Error
 	} else {
-		err = DB.Omit("password").Where("username LIKE ? or email LIKE ? or display_name LIKE ?", keyword+"%", keyword+"%", keyword+"%").Find(&users).Error
+		err = DB.Omit("password", "access_token").Where("username LIKE ? or email LIKE ? or display_name LIKE ?", keyword+"%", keyword+"%", keyword+"%").Find(&users).Error
 	}
 	return users, err
 }
@@ -197,6 +198,25 @@ func (user *User) Update(updatePassword bool) error {
 	return err
 }
 
+// UpdateGroupAndRole saves the group and the role given by the identity provider, when they changed
+func (user *User) UpdateGroupAndRole(group string, role int) error {
+	if user.Group == group && user.Role == role {
+		return nil
+	}
+	err := DB.Model(&User{}).Where("id = ?", user.Id).Updates(map[string]any{
+		"group": group,
+		"role":  role,
+	}).Error
+	if err != nil {
+		return err
+	}
+	RecordLog(user.Id, LogTypeManage, fmt.Sprintf("身份提供方将分组从 %s 改为 %s，角色从 %d 改为 %d", user.Group, group, user.Role, role))
+	user.Group = group
+	user.Role = role
+	UserGroupCache.Del([]byte(strconv.Itoa(user.Id)))
+	return nil
+}
+
 func (user *User) Delete() error {
 	if user.Id == 0 {
 		return errors.New("id 为空！")
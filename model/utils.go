@@ -1,11 +1,12 @@
 package model
 
 import (
-	"github.com/songquanpeng/one-api/common/config"
-	"github.com/songquanpeng/one-api/common/logger"
-	"strconv"
+	"fmt"
 	"sync"
 	"time"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
 )
 
 const (
@@ -14,102 +15,258 @@ const (
 	BatchUpdateTypeUsedQuota
 	BatchUpdateTypeChannelUsedQuota
 	BatchUpdateTypeRequestCount
+	BatchUpdateTypeChannelKeyRequestCount
 	BatchUpdateTypeCount // if you add a new type, you need to add a new map and a new lock
 )
 
+var batchUpdateTypeNames = []string{"user_quota", "token_quota", "used_quota", "channel_used_quota", "request_count", "channel_key_request_count"}
+
+// logs failing to insert are put back into the buffer, up to this many times the flush threshold
+const batchLogsRetryFactor = 10
+
+type usageKey struct {
+	userId      int
+	modelName   string
+	tokenName   string
+	channelType int
+	hour        int
+}
+
+// BatchUpdaterStats is the depth of the write-behind buffer and the result of the last flushes
+type BatchUpdaterStats struct {
+	QuotaBatchEnabled bool           `json:"quota_batch_enabled"`
+	Interval          int            `json:"interval"`
+	MaxSize           int            `json:"max_size"`
+	PendingLogs       int            `json:"pending_logs"`
+	PendingUsages     int            `json:"pending_usages"`
+	PendingRecords    map[string]int `json:"pending_records"`
+	LastFlushTime     int64          `json:"last_flush_time"`
+	LastFlushDuration int64          `json:"last_flush_duration"` // unit is millisecond
+	FlushCount        int64          `json:"flush_count"`
+	FlushedLogs       int64          `json:"flushed_logs"`
+	DroppedLogs       int64          `json:"dropped_logs"`
+}
+
 var batchUpdateStores []map[int]int64
 var batchUpdateLocks []sync.Mutex
 var batchLogs []*Log
+var batchUsages map[usageKey]*Usage
 var batchLogsLock sync.Mutex
 
+var batchFlushLock sync.Mutex
+var batchFlushSignal = make(chan struct{}, 1)
+var batchUpdaterStop = make(chan chan struct{})
+var batchUpdaterStarted = false
+var batchUpdaterStats = BatchUpdaterStats{}
+
 func init() {
 	for i := 0; i < BatchUpdateTypeCount; i++ {
 		batchUpdateStores = append(batchUpdateStores, make(map[int]int64))
 		batchUpdateLocks = append(batchUpdateLocks, sync.Mutex{})
 	}
 	batchLogs = make([]*Log, 0)
+	batchUsages = make(map[usageKey]*Usage)
 }
 
+// InitBatchUpdater starts the write-behind flusher. Logs and usages are always buffered,
+// quota deltas only when config.BatchUpdateEnabled is set. The buffer is flushed every
+// config.BatchUpdateInterval seconds, or earlier once it holds config.BatchUpdateMaxSize entries.
 func InitBatchUpdater() {
+	batchUpdaterStarted = true
 	go func() {
+		ticker := time.NewTicker(time.Duration(config.BatchUpdateInterval) * time.Second)
+		defer ticker.Stop()
 		for {
-			time.Sleep(time.Duration(config.BatchUpdateInterval) * time.Second)
-			batchUpdate()
-			batchInsert()
+			select {
+			case <-ticker.C:
+			case <-batchFlushSignal:
+			case done := <-batchUpdaterStop:
+				flushBatchUpdater()
+				close(done)
+				return
+			}
+			flushBatchUpdater()
 		}
 	}()
 }
 
+// StopBatchUpdater drains the buffer and stops the flusher, it is called on shutdown
+// after the HTTP server stopped accepting requests
+func StopBatchUpdater() {
+	if !batchUpdaterStarted {
+		flushBatchUpdater()
+		return
+	}
+	done := make(chan struct{})
+	batchUpdaterStop <- done
+	<-done
+	batchUpdaterStarted = false
+}
+
+func flushBatchUpdater() {
+	batchFlushLock.Lock()
+	defer batchFlushLock.Unlock()
+	start := time.Now()
+	batchUpdate()
+	batchInsert()
+	flushTokenIps()
+	batchLogsLock.Lock()
+	batchUpdaterStats.LastFlushTime = start.Unix()
+	batchUpdaterStats.LastFlushDuration = time.Since(start).Milliseconds()
+	batchUpdaterStats.FlushCount++
+	batchLogsLock.Unlock()
+}
+
+// requestBatchFlush wakes up the flusher without waiting for the interval
+func requestBatchFlush() {
+	select {
+	case batchFlushSignal <- struct{}{}:
+	default:
+	}
+}
+
 func addNewRecord(type_ int, id int, value int64) {
 	batchUpdateLocks[type_].Lock()
-	defer batchUpdateLocks[type_].Unlock()
 	if _, ok := batchUpdateStores[type_][id]; !ok {
 		batchUpdateStores[type_][id] = value
 	} else {
 		batchUpdateStores[type_][id] += value
 	}
+	full := len(batchUpdateStores[type_]) >= config.BatchUpdateMaxSize
+	batchUpdateLocks[type_].Unlock()
+	if full {
+		requestBatchFlush()
+	}
 }
 
+// addNewLog buffers the log and aggregates it into the usage of its user, model, token, channel type and hour
 func addNewLog(log *Log) {
+	key := usageKey{
+		userId:      log.UserId,
+		modelName:   log.ModelName,
+		tokenName:   log.TokenName,
+		channelType: log.ChannelType,
+		hour:        getHourOf(log.CreatedAt),
+	}
 	batchLogsLock.Lock()
-	defer batchLogsLock.Unlock()
 	batchLogs = append(batchLogs, log)
+	usage := getBatchUsage(key)
+	usage.Count++
+	usage.InputTokens += log.PromptTokens
+	usage.CachedTokens += log.CachedTokens
+	usage.CacheWriteTokens += log.CacheWriteTokens
+	usage.OutputTokens += log.CompletionTokens
+	usage.Quota += log.Quota
+	usage.Duration += log.Duration
+	full := len(batchLogs) >= config.BatchUpdateMaxSize
+	batchLogsLock.Unlock()
+	if full {
+		requestBatchFlush()
+	}
+}
+
+// RecordUsageError counts a request failing after all retries into the usage of its user, model, token,
+// channel type and hour, for the error rate
+func RecordUsageError(userId int, modelName string, tokenName string, channelType int) {
+	batchLogsLock.Lock()
+	getBatchUsage(usageKey{
+		userId:      userId,
+		modelName:   modelName,
+		tokenName:   tokenName,
+		channelType: channelType,
+		hour:        getHour(),
+	}).ErrorCount++
+	batchLogsLock.Unlock()
+}
+
+// getBatchUsage returns the buffered usage of the key, batchLogsLock must be held
+func getBatchUsage(key usageKey) *Usage {
+	usage, ok := batchUsages[key]
+	if !ok {
+		usage = &Usage{
+			UserId:      key.userId,
+			Hour:        key.hour,
+			ModelName:   key.modelName,
+			TokenName:   key.tokenName,
+			ChannelType: key.channelType,
+		}
+		batchUsages[key] = usage
+	}
+	return usage
 }
 
 func batchInsert() {
-	logger.SysLog("batch insert started")
 	batchLogsLock.Lock()
 	logs := batchLogs
+	usages := batchUsages
 	batchLogs = make([]*Log, 0)
+	batchUsages = make(map[usageKey]*Usage)
 	batchLogsLock.Unlock()
-	err := LOG_DB.CreateInBatches(logs, 100).Error
-
-	location, _ := time.LoadLocation("Asia/Shanghai") // Beijing time zone
-	startTime := time.Date(2024, 12, 1, 21, 10, 0, 0, location)
-	if time.Now().Before(startTime) {
+	if len(logs) == 0 && len(usages) == 0 {
 		return
 	}
-	usages := make(map[string]*Usage, 0)
-	for _, log := range logs {
-		key := strconv.Itoa(log.UserId) + log.ModelName + log.TokenName
-		if _, ok := usages[key]; !ok {
-			usages[key] = &Usage{
-				UserId:       log.UserId,
-				Hour:         getHour(),
-				ModelName:    log.ModelName,
-				TokenName:    log.TokenName,
-				Count:        0,
-				InputTokens:  0,
-				OutputTokens: 0,
-				Quota:        0,
-			}
-		}
-		usage := usages[key]
-		usage.Count++
-		usage.InputTokens += log.PromptTokens
-		usage.OutputTokens += log.CompletionTokens
-		usage.Quota += log.Quota
+
+	err := LOG_DB.CreateInBatches(logs, 100).Error
+	if err != nil {
+		logger.SysError("failed to batch insert logs: " + err.Error())
+		requeueLogs(logs)
+	} else {
+		batchLogsLock.Lock()
+		batchUpdaterStats.FlushedLogs += int64(len(logs))
+		batchLogsLock.Unlock()
 	}
 	for _, usage := range usages {
-		err = AddUsage(usage.UserId, usage.ModelName, usage.Hour, usage.TokenName, usage.Count, usage.InputTokens, usage.OutputTokens, usage.Quota)
+		err = AddUsage(usage)
 		if err != nil {
 			logger.SysError("failed to add usage: " + err.Error())
 		}
 	}
+}
 
-	if err != nil {
-		logger.SysError("failed to batch insert logs: " + err.Error())
+// requeueLogs puts logs failing to insert back into the buffer for the next flush,
+// their usages are already counted so only the logs themselves are kept
+func requeueLogs(logs []*Log) {
+	batchLogsLock.Lock()
+	defer batchLogsLock.Unlock()
+	capacity := config.BatchUpdateMaxSize*batchLogsRetryFactor - len(batchLogs)
+	if capacity < 0 {
+		capacity = 0
+	}
+	if len(logs) > capacity {
+		dropped := len(logs) - capacity
+		batchUpdaterStats.DroppedLogs += int64(dropped)
+		logger.SysError(fmt.Sprintf("log buffer is full, %d logs dropped", dropped))
+		logs = logs[dropped:]
 	}
+	batchLogs = append(logs, batchLogs...)
 }
 
 func batchUpdate() {
-	logger.SysLog("batch update started")
+	// the used quota and the request count of a user are written by one statement
+	batchUpdateLocks[BatchUpdateTypeUsedQuota].Lock()
+	usedQuotas := batchUpdateStores[BatchUpdateTypeUsedQuota]
+	batchUpdateStores[BatchUpdateTypeUsedQuota] = make(map[int]int64)
+	batchUpdateLocks[BatchUpdateTypeUsedQuota].Unlock()
+	batchUpdateLocks[BatchUpdateTypeRequestCount].Lock()
+	requestCounts := batchUpdateStores[BatchUpdateTypeRequestCount]
+	batchUpdateStores[BatchUpdateTypeRequestCount] = make(map[int]int64)
+	batchUpdateLocks[BatchUpdateTypeRequestCount].Unlock()
+	for id, quota := range usedQuotas {
+		updateUserUsedQuotaAndRequestCount(id, quota, int(requestCounts[id]))
+		delete(requestCounts, id)
+	}
+	for id, count := range requestCounts {
+		updateUserRequestCount(id, int(count))
+	}
+
 	for i := 0; i < BatchUpdateTypeCount; i++ {
+		if i == BatchUpdateTypeUsedQuota || i == BatchUpdateTypeRequestCount {
+			continue
+		}
 		batchUpdateLocks[i].Lock()
 		store := batchUpdateStores[i]
 		batchUpdateStores[i] = make(map[int]int64)
 		batchUpdateLocks[i].Unlock()
-		// TODO: maybe we can combine updates with same key?
 		for key, value := range store {
 			switch i {
 			case BatchUpdateTypeUserQuota:
@@ -122,14 +279,31 @@ func batchUpdate() {
 				if err != nil {
 					logger.SysError("failed to batch update token quota: " + err.Error())
 				}
-			case BatchUpdateTypeUsedQuota:
-				updateUserUsedQuota(key, value)
-			case BatchUpdateTypeRequestCount:
-				updateUserRequestCount(key, int(value))
 			case BatchUpdateTypeChannelUsedQuota:
 				updateChannelUsedQuota(key, value)
+			case BatchUpdateTypeChannelKeyRequestCount:
+				updateChannelKeyRequestCount(key, value)
 			}
 		}
 	}
-	logger.SysLog("batch update finished")
+}
+
+// GetBatchUpdaterStats returns the current depth of the write-behind buffer
+func GetBatchUpdaterStats() BatchUpdaterStats {
+	pendingRecords := make(map[string]int, BatchUpdateTypeCount)
+	for i := 0; i < BatchUpdateTypeCount; i++ {
+		batchUpdateLocks[i].Lock()
+		pendingRecords[batchUpdateTypeNames[i]] = len(batchUpdateStores[i])
+		batchUpdateLocks[i].Unlock()
+	}
+	batchLogsLock.Lock()
+	stats := batchUpdaterStats
+	stats.PendingLogs = len(batchLogs)
+	stats.PendingUsages = len(batchUsages)
+	batchLogsLock.Unlock()
+	stats.QuotaBatchEnabled = config.BatchUpdateEnabled
+	stats.Interval = config.BatchUpdateInterval
+	stats.MaxSize = config.BatchUpdateMaxSize
+	stats.PendingRecords = pendingRecords
+	return stats
 }
@@ -0,0 +1,50 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/config"
+)
+
+func TestAddNewLogAggregatesUsage(t *testing.T) {
+	maxSize := config.BatchUpdateMaxSize
+	t.Cleanup(func() { config.BatchUpdateMaxSize = maxSize })
+	config.BatchUpdateMaxSize = 3
+	hour := time.Date(2025, 3, 1, 10, 30, 0, 0, time.Local).Unix()
+	addNewLog(&Log{UserId: 1, ModelName: "gpt-4o", TokenName: "a", CreatedAt: hour, PromptTokens: 10, CompletionTokens: 5, Quota: 100})
+	addNewLog(&Log{UserId: 1, ModelName: "gpt-4o", TokenName: "a", CreatedAt: hour + 60, PromptTokens: 20, CachedTokens: 4, CacheWriteTokens: 6, CompletionTokens: 5, Quota: 200})
+	stats := GetBatchUpdaterStats()
+	if stats.PendingLogs != 2 || stats.PendingUsages != 1 {
+		t.Fatalf("expected 2 logs aggregated into 1 usage, got %d logs and %d usages", stats.PendingLogs, stats.PendingUsages)
+	}
+	usage := batchUsages[usageKey{userId: 1, modelName: "gpt-4o", tokenName: "a", hour: 2025030110}]
+	if usage == nil || usage.Count != 2 || usage.InputTokens != 30 || usage.OutputTokens != 10 || usage.Quota != 300 ||
+		usage.CachedTokens != 4 || usage.CacheWriteTokens != 6 {
+		t.Fatalf("unexpected usage %+v", usage)
+	}
+	select {
+	case <-batchFlushSignal:
+		t.Fatal("expected no flush below the size threshold")
+	default:
+	}
+
+	addNewLog(&Log{UserId: 1, ModelName: "gpt-4o", TokenName: "a", CreatedAt: hour + 3600})
+	if GetBatchUpdaterStats().PendingUsages != 2 {
+		t.Fatal("expected the next hour to be a new usage")
+	}
+	select {
+	case <-batchFlushSignal:
+	default:
+		t.Fatal("expected a flush once the size threshold is reached")
+	}
+
+	recorder := useDryRunDB(t)
+	addNewLog(&Log{UserId: 1, ModelName: "gpt-4o", TokenName: "a", ChannelId: 5, ChannelType: 14, CreatedAt: hour})
+	if batchUsages[usageKey{userId: 1, modelName: "gpt-4o", tokenName: "a", channelType: 14, hour: 2025030110}] == nil {
+		t.Fatal("expected the usage of the channel type given by the relay")
+	}
+	if recorder.count() != 0 {
+		t.Fatal("the channel type is read from the database")
+	}
+}
@@ -0,0 +1,190 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+const (
+	VirtualModelStatusEnabled  = 1
+	VirtualModelStatusDisabled = 2
+)
+
+// VirtualModel is a global model name, such as "smart", served by an ordered fallback chain
+// of real models. The first model of the chain with an available channel serves the request,
+// and the request is billed and logged as that model.
+type VirtualModel struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name" gorm:"type:varchar(128);uniqueIndex"`
+	Models      string `json:"models" gorm:"type:varchar(1024)"`            // comma separated, in fallback order
+	Groups      string `json:"groups" gorm:"type:varchar(1024);default:''"` // comma separated, empty means every group
+	Description string `json:"description" gorm:"type:varchar(512);default:''"`
+	Status      int    `json:"status" gorm:"default:1"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+}
+
+func (virtualModel *VirtualModel) GetModels() []string {
+	return splitRuleList(virtualModel.Models)
+}
+
+func (virtualModel *VirtualModel) IsAvailableForGroup(group string) bool {
+	groups := splitRuleList(virtualModel.Groups)
+	return len(groups) == 0 || isInRuleList(groups, group)
+}
+
+func (virtualModel *VirtualModel) Validate() error {
+	if virtualModel.Name == "" {
+		return errors.New("虚拟模型名称不能为空")
+	}
+	models := virtualModel.GetModels()
+	if len(models) == 0 {
+		return errors.New("虚拟模型至少需要一个真实模型")
+	}
+	for _, m := range models {
+		if m == virtualModel.Name {
+			return errors.New("虚拟模型不能引用自身")
+		}
+	}
+	// a virtual model named after a real model would silently take over its requests
+	var count int64
+	if err := DB.Model(&Ability{}).Where("model = ?", virtualModel.Name).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("虚拟模型名称 %s 与已有模型重名", virtualModel.Name)
+	}
+	return nil
+}
+
+// CheckVirtualModelNames returns an error if one of the model names is taken by a virtual model
+func CheckVirtualModelNames(models []string) error {
+	if len(models) == 0 {
+		return nil
+	}
+	var names []string
+	if err := DB.Model(&VirtualModel{}).Where("name IN ?", models).Pluck("name", &names).Error; err != nil {
+		return err
+	}
+	if len(names) > 0 {
+		return fmt.Errorf("模型 %s 与已有虚拟模型重名", names[0])
+	}
+	return nil
+}
+
+func GetAllVirtualModels() ([]*VirtualModel, error) {
+	var virtualModels []*VirtualModel
+	err := DB.Order("id asc").Find(&virtualModels).Error
+	return virtualModels, err
+}
+
+func GetVirtualModelById(id int) (*VirtualModel, error) {
+	virtualModel := VirtualModel{}
+	err := DB.First(&virtualModel, "id = ?", id).Error
+	return &virtualModel, err
+}
+
+func (virtualModel *VirtualModel) Insert() error {
+	virtualModel.CreatedTime = helper.GetTimestamp()
+	return DB.Create(virtualModel).Error
+}
+
+func (virtualModel *VirtualModel) Update() error {
+	return DB.Model(virtualModel).Select("*").Omit("id", "created_time").Updates(virtualModel).Error
+}
+
+func DeleteVirtualModelById(id int) error {
+	return DB.Delete(&VirtualModel{}, "id = ?", id).Error
+}
+
+var name2virtualModel map[string]*VirtualModel
+var virtualModelSyncLock sync.RWMutex
+
+func InitVirtualModelCache() {
+	var virtualModels []*VirtualModel
+	err := DB.Where("status = ?", VirtualModelStatusEnabled).Find(&virtualModels).Error
+	if err != nil {
+		logger.SysError("failed to load virtual models: " + err.Error())
+		return
+	}
+	newName2virtualModel := make(map[string]*VirtualModel, len(virtualModels))
+	for _, virtualModel := range virtualModels {
+		newName2virtualModel[virtualModel.Name] = virtualModel
+	}
+	virtualModelSyncLock.Lock()
+	name2virtualModel = newName2virtualModel
+	virtualModelSyncLock.Unlock()
+}
+
+// CacheGetVirtualModel returns the enabled virtual model usable by group, or nil
+func CacheGetVirtualModel(group string, name string) *VirtualModel {
+	virtualModelSyncLock.RLock()
+	defer virtualModelSyncLock.RUnlock()
+	virtualModel, ok := name2virtualModel[name]
+	if !ok || !virtualModel.IsAvailableForGroup(group) {
+		return nil
+	}
+	return virtualModel
+}
+
+// CacheGetVirtualModelNames returns the names of the virtual models usable by group
+func CacheGetVirtualModelNames(group string) []string {
+	virtualModelSyncLock.RLock()
+	defer virtualModelSyncLock.RUnlock()
+	names := make([]string, 0, len(name2virtualModel))
+	for name, virtualModel := range name2virtualModel {
+		if virtualModel.IsAvailableForGroup(group) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// CacheGetVirtualModelChannel walks the fallback chain of the virtual model, starting at fromModel
+// (or at the head of the chain when fromModel is empty), and returns the first real model with an
+// available channel. Channels that already failed the request are skipped for every model.
+func CacheGetVirtualModelChannel(group string, virtualModel *VirtualModel, fromModel string, excludedChannelIds []int) (string, *Channel) {
+	models := virtualModel.GetModels()
+	start := 0
+	for i, m := range models {
+		if m == fromModel {
+			start = i
+			break
+		}
+	}
+	for _, m := range models[start:] {
+		channel, err := CacheGetRandomSatisfiedChannel(group, m, excludedChannelIds)
+		if err == nil && channel != nil {
+			return m, channel
+		}
+	}
+	return "", nil
+}
+
+// CacheGetNextVirtualModelChannels returns the first model after servedModel in the fallback chain of the
+// virtual model which has available channels, with its ordered channels. When channelIds is not empty,
+// the channels are restricted to them, as for a request routed to specific channels.
+func CacheGetNextVirtualModelChannels(group string, virtualModel *VirtualModel, servedModel string, channelIds []int) (string, []*Channel) {
+	models := virtualModel.GetModels()
+	for i, m := range models {
+		if m != servedModel {
+			continue
+		}
+		for _, next := range models[i+1:] {
+			var channels []*Channel
+			if len(channelIds) > 0 {
+				channels = CacheGetChannelsByIds(channelIds, next, nil)
+			} else {
+				channels, _ = CacheGetOrderedChannels(group, next, nil)
+			}
+			if len(channels) > 0 {
+				return next, channels
+			}
+		}
+		break
+	}
+	return "", nil
+}
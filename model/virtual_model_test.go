@@ -0,0 +1,57 @@
+package model
+
+import "testing"
+
+func TestVirtualModelValidate(t *testing.T) {
+	recorder := useDryRunDB(t)
+
+	if err := (&VirtualModel{Name: "smart", Models: "gpt-4o, smart"}).Validate(); err == nil {
+		t.Fatal("a virtual model referencing itself is accepted")
+	}
+	if recorder.count() != 0 {
+		t.Fatal("an invalid virtual model is looked up")
+	}
+	if err := (&VirtualModel{Name: "smart", Models: "gpt-4o, gpt-4o-mini"}).Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if recorder.find(`FROM "abilities"`, `model = 'smart'`) == "" {
+		t.Fatal("the name of the virtual model is not checked against the existing models")
+	}
+
+	if err := CheckVirtualModelNames([]string{"gpt-4o", "smart"}); err != nil {
+		t.Fatal(err)
+	}
+	if recorder.find(`FROM "virtual_models"`, `name IN ('gpt-4o','smart')`) == "" {
+		t.Fatal("the channel models are not checked against the virtual models")
+	}
+}
+
+func TestCacheGetNextVirtualModelChannels(t *testing.T) {
+	weight := uint(1)
+	originalChannels, originalGroups := channelId2channel, group2model2channels
+	channelId2channel = map[int]*Channel{
+		1: {Id: 1, Models: "gpt-4o", Weight: &weight},
+		2: {Id: 2, Models: "gpt-4o-mini", Weight: &weight},
+		3: {Id: 3, Models: "claude-sonnet-4", Weight: &weight},
+	}
+	group2model2channels = map[string]map[string][]*Channel{
+		"default": {
+			"gpt-4o":          {channelId2channel[1]},
+			"claude-sonnet-4": {channelId2channel[3]},
+		},
+	}
+	defer func() { channelId2channel, group2model2channels = originalChannels, originalGroups }()
+	virtualModel := &VirtualModel{Name: "smart", Models: "gpt-4o, gpt-4o-mini, claude-sonnet-4"}
+
+	servedModel, channels := CacheGetNextVirtualModelChannels("default", virtualModel, "gpt-4o", nil)
+	if servedModel != "claude-sonnet-4" || len(channels) != 1 || channels[0].Id != 3 {
+		t.Fatalf("unexpected %s on %v", servedModel, channels)
+	}
+	if servedModel, channels = CacheGetNextVirtualModelChannels("default", virtualModel, "claude-sonnet-4", nil); len(channels) != 0 {
+		t.Fatalf("the chain goes on after its last model with %s", servedModel)
+	}
+	servedModel, channels = CacheGetNextVirtualModelChannels("default", virtualModel, "gpt-4o", []int{1, 2})
+	if servedModel != "gpt-4o-mini" || len(channels) != 1 || channels[0].Id != 2 {
+		t.Fatalf("unexpected %s on %v among the routed channels", servedModel, channels)
+	}
+}
@@ -35,6 +35,23 @@ func DisableChannel(channelId int, channelName string, reason string) {
 	notifyRootUser(subject, content)
 }
 
+// DisableChannelKey disable one key of a multi-key channel & notify, the channel itself is only disabled with its last key
+func DisableChannelKey(channelId int, channelName string, keyHash string, reason string) {
+	err := model.UpdateChannelKeyStatus(channelId, keyHash, model.ChannelKeyStatusAutoDisabled, reason)
+	if err != nil {
+		logger.SysError(fmt.Sprintf("failed to disable key %s of channel #%d: %s", keyHash, channelId, err.Error()))
+		return
+	}
+	logger.SysLog(fmt.Sprintf("key %s of channel #%d has been disabled: %s", keyHash, channelId, reason))
+	subject := fmt.Sprintf("渠道「%s」（#%d）的密钥 %s 已被禁用", channelName, channelId, keyHash)
+	content := fmt.Sprintf("渠道「%s」（#%d）的密钥 %s 已被禁用，原因：%s", channelName, channelId, keyHash, reason)
+	notifyRootUser(subject, content)
+	channel, err := model.CacheGetChannelById(channelId)
+	if err == nil && model.CacheCountEnabledChannelKeys(channel) == 0 {
+		DisableChannel(channelId, channelName, "所有密钥均已被禁用")
+	}
+}
+
 func MetricDisableChannel(channelId int, successRate float64) {
 	model.UpdateChannelStatusById(channelId, model.ChannelStatusAutoDisabled)
 	logger.SysLog(fmt.Sprintf("channel #%d has been disabled due to low success rate: %.2f", channelId, successRate*100))
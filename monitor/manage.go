@@ -44,6 +44,19 @@ func ShouldDisableChannel(err *model.ErrorWithStatusCode, statusCode int) bool {
 	return false
 }
 
+// ShouldCooldownChannelKey tells whether a key of a multi-key channel should rest for a while,
+// this is checked after ShouldDisableChannel
+func ShouldCooldownChannelKey(err *model.ErrorWithStatusCode) bool {
+	if err == nil || !err.IsChannelResponseError {
+		return false
+	}
+	switch err.StatusCode {
+	case http.StatusTooManyRequests, http.StatusUnauthorized, http.StatusForbidden:
+		return true
+	}
+	return false
+}
+
 func ShouldEnableChannel(err error, openAIErr *model.ErrorWithStatusCode) bool {
 	if !config.AutomaticEnableChannelEnabled {
 		return false
@@ -13,11 +13,13 @@ import (
 	"github.com/songquanpeng/one-api/relay/adaptor/deepl"
 	"github.com/songquanpeng/one-api/relay/adaptor/gemini"
 	"github.com/songquanpeng/one-api/relay/adaptor/jina"
+	"github.com/songquanpeng/one-api/relay/adaptor/kling"
 	"github.com/songquanpeng/one-api/relay/adaptor/ollama"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
 	"github.com/songquanpeng/one-api/relay/adaptor/palm"
 	"github.com/songquanpeng/one-api/relay/adaptor/proxy"
 	"github.com/songquanpeng/one-api/relay/adaptor/replicate"
+	"github.com/songquanpeng/one-api/relay/adaptor/runway"
 	"github.com/songquanpeng/one-api/relay/adaptor/tencent"
 	"github.com/songquanpeng/one-api/relay/adaptor/vertexai"
 	"github.com/songquanpeng/one-api/relay/adaptor/xunfei"
@@ -79,3 +81,17 @@ func GetRerankAdaptor(channelType int) adaptor.RerankAdaptor {
 	}
 	return nil
 }
+
+func GetTaskAdaptor(channelType int) adaptor.TaskAdaptor {
+	switch channelType {
+	case channeltype.OpenAI:
+		return &openai.TaskAdaptor{}
+	case channeltype.Kling:
+		return &kling.TaskAdaptor{}
+	case channeltype.Runway:
+		return &runway.TaskAdaptor{}
+	case channeltype.Replicate:
+		return &replicate.TaskAdaptor{}
+	}
+	return nil
+}
@@ -47,7 +47,11 @@ func (a *Adaptor) ConvertRequest(c *gin.Context, meta *meta.Meta, request *model
 	if request == nil {
 		return nil, errors.New("request is nil")
 	}
-	return ConvertRequest(*request), nil
+	claudeReq := ConvertRequest(*request)
+	if meta.Config.PromptCacheEnabled(meta.OriginModelName) {
+		AddCacheControl(claudeReq, meta.Config.PromptCacheMessages)
+	}
+	return claudeReq, nil
 }
 
 func (a *Adaptor) ConvertImageRequest(request *model.ImageRequest) (any, error) {
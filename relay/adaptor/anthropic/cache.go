@@ -0,0 +1,71 @@
+package anthropic
+
+// MaxCacheBreakpoints is the limit of the cache_control blocks in a request
+//
+// https://docs.anthropic.com/en/docs/build-with-claude/prompt-caching#structuring-your-prompt
+const MaxCacheBreakpoints = 4
+
+var ephemeralCacheControl = &CacheControl{Type: "ephemeral"}
+
+// AddCacheControl marks the tools, the system prompt and the last messages of the request
+// as cache breakpoints, in the order of the prompt prefix, until the limit is reached
+func AddCacheControl(request *Request, messages int) {
+	left := MaxCacheBreakpoints - countCacheBreakpoints(request)
+	if left <= 0 {
+		return
+	}
+	if n := len(request.Tools); n > 0 && request.Tools[n-1].CacheControl == nil {
+		request.Tools[n-1].CacheControl = ephemeralCacheControl
+		left--
+	}
+	if left > 0 {
+		if system, ok := request.System.(string); ok && system != "" {
+			request.System = []ContentReq{{Type: "text", Text: system, CacheControl: ephemeralCacheControl}}
+			left--
+		}
+	}
+	// the breakpoints of the later messages cover the earlier ones, so they are added from the end,
+	// the fillers alternating the roles are not counted as messages of the user
+	for i := len(request.Messages) - 1; i >= 0 && messages > 0 && left > 0; i-- {
+		if request.Messages[i].filler {
+			continue
+		}
+		messages--
+		contents, ok := request.Messages[i].Content.([]ContentReq)
+		if !ok || len(contents) == 0 {
+			continue
+		}
+		last := &contents[len(contents)-1]
+		if last.CacheControl != nil || (last.Type == "text" && last.Text == "") {
+			continue
+		}
+		last.CacheControl = ephemeralCacheControl
+		left--
+	}
+}
+
+func countCacheBreakpoints(request *Request) int {
+	count := 0
+	for _, tool := range request.Tools {
+		if tool.CacheControl != nil {
+			count++
+		}
+	}
+	if system, ok := request.System.([]ContentReq); ok {
+		for _, content := range system {
+			if content.CacheControl != nil {
+				count++
+			}
+		}
+	}
+	for _, message := range request.Messages {
+		if contents, ok := message.Content.([]ContentReq); ok {
+			for _, content := range contents {
+				if content.CacheControl != nil {
+					count++
+				}
+			}
+		}
+	}
+	return count
+}
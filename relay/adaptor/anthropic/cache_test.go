@@ -0,0 +1,71 @@
+package anthropic
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func textMessage(role string, text string) Message {
+	return Message{Role: role, Content: []ContentReq{{Type: "text", Text: text}}}
+}
+
+func cached(message Message) bool {
+	contents := message.Content.([]ContentReq)
+	return contents[len(contents)-1].CacheControl != nil
+}
+
+func TestAddCacheControlPlacement(t *testing.T) {
+	request := &Request{
+		System:   "be brief",
+		Tools:    []Tool{{Name: "search"}, {Name: "fetch"}},
+		Messages: []Message{textMessage("user", "a"), textMessage("assistant", "b"), textMessage("user", "c")},
+	}
+	AddCacheControl(request, 2)
+	// the last tool covers the tools before it
+	assert.Nil(t, request.Tools[0].CacheControl)
+	assert.NotNil(t, request.Tools[1].CacheControl)
+	system, ok := request.System.([]ContentReq)
+	assert.True(t, ok)
+	assert.Equal(t, "be brief", system[0].Text)
+	assert.NotNil(t, system[0].CacheControl)
+	assert.False(t, cached(request.Messages[0]))
+	assert.True(t, cached(request.Messages[1]))
+	assert.True(t, cached(request.Messages[2]))
+	assert.Equal(t, 4, countCacheBreakpoints(request))
+}
+
+func TestAddCacheControlLimit(t *testing.T) {
+	messages := []Message{textMessage("user", "a"), textMessage("assistant", "b"), textMessage("user", "c"), textMessage("assistant", "d"), textMessage("user", "e")}
+	request := &Request{System: "be brief", Tools: []Tool{{Name: "search"}}, Messages: messages}
+	AddCacheControl(request, 5)
+	// the tools and the system prompt come first in the prompt prefix, then the latest messages
+	assert.NotNil(t, request.Tools[0].CacheControl)
+	assert.Equal(t, []bool{false, false, false, true, true}, []bool{cached(messages[0]), cached(messages[1]), cached(messages[2]), cached(messages[3]), cached(messages[4])})
+	assert.Equal(t, MaxCacheBreakpoints, countCacheBreakpoints(request))
+
+	// the breakpoints of the user count toward the limit and are kept
+	messages = []Message{textMessage("user", "a"), textMessage("assistant", "b"), textMessage("user", "c")}
+	for i := range messages {
+		messages[i].Content.([]ContentReq)[0].CacheControl = ephemeralCacheControl
+	}
+	request = &Request{System: "be brief", Tools: []Tool{{Name: "search"}}, Messages: messages}
+	AddCacheControl(request, 3)
+	assert.NotNil(t, request.Tools[0].CacheControl)
+	assert.Equal(t, "be brief", request.System)
+	assert.Equal(t, MaxCacheBreakpoints, countCacheBreakpoints(request))
+
+	request = &Request{Messages: []Message{textMessage("user", "a"), textMessage("assistant", ""), textMessage("user", "c")}}
+	AddCacheControl(request, 2)
+	assert.False(t, cached(request.Messages[1]))
+	assert.True(t, cached(request.Messages[2]))
+	assert.False(t, cached(request.Messages[0]))
+}
+
+func TestAddCacheControlSkipsFillers(t *testing.T) {
+	request := &Request{Messages: []Message{textMessage("user", "a"), defaultAssistantMessage(), textMessage("user", "b")}}
+	AddCacheControl(request, 2)
+	assert.True(t, cached(request.Messages[0]))
+	assert.False(t, cached(request.Messages[1]))
+	assert.True(t, cached(request.Messages[2]))
+}
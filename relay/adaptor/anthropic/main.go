@@ -178,6 +178,7 @@ func defaulUserMessage() Message {
 				Text: "hello",
 			},
 		},
+		filler: true,
 	}
 }
 
@@ -190,6 +191,7 @@ func defaultAssistantMessage() Message {
 				Text: "I'm here to help. What can I do for you?",
 			},
 		},
+		filler: true,
 	}
 }
 
@@ -307,6 +309,52 @@ func ResponseClaude2OpenAI(claudeResponse *Response) *openai.TextResponse {
 	return &fullTextResponse
 }
 
+// Merge takes in the usage of an event of a stream. The usage of message_delta is cumulative and
+// repeats the counts of message_start, so each count keeps the largest value reported.
+func (u *Usage) Merge(other *Usage) {
+	if other == nil {
+		return
+	}
+	u.InputTokens = helper.Max(u.InputTokens, other.InputTokens)
+	u.OutputTokens = helper.Max(u.OutputTokens, other.OutputTokens)
+	u.CacheCreationInputTokens = helper.Max(u.CacheCreationInputTokens, other.CacheCreationInputTokens)
+	u.CacheReadInputTokens = helper.Max(u.CacheReadInputTokens, other.CacheReadInputTokens)
+	if other.CacheCreation != nil {
+		if u.CacheCreation == nil {
+			u.CacheCreation = &CacheCreation{}
+		}
+		u.CacheCreation.Ephemeral5mInputTokens = helper.Max(u.CacheCreation.Ephemeral5mInputTokens, other.CacheCreation.Ephemeral5mInputTokens)
+		u.CacheCreation.Ephemeral1hInputTokens = helper.Max(u.CacheCreation.Ephemeral1hInputTokens, other.CacheCreation.Ephemeral1hInputTokens)
+	}
+}
+
+// CacheWrite1hTokens returns the cache write tokens with the 1h TTL
+func (u *Usage) CacheWrite1hTokens() int {
+	if u.CacheCreation == nil {
+		return 0
+	}
+	return u.CacheCreation.Ephemeral1hInputTokens
+}
+
+// ToOpenAIUsage converts the usage. The prompt tokens of OpenAI include the cache reads and writes,
+// which Anthropic reports apart from the input tokens.
+func (u *Usage) ToOpenAIUsage() model.Usage {
+	usage := model.Usage{
+		PromptTokens:     u.InputTokens + u.CacheReadInputTokens + u.CacheCreationInputTokens,
+		CompletionTokens: u.OutputTokens,
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	if u.CacheReadInputTokens+u.CacheCreationInputTokens > 0 {
+		cachedTokens, cacheWriteTokens, cacheWrite1hTokens := u.CacheReadInputTokens, u.CacheCreationInputTokens, u.CacheWrite1hTokens()
+		usage.PromptTokensDetails = &model.PromptTokensDetails{
+			CachedTokens:       &cachedTokens,
+			CacheWriteTokens:   &cacheWriteTokens,
+			CacheWrite1hTokens: &cacheWrite1hTokens,
+		}
+	}
+	return usage
+}
+
 func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, *model.Usage) {
 	createdTime := helper.GetTimestamp()
 	scanner := bufio.NewScanner(resp.Body)
@@ -325,7 +373,7 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 
 	common.SetEventStreamHeaders(c)
 
-	var usage model.Usage
+	var claudeUsage Usage
 	var modelName string
 	var id string
 	var fingerprint string
@@ -349,8 +397,7 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 
 		response, meta := StreamResponseClaude2OpenAI(&claudeResponse, toolCounter)
 		if meta != nil {
-			usage.PromptTokens += meta.Usage.InputTokens
-			usage.CompletionTokens += meta.Usage.OutputTokens
+			claudeUsage.Merge(meta.Usage)
 			if len(meta.Id) > 0 { // only message_start has an id, otherwise it's a finish_reason event.
 				modelName = meta.Model
 				id = fmt.Sprintf("chatcmpl-%s", meta.Id)
@@ -391,8 +438,8 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 		logger.SysError("error reading stream: " + err.Error())
 	}
 
+	usage := claudeUsage.ToOpenAIUsage()
 	if usage.PromptTokens != 0 && usage.CompletionTokens != 0 {
-		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
 		var usageResponse openai.ChatCompletionsStreamResponse
 		usageResponse.Id = id
 		usageResponse.Model = modelName
@@ -441,11 +488,7 @@ func Handler(c *gin.Context, resp *http.Response, promptTokens int, modelName st
 	}
 	fullTextResponse := ResponseClaude2OpenAI(&claudeResponse)
 	fullTextResponse.Model = modelName
-	usage := model.Usage{
-		PromptTokens:     claudeResponse.Usage.InputTokens,
-		CompletionTokens: claudeResponse.Usage.OutputTokens,
-		TotalTokens:      claudeResponse.Usage.InputTokens + claudeResponse.Usage.OutputTokens,
-	}
+	usage := claudeResponse.Usage.ToOpenAIUsage()
 	fullTextResponse.Usage = usage
 	jsonResponse, err := json.Marshal(fullTextResponse)
 	if err != nil {
@@ -0,0 +1,40 @@
+package anthropic
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsageMerge(t *testing.T) {
+	var usage Usage
+	// message_start reports the prompt, message_delta repeats it with the cumulative output
+	usage.Merge(&Usage{InputTokens: 100, OutputTokens: 1, CacheCreationInputTokens: 50, CacheReadInputTokens: 30,
+		CacheCreation: &CacheCreation{Ephemeral5mInputTokens: 30, Ephemeral1hInputTokens: 20}})
+	usage.Merge(&Usage{InputTokens: 100, OutputTokens: 40, CacheCreationInputTokens: 50, CacheReadInputTokens: 30})
+	usage.Merge(&Usage{OutputTokens: 42})
+	usage.Merge(nil)
+	assert.Equal(t, 100, usage.InputTokens)
+	assert.Equal(t, 42, usage.OutputTokens)
+	assert.Equal(t, 50, usage.CacheCreationInputTokens)
+	assert.Equal(t, 30, usage.CacheReadInputTokens)
+	assert.Equal(t, 20, usage.CacheWrite1hTokens())
+}
+
+func TestUsageToOpenAIUsage(t *testing.T) {
+	usage := (&Usage{InputTokens: 100, OutputTokens: 42, CacheCreationInputTokens: 50, CacheReadInputTokens: 30,
+		CacheCreation: &CacheCreation{Ephemeral1hInputTokens: 20}}).ToOpenAIUsage()
+	assert.Equal(t, 180, usage.PromptTokens)
+	assert.Equal(t, 222, usage.TotalTokens)
+	assert.Equal(t, 30, *usage.PromptTokensDetails.CachedTokens)
+	assert.Equal(t, 50, *usage.PromptTokensDetails.CacheWriteTokens)
+	assert.Equal(t, 20, *usage.PromptTokensDetails.CacheWrite1hTokens)
+
+	// the cache writes are billed but not returned to the client
+	data, err := json.Marshal(usage)
+	assert.NoError(t, err)
+	assert.True(t, strings.Contains(string(data), `"cached_tokens":30`))
+	assert.False(t, strings.Contains(string(data), "cache_write"))
+}
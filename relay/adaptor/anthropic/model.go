@@ -13,9 +13,10 @@ type ImageSource struct {
 }
 
 type ContentReq struct {
-	Type   string       `json:"type"`
-	Text   string       `json:"text,omitempty"`
-	Source *ImageSource `json:"source,omitempty"`
+	Type         string        `json:"type"`
+	Text         string        `json:"text,omitempty"`
+	Source       *ImageSource  `json:"source,omitempty"`
+	CacheControl *CacheControl `json:"cache_control,omitempty"`
 	// tool_calls
 	Id        string `json:"id,omitempty"`
 	Name      string `json:"name,omitempty"`
@@ -60,6 +61,7 @@ type Citation struct {
 type Message struct {
 	Role    string `json:"role"`
 	Content any    `json:"content"`
+	filler  bool   // added to alternate the roles, not sent by the user
 }
 
 type Thinking struct {
@@ -107,10 +109,19 @@ type Request struct {
 }
 
 type Usage struct {
-	InputTokens              int `json:"input_tokens"`
-	OutputTokens             int `json:"output_tokens"`
-	CacheCreationInputTokens int `json:"cache_creation_input_tokens,omitempty"`
-	CacheReadInputTokens     int `json:"cache_read_input_tokens,omitempty"`
+	InputTokens              int            `json:"input_tokens"`
+	OutputTokens             int            `json:"output_tokens"`
+	CacheCreationInputTokens int            `json:"cache_creation_input_tokens,omitempty"`
+	CacheReadInputTokens     int            `json:"cache_read_input_tokens,omitempty"`
+	CacheCreation            *CacheCreation `json:"cache_creation,omitempty"`
+}
+
+// CacheCreation splits the cache write tokens by TTL
+//
+// https://docs.anthropic.com/en/docs/build-with-claude/prompt-caching#1-hour-cache-duration
+type CacheCreation struct {
+	Ephemeral5mInputTokens int `json:"ephemeral_5m_input_tokens"`
+	Ephemeral1hInputTokens int `json:"ephemeral_1h_input_tokens"`
 }
 
 type Error struct {
@@ -22,6 +22,9 @@ func (a *Adaptor) ConvertRequest(c *gin.Context, meta *meta.Meta, request *model
 	}
 
 	claudeReq := anthropic.ConvertRequest(*request)
+	if meta.Config.PromptCacheEnabled(meta.OriginModelName) {
+		anthropic.AddCacheControl(claudeReq, meta.Config.PromptCacheMessages)
+	}
 	c.Set(ctxkey.RequestModel, request.Model)
 	c.Set(ctxkey.ConvertedRequest, claudeReq)
 	return claudeReq, nil
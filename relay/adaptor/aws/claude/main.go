@@ -106,11 +106,7 @@ func Handler(c *gin.Context, awsCli *bedrockruntime.Client, modelName string) (*
 
 	openaiResp := anthropic.ResponseClaude2OpenAI(claudeResponse)
 	openaiResp.Model = modelName
-	usage := relaymodel.Usage{
-		PromptTokens:     claudeResponse.Usage.InputTokens,
-		CompletionTokens: claudeResponse.Usage.OutputTokens,
-		TotalTokens:      claudeResponse.Usage.InputTokens + claudeResponse.Usage.OutputTokens,
-	}
+	usage := claudeResponse.Usage.ToOpenAIUsage()
 	openaiResp.Usage = usage
 
 	c.JSON(http.StatusOK, openaiResp)
@@ -180,7 +176,7 @@ func StreamHandler(c *gin.Context, awsCli *bedrockruntime.Client) (*relaymodel.E
 	stream := awsResp.GetStream()
 	defer stream.Close()
 
-	var usage relaymodel.Usage
+	var claudeUsage anthropic.Usage
 	started := new(bool)
 	var lastToolCallChoice openai.ChatCompletionsStreamResponseChoice
 	toolCounter := &anthropic.ToolCounter{}
@@ -194,19 +190,20 @@ func StreamHandler(c *gin.Context, awsCli *bedrockruntime.Client) (*relaymodel.E
 			common.SetEventStreamHeaders(c)
 			a := true
 			started = &a
-			return streamEventHandler(c, &firstEvent, toolCounter, &lastToolCallChoice, &usage, createdTime)
+			return streamEventHandler(c, &firstEvent, toolCounter, &lastToolCallChoice, &claudeUsage, createdTime)
 		}
 		event, ok := <-stream.Events()
 		if !ok {
 			c.Render(-1, common.CustomEvent{Data: "data: [DONE]"})
 			return false
 		}
-		return streamEventHandler(c, &event, toolCounter, &lastToolCallChoice, &usage, createdTime)
+		return streamEventHandler(c, &event, toolCounter, &lastToolCallChoice, &claudeUsage, createdTime)
 	})
+	usage := claudeUsage.ToOpenAIUsage()
 	return nil, &usage
 }
 
-func streamEventHandler(c *gin.Context, event *types.ResponseStream, toolCounter *anthropic.ToolCounter, lastToolCallChoice *openai.ChatCompletionsStreamResponseChoice, usage *relaymodel.Usage, createdTime int64) bool {
+func streamEventHandler(c *gin.Context, event *types.ResponseStream, toolCounter *anthropic.ToolCounter, lastToolCallChoice *openai.ChatCompletionsStreamResponseChoice, usage *anthropic.Usage, createdTime int64) bool {
 	switch v := (*event).(type) {
 	case *types.ResponseStreamMemberChunk:
 		var id string
@@ -219,8 +216,7 @@ func streamEventHandler(c *gin.Context, event *types.ResponseStream, toolCounter
 
 		response, meta := anthropic.StreamResponseClaude2OpenAI(claudeResp, toolCounter)
 		if meta != nil {
-			usage.PromptTokens += meta.Usage.InputTokens
-			usage.CompletionTokens += meta.Usage.OutputTokens
+			usage.Merge(meta.Usage)
 			if len(meta.Id) > 0 { // only message_start has an id, otherwise it's a finish_reason event.
 				id = fmt.Sprintf("chatcmpl-%s", meta.Id)
 				return true
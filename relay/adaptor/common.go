@@ -62,3 +62,19 @@ func DoRequest(c *gin.Context, req *http.Request) (*http.Response, error) {
 	_ = c.Request.Body.Close()
 	return resp, nil
 }
+
+// TaskRequestBody merges the provider specific input of a task request with the fields converted
+// from it, the fields left empty keeping the value of the input
+func TaskRequestBody(input map[string]any, fields map[string]any) map[string]any {
+	body := make(map[string]any, len(input)+len(fields))
+	for key, value := range input {
+		body[key] = value
+	}
+	for key, value := range fields {
+		if value == nil || value == "" || value == 0 {
+			continue
+		}
+		body[key] = value
+	}
+	return body
+}
@@ -28,3 +28,15 @@ type RerankAdaptor interface {
 	DoRequest(c *gin.Context, meta *meta.Meta, requestBody io.Reader) (*http.Response, error)
 	DoRerankResponse(c *gin.Context, resp *http.Response, meta *meta.Meta) (usage *model.RerankUsage, err *model.ErrorWithStatusCode)
 }
+
+// TaskAdaptor submits asynchronous tasks, like video generations, and fetches their status. It is
+// used outside of a request when the tasks are polled, so it does not depend on the gin context.
+// The action returned with the submit url is kept with the task and passed back to fetch it.
+type TaskAdaptor interface {
+	GetSubmitURL(meta *meta.Meta, request *model.TaskRequest) (url string, action string, err error)
+	GetFetchURL(meta *meta.Meta, action string, upstreamTaskId string) (string, error)
+	SetupTaskRequestHeader(req *http.Request, meta *meta.Meta) error
+	ConvertTaskRequest(meta *meta.Meta, request *model.TaskRequest) (any, error)
+	ParseSubmitResponse(body []byte) (upstreamTaskId string, err error)
+	ParseFetchResponse(meta *meta.Meta, upstreamTaskId string, body []byte) (*model.TaskResult, error)
+}
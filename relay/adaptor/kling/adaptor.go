@@ -0,0 +1,147 @@
+package kling
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/songquanpeng/one-api/relay/adaptor"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+type tokenData struct {
+	Token      string
+	ExpiryTime time.Time
+}
+
+var klingTokens sync.Map
+var expSeconds int64 = 1800
+
+// GetToken signs the api token of a key made of the access key and the secret key, separated by |
+func GetToken(apiKey string) (string, error) {
+	data, ok := klingTokens.Load(apiKey)
+	if ok {
+		tokenData := data.(tokenData)
+		// renew the token a minute before it expires, so that it does not expire in flight
+		if time.Now().Add(time.Minute).Before(tokenData.ExpiryTime) {
+			return tokenData.Token, nil
+		}
+	}
+	accessKey, secretKey, found := strings.Cut(apiKey, "|")
+	if !found {
+		return "", errors.New("invalid kling key, it should be access_key|secret_key")
+	}
+	now := time.Now()
+	expiryTime := now.Add(time.Duration(expSeconds) * time.Second)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"iss": accessKey,
+		"exp": expiryTime.Unix(),
+		"nbf": now.Add(-5 * time.Second).Unix(),
+	})
+	tokenString, err := token.SignedString([]byte(secretKey))
+	if err != nil {
+		return "", err
+	}
+	klingTokens.Store(apiKey, tokenData{Token: tokenString, ExpiryTime: expiryTime})
+	return tokenString, nil
+}
+
+type TaskAdaptor struct{}
+
+// GetSubmitURL returns the url of text2video, or of image2video when the request has a first frame
+func (a *TaskAdaptor) GetSubmitURL(meta *meta.Meta, request *model.TaskRequest) (string, string, error) {
+	action := "text2video"
+	if request.Image != "" {
+		action = "image2video"
+	}
+	return fmt.Sprintf("%s/v1/videos/%s", meta.BaseURL, action), action, nil
+}
+
+func (a *TaskAdaptor) GetFetchURL(meta *meta.Meta, action string, upstreamTaskId string) (string, error) {
+	return fmt.Sprintf("%s/v1/videos/%s/%s", meta.BaseURL, action, upstreamTaskId), nil
+}
+
+func (a *TaskAdaptor) SetupTaskRequestHeader(req *http.Request, meta *meta.Meta) error {
+	token, err := GetToken(meta.APIKey)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+func (a *TaskAdaptor) ConvertTaskRequest(meta *meta.Meta, request *model.TaskRequest) (any, error) {
+	duration := ""
+	if request.Duration > 0 {
+		duration = strconv.Itoa(request.Duration)
+	}
+	return adaptor.TaskRequestBody(request.Input, map[string]any{
+		"model_name":      meta.ActualModelName,
+		"prompt":          request.Prompt,
+		"negative_prompt": request.NegativePrompt,
+		"image":           request.Image,
+		"duration":        duration,
+		"aspect_ratio":    request.AspectRatio,
+	}), nil
+}
+
+func parseResponse(body []byte) (*TaskResponse, error) {
+	var response TaskResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+	if response.Code != 0 {
+		return nil, fmt.Errorf("kling error %d: %s", response.Code, response.Message)
+	}
+	return &response, nil
+}
+
+func (a *TaskAdaptor) ParseSubmitResponse(body []byte) (string, error) {
+	response, err := parseResponse(body)
+	if err != nil {
+		return "", err
+	}
+	if response.Data.TaskId == "" {
+		return "", fmt.Errorf("no task id in response: %s", string(body))
+	}
+	return response.Data.TaskId, nil
+}
+
+func (a *TaskAdaptor) ParseFetchResponse(meta *meta.Meta, upstreamTaskId string, body []byte) (*model.TaskResult, error) {
+	response, err := parseResponse(body)
+	if err != nil {
+		return nil, err
+	}
+	result := &model.TaskResult{}
+	switch response.Data.TaskStatus {
+	case "submitted":
+		result.Status = model.TaskStatusQueued
+	case "processing":
+		result.Status = model.TaskStatusInProgress
+	case "succeed":
+		result.Status = model.TaskStatusSucceeded
+		result.Progress = 100
+		for _, video := range response.Data.TaskResult.Videos {
+			result.URLs = append(result.URLs, video.Url)
+			duration, _ := strconv.ParseFloat(video.Duration, 64)
+			result.Duration += duration
+		}
+		for _, image := range response.Data.TaskResult.Images {
+			result.URLs = append(result.URLs, image.Url)
+		}
+	case "failed":
+		result.Status = model.TaskStatusFailed
+		result.FailReason = response.Data.TaskStatusMsg
+	default:
+		return nil, fmt.Errorf("unknown task status: %s", response.Data.TaskStatus)
+	}
+	return result, nil
+}
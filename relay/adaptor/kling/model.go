@@ -0,0 +1,23 @@
+package kling
+
+// https://app.klingai.com/global/dev/document-api/apiReference/model/textToVideo
+
+type TaskResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    struct {
+		TaskId        string `json:"task_id"`
+		TaskStatus    string `json:"task_status"`
+		TaskStatusMsg string `json:"task_status_msg"`
+		TaskResult    struct {
+			Videos []struct {
+				Id       string `json:"id"`
+				Url      string `json:"url"`
+				Duration string `json:"duration"`
+			} `json:"videos"`
+			Images []struct {
+				Url string `json:"url"`
+			} `json:"images"`
+		} `json:"task_result"`
+	} `json:"data"`
+}
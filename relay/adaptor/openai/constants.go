@@ -12,6 +12,7 @@ var ModelList = []string{
 	"gpt-4o-2024-11-20",
 	"chatgpt-4o-latest",
 	"gpt-4o-mini", "gpt-4o-mini-2024-07-18",
+	"gpt-4o-realtime-preview", "gpt-4o-mini-realtime-preview",
 	"gpt-4-vision-preview",
 	"text-embedding-ada-002", "text-embedding-3-small", "text-embedding-3-large",
 	"text-curie-001", "text-babbage-001", "text-ada-001", "text-davinci-002", "text-davinci-003",
@@ -19,7 +20,7 @@ var ModelList = []string{
 	"text-davinci-edit-001",
 	"davinci-002", "babbage-002",
 	"dall-e-2", "dall-e-3",
-	"whisper-1",
+	"whisper-1", "gpt-4o-transcribe", "gpt-4o-mini-transcribe",
 	"tts-1", "tts-1-1106", "tts-1-hd", "tts-1-hd-1106",
 	"o1", "o1-2024-12-17",
 	"o1-preview", "o1-preview-2024-09-12",
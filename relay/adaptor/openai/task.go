@@ -0,0 +1,102 @@
+package openai
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/songquanpeng/one-api/relay/adaptor"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// https://platform.openai.com/docs/api-reference/videos
+
+type VideoResponse struct {
+	Id       string `json:"id"`
+	Status   string `json:"status"`
+	Progress int    `json:"progress"`
+	Seconds  string `json:"seconds"`
+	Error    *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type TaskAdaptor struct{}
+
+func (a *TaskAdaptor) GetSubmitURL(meta *meta.Meta, request *model.TaskRequest) (string, string, error) {
+	if meta.ChannelType == channeltype.Azure {
+		return "", "", errors.New("video generation is not supported on azure channels")
+	}
+	return GetFullRequestURL(meta.BaseURL, "/v1/videos", meta.ChannelType), "videos", nil
+}
+
+func (a *TaskAdaptor) GetFetchURL(meta *meta.Meta, action string, upstreamTaskId string) (string, error) {
+	return GetFullRequestURL(meta.BaseURL, "/v1/videos/"+upstreamTaskId, meta.ChannelType), nil
+}
+
+func (a *TaskAdaptor) SetupTaskRequestHeader(req *http.Request, meta *meta.Meta) error {
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+meta.APIKey)
+	return nil
+}
+
+func (a *TaskAdaptor) ConvertTaskRequest(meta *meta.Meta, request *model.TaskRequest) (any, error) {
+	if request.Image != "" {
+		return nil, errors.New("image to video is not supported, use input_reference in input")
+	}
+	seconds := ""
+	if request.Duration > 0 {
+		seconds = strconv.Itoa(request.Duration)
+	}
+	return adaptor.TaskRequestBody(request.Input, map[string]any{
+		"model":   meta.ActualModelName,
+		"prompt":  request.Prompt,
+		"seconds": seconds,
+		"size":    request.Size,
+	}), nil
+}
+
+func (a *TaskAdaptor) ParseSubmitResponse(body []byte) (string, error) {
+	var response VideoResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", err
+	}
+	if response.Id == "" {
+		return "", fmt.Errorf("no video id in response: %s", string(body))
+	}
+	return response.Id, nil
+}
+
+func (a *TaskAdaptor) ParseFetchResponse(meta *meta.Meta, upstreamTaskId string, body []byte) (*model.TaskResult, error) {
+	var response VideoResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+	result := &model.TaskResult{Progress: response.Progress}
+	switch response.Status {
+	case "queued":
+		result.Status = model.TaskStatusQueued
+	case "in_progress":
+		result.Status = model.TaskStatusInProgress
+	case "completed":
+		result.Status = model.TaskStatusSucceeded
+		result.Progress = 100
+		// the content of the video is only served to the owner of the key
+		result.URLs = []string{GetFullRequestURL(meta.BaseURL, "/v1/videos/"+upstreamTaskId+"/content", meta.ChannelType)}
+		result.URLsNeedAuth = true
+		result.Duration, _ = strconv.ParseFloat(response.Seconds, 64)
+	case "failed":
+		result.Status = model.TaskStatusFailed
+		if response.Error != nil {
+			result.FailReason = response.Error.Message
+		}
+	default:
+		return nil, fmt.Errorf("unknown video status: %s", response.Status)
+	}
+	return result, nil
+}
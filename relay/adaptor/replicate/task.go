@@ -0,0 +1,106 @@
+package replicate
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/songquanpeng/one-api/relay/adaptor"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/tidwall/gjson"
+)
+
+// PredictionResponse is the response of a prediction, its output depends on the model
+//
+// https://replicate.com/docs/reference/http#predictions.get
+type PredictionResponse struct {
+	Id     string          `json:"id"`
+	Status string          `json:"status"`
+	Output json.RawMessage `json:"output"`
+	Error  any             `json:"error"`
+}
+
+// TaskAdaptor runs the predictions of the models of replicate asynchronously
+type TaskAdaptor struct{}
+
+func getBaseURL(meta *meta.Meta) string {
+	if meta.BaseURL != "" {
+		return strings.TrimSuffix(meta.BaseURL, "/")
+	}
+	return "https://api.replicate.com"
+}
+
+func (a *TaskAdaptor) GetSubmitURL(meta *meta.Meta, request *model.TaskRequest) (string, string, error) {
+	return fmt.Sprintf("%s/v1/models/%s/predictions", getBaseURL(meta), meta.ActualModelName), "predictions", nil
+}
+
+func (a *TaskAdaptor) GetFetchURL(meta *meta.Meta, action string, upstreamTaskId string) (string, error) {
+	return fmt.Sprintf("%s/v1/predictions/%s", getBaseURL(meta), upstreamTaskId), nil
+}
+
+func (a *TaskAdaptor) SetupTaskRequestHeader(req *http.Request, meta *meta.Meta) error {
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+meta.APIKey)
+	return nil
+}
+
+func (a *TaskAdaptor) ConvertTaskRequest(meta *meta.Meta, request *model.TaskRequest) (any, error) {
+	return map[string]any{
+		"input": adaptor.TaskRequestBody(request.Input, map[string]any{
+			"prompt":          request.Prompt,
+			"negative_prompt": request.NegativePrompt,
+			"image":           request.Image,
+			"duration":        request.Duration,
+			"aspect_ratio":    request.AspectRatio,
+		}),
+	}, nil
+}
+
+func (a *TaskAdaptor) ParseSubmitResponse(body []byte) (string, error) {
+	var response PredictionResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", err
+	}
+	if response.Id == "" {
+		return "", fmt.Errorf("no prediction id in response: %s", string(body))
+	}
+	return response.Id, nil
+}
+
+func (a *TaskAdaptor) ParseFetchResponse(meta *meta.Meta, upstreamTaskId string, body []byte) (*model.TaskResult, error) {
+	var response PredictionResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+	result := &model.TaskResult{}
+	switch response.Status {
+	case "starting":
+		result.Status = model.TaskStatusQueued
+	case "processing":
+		result.Status = model.TaskStatusInProgress
+	case "succeeded":
+		result.Status = model.TaskStatusSucceeded
+		result.Progress = 100
+		// the output is a url or a list of urls for the image and video models
+		output := gjson.ParseBytes(response.Output)
+		if output.IsArray() {
+			for _, item := range output.Array() {
+				result.URLs = append(result.URLs, item.String())
+			}
+		} else if output.Type == gjson.String {
+			result.URLs = []string{output.String()}
+		}
+	case "failed", "canceled":
+		result.Status = model.TaskStatusFailed
+		if response.Error != nil {
+			result.FailReason = fmt.Sprintf("%v", response.Error)
+		} else {
+			result.FailReason = response.Status
+		}
+	default:
+		return nil, fmt.Errorf("unknown prediction status: %s", response.Status)
+	}
+	return result, nil
+}
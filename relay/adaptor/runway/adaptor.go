@@ -0,0 +1,87 @@
+package runway
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/songquanpeng/one-api/relay/adaptor"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+const apiVersion = "2024-11-06"
+
+type TaskAdaptor struct{}
+
+// GetSubmitURL returns the url of image_to_video when the request has a first frame, of text_to_video otherwise
+func (a *TaskAdaptor) GetSubmitURL(meta *meta.Meta, request *model.TaskRequest) (string, string, error) {
+	action := "text_to_video"
+	if request.Image != "" {
+		action = "image_to_video"
+	}
+	return fmt.Sprintf("%s/v1/%s", meta.BaseURL, action), action, nil
+}
+
+func (a *TaskAdaptor) GetFetchURL(meta *meta.Meta, action string, upstreamTaskId string) (string, error) {
+	return fmt.Sprintf("%s/v1/tasks/%s", meta.BaseURL, upstreamTaskId), nil
+}
+
+func (a *TaskAdaptor) SetupTaskRequestHeader(req *http.Request, meta *meta.Meta) error {
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+meta.APIKey)
+	req.Header.Set("X-Runway-Version", apiVersion)
+	return nil
+}
+
+func (a *TaskAdaptor) ConvertTaskRequest(meta *meta.Meta, request *model.TaskRequest) (any, error) {
+	ratio := request.Size
+	if ratio == "" {
+		ratio = request.AspectRatio
+	}
+	return adaptor.TaskRequestBody(request.Input, map[string]any{
+		"model":       meta.ActualModelName,
+		"promptText":  request.Prompt,
+		"promptImage": request.Image,
+		"duration":    request.Duration,
+		"ratio":       ratio,
+	}), nil
+}
+
+func (a *TaskAdaptor) ParseSubmitResponse(body []byte) (string, error) {
+	var response SubmitResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", err
+	}
+	if response.Id == "" {
+		return "", fmt.Errorf("no task id in response: %s", string(body))
+	}
+	return response.Id, nil
+}
+
+func (a *TaskAdaptor) ParseFetchResponse(meta *meta.Meta, upstreamTaskId string, body []byte) (*model.TaskResult, error) {
+	var response TaskResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+	result := &model.TaskResult{Progress: int(response.Progress * 100)}
+	switch response.Status {
+	case "PENDING", "THROTTLED":
+		result.Status = model.TaskStatusQueued
+	case "RUNNING":
+		result.Status = model.TaskStatusInProgress
+	case "SUCCEEDED":
+		result.Status = model.TaskStatusSucceeded
+		result.Progress = 100
+		result.URLs = response.Output
+	case "FAILED", "CANCELLED":
+		result.Status = model.TaskStatusFailed
+		result.FailReason = response.Failure
+		if result.FailReason == "" {
+			result.FailReason = response.Status
+		}
+	default:
+		return nil, fmt.Errorf("unknown task status: %s", response.Status)
+	}
+	return result, nil
+}
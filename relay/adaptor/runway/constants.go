@@ -0,0 +1,8 @@
+package runway
+
+var ModelList = []string{
+	"gen3a_turbo",
+	"gen4_turbo",
+	"gen4_aleph",
+	"veo3",
+}
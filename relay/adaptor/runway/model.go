@@ -0,0 +1,15 @@
+package runway
+
+// https://docs.dev.runwayml.com/api
+
+type SubmitResponse struct {
+	Id string `json:"id"`
+}
+
+type TaskResponse struct {
+	Id       string   `json:"id"`
+	Status   string   `json:"status"`
+	Progress float64  `json:"progress"` // from 0 to 1
+	Output   []string `json:"output"`
+	Failure  string   `json:"failure"`
+}
@@ -32,6 +32,9 @@ func (a *Adaptor) ConvertRequest(c *gin.Context, meta *meta.Meta, request *model
 	}
 
 	claudeReq := anthropic.ConvertRequest(*request)
+	if meta.Config.PromptCacheEnabled(meta.OriginModelName) {
+		anthropic.AddCacheControl(claudeReq, meta.Config.PromptCacheMessages)
+	}
 	req := Request{
 		AnthropicVersion: anthropicVersion,
 		// Model:            claudeReq.Model,
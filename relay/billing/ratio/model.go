@@ -87,6 +87,15 @@ var ModelRatio = map[string]float64{
 	"text-moderation-latest":     0.1,
 	"dall-e-2":                   0.02 * USD, // $0.016 - $0.020 / image
 	"dall-e-3":                   0.04 * USD, // $0.040 - $0.120 / image
+
+	// https://platform.openai.com/docs/guides/realtime
+	"gpt-4o-realtime-preview":      2.5, // $5.00 / 1M input tokens
+	"gpt-4o-mini-realtime-preview": 0.3, // $0.60 / 1M input tokens
+
+	// https://platform.openai.com/docs/guides/speech-to-text
+	"gpt-4o-transcribe":      1.25,  // $2.50 / 1M text input tokens
+	"gpt-4o-mini-transcribe": 0.625, // $1.25 / 1M text input tokens
+
 	// https://www.anthropic.com/api#pricing
 	"claude-instant-1.2":         0.8 / 1000 * USD,
 	"claude-2.0":                 8.0 / 1000 * USD,
@@ -366,6 +375,7 @@ var (
 type ModelRatioConfig struct {
 	ModelRatio      float64
 	CacheRatio      float64
+	CacheWriteRatio float64
 	CompletionRatio float64
 }
 
@@ -382,7 +392,7 @@ func init() {
 	}
 }
 
-func RefreshModelConfigCache(ctx context.Context, model string, modelRatio float64, cacheRatio float64, completionRatio float64) {
+func RefreshModelConfigCache(ctx context.Context, model string, modelRatio float64, cacheRatio float64, cacheWriteRatio float64, completionRatio float64) {
 	if ModelConfigCache == nil {
 		ModelConfigCache = make(map[string]*ModelRatioConfig)
 	}
@@ -392,6 +402,7 @@ func RefreshModelConfigCache(ctx context.Context, model string, modelRatio float
 	ModelConfigCache[model] = &ModelRatioConfig{
 		ModelRatio:      modelRatio,
 		CacheRatio:      cacheRatio,
+		CacheWriteRatio: cacheWriteRatio,
 		CompletionRatio: completionRatio,
 	}
 }
@@ -436,6 +447,9 @@ func GetModelRatio(name string, channelType int) float64 {
 	if strings.HasPrefix(name, "command-") && strings.HasSuffix(name, "-internet") {
 		name = strings.TrimSuffix(name, "-internet")
 	}
+	if price := GetPrice(name, channelType); price != nil {
+		return price.Ratios(0).Model
+	}
 	modelConfig := ModelConfigCache[name]
 	if modelConfig != nil {
 		return modelConfig.ModelRatio
@@ -474,18 +488,66 @@ func GetCacheRatio(name string, channelType int) float64 {
 	if strings.HasPrefix(name, "qwen-") && strings.HasSuffix(name, "-internet") {
 		name = strings.TrimSuffix(name, "-internet")
 	}
+	// a catalog price without cache_read falls back to the default cache ratio
+	if price := GetPrice(name, channelType); price != nil && price.Ratios(0).CacheRead > 0 {
+		return price.Ratios(0).CacheRead
+	}
 	modelConfig := ModelConfigCache[name]
 	if modelConfig != nil {
 		return modelConfig.CacheRatio
 	}
+	if strings.Contains(name, "claude") {
+		return ClaudeCacheReadRatio
+	}
 	return 0
 }
 
+// Anthropic bills the cache writes at 1.25 times the input price, or 2 times with the 1h TTL, and
+// the cache reads at 0.1 times
+const (
+	ClaudeCacheReadRatio    = 0.1
+	ClaudeCacheWriteRatio   = 1.25
+	ClaudeCacheWrite1hRatio = 2
+)
+
+// GetCacheWrite1hRatio returns the ratio of the cache writes with the 1h TTL, which keep the
+// proportion of Anthropic to the ratio of the writes with the default 5m TTL
+func GetCacheWrite1hRatio(cacheWriteRatio float64) float64 {
+	return cacheWriteRatio * ClaudeCacheWrite1hRatio / ClaudeCacheWriteRatio
+}
+
+// GetCacheWriteRatio returns the ratio of the cache write tokens to the input tokens. The writes are
+// billed as input tokens for the models that do not charge them.
+func GetCacheWriteRatio(name string, channelType int) float64 {
+	if price := GetPrice(name, channelType); price != nil {
+		if cacheWriteRatio := price.Ratios(0).CacheWrite; cacheWriteRatio > 0 {
+			return cacheWriteRatio
+		}
+	}
+	modelConfig := ModelConfigCache[name]
+	if modelConfig != nil && modelConfig.CacheWriteRatio > 0 {
+		return modelConfig.CacheWriteRatio
+	}
+	if strings.Contains(name, "claude") {
+		return ClaudeCacheWriteRatio
+	}
+	return 1
+}
+
 func GetAudioRatios(name string) (input float64, output float64) {
+	if price := GetPrice(name, 0); price != nil && (price.AudioInput > 0 || price.AudioOutput > 0) {
+		ratios := price.Ratios(0)
+		return ratios.AudioInput, ratios.AudioOutput
+	}
 	if strings.HasPrefix(name, "gpt-4o-audio-preview") {
 		return 16, 32
 	} else if strings.HasPrefix(name, "gpt-4o-realtime-preview") {
 		return 66.67, 133.34
+	} else if strings.HasPrefix(name, "gpt-4o-mini-realtime-preview") {
+		return 16.67, 33.33
+	} else if strings.HasPrefix(name, "gpt-4o-transcribe") || strings.HasPrefix(name, "gpt-4o-mini-transcribe") {
+		// $6.00 / 1M audio input tokens for gpt-4o-transcribe, $3.00 for the mini
+		return 2.4, 1
 	}
 	logger.SysError("audio ratio not found: " + name)
 	return 1, 1
@@ -495,6 +557,9 @@ func GetCompletionRatio(name string, channelType int) float64 {
 	if strings.HasPrefix(name, "qwen-") && strings.HasSuffix(name, "-internet") {
 		name = strings.TrimSuffix(name, "-internet")
 	}
+	if price := GetPrice(name, channelType); price != nil {
+		return price.Ratios(0).Completion
+	}
 	modelConfig := ModelConfigCache[name]
 	if modelConfig != nil {
 		return modelConfig.CompletionRatio
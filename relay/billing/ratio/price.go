@@ -0,0 +1,154 @@
+package ratio
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// PriceTier is the price of the requests with at least MinPromptTokens prompt tokens, in USD per 1M tokens
+type PriceTier struct {
+	MinPromptTokens int     `json:"min_prompt_tokens"`
+	Input           float64 `json:"input"`
+	Output          float64 `json:"output"`
+	CacheRead       float64 `json:"cache_read"`
+	CacheWrite      float64 `json:"cache_write"`
+}
+
+// Price is a version of the price of a model in the price catalog. It is in effect from
+// EffectiveFrom until EffectiveTo, 0 meaning until a later version takes effect.
+type Price struct {
+	Id            int
+	Model         string
+	ChannelType   int // 0 means any channel type
+	EffectiveFrom int64
+	EffectiveTo   int64
+	Tiers         []PriceTier // sorted by MinPromptTokens, the first one starts at 0
+	AudioInput    float64     // USD per 1M tokens
+	AudioOutput   float64     // USD per 1M tokens
+	Image         float64     // USD per image
+	AudioMinute   float64     // USD per minute of input audio
+	Character     float64     // USD per 1M characters of input text
+}
+
+// PriceRatios are the ratios of a price, in the units of ModelRatio, CompletionRatio and CacheRatio
+type PriceRatios struct {
+	Model       float64 `json:"model_ratio"`
+	Completion  float64 `json:"completion_ratio"`
+	CacheRead   float64 `json:"cache_ratio"`
+	CacheWrite  float64 `json:"cache_write_ratio"`
+	AudioInput  float64 `json:"audio_input_ratio"`
+	AudioOutput float64 `json:"audio_output_ratio"`
+}
+
+func (p *Price) effectiveAt(timestamp int64) bool {
+	return p.EffectiveFrom <= timestamp && (p.EffectiveTo == 0 || timestamp < p.EffectiveTo)
+}
+
+// Tier returns the tier of the price that applies to a request with the prompt tokens
+func (p *Price) Tier(promptTokens int) *PriceTier {
+	var tier *PriceTier
+	for i := range p.Tiers {
+		if p.Tiers[i].MinPromptTokens <= promptTokens {
+			tier = &p.Tiers[i]
+		}
+	}
+	return tier
+}
+
+// AudioSecondRatio is the quota of a second of input audio, 0 when the price is not per minute
+func (p *Price) AudioSecondRatio() float64 {
+	return p.AudioMinute * USD * 1000 / 60
+}
+
+// CharacterRatio is the quota of a character of input text, 0 when the price is not per character
+func (p *Price) CharacterRatio() float64 {
+	return p.Character * USD / 1000
+}
+
+// Ratios converts the price that applies to a request with the prompt tokens to ratios. A model
+// priced per image only has a model ratio, the quota of one image being ratio * 1000, and so does
+// a model priced per minute of audio or per character, the ratio being the quota of a second or
+// of a character.
+func (p *Price) Ratios(promptTokens int) PriceRatios {
+	tier := p.Tier(promptTokens)
+	if tier == nil {
+		ratios := PriceRatios{Model: p.Image * USD, Completion: 1, AudioInput: 1, AudioOutput: 1}
+		if p.AudioMinute > 0 {
+			ratios.Model = p.AudioSecondRatio()
+		} else if p.Character > 0 {
+			ratios.Model = p.CharacterRatio()
+		}
+		return ratios
+	}
+	// $2 per 1M tokens is a model ratio of 1, the other ratios are relative to the input price
+	ratios := PriceRatios{Model: tier.Input * USD / 1000, Completion: 1, AudioInput: 1, AudioOutput: 1}
+	if tier.Input > 0 {
+		ratios.Completion = tier.Output / tier.Input
+		ratios.CacheRead = tier.CacheRead / tier.Input
+		ratios.CacheWrite = tier.CacheWrite / tier.Input
+		if p.AudioInput > 0 {
+			ratios.AudioInput = p.AudioInput / tier.Input
+		}
+		if p.AudioOutput > 0 {
+			ratios.AudioOutput = p.AudioOutput / tier.Input
+		}
+	}
+	return ratios
+}
+
+var (
+	priceCatalog     map[string][]*Price
+	priceCatalogLock sync.RWMutex
+)
+
+// SetPriceCatalog replaces the cached price catalog
+func SetPriceCatalog(prices []*Price) {
+	catalog := make(map[string][]*Price)
+	for _, price := range prices {
+		sort.Slice(price.Tiers, func(i, j int) bool {
+			return price.Tiers[i].MinPromptTokens < price.Tiers[j].MinPromptTokens
+		})
+		catalog[price.Model] = append(catalog[price.Model], price)
+	}
+	priceCatalogLock.Lock()
+	priceCatalog = catalog
+	priceCatalogLock.Unlock()
+}
+
+// GetPrice returns the version of the price of the model in effect now, or nil when the catalog
+// has none. A version for the channel type wins over one for any channel type, and the version
+// that took effect last wins among those.
+func GetPrice(name string, channelType int) *Price {
+	return getPriceAt(name, channelType, time.Now().Unix())
+}
+
+func getPriceAt(name string, channelType int, timestamp int64) *Price {
+	priceCatalogLock.RLock()
+	defer priceCatalogLock.RUnlock()
+	var found *Price
+	for _, price := range priceCatalog[name] {
+		if !price.effectiveAt(timestamp) || (price.ChannelType != 0 && price.ChannelType != channelType) {
+			continue
+		}
+		if found == nil || (price.ChannelType != 0 && found.ChannelType == 0) {
+			found = price
+			continue
+		}
+		if (price.ChannelType == 0) != (found.ChannelType == 0) {
+			continue
+		}
+		if price.EffectiveFrom > found.EffectiveFrom || (price.EffectiveFrom == found.EffectiveFrom && price.Id > found.Id) {
+			found = price
+		}
+	}
+	return found
+}
+
+// GetPriceId returns the id of the price version of the model in effect now, 0 when the catalog has none
+func GetPriceId(name string, channelType int) int {
+	if price := GetPrice(name, channelType); price != nil {
+		return price.Id
+	}
+	return 0
+}
@@ -0,0 +1,69 @@
+package ratio
+
+import "testing"
+
+func TestGetPriceAt(t *testing.T) {
+	SetPriceCatalog([]*Price{
+		{Id: 1, Model: "gemini-2.5-pro", EffectiveFrom: 100, Tiers: []PriceTier{
+			{MinPromptTokens: 200000, Input: 2.5, Output: 15},
+			{MinPromptTokens: 0, Input: 1.25, Output: 10},
+		}},
+		{Id: 2, Model: "gemini-2.5-pro", EffectiveFrom: 200, EffectiveTo: 300, Tiers: []PriceTier{{Input: 2, Output: 8}}},
+		{Id: 3, Model: "gemini-2.5-pro", ChannelType: 24, EffectiveFrom: 150, Tiers: []PriceTier{{Input: 1, Output: 4}}},
+	})
+	defer SetPriceCatalog(nil)
+
+	cases := []struct {
+		timestamp   int64
+		channelType int
+		want        int
+	}{
+		{50, 0, 0},
+		{120, 0, 1},
+		{250, 0, 2},  // the later version wins while in effect
+		{300, 0, 1},  // and the earlier one applies again once it ended
+		{250, 24, 3}, // the version for the channel type wins
+	}
+	for _, c := range cases {
+		got := 0
+		if price := getPriceAt("gemini-2.5-pro", c.channelType, c.timestamp); price != nil {
+			got = price.Id
+		}
+		if got != c.want {
+			t.Errorf("getPriceAt(%d, %d) = %d, want %d", c.timestamp, c.channelType, got, c.want)
+		}
+	}
+
+	price := getPriceAt("gemini-2.5-pro", 0, 120)
+	if ratios := price.Ratios(1000); ratios.Model != 0.625 || ratios.Completion != 8 {
+		t.Errorf("base tier ratios = %+v", ratios)
+	}
+	if ratios := price.Ratios(250000); ratios.Model != 1.25 || ratios.Completion != 6 {
+		t.Errorf("long context tier ratios = %+v", ratios)
+	}
+}
+
+func TestAudioPriceRatios(t *testing.T) {
+	// the default ratios of whisper-1 and tts-1
+	if ratio := (&Price{AudioMinute: 0.006}).Ratios(0).Model; ratio < 49.999 || ratio > 50.001 {
+		t.Errorf("per minute ratio = %f, want 50", ratio)
+	}
+	if ratio := (&Price{Character: 15}).Ratios(0).Model; ratio != 7.5 {
+		t.Errorf("per character ratio = %f, want 7.5", ratio)
+	}
+}
+
+func TestCacheRatioFallback(t *testing.T) {
+	SetPriceCatalog([]*Price{
+		{Id: 1, Model: "claude-sonnet-4-5", Tiers: []PriceTier{{Input: 3, Output: 15}}},
+		{Id: 2, Model: "gpt-4.1", Tiers: []PriceTier{{Input: 2, Output: 8, CacheRead: 0.5}}},
+	})
+	defer SetPriceCatalog(nil)
+
+	if ratio := GetCacheRatio("claude-sonnet-4-5", 0); ratio != ClaudeCacheReadRatio {
+		t.Errorf("cache ratio without cache_read = %f, want %f", ratio, ClaudeCacheReadRatio)
+	}
+	if ratio := GetCacheRatio("gpt-4.1", 0); ratio != 0.25 {
+		t.Errorf("cache ratio = %f, want 0.25", ratio)
+	}
+}
@@ -51,4 +51,7 @@ const (
 	Perplexity = 48
 	IdeoGram   = 49
 	Jina       = 50
+	Kling      = 51
+	Runway     = 52
+	Replicate  = 53
 )
@@ -48,10 +48,13 @@ var ChannelBaseURLs = []string{
 	"https://api.siliconflow.cn",                // 44
 	//"https://api.x.ai",                          // 45
 	//"https://api.replicate.com/v1/models/",      // 46
-	"",                          // 45
-	"https://api.x.ai",          // 46
-	"",                          // 47
-	"https://api.perplexity.ai", // 48
-	"",                          // 49
-	"https://api.jina.ai",       // 50
+	"",                             // 45
+	"https://api.x.ai",             // 46
+	"",                             // 47
+	"https://api.perplexity.ai",    // 48
+	"",                             // 49
+	"https://api.jina.ai",          // 50
+	"https://api.klingai.com",      // 51
+	"https://api.dev.runwayml.com", // 52
+	"https://api.replicate.com",    // 53
 }
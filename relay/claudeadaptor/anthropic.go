@@ -83,10 +83,7 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*anthropic.Usage, *mode
 		return 0, nil, nil
 	})
 	common.SetEventStreamHeaders(c)
-	var inputTokens int
-	var outputTokens int
-	var cacheCreateTokens int
-	var cacheHitTokens int
+	usage := &anthropic.Usage{}
 	for scanner.Scan() {
 		data := scanner.Text()
 		render.RawData(c, data)
@@ -103,23 +100,9 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*anthropic.Usage, *mode
 			continue
 		}
 		if claudeResponse.Message != nil {
-			inputTokens += claudeResponse.Message.Usage.InputTokens
-			outputTokens += claudeResponse.Message.Usage.OutputTokens
-			cacheCreateTokens += claudeResponse.Message.Usage.CacheCreationInputTokens
-			cacheHitTokens += claudeResponse.Message.Usage.CacheReadInputTokens
+			usage.Merge(claudeResponse.Message.Usage)
 		}
-		if claudeResponse.Usage != nil {
-			inputTokens += claudeResponse.Usage.InputTokens
-			outputTokens += claudeResponse.Usage.OutputTokens
-			cacheCreateTokens += claudeResponse.Usage.CacheCreationInputTokens
-			cacheHitTokens += claudeResponse.Usage.CacheReadInputTokens
-		}
-	}
-	usage := &anthropic.Usage{
-		InputTokens:              inputTokens,
-		OutputTokens:             outputTokens,
-		CacheCreationInputTokens: cacheCreateTokens,
-		CacheReadInputTokens:     cacheHitTokens,
+		usage.Merge(claudeResponse.Usage)
 	}
 	if config.DebugUserIds[c.GetInt(ctxkey.Id)] {
 		logger.DebugForcef(c.Request.Context(), "claude usage: %v", usage)
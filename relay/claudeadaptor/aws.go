@@ -213,10 +213,7 @@ func AwsStreamHandler(c *gin.Context, request *anthropic.Request, client *bedroc
 	}(stream)
 
 	common.SetEventStreamHeaders(c)
-	var inputTokens int
-	var outputTokens int
-	var cacheCreateTokens int
-	var cacheHitTokens int
+	usage := &anthropic.Usage{}
 
 	c.Stream(func(w io.Writer) bool {
 		event, ok := <-stream.Events()
@@ -243,17 +240,9 @@ func AwsStreamHandler(c *gin.Context, request *anthropic.Request, client *bedroc
 			render.RawData(c, "")
 
 			if claudeResp.Message != nil {
-				inputTokens += claudeResp.Message.Usage.InputTokens
-				outputTokens += claudeResp.Message.Usage.OutputTokens
-				cacheCreateTokens += claudeResp.Message.Usage.CacheCreationInputTokens
-				cacheHitTokens += claudeResp.Message.Usage.CacheReadInputTokens
-			}
-			if claudeResp.Usage != nil {
-				inputTokens += claudeResp.Usage.InputTokens
-				outputTokens += claudeResp.Usage.OutputTokens
-				cacheCreateTokens += claudeResp.Usage.CacheCreationInputTokens
-				cacheHitTokens += claudeResp.Usage.CacheReadInputTokens
+				usage.Merge(claudeResp.Message.Usage)
 			}
+			usage.Merge(claudeResp.Usage)
 			return true
 		case *types.UnknownUnionMember:
 			logger.Errorf(ctx, "unknown tag:"+v.Tag)
@@ -264,10 +253,5 @@ func AwsStreamHandler(c *gin.Context, request *anthropic.Request, client *bedroc
 		}
 	})
 
-	return &anthropic.Usage{
-		InputTokens:              inputTokens,
-		OutputTokens:             outputTokens,
-		CacheCreationInputTokens: cacheCreateTokens,
-		CacheReadInputTokens:     cacheHitTokens,
-	}, nil
+	return usage, nil
 }
@@ -7,26 +7,29 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"mime/multipart"
 	"net/http"
 	"regexp"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/audio"
 	"github.com/songquanpeng/one-api/common/client"
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/ctxkey"
 	"github.com/songquanpeng/one-api/common/logger"
 	"github.com/songquanpeng/one-api/model"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
-	"github.com/songquanpeng/one-api/relay/billing"
 	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
 	"github.com/songquanpeng/one-api/relay/channeltype"
 	"github.com/songquanpeng/one-api/relay/meta"
 	relaymodel "github.com/songquanpeng/one-api/relay/model"
 	"github.com/songquanpeng/one-api/relay/relaymode"
+	"github.com/tidwall/gjson"
 )
 
 func RelayAudioHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatusCode {
@@ -40,6 +43,7 @@ func RelayAudioHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 	userId := c.GetInt(ctxkey.Id)
 	group := c.GetString(ctxkey.Group)
 	tokenName := c.GetString(ctxkey.TokenName)
+	tokenModelQuotas := c.GetString(ctxkey.TokenModelQuotas)
 
 	var ttsRequest openai.TextToSpeechRequest
 	if relayMode == relaymode.AudioSpeech {
@@ -51,22 +55,32 @@ func RelayAudioHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 		}
 		audioModel = ttsRequest.Model
 		// Check if text is too long 4096
-		if len(ttsRequest.Input) > 4096 {
+		if utf8.RuneCountInString(ttsRequest.Input) > 4096 {
 			return openai.ErrorWrapper(errors.New("input is too long (over 4096 characters)"), "text_too_long", http.StatusBadRequest)
 		}
+	} else {
+		audioModel = c.DefaultPostForm("model", audioModel)
 	}
 
 	modelRatio := billingratio.GetModelRatio(audioModel, channelType)
 	groupRatio := billingratio.GetGroupRatio(group)
 	ratio := modelRatio * groupRatio
+	usage := &audioUsage{}
 	var quota int64
 	var preConsumedQuota int64
 	switch relayMode {
 	case relaymode.AudioSpeech:
-		preConsumedQuota = int64(float64(len(ttsRequest.Input)) * ratio)
-		quota = preConsumedQuota
+		// the speech is billed per character of the input
+		usage.Characters = utf8.RuneCountInString(ttsRequest.Input)
+		quota = int64(math.Ceil(float64(usage.Characters) * ratio))
+		preConsumedQuota = quota
 	default:
-		preConsumedQuota = int64(float64(config.PreConsumedQuota) * ratio)
+		usage.Seconds = getAudioFileDuration(c)
+		if usage.Seconds > 0 {
+			preConsumedQuota = int64(math.Ceil(usage.Seconds * getAudioSecondRatio(audioModel, channelType) * groupRatio))
+		} else {
+			preConsumedQuota = int64(float64(config.PreConsumedQuota) * ratio)
+		}
 	}
 	userQuota, err := model.CacheGetUserQuota(ctx, userId)
 	if err != nil {
@@ -77,6 +91,11 @@ func RelayAudioHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 	if userQuota-preConsumedQuota < 0 {
 		return openai.ErrorWrapper(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusForbidden)
 	}
+	requestModel := audioModel
+	err = model.CheckTokenModelQuota(tokenId, requestModel, preConsumedQuota)
+	if err != nil {
+		return openai.ErrorWrapper(err, "insufficient_token_model_quota", http.StatusForbidden)
+	}
 	err = model.CacheDecreaseUserQuota(userId, preConsumedQuota)
 	if err != nil {
 		return openai.ErrorWrapper(err, "decrease_user_quota_failed", http.StatusInternalServerError)
@@ -148,12 +167,11 @@ func RelayAudioHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody.Bytes()))
 	} else {
 		responseFormat = c.DefaultPostForm("response_format", "json")
-		rewriteFormat = transFormat(responseFormat)
-		audioModel = c.DefaultPostForm("model", audioModel)
-		if audioModel != "whisper-1" {
-			modelRatio = billingratio.GetModelRatio(audioModel, channelType)
-			groupRatio = billingratio.GetGroupRatio(group)
-			ratio = modelRatio * groupRatio
+		rewriteFormat = responseFormat
+		if !isTokenBilledTranscription(audioModel) {
+			// the headers of the file only size the pre-consumed quota, the duration reported upstream is billed
+			// unless the headers claim a longer one
+			rewriteFormat = transFormat(responseFormat)
 		}
 		// 遍历所有表单字段
 		var hasFormat bool
@@ -248,22 +266,28 @@ func RelayAudioHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 				return openai.ErrorWrapper(fmt.Errorf("type %s, code %v, message %s", openAIErr.Error.Type, openAIErr.Error.Code, openAIErr.Error.Message), "request_error", http.StatusInternalServerError)
 			}
 		}
-		var seconds int64
-		var finalResp []byte
-		switch rewriteFormat {
-		case "srt":
-			seconds, finalResp = getSecFromSRT(responseBody)
-		case "vtt":
-			seconds, finalResp = getSecFromVTT(responseBody)
-		case "verbose_json":
-			seconds, finalResp, err = getSecFromVerboseJson(responseBody, responseFormat)
-			if err != nil {
-				return openai.ErrorWrapper(err, "get_sec_from_verbose_json_failed", http.StatusInternalServerError)
+		finalResp := responseBody
+		if rewriteFormat != responseFormat || !isTokenBilledTranscription(audioModel) {
+			var seconds int64
+			switch rewriteFormat {
+			case "srt":
+				seconds, finalResp = getSecFromSRT(responseBody)
+			case "vtt":
+				seconds, finalResp = getSecFromVTT(responseBody)
+			case "verbose_json":
+				seconds, finalResp, err = getSecFromVerboseJson(responseBody, responseFormat)
+				if err != nil {
+					return openai.ErrorWrapper(err, "get_sec_from_verbose_json_failed", http.StatusInternalServerError)
+				}
+			case "json", "text":
+				// the usage of a json response is read below
+			default:
+				return openai.ErrorWrapper(errors.New("unexpected_response_format"), "unexpected_response_format", http.StatusInternalServerError)
 			}
-		default:
-			return openai.ErrorWrapper(errors.New("unexpected_response_format"), "unexpected_response_format", http.StatusInternalServerError)
+			usage.Seconds = math.Max(usage.Seconds, float64(seconds))
 		}
-		quota = int64(float64(seconds) * ratio)
+		parseAudioUsage(responseBody, usage)
+		quota = getAudioQuota(usage, audioModel, channelType, groupRatio, preConsumedQuota)
 		resp.Body = io.NopCloser(bytes.NewBuffer(finalResp))
 	}
 	if resp.StatusCode != http.StatusOK {
@@ -272,7 +296,8 @@ func RelayAudioHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 	succeed = true
 	quotaDelta := quota - preConsumedQuota
 	defer func(ctx context.Context) {
-		go billing.PostConsumeQuota(ctx, tokenId, quotaDelta, quota, userId, channelId, modelRatio, groupRatio, audioModel, tokenName)
+		go postConsumeAudioQuota(ctx, tokenId, quotaDelta, quota, userId, channelId, channelType, usage, groupRatio, audioModel, tokenName, billingratio.GetPriceId(requestModel, channelType))
+		go model.RecordTokenModelUsage(tokenId, tokenModelQuotas, requestModel, quota)
 	}(c.Request.Context())
 
 	for k, v := range resp.Header {
@@ -291,6 +316,112 @@ func RelayAudioHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 	return nil
 }
 
+// audioUsage is what an audio request is billed for: the characters of a speech, the tokens of a
+// transcription when the upstream reports them, and the duration of the input audio otherwise
+type audioUsage struct {
+	Characters       int
+	Seconds          float64
+	PromptTokens     int
+	AudioTokens      int
+	CompletionTokens int
+}
+
+// isTokenBilledTranscription reports the transcription models billed per token, which do not
+// support the verbose_json response format
+func isTokenBilledTranscription(modelName string) bool {
+	return strings.HasPrefix(modelName, "gpt-4o") && strings.Contains(modelName, "transcribe")
+}
+
+// getAudioFileDuration reads the duration of the uploaded audio from the headers of the file,
+// 0 when the format is not supported
+func getAudioFileDuration(c *gin.Context) float64 {
+	if c.Request.MultipartForm == nil || len(c.Request.MultipartForm.File["file"]) == 0 {
+		return 0
+	}
+	fileHeader := c.Request.MultipartForm.File["file"][0]
+	file, err := fileHeader.Open()
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+	duration, err := audio.GetDuration(file, fileHeader.Size)
+	if err != nil {
+		logger.Debugf(c.Request.Context(), "get audio duration of %s failed: %s", fileHeader.Filename, err.Error())
+		return 0
+	}
+	return duration
+}
+
+// getAudioSecondRatio returns the quota of a second of input audio, from the per minute price of the
+// catalog when there is one
+func getAudioSecondRatio(modelName string, channelType int) float64 {
+	if price := billingratio.GetPrice(modelName, channelType); price != nil && price.AudioMinute > 0 {
+		return price.AudioSecondRatio()
+	}
+	return billingratio.GetModelRatio(modelName, channelType)
+}
+
+// parseAudioUsage reads the usage of a json transcription, in tokens or in seconds
+//
+// https://platform.openai.com/docs/api-reference/audio/json-object
+func parseAudioUsage(body []byte, usage *audioUsage) {
+	result := gjson.GetBytes(body, "usage")
+	if !result.Exists() {
+		return
+	}
+	switch result.Get("type").String() {
+	case "tokens":
+		usage.PromptTokens = int(result.Get("input_tokens").Int())
+		usage.AudioTokens = int(result.Get("input_token_details.audio_tokens").Int())
+		usage.CompletionTokens = int(result.Get("output_tokens").Int())
+	case "duration":
+		usage.Seconds = math.Max(usage.Seconds, result.Get("seconds").Float())
+	}
+}
+
+// getAudioQuota bills a transcription by its tokens when the upstream reports them, and by the
+// duration of the audio otherwise. The pre-consumed quota is kept when neither is known.
+func getAudioQuota(usage *audioUsage, modelName string, channelType int, groupRatio float64, preConsumedQuota int64) int64 {
+	if usage.PromptTokens+usage.CompletionTokens > 0 {
+		modelRatio := billingratio.GetModelRatio(modelName, channelType)
+		completionRatio := billingratio.GetCompletionRatio(modelName, channelType)
+		audioInputRatio, _ := billingratio.GetAudioRatios(modelName)
+		return int64(math.Ceil(modelRatio * groupRatio * (float64(usage.PromptTokens-usage.AudioTokens) +
+			float64(usage.AudioTokens)*audioInputRatio +
+			float64(usage.CompletionTokens)*completionRatio)))
+	}
+	if usage.Seconds > 0 {
+		return int64(math.Ceil(usage.Seconds * getAudioSecondRatio(modelName, channelType) * groupRatio))
+	}
+	return preConsumedQuota
+}
+
+func postConsumeAudioQuota(ctx context.Context, tokenId int, quotaDelta int64, quota int64, userId int, channelId int, channelType int, usage *audioUsage, groupRatio float64, modelName string, tokenName string, priceId int) {
+	err := model.PostConsumeTokenQuota(tokenId, quotaDelta)
+	if err != nil {
+		logger.SysError("error consuming token remain quota: " + err.Error())
+	}
+	err = model.CacheUpdateUserQuota(ctx, userId)
+	if err != nil {
+		logger.SysError("error update user quota cache: " + err.Error())
+	}
+	if quota == 0 {
+		return
+	}
+	var logContent string
+	switch {
+	case usage.Characters > 0:
+		logContent = fmt.Sprintf("字符数 %d，分组倍率 %.3f", usage.Characters, groupRatio)
+	case usage.PromptTokens+usage.CompletionTokens > 0:
+		logContent = fmt.Sprintf("分组倍率 %.3f(音频输入 %d)", groupRatio, usage.AudioTokens)
+	default:
+		logContent = fmt.Sprintf("音频时长 %.1f 秒，分组倍率 %.3f", usage.Seconds, groupRatio)
+	}
+	model.RecordConsumeLog(ctx, userId, channelId, channelType, usage.PromptTokens, 0, 0, usage.CompletionTokens, modelName, tokenName, quota, logContent, priceId)
+	model.UpdateUserUsedQuotaAndRequestCount(userId, quota)
+	model.UpdateChannelUsedQuota(channelId, quota)
+}
+
 func transFormat(format string) string {
 	if format == "" || format == "json" || format == "text" {
 		return "verbose_json"
@@ -87,6 +87,10 @@ func preConsumeQuota(ctx context.Context, textRequest *relaymodel.GeneralOpenAIR
 	if userQuota-preConsumedQuota < 0 {
 		return preConsumedQuota, openai.ErrorWrapper(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusForbidden)
 	}
+	err = model.CheckTokenModelQuota(meta.TokenId, meta.OriginModelName, preConsumedQuota)
+	if err != nil {
+		return preConsumedQuota, openai.ErrorWrapper(err, "insufficient_token_model_quota", http.StatusForbidden)
+	}
 	err = model.CacheDecreaseUserQuota(meta.UserId, preConsumedQuota)
 	if err != nil {
 		return preConsumedQuota, openai.ErrorWrapper(err, "decrease_user_quota_failed", http.StatusInternalServerError)
@@ -121,11 +125,40 @@ func postConsumeQuotaPerCall(ctx context.Context, usage *relaymodel.Usage, meta
 	var extraLog string
 	callCost := float64(callQuota) / 1000 * 0.002
 	extraLog += fmt.Sprintf("单次费用$%.4f。", callCost)
-	model.RecordConsumeLog(ctx, meta.UserId, meta.ChannelId, 0, 0, int(callQuota), textRequest.Model, meta.TokenName, callQuota, extraLog)
+	model.RecordConsumeLog(ctx, meta.UserId, meta.ChannelId, meta.ChannelType, 0, 0, 0, int(callQuota), textRequest.Model, meta.TokenName, callQuota, extraLog, 0)
+	model.RecordTokenModelUsage(meta.TokenId, meta.TokenModelQuotas, meta.OriginModelName, callQuota)
 	model.UpdateUserUsedQuotaAndRequestCount(meta.UserId, callQuota)
 	model.UpdateChannelUsedQuota(meta.ChannelId, callQuota)
 }
 
+// tokenCounts are the tokens of a text request, the cached, cache write and audio tokens are part of the prompt tokens
+type tokenCounts struct {
+	prompt, completion               int
+	cached, cacheWrite, cacheWrite1h int
+	audioPrompt, audioCompletion     int
+}
+
+// tokenRatios are the prices of the tokens relative to the text prompt tokens
+type tokenRatios struct {
+	completion, cacheRead, cacheWrite float64
+	audioInput, audioOutput           float64
+}
+
+func getTokenQuota(ratio float64, tokens tokenCounts, ratios tokenRatios) int64 {
+	quota := int64(math.Ceil(ratio *
+		(float64(tokens.prompt-tokens.cached-tokens.cacheWrite-tokens.audioPrompt) + // non-cached text prompt tokens
+			float64(tokens.cached)*ratios.cacheRead + // cached text prompt tokens
+			float64(tokens.cacheWrite-tokens.cacheWrite1h)*ratios.cacheWrite + // cache write tokens
+			float64(tokens.cacheWrite1h)*billingratio.GetCacheWrite1hRatio(ratios.cacheWrite) + // cache write tokens with the 1h TTL
+			float64(tokens.audioPrompt)*ratios.audioInput + // audio prompt tokens
+			float64(tokens.audioCompletion)*ratios.audioOutput + // audio completion tokens
+			float64(tokens.completion-tokens.audioCompletion)*ratios.completion))) // text completion tokens
+	if ratio != 0 && quota <= 0 {
+		quota = 1
+	}
+	return quota
+}
+
 func postConsumeQuota(c *gin.Context, ctx context.Context, usage *relaymodel.Usage, meta *meta.Meta, textRequest *relaymodel.GeneralOpenAIRequest, ratio float64, preConsumedQuota int64, modelRatio float64, groupRatio float64, systemPromptReset bool) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -144,9 +177,12 @@ func postConsumeQuota(c *gin.Context, ctx context.Context, usage *relaymodel.Usa
 	var quota int64
 	completionRatio := billingratio.GetCompletionRatio(textRequest.Model, meta.ChannelType)
 	cacheRatio := billingratio.GetCacheRatio(textRequest.Model, meta.ChannelType)
+	cacheWriteRatio := billingratio.GetCacheWriteRatio(textRequest.Model, meta.ChannelType)
 	promptTokens := usage.PromptTokens
 	completionTokens := usage.CompletionTokens
 	cachedTokens := 0
+	cacheWriteTokens := 0
+	cacheWrite1hTokens := 0
 	audioPromptTokens := 0
 	audioCompletionTokens := 0
 	if usage.PromptTokensDetails != nil {
@@ -156,6 +192,12 @@ func postConsumeQuota(c *gin.Context, ctx context.Context, usage *relaymodel.Usa
 		if usage.PromptTokensDetails.AudioTokens != nil {
 			audioPromptTokens = *usage.PromptTokensDetails.AudioTokens
 		}
+		if usage.PromptTokensDetails.CacheWriteTokens != nil {
+			cacheWriteTokens = *usage.PromptTokensDetails.CacheWriteTokens
+		}
+		if usage.PromptTokensDetails.CacheWrite1hTokens != nil {
+			cacheWrite1hTokens = *usage.PromptTokensDetails.CacheWrite1hTokens
+		}
 	}
 	if usage.CompletionTokensDetails != nil {
 		if usage.CompletionTokensDetails.AudioTokens != nil {
@@ -166,22 +208,45 @@ func postConsumeQuota(c *gin.Context, ctx context.Context, usage *relaymodel.Usa
 	if audioPromptTokens > 0 || audioCompletionTokens > 0 {
 		audioInputRatio, audioOutputRatio = billingratio.GetAudioRatios(textRequest.Model)
 	}
+	// the price catalog bills the tier of the prompt size, at the version in effect now
+	priceId := 0
+	if price := billingratio.GetPrice(textRequest.Model, meta.ChannelType); price != nil {
+		priceRatios := price.Ratios(promptTokens)
+		priceId = price.Id
+		modelRatio = priceRatios.Model
+		ratio = modelRatio * groupRatio
+		completionRatio = priceRatios.Completion
+		if priceRatios.CacheRead > 0 {
+			cacheRatio = priceRatios.CacheRead
+		}
+		if priceRatios.CacheWrite > 0 {
+			cacheWriteRatio = priceRatios.CacheWrite
+		}
+		if price.AudioInput > 0 || price.AudioOutput > 0 {
+			audioInputRatio, audioOutputRatio = priceRatios.AudioInput, priceRatios.AudioOutput
+		}
+	}
 
 	//if cacheRatio > 0 && cachedTokens > 0 {
 	//	quota = int64(math.Ceil((float64(promptTokens-cachedTokens) + float64(cachedTokens)*cacheRatio + float64(completionTokens)*completionRatio) * ratio))
 	//} else {
 	//	quota = int64(math.Ceil((float64(promptTokens) + float64(completionTokens)*completionRatio) * ratio))
 	//}
-	quota = int64(math.Ceil(ratio *
-		(float64(promptTokens-cachedTokens-audioPromptTokens) + // non-cached text prompt tokens
-			float64(cachedTokens)*cacheRatio + // cached text prompt tokens
-			float64(audioPromptTokens)*audioInputRatio + // audio prompt tokens
-			float64(audioCompletionTokens)*audioOutputRatio + // audio completion tokens
-			float64(completionTokens-audioCompletionTokens)*completionRatio))) // text completion tokens
-
-	if ratio != 0 && quota <= 0 {
-		quota = 1
-	}
+	quota = getTokenQuota(ratio, tokenCounts{
+		prompt:          promptTokens,
+		completion:      completionTokens,
+		cached:          cachedTokens,
+		cacheWrite:      cacheWriteTokens,
+		cacheWrite1h:    cacheWrite1hTokens,
+		audioPrompt:     audioPromptTokens,
+		audioCompletion: audioCompletionTokens,
+	}, tokenRatios{
+		completion:  completionRatio,
+		cacheRead:   cacheRatio,
+		cacheWrite:  cacheWriteRatio,
+		audioInput:  audioInputRatio,
+		audioOutput: audioOutputRatio,
+	})
 	var extraLog string
 
 	//tools cost
@@ -251,13 +316,20 @@ func postConsumeQuota(c *gin.Context, ctx context.Context, usage *relaymodel.Usa
 	if systemPromptReset {
 		extraLog += "注意系统提示词已被重置。"
 	}
+	if cacheWriteTokens > 0 {
+		extraLog += fmt.Sprintf("缓存写入 %d tokens，缓存写入倍率 %.3f。", cacheWriteTokens, cacheWriteRatio)
+	}
+	if virtualModel := c.GetString(ctxkey.VirtualModel); virtualModel != "" {
+		extraLog += fmt.Sprintf("虚拟模型 %s 由 %s 提供服务。", virtualModel, textRequest.Model)
+	}
 	var logContent string
 	if extraLog != "" {
 		logContent = fmt.Sprintf("模型倍率 %.3f，分组倍率 %.3f，补全倍率 %.3f(%s)", modelRatio, groupRatio, completionRatio, extraLog)
 	} else {
 		logContent = fmt.Sprintf("模型倍率 %.3f，分组倍率 %.3f，补全倍率 %.3f", modelRatio, groupRatio, completionRatio)
 	}
-	model.RecordConsumeLog(ctx, meta.UserId, meta.ChannelId, promptTokens, cachedTokens, completionTokens, textRequest.Model, meta.TokenName, quota, logContent)
+	model.RecordConsumeLog(ctx, meta.UserId, meta.ChannelId, meta.ChannelType, promptTokens, cachedTokens, cacheWriteTokens, completionTokens, textRequest.Model, meta.TokenName, quota, logContent, priceId)
+	model.RecordTokenModelUsage(meta.TokenId, meta.TokenModelQuotas, meta.OriginModelName, quota)
 	model.UpdateUserUsedQuotaAndRequestCount(meta.UserId, quota)
 	model.UpdateChannelUsedQuota(meta.ChannelId, quota)
 }
@@ -285,7 +357,8 @@ func postConsumeRerankQuota(c *gin.Context, ctx context.Context, usage *relaymod
 		logger.Error(ctx, "error update user quota cache: "+err.Error())
 	}
 
-	model.RecordConsumeLog(ctx, m.UserId, m.ChannelId, *usage.TotalTokens, 0, 0, m.OriginModelName, m.TokenName, quota, logContent)
+	model.RecordConsumeLog(ctx, m.UserId, m.ChannelId, m.ChannelType, *usage.TotalTokens, 0, 0, 0, m.OriginModelName, m.TokenName, quota, logContent, billingratio.GetPriceId(m.ActualModelName, m.ChannelType))
+	model.RecordTokenModelUsage(m.TokenId, m.TokenModelQuotas, m.OriginModelName, quota)
 	model.UpdateUserUsedQuotaAndRequestCount(m.UserId, quota)
 	model.UpdateChannelUsedQuota(m.ChannelId, quota)
 }
@@ -0,0 +1,22 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetTokenQuota(t *testing.T) {
+	ratios := tokenRatios{completion: 5, cacheRead: 0.1, cacheWrite: 1.25, audioInput: 1, audioOutput: 1}
+	// 500 uncached + 200 cached * 0.1 + 200 writes * 1.25 + 100 writes with the 1h TTL * 2 + 100 completion * 5
+	tokens := tokenCounts{prompt: 1000, completion: 100, cached: 200, cacheWrite: 300, cacheWrite1h: 100}
+	assert.Equal(t, int64(1470), getTokenQuota(1, tokens, ratios))
+	assert.Equal(t, int64(2940), getTokenQuota(2, tokens, ratios))
+
+	// the 1h writes keep the proportion of Anthropic to the cache write ratio of the model
+	ratios.cacheWrite = 2.5
+	assert.Equal(t, int64(500+20+500+400+500), getTokenQuota(1, tokens, ratios))
+
+	assert.Equal(t, int64(0), getTokenQuota(0, tokens, ratios))
+	assert.Equal(t, int64(1), getTokenQuota(0.0001, tokenCounts{prompt: 1}, ratios))
+}
@@ -160,6 +160,10 @@ func RelayImageHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 	if userQuota-quota < 0 {
 		return openai.ErrorWrapper(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusForbidden)
 	}
+	err = model.CheckTokenModelQuota(meta.TokenId, meta.OriginModelName, quota)
+	if err != nil {
+		return openai.ErrorWrapper(err, "insufficient_token_model_quota", http.StatusForbidden)
+	}
 
 	// Convert the original image model
 	imageRequest.Model, _ = getMappedModelName(imageRequest.Model, billingratio.ImageOriginModelName)
@@ -253,10 +257,11 @@ func RelayImageHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 			logContent := fmt.Sprintf("模型倍率 %.3f，分组倍率 %.3f", modelRatio, groupRatio)
 			if usage != nil {
 				logContent += fmt.Sprintf("，图片生成倍率 %.3f", billingratio.GetCompletionRatio(imageModel, meta.ChannelType))
-				model.RecordConsumeLog(ctx, meta.UserId, meta.ChannelId, usage.InputTokensDetails.TextTokens+usage.InputTokensDetails.ImageTokens*2, 0, usage.OutputTokens, imageRequest.Model, tokenName, quota, logContent)
+				model.RecordConsumeLog(ctx, meta.UserId, meta.ChannelId, meta.ChannelType, usage.InputTokensDetails.TextTokens+usage.InputTokensDetails.ImageTokens*2, 0, 0, usage.OutputTokens, imageRequest.Model, tokenName, quota, logContent, billingratio.GetPriceId(imageModel, meta.ChannelType))
 			} else {
-				model.RecordConsumeLog(ctx, meta.UserId, meta.ChannelId, 0, 0, 0, imageRequest.Model, tokenName, quota, logContent)
+				model.RecordConsumeLog(ctx, meta.UserId, meta.ChannelId, meta.ChannelType, 0, 0, 0, 0, imageRequest.Model, tokenName, quota, logContent, billingratio.GetPriceId(imageModel, meta.ChannelType))
 			}
+			model.RecordTokenModelUsage(meta.TokenId, meta.TokenModelQuotas, meta.OriginModelName, quota)
 			model.UpdateUserUsedQuotaAndRequestCount(meta.UserId, quota)
 			channelId := c.GetInt(ctxkey.ChannelId)
 			model.UpdateChannelUsedQuota(channelId, quota)
@@ -0,0 +1,298 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/apitype"
+	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	"github.com/songquanpeng/one-api/relay/meta"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+	"github.com/tidwall/gjson"
+)
+
+var realtimeUpgrader = websocket.Upgrader{
+	Subprotocols: []string{"realtime"},
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+// realtimeUsage is the usage of a response of a realtime session, sent in response.done. The
+// input and output tokens include the audio tokens, and the cached tokens the cached audio tokens.
+type realtimeUsage struct {
+	InputTokens       int
+	OutputTokens      int
+	CachedTokens      int
+	AudioInputTokens  int
+	AudioOutputTokens int
+	CachedAudioTokens int
+}
+
+func parseRealtimeUsage(message []byte) (*realtimeUsage, bool) {
+	usage := gjson.GetBytes(message, "response.usage")
+	if !usage.Exists() {
+		return nil, false
+	}
+	return &realtimeUsage{
+		InputTokens:       int(usage.Get("input_tokens").Int()),
+		OutputTokens:      int(usage.Get("output_tokens").Int()),
+		CachedTokens:      int(usage.Get("input_token_details.cached_tokens").Int()),
+		AudioInputTokens:  int(usage.Get("input_token_details.audio_tokens").Int()),
+		AudioOutputTokens: int(usage.Get("output_token_details.audio_tokens").Int()),
+		CachedAudioTokens: int(usage.Get("input_token_details.cached_tokens_details.audio_tokens").Int()),
+	}, true
+}
+
+func getRealtimeURL(meta *meta.Meta) (string, http.Header, error) {
+	baseURL := strings.TrimSuffix(meta.BaseURL, "/")
+	if strings.HasPrefix(baseURL, "https://") {
+		baseURL = "wss://" + strings.TrimPrefix(baseURL, "https://")
+	} else if strings.HasPrefix(baseURL, "http://") {
+		baseURL = "ws://" + strings.TrimPrefix(baseURL, "http://")
+	}
+	header := http.Header{}
+	if meta.ChannelType == channeltype.Azure {
+		// https://learn.microsoft.com/en-us/azure/ai-services/openai/how-to/realtime-audio-websockets
+		header.Set("api-key", meta.APIKey)
+		return fmt.Sprintf("%s/openai/realtime?api-version=%s&deployment=%s", baseURL, meta.Config.APIVersion, url.QueryEscape(meta.ActualModelName)), header, nil
+	}
+	if !strings.HasPrefix(baseURL, "wss://") && !strings.HasPrefix(baseURL, "ws://") {
+		return "", nil, fmt.Errorf("invalid base url: %s", meta.BaseURL)
+	}
+	header.Set("Authorization", "Bearer "+meta.APIKey)
+	header.Set("OpenAI-Beta", "realtime=v1")
+	return fmt.Sprintf("%s/v1/realtime?model=%s", baseURL, url.QueryEscape(meta.ActualModelName)), header, nil
+}
+
+func dialRealtime(ctx context.Context, meta *meta.Meta) (*websocket.Conn, *relaymodel.ErrorWithStatusCode) {
+	fullRequestURL, header, err := getRealtimeURL(meta)
+	if err != nil {
+		return nil, openai.ErrorWrapper(err, "get_request_url_failed", http.StatusInternalServerError)
+	}
+	dialer := websocket.Dialer{
+		HandshakeTimeout: 10 * time.Second,
+	}
+	if config.RelayProxy != "" {
+		proxyURL, err := url.Parse(config.RelayProxy)
+		if err == nil {
+			dialer.Proxy = http.ProxyURL(proxyURL)
+		}
+	}
+	conn, resp, err := dialer.DialContext(ctx, fullRequestURL, header)
+	if err != nil {
+		if resp != nil && resp.StatusCode != http.StatusSwitchingProtocols {
+			return nil, RelayErrorHandler(resp)
+		}
+		return nil, openai.ChannelErrorWrapper(err, "do_request_failed", http.StatusBadGateway)
+	}
+	return conn, nil
+}
+
+// realtimeSession proxies the events of a realtime session and settles each response on response.done,
+// the session is closed once settle returns an error
+type realtimeSession struct {
+	client    *websocket.Conn
+	upstream  *websocket.Conn
+	settle    func(usage *realtimeUsage) error
+	closeOnce sync.Once
+}
+
+func (s *realtimeSession) close() {
+	s.closeOnce.Do(func() {
+		_ = s.client.Close()
+		_ = s.upstream.Close()
+	})
+}
+
+// closeWithError sends an error event to the client and closes the session
+func (s *realtimeSession) closeWithError(code string, message string) {
+	event := map[string]any{
+		"type": "error",
+		"error": map[string]any{
+			"type":    code,
+			"code":    code,
+			"message": message,
+		},
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	_ = s.client.SetWriteDeadline(deadline)
+	_ = s.client.WriteJSON(event)
+	_ = s.client.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, code), deadline)
+	_ = s.upstream.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), deadline)
+	s.close()
+}
+
+func (s *realtimeSession) pumpClient() {
+	defer s.close()
+	for {
+		messageType, message, err := s.client.ReadMessage()
+		if err != nil {
+			return
+		}
+		if err = s.upstream.WriteMessage(messageType, message); err != nil {
+			return
+		}
+	}
+}
+
+func (s *realtimeSession) pumpUpstream() {
+	defer s.close()
+	for {
+		messageType, message, err := s.upstream.ReadMessage()
+		if err != nil {
+			return
+		}
+		if err = s.client.WriteMessage(messageType, message); err != nil {
+			return
+		}
+		if messageType != websocket.TextMessage || gjson.GetBytes(message, "type").String() != "response.done" {
+			continue
+		}
+		usage, ok := parseRealtimeUsage(message)
+		if !ok {
+			continue
+		}
+		if err = s.settle(usage); err != nil {
+			s.closeWithError("insufficient_quota", err.Error())
+			return
+		}
+	}
+}
+
+// getRealtimeQuota returns the quota of a response, billing the text and audio tokens apart
+func getRealtimeQuota(usage *realtimeUsage, modelName string, channelType int, groupRatio float64) (quota int64, modelRatio float64, completionRatio float64, priceId int) {
+	modelRatio = billingratio.GetModelRatio(modelName, channelType)
+	completionRatio = billingratio.GetCompletionRatio(modelName, channelType)
+	cacheRatio := billingratio.GetCacheRatio(modelName, channelType)
+	audioInputRatio, audioOutputRatio := 1.0, 1.0
+	if usage.AudioInputTokens > 0 || usage.AudioOutputTokens > 0 {
+		audioInputRatio, audioOutputRatio = billingratio.GetAudioRatios(modelName)
+	}
+	if price := billingratio.GetPrice(modelName, channelType); price != nil {
+		priceRatios := price.Ratios(usage.InputTokens)
+		priceId = price.Id
+		modelRatio = priceRatios.Model
+		completionRatio = priceRatios.Completion
+		if priceRatios.CacheRead > 0 {
+			cacheRatio = priceRatios.CacheRead
+		}
+		if price.AudioInput > 0 || price.AudioOutput > 0 {
+			audioInputRatio, audioOutputRatio = priceRatios.AudioInput, priceRatios.AudioOutput
+		}
+	}
+	ratio := modelRatio * groupRatio
+	cachedTextTokens := usage.CachedTokens - usage.CachedAudioTokens
+	quota = int64(math.Ceil(ratio *
+		(float64(usage.InputTokens-usage.CachedTokens-usage.AudioInputTokens+usage.CachedAudioTokens) + // non-cached text input tokens
+			float64(cachedTextTokens)*cacheRatio + // cached text input tokens
+			float64(usage.AudioInputTokens-usage.CachedAudioTokens)*audioInputRatio + // non-cached audio input tokens
+			float64(usage.CachedAudioTokens)*audioInputRatio*cacheRatio + // cached audio input tokens
+			float64(usage.AudioOutputTokens)*audioOutputRatio + // audio output tokens
+			float64(usage.OutputTokens-usage.AudioOutputTokens)*completionRatio))) // text output tokens
+	if ratio != 0 && quota <= 0 {
+		quota = 1
+	}
+	return quota, modelRatio, completionRatio, priceId
+}
+
+func postConsumeRealtimeQuota(ctx context.Context, usage *realtimeUsage, meta *meta.Meta, groupRatio float64) {
+	if usage.InputTokens+usage.OutputTokens == 0 {
+		return
+	}
+	quota, modelRatio, completionRatio, priceId := getRealtimeQuota(usage, meta.ActualModelName, meta.ChannelType, groupRatio)
+	err := model.PostConsumeTokenQuota(meta.TokenId, quota)
+	if err != nil {
+		logger.Error(ctx, "error consuming token remain quota: "+err.Error())
+	}
+	err = model.CacheUpdateUserQuota(ctx, meta.UserId)
+	if err != nil {
+		logger.Error(ctx, "error update user quota cache: "+err.Error())
+	}
+	logContent := fmt.Sprintf("模型倍率 %.3f，分组倍率 %.3f，补全倍率 %.3f(实时会话，音频输入 %d，音频输出 %d)",
+		modelRatio, groupRatio, completionRatio, usage.AudioInputTokens, usage.AudioOutputTokens)
+	model.RecordConsumeLog(ctx, meta.UserId, meta.ChannelId, meta.ChannelType, usage.InputTokens, usage.CachedTokens, 0, usage.OutputTokens, meta.ActualModelName, meta.TokenName, quota, logContent, priceId)
+	model.RecordTokenModelUsage(meta.TokenId, meta.TokenModelQuotas, meta.OriginModelName, quota)
+	model.UpdateUserUsedQuotaAndRequestCount(meta.UserId, quota)
+	model.UpdateChannelUsedQuota(meta.ChannelId, quota)
+}
+
+// checkRealtimeQuota returns an error once the user, the token or the model quota of the token is used up
+func checkRealtimeQuota(ctx context.Context, meta *meta.Meta) error {
+	userQuota, err := model.CacheGetUserQuota(ctx, meta.UserId)
+	if err != nil {
+		return err
+	}
+	if userQuota <= 0 {
+		return errors.New("用户额度不足")
+	}
+	token, err := model.GetTokenByIds(meta.TokenId, meta.UserId)
+	if err != nil {
+		return err
+	}
+	if !token.UnlimitedQuota && token.RemainQuota <= 0 {
+		return errors.New("令牌额度已用尽")
+	}
+	return token.CheckModelQuota(meta.OriginModelName, 0)
+}
+
+// RelayRealtimeHelper relays a realtime session to an OpenAI compatible or Azure channel over
+// WebSocket. Errors before the upstream accepts the session are returned so that another channel can
+// be tried, the session is then billed on each response.done and closed once the quota is used up.
+func RelayRealtimeHelper(c *gin.Context) *relaymodel.ErrorWithStatusCode {
+	ctx := c.Request.Context()
+	meta := meta.GetByContext(c)
+	if meta.APIType != apitype.OpenAI {
+		return openai.ErrorWrapper(errors.New("channel does not support realtime api"), "invalid_channel_type", http.StatusBadRequest)
+	}
+	if !websocket.IsWebSocketUpgrade(c.Request) {
+		return openai.ErrorWrapper(errors.New("realtime api requires a websocket connection"), "invalid_request", http.StatusBadRequest)
+	}
+	meta.ActualModelName, _ = getMappedModelName(meta.OriginModelName, meta.ModelMapping)
+	if err := checkRealtimeQuota(ctx, meta); err != nil {
+		return openai.ErrorWrapper(err, "insufficient_user_quota", http.StatusForbidden)
+	}
+
+	upstream, bizErr := dialRealtime(ctx, meta)
+	if bizErr != nil {
+		return bizErr
+	}
+	client, err := realtimeUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// the upgrader already replied to the client
+		logger.Errorf(ctx, "upgrade realtime connection failed: %s", err.Error())
+		_ = upstream.Close()
+		return nil
+	}
+	client.SetReadLimit(int64(config.RealtimeMaxMessageSize) << 20)
+	groupRatio := billingratio.GetGroupRatio(meta.Group)
+	session := &realtimeSession{
+		client:   client,
+		upstream: upstream,
+		settle: func(usage *realtimeUsage) error {
+			postConsumeRealtimeQuota(ctx, usage, meta, groupRatio)
+			err := checkRealtimeQuota(ctx, meta)
+			if err != nil {
+				logger.Warnf(ctx, "realtime session of user %d closed: %s", meta.UserId, err.Error())
+			}
+			return err
+		},
+	}
+	go session.pumpClient()
+	session.pumpUpstream()
+	return nil
+}
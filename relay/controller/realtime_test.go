@@ -0,0 +1,170 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestGetRealtimeQuota(t *testing.T) {
+	const modelName = "gpt-4o-realtime-preview-test"
+	billingratio.RefreshModelConfigCache(context.Background(), modelName, 2.5, 0.5, 0, 4)
+	t.Cleanup(func() { delete(billingratio.ModelConfigCache, modelName) })
+
+	quota, modelRatio, completionRatio, priceId := getRealtimeQuota(&realtimeUsage{InputTokens: 100, OutputTokens: 50}, modelName, 0, 1)
+	assert.Equal(t, int64(750), quota)
+	assert.Equal(t, 2.5, modelRatio)
+	assert.Equal(t, 4.0, completionRatio)
+	assert.Equal(t, 0, priceId)
+
+	// 300 text input + 100 cached text * 0.5 + 500 audio input * 66.67 + 100 cached audio * 66.67 * 0.5
+	// + 300 audio output * 133.34 + 200 text output * 4, at the model ratio 2.5
+	usage := &realtimeUsage{InputTokens: 1000, OutputTokens: 500, CachedTokens: 200, AudioInputTokens: 600, AudioOutputTokens: 300, CachedAudioTokens: 100}
+	quota, _, _, _ = getRealtimeQuota(usage, modelName, 0, 1)
+	assert.Equal(t, int64(194552), quota)
+	quota, _, _, _ = getRealtimeQuota(usage, modelName, 0, 0)
+	assert.Equal(t, int64(0), quota)
+}
+
+func TestParseRealtimeUsage(t *testing.T) {
+	usage, ok := parseRealtimeUsage([]byte(`{"type":"response.done","response":{"usage":{"input_tokens":1000,"output_tokens":500,
+		"input_token_details":{"cached_tokens":200,"audio_tokens":600,"cached_tokens_details":{"audio_tokens":100}},
+		"output_token_details":{"audio_tokens":300}}}}`))
+	assert.True(t, ok)
+	assert.Equal(t, realtimeUsage{InputTokens: 1000, OutputTokens: 500, CachedTokens: 200, AudioInputTokens: 600, AudioOutputTokens: 300, CachedAudioTokens: 100}, *usage)
+	_, ok = parseRealtimeUsage([]byte(`{"type":"response.done","response":{}}`))
+	assert.False(t, ok)
+}
+
+func TestRealtimeSession(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	events := []string{
+		`{"type":"session.created"}`,
+		`{"type":"response.done","response":{"usage":{"input_tokens":10,"output_tokens":5}}}`,
+		`{"type":"response.done","response":{"usage":{"input_tokens":20,"output_tokens":8}}}`,
+		`{"type":"response.done","response":{"usage":{"input_tokens":30,"output_tokens":9}}}`,
+	}
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// the first event of the client is echoed, then the responses are sent
+		if _, message, err := conn.ReadMessage(); err == nil {
+			_ = conn.WriteMessage(websocket.TextMessage, message)
+		}
+		for _, event := range events {
+			if conn.WriteMessage(websocket.TextMessage, []byte(event)) != nil {
+				return
+			}
+		}
+		_, _, _ = conn.ReadMessage()
+	}))
+	defer upstreamServer.Close()
+
+	var mu sync.Mutex
+	var settled []realtimeUsage
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		upstream, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(upstreamServer.URL, "http"), nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		client, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		client.SetReadLimit(1024)
+		session := &realtimeSession{client: client, upstream: upstream, settle: func(usage *realtimeUsage) error {
+			mu.Lock()
+			defer mu.Unlock()
+			settled = append(settled, *usage)
+			if len(settled) == 2 {
+				return errors.New("用户额度不足")
+			}
+			return nil
+		}}
+		go session.pumpClient()
+		session.pumpUpstream()
+	}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"session.update"}`)))
+	var types []string
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			assert.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation), err.Error())
+			break
+		}
+		types = append(types, gjson.GetBytes(message, "type").String())
+		if gjson.GetBytes(message, "type").String() == "error" {
+			assert.Equal(t, "insufficient_quota", gjson.GetBytes(message, "error.code").String())
+		}
+	}
+	<-done
+	// the session is closed after the response that used up the quota, the next one is not relayed
+	assert.Equal(t, []string{"session.update", "session.created", "response.done", "response.done", "error"}, types)
+	assert.Equal(t, []realtimeUsage{{InputTokens: 10, OutputTokens: 5}, {InputTokens: 20, OutputTokens: 8}}, settled)
+}
+
+func TestRealtimeSessionReadLimit(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _, _ = conn.ReadMessage()
+	}))
+	defer upstreamServer.Close()
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		upstream, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(upstreamServer.URL, "http"), nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		client, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		client.SetReadLimit(16)
+		session := &realtimeSession{client: client, upstream: upstream, settle: func(*realtimeUsage) error { return nil }}
+		go session.pumpUpstream()
+		session.pumpClient()
+	}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("a", 64))))
+	// a message over the limit closes the session
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseMessageTooBig), "%v", err)
+	<-done
+}
@@ -0,0 +1,58 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// RelayShadowTextHelper replays a text request against the channel set up in the context.
+// Unlike RelayTextHelper it neither pre-consumes nor post-consumes quota, and it always
+// requests a non-stream response so that usage can be read from a single body.
+func RelayShadowTextHelper(c *gin.Context) (*model.Usage, *model.ErrorWithStatusCode) {
+	ctx := c.Request.Context()
+	meta := meta.GetByContext(c)
+	textRequest, err := getAndValidateTextRequest(c, meta.Mode)
+	if err != nil {
+		return nil, openai.ErrorWrapper(err, "invalid_text_request", http.StatusBadRequest)
+	}
+	textRequest.Stream = false
+	textRequest.StreamOptions = nil
+	meta.IsStream = false
+
+	meta.OriginModelName = textRequest.Model
+	textRequest.Model, _ = getMappedModelName(textRequest.Model, meta.ModelMapping)
+	meta.ActualModelName = textRequest.Model
+	setSystemPrompt(ctx, textRequest, meta.SystemPrompt)
+	meta.PromptTokens = getPromptTokens(textRequest, meta.Mode)
+
+	adaptor := relay.GetAdaptor(meta.APIType)
+	if adaptor == nil {
+		return nil, openai.ErrorWrapper(fmt.Errorf("invalid api type: %d", meta.APIType), "invalid_api_type", http.StatusBadRequest)
+	}
+	adaptor.Init(meta)
+
+	requestBody, err := getRequestBody(c, meta, textRequest, adaptor)
+	if err != nil {
+		return nil, openai.ErrorWrapper(err, "convert_request_failed", http.StatusInternalServerError)
+	}
+	resp, err := adaptor.DoRequest(c, meta, requestBody)
+	if err != nil {
+		logger.Errorf(ctx, "shadow DoRequest failed: %s", err.Error())
+		return nil, openai.ChannelErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+	}
+	if isErrorHappened(meta, resp) {
+		return nil, RelayErrorHandler(resp)
+	}
+	usage, respErr := adaptor.DoResponse(c, resp, meta)
+	if respErr != nil {
+		return nil, respErr
+	}
+	return usage, nil
+}
@@ -0,0 +1,418 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/client"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/random"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay"
+	"github.com/songquanpeng/one-api/relay/adaptor"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	"github.com/songquanpeng/one-api/relay/meta"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+)
+
+// TaskView is the status of a task returned to the user
+type TaskView struct {
+	Id         string   `json:"id"`
+	Object     string   `json:"object"`
+	Model      string   `json:"model"`
+	Status     string   `json:"status"`
+	Progress   int      `json:"progress"`
+	ResultURLs []string `json:"result_urls,omitempty"`
+	FailReason string   `json:"fail_reason,omitempty"`
+	CreatedAt  int64    `json:"created_at"`
+	FinishedAt int64    `json:"finished_at,omitempty"`
+	Quota      int64    `json:"quota"`
+}
+
+// GetTaskView returns the status of the task. The results that are cached, or that need the
+// credentials of the channel, are served by /v1/tasks/:id/content instead of the upstream urls.
+func GetTaskView(task *model.Task) *TaskView {
+	view := &TaskView{
+		Id:         task.TaskId,
+		Object:     "task",
+		Model:      task.Model,
+		Status:     task.Status,
+		Progress:   task.Progress,
+		FailReason: task.FailReason,
+		CreatedAt:  task.CreatedTime,
+		FinishedAt: task.FinishedTime,
+		Quota:      task.Quota,
+	}
+	urls := task.GetResultURLs()
+	for i, url := range urls {
+		if task.Cached || task.URLsNeedAuth {
+			url = fmt.Sprintf("%s/v1/tasks/%s/content?index=%d", config.ServerAddress, task.TaskId, i)
+		}
+		view.ResultURLs = append(view.ResultURLs, url)
+	}
+	return view
+}
+
+func getTaskQuota(modelName string, channelType int, group string, units int) (int64, int) {
+	ratio := billingratio.GetModelRatio(modelName, channelType) * billingratio.GetGroupRatio(group)
+	// like the images, a task costs ratio * 1000 per unit, a unit being a second of video
+	return int64(math.Ceil(ratio*1000)) * int64(units), billingratio.GetPriceId(modelName, channelType)
+}
+
+func doTaskRequest(ctx context.Context, taskAdaptor adaptor.TaskAdaptor, meta *meta.Meta, method string, url string, body io.Reader) ([]byte, *relaymodel.ErrorWithStatusCode) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, openai.ErrorWrapper(err, "new_request_failed", http.StatusInternalServerError)
+	}
+	if err = taskAdaptor.SetupTaskRequestHeader(req, meta); err != nil {
+		return nil, openai.ErrorWrapper(err, "setup_request_header_failed", http.StatusInternalServerError)
+	}
+	resp, err := client.HTTPClient.Do(req)
+	if err != nil {
+		return nil, openai.ChannelErrorWrapper(errors.New(adaptor.MaskBaseURL(err.Error(), meta.BaseURL)), "do_request_failed", http.StatusBadGateway)
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, RelayErrorHandler(resp)
+	}
+	defer resp.Body.Close()
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, openai.ChannelErrorWrapper(err, "read_response_body_failed", http.StatusBadGateway)
+	}
+	return responseBody, nil
+}
+
+// RelayTaskHelper submits an asynchronous task. The quota of the task is pre-consumed, and returned
+// when the upstream rejects it, the poller settles it once the task reaches a final state.
+func RelayTaskHelper(c *gin.Context) *relaymodel.ErrorWithStatusCode {
+	ctx := c.Request.Context()
+	meta := meta.GetByContext(c)
+	var request relaymodel.TaskRequest
+	if err := common.UnmarshalBodyReusable(c, &request); err != nil {
+		return openai.ErrorWrapper(err, "invalid_task_request", http.StatusBadRequest)
+	}
+	if request.Model == "" {
+		return openai.ErrorWrapper(errors.New("model is required"), "invalid_task_request", http.StatusBadRequest)
+	}
+	if request.Duration < 0 {
+		return openai.ErrorWrapper(errors.New("duration must not be negative"), "invalid_task_request", http.StatusBadRequest)
+	}
+	taskAdaptor := relay.GetTaskAdaptor(meta.ChannelType)
+	if taskAdaptor == nil {
+		return openai.ErrorWrapper(fmt.Errorf("channel type %d does not support tasks", meta.ChannelType), "invalid_channel_type", http.StatusBadRequest)
+	}
+	meta.OriginModelName = request.Model
+	meta.ActualModelName, _ = getMappedModelName(request.Model, meta.ModelMapping)
+
+	units := 1
+	if request.Duration > 0 {
+		units = request.Duration
+	}
+	quota, priceId := getTaskQuota(meta.ActualModelName, meta.ChannelType, meta.Group, units)
+	userQuota, err := model.CacheGetUserQuota(ctx, meta.UserId)
+	if err != nil {
+		return openai.ErrorWrapper(err, "get_user_quota_failed", http.StatusInternalServerError)
+	}
+	if userQuota-quota < 0 {
+		return openai.ErrorWrapper(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusForbidden)
+	}
+	if err = model.CheckTokenModelQuota(meta.TokenId, meta.OriginModelName, quota); err != nil {
+		return openai.ErrorWrapper(err, "insufficient_token_model_quota", http.StatusForbidden)
+	}
+
+	submitURL, action, err := taskAdaptor.GetSubmitURL(meta, &request)
+	if err != nil {
+		return openai.ErrorWrapper(err, "get_request_url_failed", http.StatusBadRequest)
+	}
+	convertedRequest, err := taskAdaptor.ConvertTaskRequest(meta, &request)
+	if err != nil {
+		return openai.ErrorWrapper(err, "convert_request_failed", http.StatusBadRequest)
+	}
+	requestBody, err := json.Marshal(convertedRequest)
+	if err != nil {
+		return openai.ErrorWrapper(err, "marshal_request_failed", http.StatusInternalServerError)
+	}
+
+	if err = model.CacheDecreaseUserQuota(meta.UserId, quota); err != nil {
+		return openai.ErrorWrapper(err, "decrease_user_quota_failed", http.StatusInternalServerError)
+	}
+	if err = model.PreConsumeTokenQuota(meta.TokenId, quota); err != nil {
+		_ = model.CacheUpdateUserQuota(ctx, meta.UserId)
+		return openai.ErrorWrapper(err, "pre_consume_token_quota_failed", http.StatusForbidden)
+	}
+	refund := func() {
+		if err := model.PostConsumeTokenQuota(meta.TokenId, -quota); err != nil {
+			logger.Error(ctx, "error return pre-consumed quota: "+err.Error())
+		}
+		_ = model.CacheUpdateUserQuota(ctx, meta.UserId)
+	}
+	responseBody, bizErr := doTaskRequest(ctx, taskAdaptor, meta, http.MethodPost, submitURL, bytes.NewReader(requestBody))
+	if bizErr != nil {
+		refund()
+		return bizErr
+	}
+	upstreamTaskId, err := taskAdaptor.ParseSubmitResponse(responseBody)
+	if err != nil {
+		refund()
+		return openai.ChannelErrorWrapper(err, "parse_response_failed", http.StatusBadGateway)
+	}
+
+	task := &model.Task{
+		TaskId:         "task_" + random.GetUUID(),
+		UserId:         meta.UserId,
+		TokenId:        meta.TokenId,
+		TokenName:      meta.TokenName,
+		ChannelId:      meta.ChannelId,
+		ChannelType:    meta.ChannelType,
+		KeyHash:        c.GetString(ctxkey.ChannelKeyHash),
+		Model:          meta.OriginModelName,
+		ActualModel:    meta.ActualModelName,
+		Action:         action,
+		UpstreamTaskId: upstreamTaskId,
+		Status:         relaymodel.TaskStatusQueued,
+		Quota:          quota,
+		Units:          units,
+		PriceId:        priceId,
+	}
+	if err = task.Insert(); err != nil {
+		// the upstream task runs anyway, keep the quota rather than losing track of it. The error is
+		// not to be retried, a retry would submit the task again, the task is recovered by hand.
+		logger.Errorf(ctx, "insert task failed, recover by hand: upstream task %s of channel #%d, user #%d, token #%d, model %s, quota %d: %s",
+			upstreamTaskId, meta.ChannelId, meta.UserId, meta.TokenId, meta.OriginModelName, quota, err.Error())
+		return openai.ErrorWrapper(fmt.Errorf("任务 %s 已提交但记录失败，请勿重试并联系管理员", upstreamTaskId), "task_not_recorded", http.StatusUnprocessableEntity)
+	}
+	c.JSON(http.StatusOK, GetTaskView(task))
+	return nil
+}
+
+// GetTaskMeta rebuilds the meta of the channel a task was submitted to, for the requests made after it
+func GetTaskMeta(task *model.Task) (*meta.Meta, adaptor.TaskAdaptor, error) {
+	channel, err := model.GetChannelById(task.ChannelId, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	taskAdaptor := relay.GetTaskAdaptor(channel.Type)
+	if taskAdaptor == nil {
+		return nil, nil, fmt.Errorf("channel type %d does not support tasks", channel.Type)
+	}
+	m := &meta.Meta{
+		ChannelType:     channel.Type,
+		ChannelId:       channel.Id,
+		TokenId:         task.TokenId,
+		TokenName:       task.TokenName,
+		UserId:          task.UserId,
+		BaseURL:         channel.GetBaseURL(),
+		APIKey:          channel.GetKeyByHash(task.KeyHash),
+		OriginModelName: task.Model,
+		ActualModelName: task.ActualModel,
+		Extra:           make(map[string]string),
+	}
+	if m.BaseURL == "" {
+		m.BaseURL = channeltype.ChannelBaseURLs[m.ChannelType]
+	}
+	m.Config, _ = channel.LoadConfig()
+	m.APIType = channeltype.ToAPIType(m.ChannelType)
+	return m, taskAdaptor, nil
+}
+
+// UpdateTask fetches the status of an unfinished task, and settles it once it is finished or timed out
+func UpdateTask(ctx context.Context, task *model.Task) error {
+	meta, result, err := fetchTask(ctx, task)
+	if err == nil && result.IsFinished() {
+		return settleTask(ctx, task, meta, result)
+	}
+	if helper.GetTimestamp()-task.CreatedTime > int64(config.TaskTimeout) {
+		reason := "任务超时"
+		if err != nil {
+			reason += "：" + err.Error()
+		}
+		return settleTask(ctx, task, meta, &relaymodel.TaskResult{Status: relaymodel.TaskStatusFailed, FailReason: reason})
+	}
+	if err != nil {
+		// move the task to the back of the queue, so that it does not hold the others back
+		_ = model.UpdateTaskProgress(task.Id, task.Status, task.Progress)
+		return err
+	}
+	return model.UpdateTaskProgress(task.Id, result.Status, result.Progress)
+}
+
+func fetchTask(ctx context.Context, task *model.Task) (*meta.Meta, *relaymodel.TaskResult, error) {
+	meta, taskAdaptor, err := GetTaskMeta(task)
+	if err != nil {
+		return nil, nil, err
+	}
+	fetchURL, err := taskAdaptor.GetFetchURL(meta, task.Action, task.UpstreamTaskId)
+	if err != nil {
+		return meta, nil, err
+	}
+	responseBody, bizErr := doTaskRequest(ctx, taskAdaptor, meta, http.MethodGet, fetchURL, nil)
+	if bizErr != nil {
+		return meta, nil, errors.New(bizErr.Error.Message)
+	}
+	result, err := taskAdaptor.ParseFetchResponse(meta, task.UpstreamTaskId, responseBody)
+	return meta, result, err
+}
+
+// settleTask finishes the task, billing a succeeded task and refunding a failed one. A succeeded video
+// is billed for its actual length when the provider reports it.
+func settleTask(ctx context.Context, task *model.Task, meta *meta.Meta, result *relaymodel.TaskResult) error {
+	preConsumedQuota := task.Quota
+	task.Status = result.Status
+	task.Progress = result.Progress
+	task.FailReason = result.FailReason
+	if result.Status == relaymodel.TaskStatusSucceeded {
+		urls, _ := json.Marshal(result.URLs)
+		task.ResultURLs = string(urls)
+		task.URLsNeedAuth = result.URLsNeedAuth
+		task.Quota = getSettledTaskQuota(preConsumedQuota, task.Units, result.Duration)
+	} else {
+		task.Quota = 0
+	}
+	ok, err := model.FinishTask(task)
+	if err != nil || !ok {
+		return err
+	}
+	if task.Status == relaymodel.TaskStatusSucceeded && config.TaskResultDir != "" && meta != nil {
+		go cacheTaskResults(task, meta, result.URLs)
+	}
+
+	quotaDelta := task.Quota - preConsumedQuota
+	if quotaDelta != 0 {
+		if err = model.PostConsumeTokenQuota(task.TokenId, quotaDelta); err != nil {
+			logger.Error(ctx, "error settling task quota: "+err.Error())
+		}
+		if err = model.CacheUpdateUserQuota(ctx, task.UserId); err != nil {
+			logger.Error(ctx, "error update user quota cache: "+err.Error())
+		}
+	}
+	if task.Status != relaymodel.TaskStatusSucceeded {
+		model.RecordLog(task.UserId, model.LogTypeSystem, fmt.Sprintf("任务 %s 失败，已退还预扣额度 %s：%s", task.TaskId, common.LogQuota(preConsumedQuota), task.FailReason))
+		return nil
+	}
+	logContent := fmt.Sprintf("异步任务 %s，计费单位 %d", task.TaskId, task.Units)
+	if result.Duration > 0 {
+		logContent = fmt.Sprintf("异步任务 %s，视频时长 %.1f 秒", task.TaskId, result.Duration)
+	}
+	model.RecordConsumeLog(ctx, task.UserId, task.ChannelId, task.ChannelType, 0, 0, 0, 0, task.Model, task.TokenName, task.Quota, logContent, task.PriceId)
+	// the task is settled by the polling, after the request that loaded the token
+	if token, err := model.GetTokenById(task.TokenId); err == nil && token.ModelQuotas != nil {
+		model.RecordTokenModelUsage(task.TokenId, *token.ModelQuotas, task.Model, task.Quota)
+	}
+	model.UpdateUserUsedQuotaAndRequestCount(task.UserId, task.Quota)
+	model.UpdateChannelUsedQuota(task.ChannelId, task.Quota)
+	return nil
+}
+
+// getSettledTaskQuota bills the pre-consumed quota per unit for the duration reported by the provider,
+// a task billed for one unit without a requested duration is thus billed per second of its result
+func getSettledTaskQuota(preConsumedQuota int64, units int, duration float64) int64 {
+	if duration <= 0 || units <= 0 {
+		return preConsumedQuota
+	}
+	return int64(math.Ceil(float64(preConsumedQuota) / float64(units) * duration))
+}
+
+// GetTaskResultPath returns the path of a result of the task downloaded to TASK_RESULT_DIR
+func GetTaskResultPath(task *model.Task, index int) string {
+	return filepath.Join(config.TaskResultDir, task.TaskId, strconv.Itoa(index))
+}
+
+// taskResultDownloads bounds the results downloaded at the same time
+var taskResultDownloads = make(chan struct{}, 4)
+
+// cacheTaskResults downloads the results of a finished task to TASK_RESULT_DIR, off the poll loop,
+// the upstream urls are served until it is done
+func cacheTaskResults(task *model.Task, meta *meta.Meta, urls []string) {
+	if len(urls) == 0 {
+		return
+	}
+	taskResultDownloads <- struct{}{}
+	defer func() { <-taskResultDownloads }()
+	ctx := context.Background()
+	if err := os.MkdirAll(filepath.Join(config.TaskResultDir, task.TaskId), 0755); err != nil {
+		logger.Errorf(ctx, "create result dir of task %s failed: %s", task.TaskId, err.Error())
+		return
+	}
+	for i, url := range urls {
+		if err := downloadTaskResult(ctx, task, meta, url, GetTaskResultPath(task, i)); err != nil {
+			logger.Errorf(ctx, "download result %d of task %s failed: %s", i, task.TaskId, err.Error())
+			_ = os.RemoveAll(filepath.Join(config.TaskResultDir, task.TaskId))
+			return
+		}
+	}
+	if err := model.SetTaskCached(task.Id); err != nil {
+		logger.Errorf(ctx, "set task %s cached failed: %s", task.TaskId, err.Error())
+	}
+}
+
+func downloadTaskResult(ctx context.Context, task *model.Task, meta *meta.Meta, url string, path string) error {
+	resp, err := OpenTaskResult(ctx, task, meta, url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = copyTaskResult(file, resp.Body, int64(config.TaskResultMaxSize)<<20)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// copyTaskResult copies a result, failing when it is larger than maxSize bytes
+func copyTaskResult(dst io.Writer, src io.Reader, maxSize int64) error {
+	n, err := io.Copy(dst, io.LimitReader(src, maxSize+1))
+	if err != nil {
+		return err
+	}
+	if n > maxSize {
+		return fmt.Errorf("result is larger than %d bytes", maxSize)
+	}
+	return nil
+}
+
+// OpenTaskResult requests a result of the task upstream, with the credentials of the channel when needed
+func OpenTaskResult(ctx context.Context, task *model.Task, meta *meta.Meta, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if task.URLsNeedAuth {
+		taskAdaptor := relay.GetTaskAdaptor(meta.ChannelType)
+		if taskAdaptor == nil {
+			return nil, fmt.Errorf("channel type %d does not support tasks", meta.ChannelType)
+		}
+		if err = taskAdaptor.SetupTaskRequestHeader(req, meta); err != nil {
+			return nil, err
+		}
+		req.Header.Del("Content-Type")
+	}
+	resp, err := client.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("bad response status code %d", resp.StatusCode)
+	}
+	return resp, nil
+}
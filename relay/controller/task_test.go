@@ -0,0 +1,28 @@
+package controller
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetSettledTaskQuota(t *testing.T) {
+	assert.Equal(t, int64(500), getSettledTaskQuota(500, 5, 0))
+	assert.Equal(t, int64(1000), getSettledTaskQuota(500, 5, 10))
+	assert.Equal(t, int64(400), getSettledTaskQuota(500, 5, 4))
+	// a task billed for one unit is billed per second reported
+	assert.Equal(t, int64(500), getSettledTaskQuota(100, 1, 5))
+	assert.Equal(t, int64(134), getSettledTaskQuota(100, 3, 4))
+}
+
+func TestCopyTaskResult(t *testing.T) {
+	var dst bytes.Buffer
+	assert.NoError(t, copyTaskResult(&dst, strings.NewReader("12345"), 5))
+	assert.Equal(t, "12345", dst.String())
+
+	dst.Reset()
+	assert.Error(t, copyTaskResult(&dst, strings.NewReader("123456"), 5))
+	assert.Equal(t, 6, dst.Len())
+}
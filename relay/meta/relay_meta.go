@@ -10,14 +10,16 @@ import (
 )
 
 type Meta struct {
-	Mode         int
-	ChannelType  int
-	ChannelId    int
-	TokenId      int
-	TokenName    string
-	UserId       int
-	Group        string
-	ModelMapping map[string]string
+	Mode        int
+	ChannelType int
+	ChannelId   int
+	TokenId     int
+	TokenName   string
+	// TokenModelQuotas is the model quotas of the token, loaded with the token by the auth
+	TokenModelQuotas string
+	UserId           int
+	Group            string
+	ModelMapping     map[string]string
 	// BaseURL is the proxy url set in the channel config
 	BaseURL  string
 	APIKey   string
@@ -36,20 +38,21 @@ type Meta struct {
 
 func GetByContext(c *gin.Context) *Meta {
 	meta := Meta{
-		Mode:            relaymode.GetByPath(c.Request.URL.Path),
-		ChannelType:     c.GetInt(ctxkey.Channel),
-		ChannelId:       c.GetInt(ctxkey.ChannelId),
-		TokenId:         c.GetInt(ctxkey.TokenId),
-		TokenName:       c.GetString(ctxkey.TokenName),
-		UserId:          c.GetInt(ctxkey.Id),
-		Group:           c.GetString(ctxkey.Group),
-		ModelMapping:    c.GetStringMapString(ctxkey.ModelMapping),
-		OriginModelName: c.GetString(ctxkey.RequestModel),
-		BaseURL:         c.GetString(ctxkey.BaseURL),
-		APIKey:          strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer "),
-		RequestURLPath:  c.Request.URL.String(),
-		SystemPrompt:    c.GetString(ctxkey.SystemPrompt),
-		Extra:           make(map[string]string),
+		Mode:             relaymode.GetByPath(c.Request.URL.Path),
+		ChannelType:      c.GetInt(ctxkey.Channel),
+		ChannelId:        c.GetInt(ctxkey.ChannelId),
+		TokenId:          c.GetInt(ctxkey.TokenId),
+		TokenName:        c.GetString(ctxkey.TokenName),
+		TokenModelQuotas: c.GetString(ctxkey.TokenModelQuotas),
+		UserId:           c.GetInt(ctxkey.Id),
+		Group:            c.GetString(ctxkey.Group),
+		ModelMapping:     c.GetStringMapString(ctxkey.ModelMapping),
+		OriginModelName:  c.GetString(ctxkey.RequestModel),
+		BaseURL:          c.GetString(ctxkey.BaseURL),
+		APIKey:           strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer "),
+		RequestURLPath:   c.Request.URL.String(),
+		SystemPrompt:     c.GetString(ctxkey.SystemPrompt),
+		Extra:            make(map[string]string),
 	}
 	cfg, ok := c.Get(ctxkey.Config)
 	if ok {
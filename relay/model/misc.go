@@ -23,6 +23,9 @@ type InputTokensDetails struct {
 type PromptTokensDetails struct {
 	AudioTokens  *int `json:"audio_tokens,omitempty"`
 	CachedTokens *int `json:"cached_tokens,omitempty"`
+	// the cache writes are only used for billing and are not part of the OpenAI usage returned to the client
+	CacheWriteTokens   *int `json:"-"`
+	CacheWrite1hTokens *int `json:"-"` // included in CacheWriteTokens
 }
 
 type CompletionTokensDetails struct {
@@ -0,0 +1,36 @@
+package model
+
+const (
+	TaskStatusQueued     = "queued"
+	TaskStatusInProgress = "in_progress"
+	TaskStatusSucceeded  = "succeeded"
+	TaskStatusFailed     = "failed"
+)
+
+// TaskRequest is the request of an asynchronous task, like a video generation. Input holds the
+// parameters specific to the provider, they are passed through to it.
+type TaskRequest struct {
+	Model          string         `json:"model"`
+	Prompt         string         `json:"prompt,omitempty"`
+	NegativePrompt string         `json:"negative_prompt,omitempty"`
+	Image          string         `json:"image,omitempty"`    // url or base64 of the first frame
+	Duration       int            `json:"duration,omitempty"` // unit is second, for videos
+	AspectRatio    string         `json:"aspect_ratio,omitempty"`
+	Size           string         `json:"size,omitempty"`
+	Input          map[string]any `json:"input,omitempty"`
+}
+
+// TaskResult is the status of a task upstream
+type TaskResult struct {
+	Status     string
+	Progress   int // percent
+	URLs       []string
+	FailReason string
+	Duration   float64 // unit is second, the length of the generated video when the provider reports it
+	// URLsNeedAuth is set when the result urls are only served with the credentials of the channel
+	URLsNeedAuth bool
+}
+
+func (r *TaskResult) IsFinished() bool {
+	return r.Status == TaskStatusSucceeded || r.Status == TaskStatusFailed
+}
@@ -16,4 +16,8 @@ const (
 	Rerank
 	// Proxy is a special relay mode for proxying requests to custom upstream
 	Proxy
+	// Realtime relays a realtime session over WebSocket
+	Realtime
+	// Tasks submits an asynchronous task, like a video generation
+	Tasks
 )
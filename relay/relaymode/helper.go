@@ -32,6 +32,10 @@ func GetByPath(path string) int {
 		relayMode = Rerank
 	} else if strings.HasPrefix(path, "/v1/proxy") {
 		relayMode = Proxy
+	} else if strings.HasPrefix(path, "/v1/realtime") {
+		relayMode = Realtime
+	} else if strings.HasPrefix(path, "/v1/tasks") {
+		relayMode = Tasks
 	}
 	return relayMode
 }
@@ -0,0 +1,182 @@
+package routing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/tidwall/gjson"
+)
+
+// text fields of the supported request formats (OpenAI, Anthropic, Gemini, Responses)
+var promptPaths = []string{
+	"messages.#.content",
+	"messages.#.content.#.text",
+	"system",
+	"system.#.text",
+	"prompt",
+	"input",
+	"input.#.content",
+	"input.#.content.#.text",
+	"instructions",
+	"contents.#.parts.#.text",
+	"systemInstruction.parts.#.text",
+}
+
+// EstimatePromptTokens counts the tokens of every text field found in the request body
+func EstimatePromptTokens(body []byte, modelName string) int {
+	if !gjson.ValidBytes(body) {
+		return 0
+	}
+	var builder strings.Builder
+	for _, p := range promptPaths {
+		collectText(gjson.GetBytes(body, p), &builder)
+	}
+	return openai.CountTokenText(builder.String(), modelName)
+}
+
+func collectText(result gjson.Result, builder *strings.Builder) {
+	switch {
+	case result.IsArray():
+		for _, item := range result.Array() {
+			collectText(item, builder)
+		}
+	case result.Type == gjson.String:
+		builder.WriteString(result.Str)
+		builder.WriteString("\n")
+	}
+}
+
+// Match evaluates the routing rules for the request in c, after the token has been authenticated
+func Match(c *gin.Context, group string, modelName string) *model.RoutingRule {
+	body, _ := common.GetRequestBody(c)
+	req := &model.RoutingRequest{
+		TokenId:  c.GetInt(ctxkey.TokenId),
+		Group:    group,
+		Model:    modelName,
+		IsStream: gjson.GetBytes(body, "stream").Bool() || strings.Contains(c.Request.URL.Path, "streamGenerateContent"),
+		Header:   c.Request.Header,
+		Time:     time.Now(),
+		EstimatePromptTokens: func() int {
+			return EstimatePromptTokens(body, modelName)
+		},
+	}
+	return model.CacheMatchRoutingRule(req)
+}
+
+// CheckTokenModel checks a model the request is rewritten to against the models of the token,
+// the auth middleware only checked the requested one
+func CheckTokenModel(c *gin.Context, modelName string) error {
+	if available := c.GetString(ctxkey.AvailableModels); available != "" && !model.IsModelAllowed(available, modelName) {
+		return fmt.Errorf("该令牌无权使用模型：%s", modelName)
+	}
+	if tokenId := c.GetInt(ctxkey.TokenId); tokenId != 0 {
+		return model.CheckTokenModelQuota(tokenId, modelName, 0)
+	}
+	return nil
+}
+
+// RewriteModel replaces the model of the request in c, in the JSON or multipart body and in the Gemini style path
+func RewriteModel(c *gin.Context, from string, to string) error {
+	c.Set(ctxkey.RequestModel, to)
+	if strings.Contains(c.Request.URL.Path, "/models/"+from) {
+		c.Request.URL.Path = strings.Replace(c.Request.URL.Path, "/models/"+from, "/models/"+to, 1)
+	}
+	body, err := common.GetRequestBody(c)
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(c.Request.Header.Get("Content-Type"), "multipart/form-data") {
+		body, err = rewriteMultipartModel(body, c.Request.Header.Get("Content-Type"), to)
+		if err != nil {
+			return err
+		}
+		// the form parsed with the previous model is parsed again from the new body
+		c.Request.MultipartForm = nil
+		c.Request.PostForm = nil
+		c.Request.Form = nil
+	} else {
+		if !gjson.GetBytes(body, "model").Exists() {
+			return nil
+		}
+		var jsonBody map[string]any
+		if err = json.Unmarshal(body, &jsonBody); err != nil {
+			return err
+		}
+		jsonBody["model"] = to
+		body, err = json.Marshal(jsonBody)
+		if err != nil {
+			return err
+		}
+	}
+	c.Set(ctxkey.KeyRequestBody, body)
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(body))
+	c.Request.ContentLength = int64(len(body))
+	return nil
+}
+
+// rewriteMultipartModel replaces the model field of a multipart form, or adds it, keeping the boundary
+// so that the content type of the request still holds
+func rewriteMultipartModel(body []byte, contentType string, to string) ([]byte, error) {
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, err
+	}
+	reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	if err = writer.SetBoundary(params["boundary"]); err != nil {
+		return nil, err
+	}
+	found := false
+	for {
+		part, err := reader.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		partWriter, err := writer.CreatePart(part.Header)
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == "model" && part.FileName() == "" {
+			found = true
+			_, err = io.WriteString(partWriter, to)
+		} else {
+			_, err = io.Copy(partWriter, part)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !found {
+		if err = writer.WriteField("model", to); err != nil {
+			return nil, err
+		}
+	}
+	if err = writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ServeVirtualModel rewrites a request of a virtual model to the real model serving it,
+// and reports the served model in the response header
+func ServeVirtualModel(c *gin.Context, virtualModelName string, from string, servedModel string) error {
+	c.Set(ctxkey.VirtualModel, virtualModelName)
+	c.Header(helper.ServedModelKey, servedModel)
+	return RewriteModel(c, from, servedModel)
+}
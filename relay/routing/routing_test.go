@@ -0,0 +1,42 @@
+package routing
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRewriteMultipartModel(t *testing.T) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	require.NoError(t, writer.WriteField("model", "whisper-1"))
+	file, err := writer.CreateFormFile("file", "audio.wav")
+	require.NoError(t, err)
+	_, _ = file.Write([]byte("RIFF"))
+	require.NoError(t, writer.Close())
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/audio/transcriptions", &body)
+	c.Request.Header.Set("Content-Type", writer.FormDataContentType())
+	require.NoError(t, RewriteModel(c, "whisper-1", "gpt-4o-transcribe"))
+
+	assert.Equal(t, "gpt-4o-transcribe", c.GetString(ctxkey.RequestModel))
+	require.NoError(t, c.Request.ParseMultipartForm(1<<20))
+	assert.Equal(t, "gpt-4o-transcribe", c.Request.FormValue("model"))
+	assert.Len(t, c.Request.MultipartForm.File["file"], 1)
+}
+
+func TestCheckTokenModel(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	assert.NoError(t, CheckTokenModel(c, "gpt-4o"))
+	c.Set(ctxkey.AvailableModels, "gpt-4o-mini")
+	assert.Error(t, CheckTokenModel(c, "gpt-4o"))
+	assert.NoError(t, CheckTokenModel(c, "gpt-4o-mini"))
+}
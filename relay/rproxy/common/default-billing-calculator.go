@@ -92,6 +92,7 @@ type Bill struct {
 	TotalQuota       int64
 	Discounts        []*Discount
 	Extra            map[string]any
+	Price            *ratio.Price // the version of the price catalog billed, nil for the ratios
 }
 
 type DefaultBillingCalculator struct {
@@ -125,6 +126,7 @@ func (b *DefaultBillingCalculator) PreCalAndExecute(context *rproxy.RproxyContex
 		PreBillItems: make([]*BillItem, 0),
 		BillItems:    make([]*BillItem, 0),
 		Discounts:    make([]*Discount, 0),
+		Price:        ratio.GetPrice(context.GetOriginalModel(), channel.Type),
 	}
 	// 获取并添加模型倍率折扣
 	modelRatio := ratio.GetModelRatio(context.GetOriginalModel(), channel.Type)
@@ -161,6 +163,10 @@ func (b *DefaultBillingCalculator) PreCalAndExecute(context *rproxy.RproxyContex
 	if userQuota-b.Bill.PreTotalQuota < 0 {
 		return openai.ErrorWrapper(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusForbidden)
 	}
+	err = model.CheckTokenModelQuota(context.Meta.TokenId, context.GetOriginalModel(), b.Bill.PreTotalQuota)
+	if err != nil {
+		return openai.ErrorWrapper(err, "insufficient_token_model_quota", http.StatusForbidden)
+	}
 	err = model.CacheDecreaseUserQuota(context.GetUserId(), b.Bill.PreTotalQuota)
 	if err != nil {
 		return openai.ErrorWrapper(err, "decrease_user_quota_failed", http.StatusInternalServerError)
@@ -188,6 +194,7 @@ func (b *DefaultBillingCalculator) PostCalcAndExecute(context *rproxy.RproxyCont
 	if b.PostCalcStrategyFunc != nil {
 		b.PostCalcStrategyFunc(context, b.GetChannel(), b.Bill)
 	}
+	b.applyPriceTier()
 	//计算总费率
 	b.calcTotalBill()
 	if b.Bill.TotalQuota <= 0 {
@@ -234,12 +241,76 @@ func (b *DefaultBillingCalculator) PostCalcAndExecute(context *rproxy.RproxyCont
 	if err != nil {
 		logger.SysError("error update user quota cache: " + err.Error())
 	}
-	model.RecordConsumeLog(context.SrcContext, context.GetUserId(), b.GetChannel().Id, promptTokens, cachedTokens, completionTokens, b.Bill.ModelName, context.Meta.TokenName, b.Bill.TotalQuota, logContent)
+	priceId := 0
+	if b.Bill.Price != nil {
+		priceId = b.Bill.Price.Id
+	}
+	model.RecordConsumeLog(context.SrcContext, context.GetUserId(), b.GetChannel().Id, b.GetChannel().Type, promptTokens, cachedTokens, 0, completionTokens, b.Bill.ModelName, context.Meta.TokenName, b.Bill.TotalQuota, logContent, priceId)
+	model.RecordTokenModelUsage(context.Meta.TokenId, context.Meta.TokenModelQuotas, context.GetOriginalModel(), b.Bill.TotalQuota)
 	model.UpdateUserUsedQuotaAndRequestCount(context.GetUserId(), b.Bill.TotalQuota)
 	model.UpdateChannelUsedQuota(b.GetChannel().Id, b.Bill.TotalQuota)
 	return nil
 }
 
+// billItemType returns the type of a bill item, the items named by the adaptors keep the type of their name
+func billItemType(item *BillItem) ItemType {
+	switch item.Name {
+	case "PromptTokens":
+		return PromptTokens
+	case "CompletionTokens":
+		return CompletionTokens
+	case "CachedTokens":
+		return CachedTokens
+	}
+	return item.ItemType
+}
+
+// applyPriceTier bills the token usage at the tier of the price catalog matching the prompt size,
+// the pre-consumed quota having been estimated at the base tier
+func (b *DefaultBillingCalculator) applyPriceTier() {
+	if b.Bill == nil || b.Bill.Price == nil {
+		return
+	}
+	items := b.Bill.BillItems
+	if len(items) == 0 {
+		items = b.Bill.PreBillItems
+	}
+	promptTokens := 0
+	for _, item := range items {
+		if item.ChargeMode == TokenUsage && billItemType(item) == PromptTokens {
+			promptTokens += int(item.Quantity)
+		}
+	}
+	priceRatios := b.Bill.Price.Ratios(promptTokens)
+	for _, discount := range b.Bill.Discounts {
+		if discount.ID == "model_ratio" {
+			discount.Ratio = priceRatios.Model
+		}
+	}
+	for _, item := range items {
+		if item.ChargeMode != TokenUsage {
+			continue
+		}
+		var unitPrice float64
+		switch billItemType(item) {
+		case CompletionTokens:
+			unitPrice = priceRatios.Completion
+		case CachedTokens:
+			if priceRatios.CacheRead <= 0 {
+				continue
+			}
+			unitPrice = priceRatios.CacheRead
+		default:
+			continue
+		}
+		item.UnitPrice = unitPrice
+		item.Quota = int64(item.Quantity * unitPrice)
+		if item.Discount != nil {
+			item.Discount.Ratio = unitPrice
+		}
+	}
+}
+
 func (b *DefaultBillingCalculator) calcPreTotalBill() {
 	if b.Bill == nil {
 		return
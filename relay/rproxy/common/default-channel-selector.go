@@ -4,9 +4,13 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/logger"
 	"github.com/songquanpeng/one-api/model"
 	dbmodel "github.com/songquanpeng/one-api/model"
 	relaymodel "github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/routing"
 	"github.com/songquanpeng/one-api/relay/rproxy"
 )
 
@@ -30,6 +34,73 @@ func (c DefaultChannelSelector) SelectChannel(context *rproxy.RproxyContext) (or
 		}
 		return []*model.Channel{channel}, nil
 	} else {
+		if rule := routing.Match(context.SrcContext, context.GetGroup(), context.GetRequestModel()); rule != nil {
+			logger.Infof(context.SrcContext, "routing rule #%d %s matched, action: %s", rule.Id, rule.Name, rule.Action)
+			switch rule.Action {
+			case model.RoutingActionReject:
+				message := rule.RejectMessage
+				if message == "" {
+					message = "request rejected by routing rule"
+				}
+				return nil, relaymodel.NewErrorWithStatusCode(http.StatusForbidden, "routing_rule_rejected", message)
+			case model.RoutingActionRewrite:
+				if e := routing.CheckTokenModel(context.SrcContext, rule.TargetModel); e != nil {
+					return nil, relaymodel.NewErrorWithStatusCode(http.StatusForbidden, "model_not_allowed", e.Error())
+				}
+				if e := routing.RewriteModel(context.SrcContext, context.GetRequestModel(), rule.TargetModel); e != nil {
+					return nil, relaymodel.NewErrorWithStatusCode(http.StatusBadRequest, "routing_rule_rewrite_failed", e.Error())
+				}
+				context.Meta.OriginModelName = rule.TargetModel
+				if _, ok := context.ResolvedRequest.([]byte); ok {
+					context.ResolvedRequest, _ = common.GetRequestBody(context.SrcContext)
+				}
+			case model.RoutingActionRoute:
+				context.SrcContext.Set(ctxkey.RoutingChannelIds, rule.GetChannelIds())
+				// a virtual model is served by the first model of its chain the routed channels support
+				modelNames := []string{context.GetRequestModel()}
+				virtualModel := model.CacheGetVirtualModel(context.GetGroup(), context.GetRequestModel())
+				if virtualModel != nil {
+					modelNames = virtualModel.GetModels()
+				}
+				var servedModel string
+				for _, servedModel = range modelNames {
+					orderedChannels = model.CacheGetChannelsByIds(rule.GetChannelIds(), servedModel, nil)
+					if len(orderedChannels) > 0 {
+						break
+					}
+				}
+				if len(orderedChannels) == 0 {
+					return nil, relaymodel.NewErrorWithStatusCode(http.StatusServiceUnavailable, "no_valid_channel_error", "no channel of the routing rule is available for the model")
+				}
+				if virtualModel != nil {
+					if e := routing.ServeVirtualModel(context.SrcContext, virtualModel.Name, context.GetRequestModel(), servedModel); e != nil {
+						return nil, relaymodel.NewErrorWithStatusCode(http.StatusBadRequest, "virtual_model_rewrite_failed", e.Error())
+					}
+					context.Meta.OriginModelName = servedModel
+					if _, ok := context.ResolvedRequest.([]byte); ok {
+						context.ResolvedRequest, _ = common.GetRequestBody(context.SrcContext)
+					}
+				}
+				return orderedChannels, nil
+			}
+		}
+		if virtualModel := model.CacheGetVirtualModel(context.GetGroup(), context.GetRequestModel()); virtualModel != nil {
+			// the channels of the first model of the chain are returned here, the tolerancer
+			// falls back to the next models of the chain when all of them fail
+			servedModel, channel := model.CacheGetVirtualModelChannel(context.GetGroup(), virtualModel, "", nil)
+			if channel == nil {
+				return nil, relaymodel.NewErrorWithStatusCode(http.StatusServiceUnavailable, "no_valid_channel_error", "no model of the virtual model is available")
+			}
+			if e := routing.ServeVirtualModel(context.SrcContext, virtualModel.Name, context.GetRequestModel(), servedModel); e != nil {
+				return nil, relaymodel.NewErrorWithStatusCode(http.StatusBadRequest, "virtual_model_rewrite_failed", e.Error())
+			}
+			context.Meta.OriginModelName = servedModel
+			if _, ok := context.ResolvedRequest.([]byte); ok {
+				context.ResolvedRequest, _ = common.GetRequestBody(context.SrcContext)
+			}
+			channels, _ := dbmodel.CacheGetOrderedChannels(context.GetGroup(), servedModel, nil)
+			return channels, nil
+		}
 		orderedChannels = make([]*model.Channel, 0)
 		//根据能力选择供应商
 		recentChannelId := model.CacheGetRecentChannel(context.SrcContext.Request.Context(), context.GetUserId(), context.GetRequestModel())
@@ -40,6 +40,9 @@ func (c *DefaultContextInitializer) Initialize(context *rproxy.RproxyContext) (e
 	context.SrcContext.Set(ctxkey.Id, token.UserId)
 	context.SrcContext.Set(ctxkey.TokenId, token.Id)
 	context.SrcContext.Set(ctxkey.TokenName, token.Name)
+	if token.ModelQuotas != nil && *token.ModelQuotas != "" {
+		context.SrcContext.Set(ctxkey.TokenModelQuotas, *token.ModelQuotas)
+	}
 	if channelId := context.SrcContext.Param("channelid"); channelId != "" {
 		context.SrcContext.Set(ctxkey.SpecificChannelId, channelId)
 	}
@@ -14,10 +14,15 @@ type TokenModelValidator struct {
 
 func (this *TokenModelValidator) Validate() *relaymodel.ErrorWithStatusCode {
 	if this.ctx.Token.Models != nil && *this.ctx.Token.Models != "" {
-		if this.ctx.GetOriginalModel() != "" && !isModelInList(this.ctx.GetOriginalModel(), *this.ctx.Token.Models) {
+		if this.ctx.GetOriginalModel() != "" && !this.ctx.Token.AllowsModel(this.ctx.GetOriginalModel()) {
 			return relaymodel.NewErrorWithStatusCode(http.StatusForbidden, nil, "该令牌不支持该模型")
 		}
 	}
+	if this.ctx.GetOriginalModel() != "" {
+		if err := this.ctx.Token.CheckModelQuota(this.ctx.GetOriginalModel(), 0); err != nil {
+			return relaymodel.NewErrorWithStatusCode(http.StatusForbidden, nil, err.Error())
+		}
+	}
 	return nil
 }
 
@@ -3,9 +3,12 @@ package common
 import (
 	"net/http"
 	"strconv"
+	"time"
 
+	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/logger"
 	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/monitor"
 	relaymodel "github.com/songquanpeng/one-api/relay/model"
 	"github.com/songquanpeng/one-api/relay/rproxy"
 )
@@ -24,7 +27,31 @@ func (t *ToleranceHandler) Handle(channel *model.Channel, context *rproxy.Rproxy
 		logger.Errorf(context.SrcContext, "get_adaptor_failed channel %v", channel)
 		return relaymodel.NewErrorWithStatusCode(http.StatusInternalServerError, "get_adaptor_failed", "get_adaptor_failed")
 	}
+	if channel.HasLimit() {
+		timeout := time.Duration(config.ChannelLimitQueueTimeout) * time.Second
+		if !model.AcquireChannel(context.SrcContext.Request.Context(), channel, timeout) {
+			return relaymodel.NewErrorWithStatusCode(http.StatusTooManyRequests, "channel_busy", "channel is at its concurrency or rpm limit")
+		}
+		defer model.ReleaseChannel(channel)
+	}
+	// the adaptors read channel.Key, hand them a copy holding the selected key in plaintext
+	keyedChannel := *channel
+	var keyHash string
+	var e error
+	keyedChannel.Key, keyHash, e = channel.SelectKey()
+	if e != nil {
+		go monitor.DisableChannel(channel.Id, channel.Name, "所有密钥均已被禁用")
+		return relaymodel.NewErrorWithStatusCode(http.StatusServiceUnavailable, "channel_keys_disabled", e.Error())
+	}
+	channel = &keyedChannel
 	adaptor.SetChannel(channel)
 	_, err = adaptor.DoRequest(context)
+	if err != nil && keyHash != "" {
+		if monitor.ShouldDisableChannel(err, err.StatusCode) {
+			go monitor.DisableChannelKey(channel.Id, channel.Name, keyHash, err.Message)
+		} else if monitor.ShouldCooldownChannelKey(err) {
+			model.CooldownChannelKey(channel.Id, keyHash)
+		}
+	}
 	return
 }
@@ -3,9 +3,12 @@ package rproxy
 import (
 	"net/http"
 
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/ctxkey"
 	"github.com/songquanpeng/one-api/common/logger"
 	"github.com/songquanpeng/one-api/model"
 	relaymodel "github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/routing"
 )
 
 type FailOverTolerancer struct {
@@ -31,27 +34,59 @@ func (f *FailOverTolerancer) FaultTolerance(context *RproxyContext) (err *relaym
 	if len(orderedChannels) == 0 {
 		return relaymodel.NewErrorWithStatusCode(http.StatusInternalServerError, "no_channel_available", "通道访问失败")
 	}
-	f.channels = orderedChannels
-	for _, channel := range f.channels {
-		if channel.Status != 1 {
-			continue
+	triedChannels := orderedChannels
+	for len(orderedChannels) > 0 {
+		// channels at their concurrency or rpm limit are tried last
+		f.channels = model.SortChannelsByAvailability(orderedChannels)
+		for _, channel := range f.channels {
+			if channel.Status != 1 {
+				continue
+			}
+			e := f.handler.Handle(channel, context)
+			if e == nil {
+				model.CacheSetRecentChannel(context.SrcContext, context.GetUserId(), context.GetOriginalModel(), channel.Id)
+				return nil
+			}
+			logger.Errorf(context.SrcContext, "channelId: %d ,error handling request: msg:%s ,err:%s", channel.Id, e.Message, e.Error.Message)
+			if e.StatusCode == http.StatusInternalServerError && e.Error.Code == "get_adaptor_failed" {
+				continue
+			}
+			err = e
 		}
-		e := f.handler.Handle(channel, context)
-		if e == nil {
-			model.CacheSetRecentChannel(context.SrcContext, context.GetUserId(), context.GetOriginalModel(), channel.Id)
-			return nil
-		}
-		logger.Errorf(context.SrcContext, "channelId: %d ,error handling request: msg:%s ,err:%s", channel.Id, e.Message, e.Error.Message)
-		if e.StatusCode == http.StatusInternalServerError && e.Error.Code == "get_adaptor_failed" {
-			continue
-		}
-		err = e
+		orderedChannels = nextVirtualModelChannels(context)
+		triedChannels = append(triedChannels, orderedChannels...)
 	}
 	model.CacheSetRecentChannel(context.SrcContext, context.GetUserId(), context.GetOriginalModel(), 0)
-	go LogRespError(context, orderedChannels, err)
+	go LogRespError(context, triedChannels, err)
 	return
 }
 
+// nextVirtualModelChannels moves a request of a virtual model to the next model of its chain
+// with available channels, and returns these channels, or nil at the end of the chain
+func nextVirtualModelChannels(context *RproxyContext) []*model.Channel {
+	virtualModel := model.CacheGetVirtualModel(context.GetGroup(), context.SrcContext.GetString(ctxkey.VirtualModel))
+	if virtualModel == nil {
+		return nil
+	}
+	servedModel := context.GetOriginalModel()
+	channelIds, _ := context.SrcContext.Get(ctxkey.RoutingChannelIds)
+	routedIds, _ := channelIds.([]int)
+	nextModel, channels := model.CacheGetNextVirtualModelChannels(context.GetGroup(), virtualModel, servedModel, routedIds)
+	if len(channels) == 0 {
+		return nil
+	}
+	if e := routing.ServeVirtualModel(context.SrcContext, virtualModel.Name, servedModel, nextModel); e != nil {
+		logger.Errorf(context.SrcContext, "failed to fall back to model %s of virtual model %s: %s", nextModel, virtualModel.Name, e.Error())
+		return nil
+	}
+	logger.Infof(context.SrcContext, "virtual model %s falls back from %s to %s", virtualModel.Name, servedModel, nextModel)
+	context.Meta.OriginModelName = nextModel
+	if _, ok := context.ResolvedRequest.([]byte); ok {
+		context.ResolvedRequest, _ = common.GetRequestBody(context.SrcContext)
+	}
+	return channels
+}
+
 func (f *FailOverTolerancer) GetHandler() Handler {
 	return f.handler
 }
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 
 	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/logger"
 	"github.com/songquanpeng/one-api/model"
@@ -19,6 +20,11 @@ func LogRespError(ctx *RproxyContext, channels []*model.Channel, err *relaymodel
 		channelIds = append(channelIds, channel.Id)
 	}
 	logger.Errorf(ctx.SrcContext, "relay error (user id: %d, model: %s, channels: %v): %s", userId, originalModel, channelIds, err.Message)
+	channelType := 0
+	if len(channels) > 0 {
+		channelType = channels[len(channels)-1].Type
+	}
+	dbmodel.RecordUsageError(userId, originalModel, ctx.SrcContext.GetString(ctxkey.TokenName), channelType)
 	if config.IsZiai {
 		return
 	}
@@ -5,6 +5,7 @@ import (
 	"github.com/songquanpeng/one-api/controller/auth"
 	"github.com/songquanpeng/one-api/controller/pay"
 	"github.com/songquanpeng/one-api/middleware"
+	"github.com/songquanpeng/one-api/model"
 
 	"github.com/gin-contrib/gzip"
 	"github.com/gin-gonic/gin"
@@ -19,6 +20,7 @@ func SetApiRouter(router *gin.Engine) {
 		apiRouter.GET("/model_usage_detail", controller.GetModelUsageDetail)
 		apiRouter.GET("/model_usage_count", controller.GetModelUsageCount)
 		apiRouter.GET("/status", controller.GetStatus)
+		apiRouter.GET("/status/buffer", middleware.ScopeAuth(model.ScopeStatusRead), controller.GetBufferStatus)
 		apiRouter.GET("/models", middleware.UserAuth(), controller.DashboardListModels)
 		apiRouter.GET("/model_info", controller.GetModelInfo)
 		apiRouter.GET("/developers", controller.GetModelDevelopers)
@@ -32,17 +34,19 @@ func SetApiRouter(router *gin.Engine) {
 		apiRouter.GET("/oauth/github", middleware.CriticalRateLimit(), auth.GitHubOAuth)
 		apiRouter.GET("/oauth/google", middleware.CriticalRateLimit(), auth.GoogleOAuth)
 		apiRouter.GET("/oauth/oidc", middleware.CriticalRateLimit(), auth.OidcAuth)
+		apiRouter.GET("/oauth/oidc/login", middleware.CriticalRateLimit(), auth.OidcLogin)
 		apiRouter.GET("/oauth/lark", middleware.CriticalRateLimit(), auth.LarkOAuth)
 		apiRouter.GET("/oauth/state", middleware.CriticalRateLimit(), auth.GenerateOAuthCode)
 		apiRouter.GET("/oauth/wechat", middleware.CriticalRateLimit(), auth.WeChatAuth)
-		apiRouter.GET("/oauth/wechat/bind", middleware.CriticalRateLimit(), middleware.UserAuth(), auth.WeChatBind)
-		apiRouter.GET("/oauth/email/bind", middleware.CriticalRateLimit(), middleware.UserAuth(), controller.EmailBind)
-		apiRouter.POST("/topup", middleware.AdminAuth(), controller.AdminTopUp)
+		apiRouter.GET("/oauth/wechat/bind", middleware.CriticalRateLimit(), middleware.UserAuth(), middleware.DenyManagementKey(), auth.WeChatBind)
+		apiRouter.GET("/oauth/email/bind", middleware.CriticalRateLimit(), middleware.UserAuth(), middleware.DenyManagementKey(), controller.EmailBind)
+		apiRouter.POST("/topup", middleware.ScopeAuth(model.ScopeUsersManage), middleware.Audit(model.AuditTargetUserQuota), controller.AdminTopUp)
 
 		userRoute := apiRouter.Group("/user")
 		{
 			userRoute.POST("/register", middleware.CriticalRateLimit(), middleware.TurnstileCheck(), controller.Register)
 			userRoute.POST("/login", middleware.CriticalRateLimit(), controller.Login)
+			userRoute.POST("/login/two_factor", middleware.CriticalRateLimit(), controller.VerifyTwoFactorLogin)
 			userRoute.GET("/logout", controller.Logout)
 
 			selfRoute := userRoute.Group("/")
@@ -50,89 +54,191 @@ func SetApiRouter(router *gin.Engine) {
 			{
 				//selfRoute.GET("/dashboard", controller.GetUserDashboard)
 				selfRoute.GET("/self", controller.GetSelf)
-				selfRoute.PUT("/self", controller.UpdateSelf)
-				selfRoute.DELETE("/self", controller.DeleteSelf)
-				selfRoute.GET("/token", controller.GenerateAccessToken)
+				selfRoute.PUT("/self", middleware.Audit(model.AuditTargetSelf), controller.UpdateSelf)
+				selfRoute.DELETE("/self", middleware.Audit(model.AuditTargetSelf), controller.DeleteSelf)
+				selfRoute.GET("/token", middleware.DenyManagementKey(), middleware.AuditGet(model.AuditTargetSelf), controller.GenerateAccessToken)
 				selfRoute.GET("/aff", controller.GetAffCode)
-				selfRoute.POST("/topup", controller.TopUp)
-				selfRoute.POST("/remind", controller.UpdateRemind)
+				selfRoute.POST("/topup", middleware.Audit(model.AuditTargetSelf), controller.TopUp)
+				selfRoute.POST("/remind", middleware.Audit(model.AuditTargetSelf), controller.UpdateRemind)
 				selfRoute.GET("/available_models", controller.GetUserAvailableModels)
 				selfRoute.GET("/quota_records", controller.GetUserQuotaRecords)
+				selfRoute.GET("/permissions", controller.GetSelfPermissions)
+				selfRoute.GET("/management_keys", controller.GetManagementKeys)
+				selfRoute.POST("/management_keys", middleware.Audit(model.AuditTargetManagementKey), controller.AddManagementKey)
+				selfRoute.DELETE("/management_keys/:id", middleware.Audit(model.AuditTargetManagementKey), controller.RevokeManagementKey)
+				selfRoute.GET("/two_factor", middleware.DenyManagementKey(), controller.GetSelfTwoFactor)
+				selfRoute.POST("/two_factor/setup", middleware.DenyManagementKey(), controller.SetupSelfTwoFactor)
+				selfRoute.POST("/two_factor/enable", middleware.CriticalRateLimit(), middleware.DenyManagementKey(), controller.EnableSelfTwoFactor)
+				selfRoute.POST("/two_factor/disable", middleware.CriticalRateLimit(), middleware.DenyManagementKey(), controller.DisableSelfTwoFactor)
+				selfRoute.POST("/two_factor/recovery_codes", middleware.CriticalRateLimit(), middleware.DenyManagementKey(), controller.RegenerateSelfRecoveryCodes)
 			}
 
 			adminRoute := userRoute.Group("/")
-			adminRoute.Use(middleware.AdminAuth())
+			adminRoute.Use(middleware.ReadWriteScopeAuth(model.ScopeUsersRead, model.ScopeUsersManage))
 			{
 				adminRoute.GET("/", controller.GetAllUsers)
 				adminRoute.GET("/search", controller.SearchUsers)
 				adminRoute.GET("/:id", controller.GetUser)
-				adminRoute.POST("/", controller.CreateUser)
-				adminRoute.POST("/manage", controller.ManageUser)
-				adminRoute.PUT("/", controller.UpdateUser)
-				adminRoute.DELETE("/:id", controller.DeleteUser)
+				adminRoute.POST("/", middleware.Audit(model.AuditTargetUser), controller.CreateUser)
+				adminRoute.POST("/manage", middleware.Audit(model.AuditTargetUser), controller.ManageUser)
+				adminRoute.PUT("/", middleware.Audit(model.AuditTargetUser), controller.UpdateUser)
+				adminRoute.DELETE("/:id", middleware.Audit(model.AuditTargetUser), controller.DeleteUser)
+				adminRoute.DELETE("/:id/two_factor", middleware.Audit(model.AuditTargetTwoFactor), controller.ResetUserTwoFactor)
 			}
 		}
 		optionRoute := apiRouter.Group("/option")
-		optionRoute.Use(middleware.AdminAuth())
+		optionRoute.Use(middleware.ReadWriteScopeAuth(model.ScopeOptionsRead, model.ScopeOptionsWrite))
 		{
 			optionRoute.GET("/", controller.GetOptions)
-			optionRoute.PUT("/", controller.UpdateOption)
+			optionRoute.PUT("/", middleware.Audit(model.AuditTargetOption), controller.UpdateOption)
 			optionRoute.GET("/model", controller.GetModelOptions)
-			optionRoute.PUT("/model", controller.UpsertModelOption)
-			optionRoute.DELETE("/model", controller.DeleteModelOption)
+			optionRoute.PUT("/model", middleware.Audit(model.AuditTargetModelOption), controller.UpsertModelOption)
+			optionRoute.DELETE("/model", middleware.Audit(model.AuditTargetModelOption), controller.DeleteModelOption)
 			optionRoute.GET("/tags", controller.GetAllTags)
 		}
+		priceRoute := apiRouter.Group("/price_catalog")
+		priceRoute.Use(middleware.ReadWriteScopeAuth(model.ScopeOptionsRead, model.ScopeOptionsWrite))
+		{
+			priceRoute.GET("/", controller.GetModelPrices)
+			priceRoute.GET("/effective", controller.GetEffectiveModelPrice)
+			priceRoute.GET("/:id", controller.GetModelPrice)
+			priceRoute.POST("/", middleware.Audit(model.AuditTargetModelPrice), controller.AddModelPrice)
+			priceRoute.PUT("/", middleware.Audit(model.AuditTargetModelPrice), controller.UpdateModelPrice)
+			priceRoute.DELETE("/:id", middleware.Audit(model.AuditTargetModelPrice), controller.DeleteModelPrice)
+		}
 		channelRoute := apiRouter.Group("/channel")
-		channelRoute.Use(middleware.AdminAuth())
+		channelRoute.Use(middleware.ReadWriteScopeAuth(model.ScopeChannelsRead, model.ScopeChannelsWrite))
 		{
 			channelRoute.GET("/", controller.GetAllChannels)
 			channelRoute.GET("/search", controller.SearchChannels)
 			channelRoute.GET("/models", controller.ListAllModels)
 			channelRoute.GET("/:id", controller.GetChannel)
-			channelRoute.GET("/test", controller.TestChannels)
-			channelRoute.GET("/test/:id", controller.TestChannel)
-			channelRoute.GET("/update_balance", controller.UpdateAllChannelsBalance)
-			channelRoute.GET("/update_balance/:id", controller.UpdateChannelBalance)
-			channelRoute.POST("/", controller.AddChannel)
-			channelRoute.PUT("/", controller.UpdateChannel)
-			channelRoute.DELETE("/disabled", controller.DeleteDisabledChannel)
-			channelRoute.DELETE("/:id", controller.DeleteChannel)
+			channelRoute.GET("/test", middleware.ScopeAuth(model.ScopeChannelsWrite), middleware.AuditGet(model.AuditTargetChannel), controller.TestChannels)
+			channelRoute.GET("/test/:id", middleware.ScopeAuth(model.ScopeChannelsWrite), middleware.AuditGet(model.AuditTargetChannel), controller.TestChannel)
+			channelRoute.GET("/occupancy", middleware.ScopeAuth(model.ScopeChannelsRead), controller.GetChannelOccupancies)
+			channelRoute.GET("/reconcile", controller.GetChannelReconciliations)
+			channelRoute.POST("/reencrypt", middleware.RootAuth(), middleware.Audit(model.AuditTargetChannel), controller.ReencryptChannels)
+			channelRoute.GET("/keys/:id", middleware.ScopeAuth(model.ScopeChannelsWrite), controller.GetChannelKeys)
+			channelRoute.PUT("/keys/:id", middleware.Audit(model.AuditTargetChannelKey), controller.UpdateChannelKeyStatus)
+			channelRoute.GET("/update_balance", middleware.ScopeAuth(model.ScopeChannelsWrite), middleware.AuditGet(model.AuditTargetChannel), controller.UpdateAllChannelsBalance)
+			channelRoute.GET("/update_balance/:id", middleware.ScopeAuth(model.ScopeChannelsWrite), middleware.AuditGet(model.AuditTargetChannel), controller.UpdateChannelBalance)
+			channelRoute.POST("/", middleware.Audit(model.AuditTargetChannel), controller.AddChannel)
+			channelRoute.PUT("/", middleware.Audit(model.AuditTargetChannel), controller.UpdateChannel)
+			channelRoute.DELETE("/disabled", middleware.Audit(model.AuditTargetChannel), controller.DeleteDisabledChannel)
+			channelRoute.DELETE("/:id", middleware.Audit(model.AuditTargetChannel), controller.DeleteChannel)
 			channelRoute.GET("/providers", controller.GetChannelProviders)
-			channelRoute.POST("/providers", controller.AddChannelProvider)
+			channelRoute.POST("/providers", middleware.Audit(model.AuditTargetProvider), controller.AddChannelProvider)
+		}
+		shadowRoute := apiRouter.Group("/shadow")
+		shadowRoute.Use(middleware.ReadWriteScopeAuth(model.ScopeRoutingRead, model.ScopeRoutingWrite))
+		{
+			shadowRoute.GET("/", controller.GetAllShadowConfigs)
+			shadowRoute.POST("/", middleware.Audit(model.AuditTargetShadowConfig), controller.AddShadowConfig)
+			shadowRoute.PUT("/", middleware.Audit(model.AuditTargetShadowConfig), controller.UpdateShadowConfig)
+			shadowRoute.DELETE("/:id", middleware.Audit(model.AuditTargetShadowConfig), controller.DeleteShadowConfig)
+			shadowRoute.GET("/records", controller.GetShadowRecords)
+			shadowRoute.GET("/report", controller.GetShadowReport)
+		}
+		routingRoute := apiRouter.Group("/routing_rule")
+		routingRoute.Use(middleware.ReadWriteScopeAuth(model.ScopeRoutingRead, model.ScopeRoutingWrite))
+		{
+			routingRoute.GET("/", controller.GetAllRoutingRules)
+			routingRoute.GET("/:id", controller.GetRoutingRule)
+			routingRoute.POST("/", middleware.Audit(model.AuditTargetRoutingRule), controller.AddRoutingRule)
+			routingRoute.PUT("/", middleware.Audit(model.AuditTargetRoutingRule), controller.UpdateRoutingRule)
+			routingRoute.DELETE("/:id", middleware.Audit(model.AuditTargetRoutingRule), controller.DeleteRoutingRule)
+		}
+		virtualModelRoute := apiRouter.Group("/virtual_model")
+		virtualModelRoute.Use(middleware.ReadWriteScopeAuth(model.ScopeRoutingRead, model.ScopeRoutingWrite))
+		{
+			virtualModelRoute.GET("/", controller.GetAllVirtualModels)
+			virtualModelRoute.GET("/:id", controller.GetVirtualModel)
+			virtualModelRoute.POST("/", middleware.Audit(model.AuditTargetVirtualModel), controller.AddVirtualModel)
+			virtualModelRoute.PUT("/", middleware.Audit(model.AuditTargetVirtualModel), controller.UpdateVirtualModel)
+			virtualModelRoute.DELETE("/:id", middleware.Audit(model.AuditTargetVirtualModel), controller.DeleteVirtualModel)
+		}
+		roleRoute := apiRouter.Group("/role")
+		roleRoute.Use(middleware.ScopeAuth(model.ScopeRolesManage))
+		{
+			roleRoute.GET("/", controller.GetAllRoles)
+			roleRoute.GET("/scopes", controller.GetAllScopes)
+			roleRoute.GET("/user/:id", controller.GetUserPermissions)
+			roleRoute.PUT("/user", middleware.Audit(model.AuditTargetUserRole), controller.SetUserRoles)
+			roleRoute.GET("/:id", controller.GetRole)
+			roleRoute.POST("/", middleware.Audit(model.AuditTargetRole), controller.AddRole)
+			roleRoute.PUT("/", middleware.Audit(model.AuditTargetRole), controller.UpdateRole)
+			roleRoute.DELETE("/:id", middleware.Audit(model.AuditTargetRole), controller.DeleteRole)
 		}
 		tokenRoute := apiRouter.Group("/token")
-		tokenRoute.Use(middleware.UserAuth())
+		tokenRoute.Use(middleware.UserKeyScopeAuth(model.KeyScopeTokensRead, model.KeyScopeTokensWrite))
 		{
 			tokenRoute.GET("/", controller.GetAllTokens)
 			tokenRoute.GET("/client", controller.GetClientAllTokens)
 			tokenRoute.GET("/search", controller.SearchTokens)
 			tokenRoute.GET("/:id", controller.GetToken)
-			tokenRoute.POST("/", controller.AddToken)
-			tokenRoute.PUT("/", controller.UpdateToken)
-			tokenRoute.DELETE("/:id", controller.DeleteToken)
+			tokenRoute.POST("/", middleware.Audit(model.AuditTargetToken), controller.AddToken)
+			tokenRoute.PUT("/", middleware.Audit(model.AuditTargetToken), controller.UpdateToken)
+			tokenRoute.DELETE("/:id", middleware.Audit(model.AuditTargetToken), controller.DeleteToken)
 		}
 		redemptionRoute := apiRouter.Group("/redemption")
-		redemptionRoute.Use(middleware.AdminAuth())
+		redemptionRoute.Use(middleware.ReadWriteScopeAuth(model.ScopeRedemptionsRead, model.ScopeRedemptionsWrite))
 		{
 			redemptionRoute.GET("/", controller.GetAllRedemptions)
 			redemptionRoute.GET("/search", controller.SearchRedemptions)
 			redemptionRoute.GET("/:id", controller.GetRedemption)
-			redemptionRoute.POST("/", controller.AddRedemption)
-			redemptionRoute.PUT("/", controller.UpdateRedemption)
-			redemptionRoute.DELETE("/:id", controller.DeleteRedemption)
+			redemptionRoute.POST("/", middleware.ScopeAuth(model.ScopeRedemptionsCreate), middleware.Audit(model.AuditTargetRedemption), controller.AddRedemption)
+			redemptionRoute.PUT("/", middleware.Audit(model.AuditTargetRedemption), controller.UpdateRedemption)
+			redemptionRoute.DELETE("/:id", middleware.Audit(model.AuditTargetRedemption), controller.DeleteRedemption)
 		}
 		logRoute := apiRouter.Group("/log")
-		logRoute.GET("/", middleware.AdminAuth(), controller.GetAllLogs)
-		logRoute.DELETE("/", middleware.AdminAuth(), controller.DeleteHistoryLogs)
+		logRoute.GET("/", middleware.ScopeAuth(model.ScopeLogsRead), controller.GetAllLogs)
+		logRoute.DELETE("/", middleware.ScopeAuth(model.ScopeLogsWrite), middleware.Audit(model.AuditTargetLog), controller.DeleteHistoryLogs)
+		logRoute.GET("/archive", middleware.ScopeAuth(model.ScopeLogsRead), controller.GetArchivedLogs)
+		logRoute.POST("/archive/restore", middleware.ScopeAuth(model.ScopeLogsWrite), middleware.Audit(model.AuditTargetLog), controller.RestoreArchivedLogs)
 		//logRoute.GET("/stat", middleware.AdminAuth(), controller.GetLogsStat)
 		//logRoute.GET("/self/stat", middleware.UserAuth(), controller.GetLogsSelfStat)
-		logRoute.GET("/search", middleware.AdminAuth(), controller.SearchAllLogs)
-		logRoute.GET("/self", middleware.UserAuth(), controller.GetUserLogs)
-		logRoute.GET("/self/search", middleware.UserAuth(), controller.SearchUserLogs)
+		logRoute.GET("/search", middleware.ScopeAuth(model.ScopeLogsRead), controller.SearchAllLogs)
+		logRoute.GET("/self", middleware.UserKeyScopeAuth(model.ScopeLogsRead, model.ScopeLogsRead), controller.GetUserLogs)
+		logRoute.GET("/self/search", middleware.UserKeyScopeAuth(model.ScopeLogsRead, model.ScopeLogsRead), controller.SearchUserLogs)
 		logRoute.GET("/usage", middleware.UserAuth(), controller.GetUserUsage)
-		logRoute.GET("/usage/flush", middleware.AdminAuth(), controller.FlushUserUsage)
+		logRoute.GET("/usage/flush", middleware.ScopeAuth(model.ScopeLogsWrite), middleware.AuditGet(model.AuditTargetLog), controller.FlushUserUsage)
+		usageRoute := apiRouter.Group("/usage")
+		{
+			usageRoute.GET("/stats", middleware.ScopeAuth(model.ScopeLogsRead), controller.GetAllUsageStats)
+			usageRoute.GET("/self/stats", middleware.UserKeyScopeAuth(model.ScopeLogsRead, model.ScopeLogsRead), controller.GetUserUsageStats)
+		}
+		exportRoute := apiRouter.Group("/export")
+		{
+			exportRoute.GET("/", middleware.ScopeAuth(model.ScopeLogsRead), controller.ExportData)
+			exportRoute.GET("/self", middleware.UserKeyScopeAuth(model.ScopeLogsRead, model.ScopeLogsRead), controller.ExportUserData)
+			scheduleRoute := exportRoute.Group("/schedule")
+			scheduleRoute.Use(middleware.ReadWriteScopeAuth(model.ScopeLogsRead, model.ScopeLogsWrite))
+			{
+				scheduleRoute.GET("/", controller.GetAllExportSchedules)
+				scheduleRoute.POST("/", middleware.Audit(model.AuditTargetExportSchedule), controller.AddExportSchedule)
+				scheduleRoute.PUT("/", middleware.Audit(model.AuditTargetExportSchedule), controller.UpdateExportSchedule)
+				scheduleRoute.DELETE("/:id", middleware.Audit(model.AuditTargetExportSchedule), controller.DeleteExportSchedule)
+			}
+		}
+		anomalyRoute := apiRouter.Group("/anomaly")
+		anomalyRoute.Use(middleware.ReadWriteScopeAuth(model.ScopeUsersRead, model.ScopeUsersManage))
+		{
+			anomalyRoute.GET("/", controller.GetAnomalyEvents)
+			anomalyRoute.POST("/:id/resolve", middleware.Audit(model.AuditTargetAnomalyEvent), controller.ResolveAnomalyEvent)
+		}
+		taskRoute := apiRouter.Group("/task")
+		{
+			taskRoute.GET("/", middleware.ScopeAuth(model.ScopeLogsRead), controller.GetAllTasks)
+			taskRoute.GET("/self", middleware.UserKeyScopeAuth(model.ScopeLogsRead, model.ScopeLogsRead), controller.GetUserTasks)
+		}
+		auditLogRoute := apiRouter.Group("/audit_log")
+		auditLogRoute.Use(middleware.ScopeAuth(model.ScopeAuditRead))
+		{
+			auditLogRoute.GET("/", controller.GetAuditLogs)
+			auditLogRoute.GET("/export", controller.ExportAuditLogs)
+		}
 		groupRoute := apiRouter.Group("/group")
-		groupRoute.Use(middleware.AdminAuth())
+		groupRoute.Use(middleware.ScopeAuth(model.ScopeChannelsRead))
 		{
 			groupRoute.GET("/", controller.GetGroups)
 		}
@@ -145,7 +251,7 @@ func SetApiRouter(router *gin.Engine) {
 			payRoute.GET("/stripe/success", pay.StripeOrderSuccess)
 			payRoute.GET("/stripe/failed", pay.StripeOrderFailed)
 			payRoute.GET("/query/order", pay.QueryOrderByTradeNo)
-			payRoute.GET("/update_order_status", middleware.AdminAuth(), pay.UpdateOrderStatusByUser)
+			payRoute.GET("/update_order_status", middleware.ScopeAuth(model.ScopeOrdersWrite), middleware.AuditGet(model.AuditTargetOrder), pay.UpdateOrderStatusByUser)
 		}
 	}
 }
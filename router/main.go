@@ -13,6 +13,7 @@ import (
 
 func SetRouter(router *gin.Engine, buildFS embed.FS) {
 	SetApiRouter(router)
+	SetScimRouter(router)
 	SetDashboardRouter(router)
 	SetRelayRouter(router)
 	frontendBaseUrl := os.Getenv("FRONTEND_BASE_URL")
@@ -122,12 +122,21 @@ func SetRelayRouter(router *gin.Engine) {
 		}))
 	}
 
+	taskRouter := router.Group("/v1/tasks")
+	taskRouter.Use(middleware.RelayPanicRecover(), middleware.TokenAuth())
+	{
+		taskRouter.GET("/:id", controller.GetTask)
+		taskRouter.GET("/:id/content", controller.GetTaskContent)
+	}
+
 	relayV1Router := router.Group("/v1")
 	relayV1Router.Use(middleware.RelayPanicRecover(), middleware.TokenAuth(), middleware.Distribute(), middleware.RelayTime())
 	{
 		relayV1Router.Any("/proxy/:channelid/*target", controller.Relay)
 		relayV1Router.POST("/completions", controller.Relay)
 		relayV1Router.POST("/chat/completions", controller.Relay)
+		relayV1Router.GET("/realtime", controller.Relay)
+		relayV1Router.POST("/tasks", controller.Relay)
 		relayV1Router.POST("/edits", controller.Relay)
 		relayV1Router.POST("/images/generations", controller.Relay)
 		relayV1Router.POST("/images/edits", controller.Relay)
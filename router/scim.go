@@ -0,0 +1,40 @@
+package router
+
+import (
+	"github.com/songquanpeng/one-api/controller"
+	"github.com/songquanpeng/one-api/middleware"
+	"github.com/songquanpeng/one-api/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetScimRouter serves the SCIM 2.0 endpoint used by the identity provider to provision users
+func SetScimRouter(router *gin.Engine) {
+	scimRouter := router.Group("/scim/v2")
+	scimRouter.Use(middleware.GlobalAPIRateLimit(), middleware.ScimAuth())
+	{
+		scimRouter.GET("/ServiceProviderConfig", controller.GetScimServiceProviderConfig)
+		scimRouter.GET("/ResourceTypes", controller.GetScimResourceTypes)
+
+		usersRoute := scimRouter.Group("/Users")
+		usersRoute.Use(middleware.Audit(model.AuditTargetUser))
+		{
+			usersRoute.GET("", controller.ScimListUsers)
+			usersRoute.GET("/:id", controller.ScimGetUser)
+			usersRoute.POST("", controller.ScimCreateUser)
+			usersRoute.PUT("/:id", controller.ScimReplaceUser)
+			usersRoute.PATCH("/:id", controller.ScimPatchUser)
+			usersRoute.DELETE("/:id", controller.ScimDeleteUser)
+		}
+		groupsRoute := scimRouter.Group("/Groups")
+		groupsRoute.Use(middleware.Audit(model.AuditTargetScimGroup))
+		{
+			groupsRoute.GET("", controller.ScimListGroups)
+			groupsRoute.GET("/:id", controller.ScimGetGroup)
+			groupsRoute.POST("", controller.ScimCreateGroup)
+			groupsRoute.PUT("/:id", controller.ScimReplaceGroup)
+			groupsRoute.PATCH("/:id", controller.ScimPatchGroup)
+			groupsRoute.DELETE("/:id", controller.ScimDeleteGroup)
+		}
+	}
+}
@@ -98,13 +98,9 @@ export async function onLarkOAuthClicked(lark_client_id) {
   window.open(`https://accounts.feishu.cn/open-apis/authen/v1/authorize?redirect_uri=${redirect_uri}&client_id=${lark_client_id}&state=${state}`);
 }
 
-export async function onOidcClicked(auth_url, client_id, openInNewTab = false) {
-  const state = await getOAuthState();
-  if (!state) return;
-  const redirect_uri = `${window.location.origin}/oauth/oidc`;
-  const response_type = "code";
-  const scope = "openid profile email";
-  const url = `${auth_url}?client_id=${client_id}&redirect_uri=${redirect_uri}&response_type=${response_type}&scope=${scope}&state=${state}`;
+// the server starts the login, with PKCE and a nonce kept in the session
+export function onOidcClicked(openInNewTab = false) {
+  const url = '/api/oauth/oidc/login';
   if (openInNewTab) {
     window.open(url);
   } else
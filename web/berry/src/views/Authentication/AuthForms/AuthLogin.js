@@ -152,7 +152,7 @@ const LoginForm = ({ ...others }) => {
                 <Button
                   disableElevation
                   fullWidth
-                  onClick={() => onOidcClicked(siteInfo.oidc_authorization_endpoint,siteInfo.oidc_client_id)}
+                  onClick={() => onOidcClicked()}
                   size="large"
                   variant="outlined"
                   sx={{
@@ -21,6 +21,7 @@ import {
   Container,
   Autocomplete,
   FormHelperText,
+  FormControlLabel,
   Switch,
   Checkbox
 } from '@mui/material';
@@ -35,6 +36,9 @@ import CheckBoxIcon from '@mui/icons-material/CheckBox';
 const icon = <CheckBoxOutlineBlankIcon fontSize="small" />;
 const checkedIcon = <CheckBoxIcon fontSize="small" />;
 
+// Anthropic, AWS Claude and Vertex AI convert the requests for Claude and can add the cache breakpoints
+const PROMPT_CACHE_TYPES = [14, 33, 42];
+
 const filter = createFilterOptions();
 const validationSchema = Yup.object().shape({
   is_edit: Yup.boolean(),
@@ -573,6 +577,50 @@ const EditModal = ({ open, channelId, onCancel, onOk }) => {
                   );
                 })}
 
+              {PROMPT_CACHE_TYPES.includes(values.type) && (
+                <>
+                  <FormControl fullWidth sx={{ ...theme.typography.otherInput }}>
+                    <FormControlLabel
+                      control={
+                        <Switch
+                          checked={Boolean(values.config?.prompt_cache)}
+                          onChange={(e) => setFieldValue('config.prompt_cache', e.target.checked)}
+                        />
+                      }
+                      label={inputLabel.prompt_cache}
+                    />
+                    <FormHelperText id="helper-tex-channel-prompt_cache-label"> {inputPrompt.prompt_cache} </FormHelperText>
+                  </FormControl>
+                  {values.config?.prompt_cache && (
+                    <>
+                      <FormControl fullWidth sx={{ ...theme.typography.otherInput }}>
+                        <TextField
+                          type="number"
+                          id="channel-prompt_cache_messages-label"
+                          label={inputLabel.prompt_cache_messages}
+                          value={values.config?.prompt_cache_messages || 0}
+                          name="config.prompt_cache_messages"
+                          inputProps={{ min: 0, max: 4 }}
+                          onChange={(e) => setFieldValue('config.prompt_cache_messages', parseInt(e.target.value) || 0)}
+                        />
+                        <FormHelperText id="helper-tex-channel-prompt_cache_messages-label"> {inputPrompt.prompt_cache_messages} </FormHelperText>
+                      </FormControl>
+                      <FormControl fullWidth sx={{ ...theme.typography.otherInput }}>
+                        <TextField
+                          id="channel-prompt_cache_models-label"
+                          label={inputLabel.prompt_cache_models}
+                          value={values.config?.prompt_cache_models || ''}
+                          name="config.prompt_cache_models"
+                          placeholder={inputPrompt.prompt_cache_models}
+                          onChange={handleChange}
+                        />
+                        <FormHelperText id="helper-tex-channel-prompt_cache_models-label"> {inputPrompt.prompt_cache_models} </FormHelperText>
+                      </FormControl>
+                    </>
+                  )}
+                </>
+              )}
+
               <FormControl fullWidth error={Boolean(touched.model_mapping && errors.model_mapping)} sx={{ ...theme.typography.otherInput }}>
                 {/* <InputLabel htmlFor="channel-model_mapping-label">{inputLabel.model_mapping}</InputLabel> */}
                 <TextField
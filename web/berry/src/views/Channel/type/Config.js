@@ -20,6 +20,9 @@ const defaultConfig = {
     model_mapping: '模型映射关系',
     system_prompt: '系统提示词',
     groups: '用户组',
+    prompt_cache: '提示词缓存',
+    prompt_cache_messages: '缓存的消息数',
+    prompt_cache_models: '启用缓存的模型',
     config: null
   },
   prompt: {
@@ -33,6 +36,9 @@ const defaultConfig = {
       '请输入要修改的模型映射关系，格式为：api请求模型ID:实际转发给渠道的模型ID，使用JSON数组表示，例如：{"gpt-3.5": "gpt-35"}',
     system_prompt:"此项可选，用于强制设置给定的系统提示词，请配合自定义模型 & 模型重定向使用，首先创建一个唯一的自定义模型名称并在上面填入，之后将该自定义模型重定向映射到该渠道一个原生支持的模型此项可选，用于强制设置给定的系统提示词，请配合自定义模型 & 模型重定向使用，首先创建一个唯一的自定义模型名称并在上面填入，之后将该自定义模型重定向映射到该渠道一个原生支持的模型",
     groups: '请选择该渠道所支持的用户组',
+    prompt_cache: '为转换为 Claude 格式的请求自动添加 cache_control 缓存断点，依次为工具、系统提示词和最后几条消息，最多 4 个',
+    prompt_cache_messages: '缓存最后几条消息，0 表示只缓存工具和系统提示词',
+    prompt_cache_models: '逗号分隔，留空表示所有模型',
     config: null
   },
   modelGroup: 'openai'
@@ -533,6 +533,43 @@ const EditChannel = () => {
               </Form.Field>
             )
           }
+          {
+            [14, 33, 42].includes(inputs.type) && (
+              <Form.Field>
+                <Form.Checkbox
+                  checked={Boolean(config.prompt_cache)}
+                  label='启用提示词缓存，为转换为 Claude 格式的请求自动添加 cache_control 缓存断点'
+                  name='prompt_cache'
+                  onChange={(e, { name, checked }) => setConfig((inputs) => ({ ...inputs, [name]: checked }))}
+                />
+                {
+                  config.prompt_cache && (
+                    <Form.Group widths='equal'>
+                      <Form.Input
+                        label='缓存的消息数'
+                        name='prompt_cache_messages'
+                        type='number'
+                        min={0}
+                        max={4}
+                        placeholder={'缓存最后几条消息，0 表示只缓存工具和系统提示词'}
+                        onChange={(e, { name, value }) => setConfig((inputs) => ({ ...inputs, [name]: parseInt(value) || 0 }))}
+                        value={config.prompt_cache_messages || 0}
+                        autoComplete=''
+                      />
+                      <Form.Input
+                        label='启用缓存的模型'
+                        name='prompt_cache_models'
+                        placeholder={'逗号分隔，留空表示所有模型'}
+                        onChange={handleConfigChange}
+                        value={config.prompt_cache_models || ''}
+                        autoComplete=''
+                      />
+                    </Form.Group>
+                  )
+                }
+              </Form.Field>
+            )
+          }
           {
             inputs.type === 34 && (
               <Form.Input